@@ -34,7 +34,8 @@ func (f family) String() string {
 }
 
 // bindSockaddr choses an IPv4 or IPv6 bind address for the given interface.
-func bindSockaddr(family family, ifi *net.Interface) (unix.Sockaddr, netip.Addr, error) {
+// If linkLocal is set, only IPv6 link-local addresses are considered.
+func bindSockaddr(family family, ifi *net.Interface, linkLocal bool) (unix.Sockaddr, netip.Addr, error) {
 	// Strict mode allows in-kernel filtering of addresses for a given interface
 	// index.
 	rc, err := rtnetlink.Dial(&netlink.Config{Strict: true})
@@ -59,15 +60,17 @@ func bindSockaddr(family family, ifi *net.Interface) (unix.Sockaddr, netip.Addr,
 	}
 
 	return (&bindContext{
-		family: family,
-		ifi:    ifi,
+		family:    family,
+		ifi:       ifi,
+		linkLocal: linkLocal,
 	}).Select(ams)
 }
 
 // A bindContext manages shared state while selecting a socket bind address.
 type bindContext struct {
-	family family
-	ifi    *net.Interface
+	family    family
+	ifi       *net.Interface
+	linkLocal bool
 }
 
 // Select chooses an appropriate bind address based on rtnetlink address
@@ -126,7 +129,7 @@ func (bc *bindContext) selectIPv6(msgs []*rtnetlink.AddressMessage) (unix.Sockad
 		}
 
 		ip, ok := netip.AddrFromSlice(m.Attributes.Address)
-		if !ok {
+		if !ok || (bc.linkLocal && !ip.IsLinkLocalUnicast()) {
 			continue
 		}
 
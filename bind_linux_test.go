@@ -43,7 +43,7 @@ func TestIntegration_bindSockaddr(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, ip, err := bindSockaddr(tt.f, lo)
+			_, ip, err := bindSockaddr(tt.f, lo, false)
 			if err != nil {
 				t.Fatalf("failed to bind: %v", err)
 			}
@@ -57,9 +57,10 @@ func TestIntegration_bindSockaddr(t *testing.T) {
 
 func Test_bindContextSelect(t *testing.T) {
 	tests := []struct {
-		name string
-		f    family
-		msgs []*rtnetlink.AddressMessage
+		name      string
+		f         family
+		linkLocal bool
+		msgs      []*rtnetlink.AddressMessage
 
 		sa unix.Sockaddr
 		ip netip.Addr
@@ -134,13 +135,45 @@ func Test_bindContextSelect(t *testing.T) {
 			},
 			ip: netip.MustParseAddr("2001:db8::1"),
 		},
+		{
+			name:      "IPv6 link-local",
+			f:         fIPv6,
+			linkLocal: true,
+			msgs: []*rtnetlink.AddressMessage{
+				{
+					Family: unix.AF_INET6,
+					Index:  uint32(lo.Index),
+					Attributes: &rtnetlink.AddressAttributes{
+						Address: net.ParseIP("2001:db8::1"),
+						Flags:   unix.IFA_F_MANAGETEMPADDR,
+					},
+				},
+				{
+					Family: unix.AF_INET6,
+					Index:  uint32(lo.Index),
+					Attributes: &rtnetlink.AddressAttributes{
+						Address: net.ParseIP("fe80::1"),
+					},
+				},
+			},
+
+			sa: &unix.SockaddrInet6{
+				Addr: [16]byte{
+					0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+				},
+				ZoneId: uint32(lo.Index),
+			},
+			ip: netip.MustParseAddr("fe80::1"),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			sa, ip, err := (&bindContext{
-				family: tt.f,
-				ifi:    lo,
+				family:    tt.f,
+				ifi:       lo,
+				linkLocal: tt.linkLocal,
 			}).Select(tt.msgs)
 			if err != nil {
 				t.Fatalf("failed to select bind sockaddr: %v", err)
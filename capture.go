@@ -0,0 +1,141 @@
+package icmpx
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/mdlayher/icmpx/internal/pcap"
+)
+
+// A capture writes the ICMPv4/6 traffic for a Conn to a pcapng stream. A nil
+// *capture is valid and discards all packets.
+type capture struct {
+	w *pcap.Writer
+}
+
+// newCapture creates a capture which writes to w. If w is nil, newCapture
+// returns a nil *capture.
+func newCapture(w io.Writer, ifi *net.Interface) (*capture, error) {
+	if w == nil {
+		return nil, nil
+	}
+
+	// The synthesized packets begin with an IPv4 or IPv6 header and no
+	// link-layer header.
+	pw, err := pcap.NewWriter(w, pcap.Interface{
+		Name:     ifi.Name,
+		LinkType: pcap.LinkTypeRaw,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &capture{w: pw}, nil
+}
+
+// write writes a complete IPv4 or IPv6 packet to the capture.
+func (c *capture) write(dir pcap.Direction, b []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	return c.w.WritePacket(pcap.Packet{
+		Timestamp: time.Now(),
+		Direction: dir,
+		Data:      b,
+	})
+}
+
+// ipv4Packet synthesizes an IPv4 packet which carries an ICMPv4 message.
+func ipv4Packet(src, dst netip.Addr, tos, ttl int, msg []byte) []byte {
+	const hdrLen = 20
+
+	b := make([]byte, hdrLen, hdrLen+len(msg))
+	b[0] = 4<<4 | hdrLen/4
+	b[1] = byte(tos)
+	binary.BigEndian.PutUint16(b[2:4], uint16(hdrLen+len(msg)))
+	b[8] = byte(ttl)
+	b[9] = 1 // ICMP
+
+	src4, dst4 := src.As4(), dst.As4()
+	copy(b[12:16], src4[:])
+	copy(b[16:20], dst4[:])
+	binary.BigEndian.PutUint16(b[10:12], checksum(0, b))
+
+	return append(b, msg...)
+}
+
+// ipv6Packet synthesizes an IPv6 packet which carries an ICMPv6 message,
+// preceded by the Hop-by-Hop Options header hbh if it is set. The ICMPv6
+// checksum is computed if it was left unset for the kernel to fill.
+func ipv6Packet(src, dst netip.Addr, tc, hopLimit int, hbh, msg []byte) []byte {
+	const hdrLen = 40
+
+	b := make([]byte, hdrLen, hdrLen+len(hbh)+len(msg))
+	binary.BigEndian.PutUint32(b[0:4], 6<<28|uint32(tc&0xff)<<20)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(hbh)+len(msg)))
+	b[6] = 58 // ICMPv6
+	b[7] = byte(hopLimit)
+
+	src16, dst16 := src.As16(), dst.As16()
+	copy(b[8:24], src16[:])
+	copy(b[24:40], dst16[:])
+
+	if len(hbh) > 0 {
+		b[6] = 0 // Hop-by-Hop Options
+		b = append(b, hbh...)
+		b[hdrLen] = 58
+	}
+
+	off := len(b)
+	b = append(b, msg...)
+
+	if len(msg) >= 4 && binary.BigEndian.Uint16(msg[2:4]) == 0 {
+		// The checksum covers a pseudo-header which consists of the source
+		// and destination addresses, the upper-layer packet length, and the
+		// next header value.
+		var psh []byte
+		psh = append(psh, b[8:40]...)
+		psh = binary.BigEndian.AppendUint32(psh, uint32(len(msg)))
+		psh = append(psh, 0, 0, 0, 58)
+
+		icmp := b[off:]
+		binary.BigEndian.PutUint16(icmp[2:4], checksum(sum(0, psh), icmp))
+	}
+
+	return b
+}
+
+// routerAlert is an IPv6 Hop-by-Hop Options header which carries a Router
+// Alert option with the Multicast Listener Discovery value, as described in
+// RFC 2711. The Next Header field is filled in when the header is sent.
+var routerAlert = []byte{
+	0, 0, // Next Header, Hdr Ext Len
+	5, 2, 0, 0, // Router Alert: MLD
+	1, 0, // PadN
+}
+
+// checksum computes the Internet checksum of b with an initial partial sum.
+func checksum(initial uint32, b []byte) uint16 {
+	s := sum(initial, b)
+	for s > 0xffff {
+		s = (s >> 16) + (s & 0xffff)
+	}
+
+	return ^uint16(s)
+}
+
+// sum computes the unfolded one's complement sum of b.
+func sum(s uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+
+	return s
+}
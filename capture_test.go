@@ -0,0 +1,98 @@
+package icmpx
+
+import (
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func Test_ipv4Packet(t *testing.T) {
+	msg, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: 1, Seq: 1},
+	}).Marshal(nil)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	b := ipv4Packet(
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+		0, 64, msg,
+	)
+
+	h, err := ipv4.ParseHeader(b)
+	if err != nil {
+		t.Fatalf("failed to parse IPv4 header: %v", err)
+	}
+
+	if h.TotalLen != len(b) || h.TTL != 64 || h.Protocol != 1 {
+		t.Fatalf("unexpected IPv4 header: %+v", h)
+	}
+	if c := checksum(0, b[:h.Len]); c != 0 {
+		t.Fatalf("invalid IPv4 header checksum: %#04x", c)
+	}
+}
+
+func Test_ipv6Packet(t *testing.T) {
+	// The kernel computes ICMPv6 checksums, so marshal without a
+	// pseudo-header and leave it unset.
+	msg, err := (&icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: &icmp.Echo{ID: 1, Seq: 1, Data: []byte{0xff}},
+	}).Marshal(nil)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	var (
+		src = netip.MustParseAddr("2001:db8::1")
+		dst = netip.MustParseAddr("2001:db8::2")
+	)
+
+	tests := []struct {
+		name string
+		hbh  []byte
+		next int
+	}{
+		{
+			name: "ICMPv6",
+			next: 58,
+		},
+		{
+			name: "router alert",
+			hbh:  routerAlert,
+			next: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := ipv6Packet(src, dst, 0, 255, tt.hbh, msg)
+
+			h, err := ipv6.ParseHeader(b)
+			if err != nil {
+				t.Fatalf("failed to parse IPv6 header: %v", err)
+			}
+
+			if h.PayloadLen != len(tt.hbh)+len(msg) || h.HopLimit != 255 || h.NextHeader != tt.next {
+				t.Fatalf("unexpected IPv6 header: %+v", h)
+			}
+
+			if len(tt.hbh) > 0 && b[40] != 58 {
+				t.Fatalf("unexpected Hop-by-Hop Options next header: %d", b[40])
+			}
+
+			// The checksum is valid if the sum over the pseudo-header and
+			// message is zero.
+			psh := icmp.IPv6PseudoHeader(src.AsSlice(), dst.AsSlice())
+			psh[35] = byte(len(msg))
+			if c := checksum(sum(0, psh), b[40+len(tt.hbh):]); c != 0 {
+				t.Fatalf("invalid ICMPv6 checksum: %#04x", c)
+			}
+		})
+	}
+}
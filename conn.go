@@ -3,6 +3,7 @@ package icmpx
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/netip"
@@ -29,10 +30,13 @@ type IPv4Conn struct {
 	// IP is the chosen IPv4 bind address for ICMPv4 communication.
 	IP netip.Addr
 
-	c   *conn
-	ifi *net.Interface
-	mu  sync.RWMutex
-	b   []byte
+	c          *conn
+	ifi        *net.Interface
+	filter     *IPv4Filter
+	nextHopMTU bool
+	capture    *capture
+	mu         sync.RWMutex
+	b          []byte
 }
 
 // An IPv4Config configures an IPv4Conn.
@@ -43,6 +47,22 @@ type IPv4Config struct {
 	//
 	// If nil, no ICMPv4 filter is applied.
 	Filter *IPv4Filter
+
+	// NextHopMTU causes ReadFrom to return each ICMPv4 Destination
+	// Unreachable message with the Fragmentation Needed code with an
+	// *icmp.PacketTooBig body, whose MTU field holds the next-hop MTU reported
+	// by a router as described in RFC 1191. Otherwise, the next-hop MTU is
+	// discarded when the message is parsed as an *icmp.DstUnreach.
+	NextHopMTU bool
+
+	// Capture receives a pcapng stream of every ICMPv4 message sent and
+	// received by an IPv4Conn. Received messages include the IPv4 header
+	// returned by the kernel, and sent messages include a synthesized IPv4
+	// header. Errors which occur while writing to Capture are returned by
+	// WriteTo and ReadFrom.
+	//
+	// If nil, no capture is performed.
+	Capture io.Writer
 }
 
 // ListenIPv4 binds an ICMPv4 socket on the specified network interface.
@@ -76,15 +96,30 @@ func (c *IPv4Conn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, err
 // SetTOS sets the IPv4 Type of Service (ToS) field for outgoing packets.
 func (c *IPv4Conn) SetTOS(tos int) error { return c.setTOS(tos) }
 
+// SetTTL sets the IPv4 Time to Live (TTL) field for outgoing unicast and
+// multicast packets.
+func (c *IPv4Conn) SetTTL(ttl int) error { return c.setTTL(ttl) }
+
+// SetDontFragment sets whether outgoing packets are sent with the IPv4 Don't
+// Fragment flag, so that routers which cannot forward them report the next-hop
+// MTU rather than fragmenting them. Any path MTU learned by the kernel is
+// ignored, and sending a packet larger than the network interface MTU returns
+// an error. If false, outgoing packets may be fragmented.
+func (c *IPv4Conn) SetDontFragment(on bool) error { return c.setDontFragment(on) }
+
 // An IPv6Conn allows reading and writing ICMPv6 data on a network interface.
 type IPv6Conn struct {
 	// IP is the chosen IPv6 bind address for ICMPv6 communication.
 	IP netip.Addr
 
-	c   *conn
-	ifi *net.Interface
-	mu  sync.RWMutex
-	b   []byte
+	c        *conn
+	ifi      *net.Interface
+	hopLimit int
+	hdrincl  bool
+	hbh      []byte
+	capture  *capture
+	mu       sync.RWMutex
+	b, oob   []byte
 }
 
 // An IPv6Config configures an IPv6Conn.
@@ -95,6 +130,41 @@ type IPv6Config struct {
 	//
 	// If nil, no ICMPv6 filter is applied.
 	Filter *IPv6Filter
+
+	// LinkLocal forces an IPv6Conn to bind to a link-local address on its
+	// network interface, as required by protocols such as Neighbor Discovery
+	// whose messages must be sourced from a link-local address.
+	LinkLocal bool
+
+	// UnspecifiedSource causes an IPv6Conn to send all messages from the
+	// unspecified address (::), as required by Duplicate Address Detection.
+	// The IPv6Conn is not bound to any address, so it may be used on an
+	// interface which has no IPv6 addresses, and its IP field is set to the
+	// unspecified address. Messages are still only sent and received on the
+	// specified network interface. UnspecifiedSource and LinkLocal are
+	// mutually exclusive.
+	UnspecifiedSource bool
+
+	// ReceiveHopLimit causes ReadFrom to discard any ICMPv6 message which was
+	// not received with the specified IPv6 Hop Limit. Neighbor Discovery uses
+	// a value of 255 to verify that a message originated on the local link.
+	//
+	// If zero, messages are received regardless of their Hop Limit.
+	ReceiveHopLimit int
+
+	// RouterAlert causes an IPv6Conn to send every message with a Router
+	// Alert option in an IPv6 Hop-by-Hop Options header, as described in RFC
+	// 2711, using the value for Multicast Listener Discovery. Routers
+	// examine such messages even when they are not addressed to the router.
+	RouterAlert bool
+
+	// Capture receives a pcapng stream of every ICMPv6 message sent and
+	// received by an IPv6Conn. Each message includes a synthesized IPv6
+	// header. Errors which occur while writing to Capture are returned by
+	// WriteTo and ReadFrom.
+	//
+	// If nil, no capture is performed.
+	Capture io.Writer
 }
 
 // ListenIPv6 binds an ICMPv6 socket on the specified network interface.
@@ -127,3 +197,37 @@ func (c *IPv6Conn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, err
 
 // SetTrafficClass sets the IPv6 Traffic Class field for outgoing packets.
 func (c *IPv6Conn) SetTrafficClass(tc int) error { return c.setTrafficClass(tc) }
+
+// SetHopLimit sets the IPv6 Hop Limit field for outgoing unicast and multicast
+// packets.
+func (c *IPv6Conn) SetHopLimit(hops int) error { return c.setHopLimit(hops) }
+
+// SetDontFragment sets whether outgoing packets are sent without fragmentation
+// by the local host, so that routers which cannot forward them report the path
+// MTU in Packet Too Big messages. Any path MTU learned by the kernel is
+// ignored, and sending a packet larger than the network interface MTU returns
+// an error. If false, outgoing packets may be fragmented by the local host.
+func (c *IPv6Conn) SetDontFragment(on bool) error { return c.setDontFragment(on) }
+
+// JoinGroup joins the IPv6 multicast group on the IPv6Conn's network
+// interface so that messages sent to group will be received.
+func (c *IPv6Conn) JoinGroup(group netip.Addr) error {
+	if !group.Is6() || !group.IsMulticast() {
+		return fmt.Errorf("invalid IPv6 multicast group: %q", group)
+	}
+
+	return c.joinGroup(group)
+}
+
+// LeaveGroup leaves an IPv6 multicast group previously joined with JoinGroup.
+func (c *IPv6Conn) LeaveGroup(group netip.Addr) error {
+	if !group.Is6() || !group.IsMulticast() {
+		return fmt.Errorf("invalid IPv6 multicast group: %q", group)
+	}
+
+	return c.leaveGroup(group)
+}
+
+// SetMulticastLoopback sets whether multicast messages sent by the IPv6Conn are
+// looped back to sockets on the local host, including the IPv6Conn itself.
+func (c *IPv6Conn) SetMulticastLoopback(on bool) error { return c.setMulticastLoopback(on) }
@@ -2,11 +2,14 @@ package icmpx
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"strconv"
 
+	"github.com/mdlayher/icmpx/internal/pcap"
 	"github.com/mdlayher/socket"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
@@ -16,9 +19,14 @@ import (
 // A conn abstracts socket.Conn in an OS-independent way.
 type conn = socket.Conn
 
+// maxDatagram is the size of the largest IPv4 or IPv6 datagram, excluding IPv6
+// jumbograms. Receive buffers use this size rather than the interface MTU so
+// that messages reassembled from fragments are not truncated.
+const maxDatagram = 65535
+
 // listenIPv4 is the IPv4Conn entry point on Linux.
 func listenIPv4(ifi *net.Interface, cfg IPv4Config) (*IPv4Conn, error) {
-	sa, ip, err := bindSockaddr(fIPv4, ifi)
+	sa, ip, err := bindSockaddr(fIPv4, ifi, false)
 	if err != nil {
 		return nil, err
 	}
@@ -33,11 +41,16 @@ func listenIPv4(ifi *net.Interface, cfg IPv4Config) (*IPv4Conn, error) {
 		return nil, err
 	}
 
+	var filter *IPv4Filter
 	if cfg.Filter != nil {
 		if err := cfg.Filter.set(conn); err != nil {
 			_ = conn.Close()
 			return nil, err
 		}
+
+		// Keep a copy of the filter to apply to types the kernel ignores.
+		f := *cfg.Filter
+		filter = &f
 	}
 
 	if err := conn.Bind(sa); err != nil {
@@ -45,44 +58,113 @@ func listenIPv4(ifi *net.Interface, cfg IPv4Config) (*IPv4Conn, error) {
 		return nil, err
 	}
 
+	capture, err := newCapture(cfg.Capture, ifi)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
 	return &IPv4Conn{
-		IP:  ip,
-		c:   conn,
-		ifi: ifi,
-		b:   make([]byte, ifi.MTU),
+		IP:         ip,
+		c:          conn,
+		ifi:        ifi,
+		filter:     filter,
+		nextHopMTU: cfg.NextHopMTU,
+		capture:    capture,
+		b:          make([]byte, maxDatagram),
 	}, nil
 }
 
 // sendto sends an ICMPv4 message.
 func (c *IPv4Conn) sendto(ctx context.Context, b []byte, dst netip.Addr) error {
 	// IPv4 addresses do not use the IPv6 zone in the destination sockaddr.
-	return c.c.Sendto(ctx, b, 0, toSockaddr(dst, 0))
+	if err := c.c.Sendto(ctx, b, 0, toSockaddr(dst, 0)); err != nil {
+		return err
+	}
+	if c.capture == nil {
+		return nil
+	}
+
+	// The kernel builds the IPv4 header for us, so fetch the values it will
+	// use to synthesize an equivalent header for the capture.
+	tos, err := c.c.GetsockoptInt(unix.SOL_IP, unix.IP_TOS)
+	if err != nil {
+		return err
+	}
+
+	opt := unix.IP_TTL
+	if dst.IsMulticast() {
+		opt = unix.IP_MULTICAST_TTL
+	}
+
+	ttl, err := c.c.GetsockoptInt(unix.SOL_IP, opt)
+	if err != nil {
+		return err
+	}
+
+	return c.capture.write(pcap.DirectionOutbound, ipv4Packet(c.IP, dst, tos, ttl, b))
 }
 
 // recvfromLocked receives an ICMPv4 message. It assumes c.mu is locked so that
 // c.b may be reused safely.
 func (c *IPv4Conn) recvfromLocked(ctx context.Context) (*icmp.Message, netip.Addr, error) {
-	n, addr, err := c.c.Recvfrom(ctx, c.b, 0)
-	if err != nil {
-		return nil, netip.Addr{}, err
-	}
+	for {
+		n, addr, err := c.c.Recvfrom(ctx, c.b, 0)
+		if err != nil {
+			return nil, netip.Addr{}, err
+		}
 
-	// ICMPv4 sockets return the entire IPv4 header, but we only care about the
-	// ICMP message that lies beyond the header.
-	//
-	// TODO(mdlayher): consider an API that exposes the header, though no
-	// equivalent exists for IPv6 and it would create an awkward API.
-	h, err := ipv4.ParseHeader(c.b)
-	if err != nil {
-		return nil, netip.Addr{}, err
+		// ICMPv4 sockets return the entire IPv4 header, but we only care about
+		// the ICMP message that lies beyond the header.
+		//
+		// TODO(mdlayher): consider an API that exposes the header, though no
+		// equivalent exists for IPv6 and it would create an awkward API.
+		h, err := ipv4.ParseHeader(c.b)
+		if err != nil {
+			return nil, netip.Addr{}, err
+		}
+
+		if c.filter != nil && n > h.Len && c.filter.WillBlock(ipv4.ICMPType(c.b[h.Len])) {
+			// The kernel passes all ICMPv4 types above 31, so discard any
+			// which were blocked by the filter.
+			continue
+		}
+
+		m, err := icmp.ParseMessage(unix.IPPROTO_ICMP, c.b[h.Len:n])
+		if err != nil {
+			return nil, netip.Addr{}, err
+		}
+
+		if c.nextHopMTU {
+			m = nextHopMTU(m, c.b[h.Len:n])
+		}
+
+		if err := c.capture.write(pcap.DirectionInbound, c.b[:n]); err != nil {
+			return nil, netip.Addr{}, err
+		}
+
+		return m, fromSockaddr(addr), nil
 	}
+}
 
-	m, err := icmp.ParseMessage(unix.IPPROTO_ICMP, c.b[h.Len:n])
-	if err != nil {
-		return nil, netip.Addr{}, err
+// nextHopMTU converts m, an ICMPv4 message parsed from b, into an equivalent
+// message with an *icmp.PacketTooBig body if it is a Fragmentation Needed
+// message, preserving the next-hop MTU field.
+func nextHopMTU(m *icmp.Message, b []byte) *icmp.Message {
+	body, ok := m.Body.(*icmp.DstUnreach)
+	if !ok || m.Type != ipv4.ICMPTypeDestinationUnreachable || m.Code != 4 || len(b) < 8 {
+		return m
 	}
 
-	return m, fromSockaddr(addr), nil
+	return &icmp.Message{
+		Type:     m.Type,
+		Code:     m.Code,
+		Checksum: m.Checksum,
+		Body: &icmp.PacketTooBig{
+			MTU:  int(binary.BigEndian.Uint16(b[6:8])),
+			Data: body.Data,
+		},
+	}
 }
 
 // setTOS sets the IPv4 Type of Service socket option.
@@ -90,6 +172,27 @@ func (c *IPv4Conn) setTOS(tos int) error {
 	return c.c.SetsockoptInt(unix.SOL_IP, unix.IP_TOS, tos)
 }
 
+// setTTL sets the IPv4 unicast and multicast TTL socket options.
+func (c *IPv4Conn) setTTL(ttl int) error {
+	if err := c.c.SetsockoptInt(unix.SOL_IP, unix.IP_TTL, ttl); err != nil {
+		return err
+	}
+
+	return c.c.SetsockoptInt(unix.SOL_IP, unix.IP_MULTICAST_TTL, ttl)
+}
+
+// setDontFragment sets the IPv4 path MTU discovery socket option.
+func (c *IPv4Conn) setDontFragment(on bool) error {
+	v := unix.IP_PMTUDISC_DONT
+	if on {
+		// Set DF but ignore the kernel's path MTU cache, which is updated by
+		// any Fragmentation Needed messages received.
+		v = unix.IP_PMTUDISC_PROBE
+	}
+
+	return c.c.SetsockoptInt(unix.SOL_IP, unix.IP_MTU_DISCOVER, v)
+}
+
 // set applies the IPv4 filter to a *socket.Conn.
 func (f *IPv4Filter) set(c *socket.Conn) error {
 	// The filter is technically a 4 byte struct but passing a uint32 with an
@@ -99,9 +202,20 @@ func (f *IPv4Filter) set(c *socket.Conn) error {
 
 // listenIPv6 is the IPv6Conn entry point on Linux.
 func listenIPv6(ifi *net.Interface, cfg IPv6Config) (*IPv6Conn, error) {
-	sa, ip, err := bindSockaddr(fIPv6, ifi)
-	if err != nil {
-		return nil, err
+	var (
+		sa  unix.Sockaddr
+		ip  = netip.IPv6Unspecified()
+		err error
+	)
+
+	switch {
+	case cfg.UnspecifiedSource && cfg.LinkLocal:
+		return nil, errors.New("IPv6 unspecified source and link-local bind are mutually exclusive")
+	case !cfg.UnspecifiedSource:
+		sa, ip, err = bindSockaddr(fIPv6, ifi, cfg.LinkLocal)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	conn, err := socket.Socket(unix.AF_INET6, unix.SOCK_RAW, unix.IPPROTO_ICMPV6, "icmpx-ipv6", nil)
@@ -121,43 +235,188 @@ func listenIPv6(ifi *net.Interface, cfg IPv6Config) (*IPv6Conn, error) {
 		}
 	}
 
-	if err := conn.Bind(sa); err != nil {
+	var opts []int
+	if cfg.Capture != nil {
+		// Request the ancillary data needed to synthesize accurate IPv6
+		// headers for received messages.
+		opts = []int{unix.IPV6_RECVPKTINFO, unix.IPV6_RECVHOPLIMIT, unix.IPV6_RECVTCLASS}
+	} else if cfg.ReceiveHopLimit != 0 {
+		opts = []int{unix.IPV6_RECVHOPLIMIT}
+	}
+
+	if cfg.UnspecifiedSource {
+		// The kernel always chooses a source address when building the IPv6
+		// header, so we must build the header ourselves.
+		opts = append(opts, unix.IPV6_HDRINCL)
+	}
+
+	for _, opt := range opts {
+		if err := conn.SetsockoptInt(unix.SOL_IPV6, opt, 1); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	var hbh []byte
+	if cfg.RouterAlert {
+		// The kernel ignores sticky options when IPV6_HDRINCL is set, but
+		// the header is also needed to build packets ourselves.
+		hbh = routerAlert
+		if err := conn.SetsockoptString(unix.SOL_IPV6, unix.IPV6_HOPOPTS, string(hbh)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if sa != nil {
+		if err := conn.Bind(sa); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	capture, err := newCapture(cfg.Capture, ifi)
+	if err != nil {
 		_ = conn.Close()
 		return nil, err
 	}
 
 	return &IPv6Conn{
-		IP:  ip,
-		c:   conn,
-		ifi: ifi,
-		b:   make([]byte, ifi.MTU),
+		IP:       ip,
+		c:        conn,
+		ifi:      ifi,
+		hopLimit: cfg.ReceiveHopLimit,
+		hdrincl:  cfg.UnspecifiedSource,
+		hbh:      hbh,
+		capture:  capture,
+		b:        make([]byte, maxDatagram),
+		oob:      make([]byte, unix.CmsgSpace(unix.SizeofInet6Pktinfo)+2*unix.CmsgSpace(4)),
 	}, nil
 }
 
 // sendto sends an ICMPv6 message.
 func (c *IPv6Conn) sendto(ctx context.Context, b []byte, dst netip.Addr) error {
-	return c.c.Sendto(ctx, b, 0, toSockaddr(dst, uint32(c.ifi.Index)))
+	sa := toSockaddr(dst, uint32(c.ifi.Index))
+	if !c.hdrincl && c.capture == nil {
+		return c.c.Sendto(ctx, b, 0, sa)
+	}
+
+	// The kernel builds the IPv6 header for us unless IPV6_HDRINCL is set,
+	// so fetch the values it would use to build an equivalent header.
+	tc, err := c.c.GetsockoptInt(unix.SOL_IPV6, unix.IPV6_TCLASS)
+	if err != nil {
+		return err
+	}
+
+	opt := unix.IPV6_UNICAST_HOPS
+	if dst.IsMulticast() {
+		opt = unix.IPV6_MULTICAST_HOPS
+	}
+
+	hops, err := c.c.GetsockoptInt(unix.SOL_IPV6, opt)
+	if err != nil {
+		return err
+	}
+
+	pkt := ipv6Packet(c.IP, dst, tc, hops, c.hbh, b)
+	if c.hdrincl {
+		err = c.c.Sendto(ctx, pkt, 0, sa)
+	} else {
+		err = c.c.Sendto(ctx, b, 0, sa)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.capture.write(pcap.DirectionOutbound, pkt)
 }
 
 // recvfromLocked receives an ICMPv6 message. It assumes c.mu is locked so that
 // c.b may be reused safely.
 func (c *IPv6Conn) recvfromLocked(ctx context.Context) (*icmp.Message, netip.Addr, error) {
-	n, addr, err := c.c.Recvfrom(ctx, c.b, 0)
-	if err != nil {
-		return nil, netip.Addr{}, err
+	for {
+		n, oobn, _, addr, err := c.c.Recvmsg(ctx, c.b, c.oob, 0)
+		if err != nil {
+			return nil, netip.Addr{}, err
+		}
+
+		var cm ipv6Control
+		if c.capture != nil || c.hopLimit != 0 {
+			cm, err = parseIPv6Control(c.oob[:oobn])
+			if err != nil {
+				return nil, netip.Addr{}, err
+			}
+		}
+
+		if c.hopLimit != 0 && cm.HopLimit != c.hopLimit {
+			// The message may have been forwarded from off-link or otherwise
+			// does not meet the caller's requirements, discard it.
+			continue
+		}
+
+		m, err := icmp.ParseMessage(unix.IPPROTO_ICMPV6, c.b[:n])
+		if err != nil {
+			return nil, netip.Addr{}, err
+		}
+
+		ip, err := fromSockaddrIPv6(addr, c.ifi)
+		if err != nil {
+			return nil, netip.Addr{}, err
+		}
+
+		if c.capture != nil {
+			dst := cm.Dst
+			if !dst.IsValid() {
+				dst = c.IP
+			}
+
+			b := ipv6Packet(ip, dst, cm.TrafficClass, cm.HopLimit, nil, c.b[:n])
+			if err := c.capture.write(pcap.DirectionInbound, b); err != nil {
+				return nil, netip.Addr{}, err
+			}
+		}
+
+		return m, ip, nil
 	}
+}
 
-	m, err := icmp.ParseMessage(unix.IPPROTO_ICMPV6, c.b[:n])
+// An ipv6Control contains the ancillary data received with an ICMPv6 message.
+type ipv6Control struct {
+	Dst          netip.Addr
+	HopLimit     int
+	TrafficClass int
+}
+
+// parseIPv6Control parses IPv6 socket control messages.
+func parseIPv6Control(oob []byte) (ipv6Control, error) {
+	scms, err := unix.ParseSocketControlMessage(oob)
 	if err != nil {
-		return nil, netip.Addr{}, err
+		return ipv6Control{}, err
 	}
 
-	ip, err := fromSockaddrIPv6(addr, c.ifi)
-	if err != nil {
-		return nil, netip.Addr{}, err
+	var cm ipv6Control
+	for _, scm := range scms {
+		if scm.Header.Level != unix.SOL_IPV6 {
+			continue
+		}
+
+		switch scm.Header.Type {
+		case unix.IPV6_PKTINFO:
+			if len(scm.Data) >= unix.SizeofInet6Pktinfo {
+				cm.Dst = netip.AddrFrom16([16]byte(scm.Data[:16]))
+			}
+		case unix.IPV6_HOPLIMIT:
+			if len(scm.Data) >= 4 {
+				cm.HopLimit = int(binary.NativeEndian.Uint32(scm.Data[:4]))
+			}
+		case unix.IPV6_TCLASS:
+			if len(scm.Data) >= 4 {
+				cm.TrafficClass = int(binary.NativeEndian.Uint32(scm.Data[:4]))
+			}
+		}
 	}
 
-	return m, ip, nil
+	return cm, nil
 }
 
 // setTrafficClass sets the IPv6 Traffic Class socket option.
@@ -165,6 +424,61 @@ func (c *IPv6Conn) setTrafficClass(tc int) error {
 	return c.c.SetsockoptInt(unix.SOL_IPV6, unix.IPV6_TCLASS, tc)
 }
 
+// setHopLimit sets the IPv6 unicast and multicast hop limit socket options.
+func (c *IPv6Conn) setHopLimit(hops int) error {
+	if err := c.c.SetsockoptInt(unix.SOL_IPV6, unix.IPV6_UNICAST_HOPS, hops); err != nil {
+		return err
+	}
+
+	return c.c.SetsockoptInt(unix.SOL_IPV6, unix.IPV6_MULTICAST_HOPS, hops)
+}
+
+// setDontFragment sets the IPv6 path MTU discovery and don't fragment socket
+// options.
+func (c *IPv6Conn) setDontFragment(on bool) error {
+	v, pmtu := 0, unix.IPV6_PMTUDISC_WANT
+	if on {
+		// As with IPv4, ignore the kernel's path MTU cache.
+		v, pmtu = 1, unix.IPV6_PMTUDISC_PROBE
+	}
+
+	if err := c.c.SetsockoptInt(unix.SOL_IPV6, unix.IPV6_MTU_DISCOVER, pmtu); err != nil {
+		return err
+	}
+
+	return c.c.SetsockoptInt(unix.SOL_IPV6, unix.IPV6_DONTFRAG, v)
+}
+
+// setMulticastLoopback sets the IPv6 multicast loopback socket option.
+func (c *IPv6Conn) setMulticastLoopback(on bool) error {
+	var v int
+	if on {
+		v = 1
+	}
+
+	return c.c.SetsockoptInt(unix.SOL_IPV6, unix.IPV6_MULTICAST_LOOP, v)
+}
+
+// joinGroup joins an IPv6 multicast group on the IPv6Conn's interface.
+func (c *IPv6Conn) joinGroup(group netip.Addr) error {
+	return c.c.SetsockoptString(unix.SOL_IPV6, unix.IPV6_JOIN_GROUP, ipv6Mreq(group, c.ifi))
+}
+
+// leaveGroup leaves an IPv6 multicast group on the IPv6Conn's interface.
+func (c *IPv6Conn) leaveGroup(group netip.Addr) error {
+	return c.c.SetsockoptString(unix.SOL_IPV6, unix.IPV6_LEAVE_GROUP, ipv6Mreq(group, c.ifi))
+}
+
+// ipv6Mreq produces the memory layout of a struct ipv6_mreq for group on ifi.
+func ipv6Mreq(group netip.Addr, ifi *net.Interface) string {
+	b := make([]byte, unix.SizeofIPv6Mreq)
+	a := group.As16()
+	copy(b[:16], a[:])
+	binary.NativeEndian.PutUint32(b[16:], uint32(ifi.Index))
+
+	return string(b)
+}
+
 // set applies the IPv6 filter to a *socket.Conn.
 func (f *IPv6Filter) set(c *socket.Conn) error {
 	return c.SetsockoptICMPv6Filter(unix.SOL_ICMPV6, unix.ICMPV6_FILTER, &unix.ICMPv6Filter{Data: f.data})
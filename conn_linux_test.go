@@ -0,0 +1,82 @@
+package icmpx
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func Test_nextHopMTU(t *testing.T) {
+	// A minimal quoted IPv4 header.
+	quote := make([]byte, 20)
+	quote[0] = 0x45
+
+	tests := []struct {
+		name string
+		m    *icmp.Message
+		want *icmp.Message
+	}{
+		{
+			name: "fragmentation needed",
+			m: &icmp.Message{
+				Type: ipv4.ICMPTypeDestinationUnreachable,
+				Code: 4,
+				Body: &icmp.PacketTooBig{MTU: 1400, Data: quote},
+			},
+			want: &icmp.Message{
+				Type: ipv4.ICMPTypeDestinationUnreachable,
+				Code: 4,
+				Body: &icmp.PacketTooBig{MTU: 1400, Data: quote},
+			},
+		},
+		{
+			name: "port unreachable",
+			m: &icmp.Message{
+				Type: ipv4.ICMPTypeDestinationUnreachable,
+				Code: 3,
+				Body: &icmp.DstUnreach{Data: quote},
+			},
+			want: &icmp.Message{
+				Type: ipv4.ICMPTypeDestinationUnreachable,
+				Code: 3,
+				Body: &icmp.DstUnreach{Data: quote},
+			},
+		},
+		{
+			name: "time exceeded",
+			m: &icmp.Message{
+				Type: ipv4.ICMPTypeTimeExceeded,
+				Body: &icmp.TimeExceeded{Data: quote},
+			},
+			want: &icmp.Message{
+				Type: ipv4.ICMPTypeTimeExceeded,
+				Body: &icmp.TimeExceeded{Data: quote},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Marshal the message and parse it as the kernel would return it,
+			// at which point x/net/icmp discards the next-hop MTU.
+			b, err := tt.m.Marshal(nil)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			m, err := icmp.ParseMessage(1, b)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			got := nextHopMTU(m, b)
+			got.Checksum = 0
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected message (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -33,4 +33,13 @@ func (*IPv6Conn) recvfromLocked(_ context.Context) (*icmp.Message, netip.Addr, e
 }
 
 func (*IPv4Conn) setTOS(_ int) error          { return errUnimplemented }
+func (*IPv4Conn) setTTL(_ int) error          { return errUnimplemented }
 func (*IPv6Conn) setTrafficClass(_ int) error { return errUnimplemented }
+func (*IPv6Conn) setHopLimit(_ int) error     { return errUnimplemented }
+
+func (*IPv4Conn) setDontFragment(_ bool) error      { return errUnimplemented }
+func (*IPv6Conn) setDontFragment(_ bool) error      { return errUnimplemented }
+func (*IPv6Conn) setMulticastLoopback(_ bool) error { return errUnimplemented }
+
+func (*IPv6Conn) joinGroup(_ netip.Addr) error  { return errUnimplemented }
+func (*IPv6Conn) leaveGroup(_ netip.Addr) error { return errUnimplemented }
@@ -1,10 +1,12 @@
 package icmpx_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"io"
 	"net"
 	"net/netip"
 	"os"
@@ -128,6 +130,368 @@ func TestIntegrationIPv6Conn(t *testing.T) {
 	}
 }
 
+func TestIntegrationConnCapture(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		listen  func(w io.Writer) (icmpx.Conn, error)
+		dst     netip.Addr
+		typ     icmp.Type
+		version byte
+	}{
+		{
+			name: "IPv4",
+			listen: func(w io.Writer) (icmpx.Conn, error) {
+				return icmpx.ListenIPv4(lo, icmpx.IPv4Config{
+					Filter:  icmpx.IPv4AllowOnly(ipv4.ICMPTypeEchoReply),
+					Capture: w,
+				})
+			},
+			dst:     netip.MustParseAddr("127.0.0.1"),
+			typ:     ipv4.ICMPTypeEcho,
+			version: 4,
+		},
+		{
+			name: "IPv6",
+			listen: func(w io.Writer) (icmpx.Conn, error) {
+				return icmpx.ListenIPv6(lo, icmpx.IPv6Config{
+					Filter:  icmpx.IPv6AllowOnly(ipv6.ICMPTypeEchoReply),
+					Capture: w,
+				})
+			},
+			dst:     netip.IPv6Loopback(),
+			typ:     ipv6.ICMPTypeEchoRequest,
+			version: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			c, err := tt.listen(&buf)
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					t.Skipf("skipping, permission denied")
+				}
+
+				t.Fatalf("failed to listen: %v", err)
+			}
+			defer c.Close()
+
+			_ = ping(t, c, &icmp.Message{
+				Type: tt.typ,
+				Body: &icmp.Echo{
+					ID:   echoID(t),
+					Seq:  1,
+					Data: []byte{0xde, 0xad, 0xbe, 0xef},
+				},
+			}, tt.dst)
+
+			// The capture must contain exactly one outbound request and
+			// one inbound reply, both with IP headers.
+			pkts := pcapngPackets(t, buf.Bytes())
+			if diff := cmp.Diff(2, len(pkts)); diff != "" {
+				t.Fatalf("unexpected number of packets (-want +got):\n%s", diff)
+			}
+
+			for _, p := range pkts {
+				if v := p[0] >> 4; v != tt.version {
+					t.Fatalf("unexpected IP version: %d", v)
+				}
+			}
+		})
+	}
+}
+
+func TestIntegrationIPv4ConnTTL(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	c, err := icmpx.ListenIPv4(lo, icmpx.IPv4Config{
+		Filter:  icmpx.IPv4AllowOnly(ipv4.ICMPTypeEchoReply),
+		Capture: &buf,
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to listen IPv4: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SetTTL(3); err != nil {
+		t.Fatalf("failed to set TTL: %v", err)
+	}
+
+	_ = ping(t, c, &icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{
+			ID:   echoID(t),
+			Seq:  1,
+			Data: []byte{0xde, 0xad, 0xbe, 0xef},
+		},
+	}, netip.MustParseAddr("127.0.0.1"))
+
+	// The first packet is the outbound echo request, whose synthesized header
+	// reflects the TTL reported by the kernel.
+	pkts := pcapngPackets(t, buf.Bytes())
+	if diff := cmp.Diff(3, int(pkts[0][8])); diff != "" {
+		t.Fatalf("unexpected TTL (-want +got):\n%s", diff)
+	}
+}
+
+func TestIntegrationIPv6ConnHopLimit(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	c, err := icmpx.ListenIPv6(lo, icmpx.IPv6Config{
+		Filter:  icmpx.IPv6AllowOnly(ipv6.ICMPTypeEchoReply),
+		Capture: &buf,
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to listen IPv6: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SetHopLimit(255); err != nil {
+		t.Fatalf("failed to set hop limit: %v", err)
+	}
+
+	_ = ping(t, c, &icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: &icmp.Echo{
+			ID:   echoID(t),
+			Seq:  1,
+			Data: []byte{0xde, 0xad, 0xbe, 0xef},
+		},
+	}, netip.IPv6Loopback())
+
+	// The first packet is the outbound echo request, whose synthesized header
+	// reflects the hop limit reported by the kernel.
+	pkts := pcapngPackets(t, buf.Bytes())
+	if diff := cmp.Diff(255, int(pkts[0][7])); diff != "" {
+		t.Fatalf("unexpected hop limit (-want +got):\n%s", diff)
+	}
+}
+
+func TestIntegrationIPv6ConnReceiveHopLimit(t *testing.T) {
+	t.Parallel()
+
+	c, err := icmpx.ListenIPv6(lo, icmpx.IPv6Config{
+		Filter:          icmpx.IPv6AllowOnly(ipv6.ICMPTypeEchoRequest),
+		ReceiveHopLimit: 255,
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to listen IPv6: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Send echo requests to ourselves with varying hop limits. Only the
+	// request which was sent with a hop limit of 255 should be received.
+	id := echoID(t)
+	for i, hops := range []int{64, 255} {
+		if err := c.SetHopLimit(hops); err != nil {
+			t.Fatalf("failed to set hop limit: %v", err)
+		}
+
+		err := c.WriteTo(ctx, &icmp.Message{
+			Type: ipv6.ICMPTypeEchoRequest,
+			Body: &icmp.Echo{ID: id, Seq: i + 1},
+		}, netip.IPv6Loopback())
+		if err != nil {
+			t.Fatalf("failed to write echo: %v", err)
+		}
+	}
+
+	for {
+		m, _, err := c.ReadFrom(ctx)
+		if err != nil {
+			t.Fatalf("failed to read echo: %v", err)
+		}
+
+		// Ignore any requests from concurrent tests.
+		echo := m.Body.(*icmp.Echo)
+		if echo.ID != id {
+			continue
+		}
+
+		if diff := cmp.Diff(2, echo.Seq); diff != "" {
+			t.Fatalf("unexpected sequence (-want +got):\n%s", diff)
+		}
+
+		return
+	}
+}
+
+func TestIntegrationIPv6ConnUnspecifiedSource(t *testing.T) {
+	t.Parallel()
+
+	c, err := icmpx.ListenIPv6(lo, icmpx.IPv6Config{
+		Filter:            icmpx.IPv6AllowOnly(ipv6.ICMPTypeEchoRequest),
+		UnspecifiedSource: true,
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to listen IPv6: %v", err)
+	}
+	defer c.Close()
+
+	if diff := cmp.Diff(netip.IPv6Unspecified(), c.IP, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected IP (-want +got):\n%s", diff)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Send an echo request to ourselves, which should be received from the
+	// unspecified address.
+	req := &icmp.Echo{ID: echoID(t), Seq: 1, Data: []byte{0xde, 0xad, 0xbe, 0xef}}
+	err = c.WriteTo(ctx, &icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: req,
+	}, netip.IPv6Loopback())
+	if err != nil {
+		t.Fatalf("failed to write echo: %v", err)
+	}
+
+	for {
+		m, ip, err := c.ReadFrom(ctx)
+		if err != nil {
+			t.Fatalf("failed to read echo: %v", err)
+		}
+
+		// Ignore any requests from concurrent tests.
+		echo := m.Body.(*icmp.Echo)
+		if echo.ID != req.ID {
+			continue
+		}
+
+		if diff := cmp.Diff(req, echo); diff != "" {
+			t.Fatalf("unexpected echo (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(netip.IPv6Unspecified(), ip, cmp.Comparer(ipEqual)); diff != "" {
+			t.Fatalf("unexpected source IP (-want +got):\n%s", diff)
+		}
+
+		return
+	}
+}
+
+func TestIntegrationIPv6ConnRouterAlert(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	c, err := icmpx.ListenIPv6(lo, icmpx.IPv6Config{
+		Filter:      icmpx.IPv6AllowOnly(ipv6.ICMPTypeEchoReply),
+		RouterAlert: true,
+		Capture:     &buf,
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to listen IPv6: %v", err)
+	}
+	defer c.Close()
+
+	_ = ping(t, c, &icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: &icmp.Echo{
+			ID:   echoID(t),
+			Seq:  1,
+			Data: []byte{0xde, 0xad, 0xbe, 0xef},
+		},
+	}, netip.IPv6Loopback())
+
+	// The outbound echo request carries a Hop-by-Hop Options header with a
+	// Router Alert option, followed by the ICMPv6 message.
+	pkt := pcapngPackets(t, buf.Bytes())[0]
+	want := []byte{
+		0,     // IPv6 Next Header: Hop-by-Hop Options
+		58, 0, // Hop-by-Hop Next Header: ICMPv6, length
+		5, 2, 0, 0, // Router Alert: MLD
+	}
+	got := append([]byte{pkt[6]}, pkt[40:46]...)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected Hop-by-Hop Options (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(byte(ipv6.ICMPTypeEchoRequest), pkt[48]); diff != "" {
+		t.Fatalf("unexpected ICMPv6 type (-want +got):\n%s", diff)
+	}
+}
+
+func TestIntegrationIPv6ConnJoinGroup(t *testing.T) {
+	t.Parallel()
+
+	c, err := icmpx.ListenIPv6(lo, icmpx.IPv6Config{})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to listen IPv6: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.JoinGroup(netip.IPv6Loopback()); err == nil {
+		t.Fatal("expected an error joining a unicast address, but none occurred")
+	}
+
+	group := netip.MustParseAddr("ff02::114")
+	if err := c.JoinGroup(group); err != nil {
+		t.Fatalf("failed to join group: %v", err)
+	}
+	if err := c.LeaveGroup(group); err != nil {
+		t.Fatalf("failed to leave group: %v", err)
+	}
+}
+
+// pcapngPackets returns the packet data from each enhanced packet block in a
+// little-endian pcapng stream.
+func pcapngPackets(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+
+	var pkts [][]byte
+	for len(b) >= 12 {
+		var (
+			typ = binary.LittleEndian.Uint32(b[0:4])
+			n   = int(binary.LittleEndian.Uint32(b[4:8]))
+		)
+		if n < 12 || n > len(b) {
+			t.Fatalf("invalid pcapng block length: %d", n)
+		}
+
+		if typ == 6 {
+			l := binary.LittleEndian.Uint32(b[20:24])
+			pkts = append(pkts, b[28:28+l])
+		}
+
+		b = b[n:]
+	}
+
+	return pkts
+}
+
 func ping(
 	t *testing.T,
 	c icmpx.Conn,
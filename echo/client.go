@@ -1,52 +1,223 @@
 package echo
 
 import (
+	"container/list"
 	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"math"
+	mrand "math/rand"
 	"net"
 	"net/netip"
 	"sync"
 	"time"
 
 	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/quoted"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 	"golang.org/x/sync/errgroup"
 )
 
+// Default Config values.
+const (
+	defaultTimeout    = 1 * time.Second
+	defaultMinTimeout = 200 * time.Millisecond
+	defaultMaxTimeout = 60 * time.Second
+
+	defaultMaxDestinations = 4096
+	defaultIdleTimeout     = 10 * time.Minute
+
+	// maxDestinations leaves half of the 16-bit echo ID space for Pingers,
+	// sweeps, and the quarantined IDs of forgotten destinations.
+	maxDestinations = 1 << 15
+
+	// idQuarantine is how long a released echo ID is not reused, so that
+	// late replies to its echo requests are not mistaken for replies to
+	// another destination.
+	idQuarantine = 1 * time.Minute
+)
+
+// Payload size limits, in bytes.
+const (
+	defaultSize = 56
+
+	// The largest echo payloads which fit in an IPv4 or IPv6 packet, less
+	// the IPv4 header and the echo header.
+	maxSize4 = 65535 - 20 - 8
+	maxSize6 = 65535 - 8
+)
+
+// A Config configures a Client. The zero value is valid and uses sensible
+// defaults.
+type Config struct {
+	// Size is the number of data bytes sent in each echo request, as with
+	// ping -s. Payloads larger than the path MTU are fragmented by the
+	// kernel. If zero, it defaults to 56 bytes.
+	Size int
+
+	// Pattern, if set, is repeated to fill the data of each echo request, as
+	// with ping -p. Otherwise, each destination is sent random data.
+	Pattern []byte
+
+	// Timeout is how long to wait for a reply to the first echo request of
+	// each Ping before sending another. If zero, it defaults to 1 second.
+	Timeout time.Duration
+
+	// Attempts is the maximum number of echo requests sent by each Ping
+	// before it returns an error. If zero, Ping sends echo requests until
+	// its context is canceled.
+	Attempts int
+
+	// Backoff multiplies the timeout after each echo request which does not
+	// receive a reply, up to MaxTimeout. If zero, it defaults to 1 and every
+	// echo request uses the same timeout.
+	Backoff float64
+
+	// Jitter randomly varies each timeout by up to this fraction of its
+	// value, so that many Clients do not retry in lockstep. It must be at
+	// least 0 and less than 1.
+	Jitter float64
+
+	// Adaptive derives the timeout for each destination from its smoothed
+	// round-trip time and round-trip time variance, as described in RFC
+	// 6298, once a reply has been received. Until then, Timeout is used.
+	Adaptive bool
+
+	// MinTimeout is the smallest adaptive timeout, and MaxTimeout is the
+	// largest timeout after backoff is applied. If zero, they default to 200
+	// milliseconds and 60 seconds.
+	MinTimeout, MaxTimeout time.Duration
+
+	// MaxDestinations is the maximum number of destinations for each address
+	// family for which the Client keeps state, such as echo IDs, sequence
+	// numbers, and round-trip time estimates. When it is exceeded, the state
+	// of the least recently used destination without a Ping in progress is
+	// forgotten. If zero, it defaults to 4096. It may be at most 32768, as
+	// each destination uses one of the 65536 echo IDs.
+	MaxDestinations int
+
+	// IdleTimeout is how long the Client keeps state for a destination which
+	// is not being pinged. If zero, it defaults to 10 minutes.
+	IdleTimeout time.Duration
+
+	// OnOrphan, if set, is called with each echo reply which is not
+	// delivered, such as late and duplicate replies. It is called
+	// synchronously by the Client's background reader and must not block.
+	// The Client counts orphaned replies regardless, see Client.Orphans.
+	OnOrphan func(Orphan)
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg Config) withDefaults() (Config, error) {
+	if cfg.Size == 0 {
+		cfg.Size = defaultSize
+	}
+	if cfg.Size < 1 || cfg.Size > maxSize6 {
+		return Config{}, fmt.Errorf("echo: size must be between 1 and %d bytes: %d", maxSize6, cfg.Size)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Timeout < 0 {
+		return Config{}, fmt.Errorf("echo: timeout must be positive: %s", cfg.Timeout)
+	}
+
+	if cfg.Attempts < 0 {
+		return Config{}, fmt.Errorf("echo: attempts must not be negative: %d", cfg.Attempts)
+	}
+
+	if cfg.Backoff == 0 {
+		cfg.Backoff = 1
+	}
+	if cfg.Backoff < 1 {
+		return Config{}, fmt.Errorf("echo: backoff must be at least 1: %v", cfg.Backoff)
+	}
+
+	if cfg.Jitter < 0 || cfg.Jitter >= 1 {
+		return Config{}, fmt.Errorf("echo: jitter must be at least 0 and less than 1: %v", cfg.Jitter)
+	}
+
+	if cfg.MinTimeout == 0 {
+		cfg.MinTimeout = defaultMinTimeout
+	}
+	if cfg.MaxTimeout == 0 {
+		cfg.MaxTimeout = defaultMaxTimeout
+	}
+	if cfg.MinTimeout < 0 || cfg.MinTimeout > cfg.MaxTimeout || cfg.Timeout > cfg.MaxTimeout {
+		return Config{}, fmt.Errorf("echo: timeout %s and minimum timeout %s must be positive and must not exceed maximum timeout %s",
+			cfg.Timeout, cfg.MinTimeout, cfg.MaxTimeout)
+	}
+
+	if cfg.MaxDestinations == 0 {
+		cfg.MaxDestinations = defaultMaxDestinations
+	}
+	if cfg.MaxDestinations < 0 || cfg.MaxDestinations > maxDestinations {
+		return Config{}, fmt.Errorf("echo: maximum destinations must be between 1 and %d: %d",
+			maxDestinations, cfg.MaxDestinations)
+	}
+
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = defaultIdleTimeout
+	}
+	if cfg.IdleTimeout < 0 {
+		return Config{}, fmt.Errorf("echo: idle timeout must be positive: %s", cfg.IdleTimeout)
+	}
+
+	return cfg, nil
+}
+
 // A Client sends ICMPv4/6 echo requests to perform ping operations.
 type Client struct {
 	v4, v6 *connContext
 }
 
 // NewClient binds a Client on the specified network interface.
-func NewClient(ifi *net.Interface) (*Client, error) {
+func NewClient(ifi *net.Interface, cfg Config) (*Client, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
 	c4, err := icmpx.ListenIPv4(ifi, icmpx.IPv4Config{
-		Filter: icmpx.IPv4AllowOnly(ipv4.ICMPTypeEchoReply),
+		Filter: icmpx.IPv4AllowOnly(
+			ipv4.ICMPTypeEchoReply,
+			ipv4.ICMPTypeDestinationUnreachable,
+			ipv4.ICMPTypeTimeExceeded,
+			ipv4.ICMPTypeParameterProblem,
+		),
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	c6, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
-		Filter: icmpx.IPv6AllowOnly(ipv6.ICMPTypeEchoReply),
+		Filter: icmpx.IPv6AllowOnly(
+			ipv6.ICMPTypeEchoReply,
+			ipv6.ICMPTypeDestinationUnreachable,
+			ipv6.ICMPTypePacketTooBig,
+			ipv6.ICMPTypeTimeExceeded,
+			ipv6.ICMPTypeParameterProblem,
+		),
 	})
 	if err != nil {
 		_ = c4.Close()
 		return nil, err
 	}
 
-	return newClient(c4, c6), nil
+	return newClient(cfg, c4, c6), nil
 }
 
-// newClient constructs a Client from raw icmpx.Conns.
-func newClient(c4, c6 icmpx.Conn) *Client {
+// newClient constructs a Client from raw icmpx.Conns. cfg must already have its
+// defaults applied.
+func newClient(cfg Config, c4, c6 icmpx.Conn) *Client {
 	return &Client{
-		v4: newConnContext(ipv4.ICMPTypeEcho, c4),
-		v6: newConnContext(ipv6.ICMPTypeEchoRequest, c6),
+		v4: newConnContext(ipv4.ICMPTypeEcho, c4, cfg, maxSize4),
+		v6: newConnContext(ipv6.ICMPTypeEchoRequest, c6, cfg, maxSize6),
 	}
 }
 
@@ -70,11 +241,25 @@ type Response struct {
 	// received from the target host.
 	Ping, Pong *icmp.Echo
 
+	// Attempts is the number of echo requests sent before a reply was
+	// received.
+	Attempts int
+
 	// IP is the IPv4/6 address of the target host.
 	IP netip.Addr
 }
 
-// Ping performs an ICMPv4/6 echo or "ping" on a target host.
+// Ping performs an ICMPv4/6 echo or "ping" on a target host. Replies which do
+// not carry the same data as the echo request are reported as errors. If an
+// ICMP error such as Destination Unreachable or Time Exceeded is received in
+// reply to an echo request, Ping returns it as an *Error without retrying.
+// Packet Too Big and Fragmentation Needed errors are instead treated as a
+// signal to retry, since the kernel fragments subsequent echo requests to fit
+// the path MTU, and are only returned if Ping runs out of attempts.
+//
+// Ping is safe for concurrent use, including with the same target host. Each
+// call only accepts replies to its own echo requests, matched by ID and
+// sequence number, and a late reply to one attempt never satisfies another.
 func (ec *Client) Ping(ctx context.Context, dst netip.Addr) (*Response, error) {
 	if dst.Is4() {
 		return ec.v4.Ping(ctx, dst)
@@ -89,20 +274,36 @@ type connContext struct {
 	conn icmpx.Conn
 	typ  icmp.Type
 
+	// Echo payload and retry parameters, and the largest payload which fits
+	// in a packet for this family.
+	cfg     Config
+	maxSize int
+
 	// Manages the concurrency of the connContext.
 	eg     *errgroup.Group
 	cancel context.CancelFunc
 
-	// Manages the echo message state per unique destination host.
+	// Manages the echo message state per unique destination host, with the
+	// most recently used destinations at the front of lru.
 	pingsMu sync.Mutex
-	pings   map[netip.Addr]icmp.Echo
-
-	// Manages dispatching ping responses to listeners by the ICMPv4/6 echo ID.
-	resMu     sync.RWMutex
-	responses map[echoID]chan pingResponse
+	dests   map[netip.Addr]*destination
+	lru     *list.List
+
+	// Manages dispatching ping responses to listeners. Each echo request
+	// sent by Ping has a waiter keyed by its ICMPv4/6 echo ID and sequence
+	// number, while subscribers receive every response with their echo ID.
+	// Echo IDs are unique among destinations and subscribers. Responses
+	// which cannot be delivered without blocking are counted as orphans.
+	resMu        sync.Mutex
+	ids          map[echoID]struct{}
+	released     map[echoID]time.Time
+	releaseOrder []echoID
+	waiters      map[replyKey]chan pingResponse
+	subs         map[echoID]chan pingResponse
+	orphanCounts OrphanCounts
 
 	// Swappable parameters for testing.
-	retryDelay time.Duration
+	quarantine time.Duration
 	hooks      testHooks
 }
 
@@ -114,18 +315,26 @@ type testHooks struct {
 	OnRetry func(req *icmp.Echo)
 }
 
-// An echoID is a hint for the keys used in the connContext.responses map.
+// An echoID is a hint for the keys used in the connContext.subs map.
 type echoID = int
 
+// A replyKey identifies the echo request an echo response replies to.
+type replyKey struct {
+	ID, Seq int
+}
+
 // A pingResponse contains an ICMPv4/6 echo response to dispatch to a listener.
+// If Err is set, the response is an ICMP error and Echo holds only the ID and
+// sequence number of the quoted echo request.
 type pingResponse struct {
 	Echo *icmp.Echo
 	IP   netip.Addr
+	Err  *Error
 }
 
 // newConnContext creates a connContext for a given ICMPv4/6 type and socket,
 // starting its background goroutines.
-func newConnContext(typ icmp.Type, conn icmpx.Conn) *connContext {
+func newConnContext(typ icmp.Type, conn icmpx.Conn, cfg Config, maxSize int) *connContext {
 	ctx, cancel := context.WithCancel(context.Background())
 	eg, ctx := errgroup.WithContext(ctx)
 
@@ -133,16 +342,21 @@ func newConnContext(typ icmp.Type, conn icmpx.Conn) *connContext {
 		conn: conn,
 		typ:  typ,
 
+		cfg:     cfg,
+		maxSize: maxSize,
+
 		eg:     eg,
 		cancel: cancel,
 
-		pings: make(map[netip.Addr]icmp.Echo),
+		dests: make(map[netip.Addr]*destination),
+		lru:   list.New(),
 
-		responses: make(map[echoID]chan pingResponse),
+		ids:      make(map[echoID]struct{}),
+		released: make(map[echoID]time.Time),
+		waiters:  make(map[replyKey]chan pingResponse),
+		subs:     make(map[echoID]chan pingResponse),
 
-		// By default, we try sending another echo after 1 second has elapsed
-		// without a reply to a prior attempt.
-		retryDelay: 1 * time.Second,
+		quarantine: idQuarantine,
 	}
 
 	eg.Go(func() error { return cc.readLoop(ctx) })
@@ -169,26 +383,47 @@ var errRetry = errors.New("retry")
 func (cc *connContext) Ping(ctx context.Context, dst netip.Addr) (*Response, error) {
 	start := time.Now()
 
+	// Keep the destination's state until the Ping completes.
+	d, err := cc.acquire(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer cc.release(d)
+
 	// It may take more than one attempt for an echo request to succeed, so send
-	// them at regular intervals until a response is received.
-	for {
+	// them with increasing timeouts until a response is received or we run
+	// out of attempts.
+	for attempt := 1; ; attempt++ {
 		// Generates an appropriate echo message for the target while also
-		// maintaining the appropriate sequence number state.
-		echo, err := cc.echo(dst)
+		// maintaining the appropriate sequence number state. Each attempt
+		// has its own sequence number, so only a reply to this exact echo
+		// request is delivered on resC.
+		echo, resC, err := cc.echo(d)
 		if err != nil {
 			return nil, err
 		}
 
-		switch res, err := cc.doPing(ctx, start, echo, dst); {
+		switch res, err := cc.doPing(ctx, start, echo, resC, d, cc.timeout(d, attempt)); {
 		case err == nil:
 			// Ping succeeded.
+			res.Attempts = attempt
 			return res, nil
 		case errors.Is(err, errRetry):
+			if attempt == cc.cfg.Attempts {
+				var eerr *Error
+				if errors.As(err, &eerr) {
+					return nil, eerr
+				}
+
+				return nil, fmt.Errorf("echo: no reply from %s after %d attempts", dst, attempt)
+			}
+
 			if cc.hooks.OnRetry != nil {
 				cc.hooks.OnRetry(echo)
 			}
 
-			// Timed out waiting for a response. Try again.
+			// Timed out waiting for a response, or the path MTU changed.
+			// Try again.
 			continue
 		default:
 			// Unhandled error.
@@ -197,45 +432,64 @@ func (cc *connContext) Ping(ctx context.Context, dst netip.Addr) (*Response, err
 	}
 }
 
-// doPing performs a single echo request/response cycle with a short timeout. If
-// the ping does not receive a timely response, it returns errRetry.
+// doPing performs a single echo request/response cycle with the specified
+// timeout. If the ping does not receive a timely response, it returns errRetry.
+// If the echo request exceeded the path MTU, it returns errRetry wrapped with
+// the *Error which reported it.
 func (cc *connContext) doPing(
 	ctx context.Context,
 	start time.Time,
 	echo *icmp.Echo,
-	dst netip.Addr,
+	resC <-chan pingResponse,
+	d *destination,
+	timeout time.Duration,
 ) (*Response, error) {
+	defer cc.unwait(replyKey{ID: echo.ID, Seq: echo.Seq})
+
 	msg := &icmp.Message{
 		Type: cc.typ,
 		Body: echo,
 	}
 
-	if err := cc.conn.WriteTo(ctx, msg, dst); err != nil {
+	if err := cc.conn.WriteTo(ctx, msg, d.ip); err != nil {
 		return nil, err
 	}
+	sent := time.Now()
 
 	// Once a ping has been sent, wait for the background reader to notify
-	// us of a matching response by ID. If we receive none in a short period
-	// of time, tell the caller to try again.
-	cc.resMu.RLock()
-	defer cc.resMu.RUnlock()
+	// us of a matching response by ID and sequence number. If we receive none
+	// in a short period of time, tell the caller to try again.
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-resC:
+		if res.Err != nil {
+			if res.Err.tooBig() {
+				return nil, fmt.Errorf("%w: %w", errRetry, res.Err)
+			}
 
-	tickC := time.After(cc.retryDelay)
-	for {
-		select {
-		case res := <-cc.responses[echo.ID]:
-			// TODO(mdlayher): check for sequence/data mismatch.
-			return &Response{
-				Duration: time.Since(start),
-				Ping:     echo,
-				Pong:     res.Echo,
-				IP:       res.IP,
-			}, nil
-		case <-tickC:
-			return nil, errRetry
-		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, res.Err
 		}
+
+		if err := validate(echo, res.Echo); err != nil {
+			return nil, fmt.Errorf("echo: invalid reply from %s: %w", res.IP, err)
+		}
+
+		// Replies are matched to a single attempt by sequence number, so
+		// the round-trip time sample is unambiguous.
+		cc.sample(d, time.Since(sent))
+
+		return &Response{
+			Duration: time.Since(start),
+			Ping:     echo,
+			Pong:     res.Echo,
+			IP:       res.IP,
+		}, nil
+	case <-timer.C:
+		return nil, errRetry
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
@@ -251,56 +505,291 @@ func (cc *connContext) readLoop(ctx context.Context) error {
 			return err
 		}
 
-		// Our ICMP filter guarantees that all messages are echoes.
-		echo := msg.Body.(*icmp.Echo)
+		res, ok := cc.response(msg, ip)
+		if !ok {
+			continue
+		}
+
+		if reason, ok := cc.dispatch(res); !ok && cc.cfg.OnOrphan != nil {
+			cc.cfg.OnOrphan(Orphan{
+				Reason: reason,
+				Echo:   res.Echo,
+				IP:     res.IP,
+				Err:    res.Err,
+			})
+		}
+	}
+}
+
+// response produces a pingResponse from an echo reply, or from an ICMP error
+// which quotes an echo request sent on this connContext.
+func (cc *connContext) response(msg *icmp.Message, ip netip.Addr) (pingResponse, bool) {
+	// Our ICMP filter guarantees that all other messages are errors.
+	if echo, ok := msg.Body.(*icmp.Echo); ok {
+		return pingResponse{Echo: echo, IP: ip}, true
+	}
+
+	d, err := quoted.FromMessage(msg)
+	if err != nil || d.Echo == nil || d.Echo.Type != cc.typ {
+		// Not an error in reply to an echo request.
+		return pingResponse{}, false
+	}
+
+	return pingResponse{
+		Echo: &icmp.Echo{ID: d.Echo.ID, Seq: d.Echo.Seq},
+		IP:   ip,
+		Err: &Error{
+			IP:   ip,
+			Dst:  d.Dst,
+			Type: msg.Type,
+			Code: msg.Code,
+		},
+	}, true
+}
+
+// dispatch delivers res to its listener without blocking. If res cannot be
+// delivered, it is counted as an orphan and dispatch reports why.
+func (cc *connContext) dispatch(res pingResponse) (OrphanReason, bool) {
+	cc.resMu.Lock()
+	defer cc.resMu.Unlock()
+
+	var (
+		id     = res.Echo.ID
+		reason OrphanReason
+	)
+
+	if resC, ok := cc.waiters[replyKey{ID: id, Seq: res.Echo.Seq}]; ok {
+		// A Ping is waiting for this echo response. Only the first reply
+		// matters, so any others are duplicates.
+		select {
+		case resC <- res:
+			return 0, true
+		default:
+			reason = OrphanDuplicate
+		}
+	} else if resC, ok := cc.subs[id]; ok {
+		// A subscriber is draining all responses for this ID, but may
+		// have fallen behind.
+		select {
+		case resC <- res:
+			return 0, true
+		default:
+			reason = OrphanDropped
+		}
+	} else {
+		// Replies to quarantined IDs are late.
+		_, inUse := cc.ids[id]
+		_, released := cc.released[id]
+
+		reason = OrphanUnknown
+		if inUse || released {
+			reason = OrphanLate
+		}
+	}
+
+	cc.orphanCounts.add(reason)
+	return reason, false
+}
+
+// allocID allocates an echo ID which is not in use and has not been released
+// recently. If every ID which is not in use has been released recently, the
+// ID which was released first is reused early. The caller must hold resMu.
+func (cc *connContext) allocID() (echoID, error) {
+	// End the quarantine of IDs released long enough ago.
+	now := time.Now()
+	for len(cc.releaseOrder) > 0 {
+		id := cc.releaseOrder[0]
+		if now.Sub(cc.released[id]) < cc.quarantine {
+			break
+		}
+
+		cc.releaseOrder = cc.releaseOrder[1:]
+		delete(cc.released, id)
+	}
+
+	if len(cc.ids)+len(cc.released) > 0xffff {
+		if len(cc.releaseOrder) == 0 {
+			return 0, errors.New("echo: all echo IDs are in use")
+		}
+
+		id := cc.releaseOrder[0]
+		cc.releaseOrder = cc.releaseOrder[1:]
+		delete(cc.released, id)
+
+		cc.ids[id] = struct{}{}
+		return id, nil
+	}
+
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+
+	// Start at a random ID and search for the next one which is free. At
+	// least one is.
+	start := int(binary.BigEndian.Uint16(b[:]))
+	for i := 0; ; i++ {
+		id := (start + i) & 0xffff
+		if _, ok := cc.ids[id]; ok {
+			continue
+		}
+		if _, ok := cc.released[id]; ok {
+			continue
+		}
+
+		cc.ids[id] = struct{}{}
+		return id, nil
+	}
+}
+
+// releaseID releases an echo ID allocated by allocID, and quarantines it. The
+// caller must hold resMu.
+func (cc *connContext) releaseID(id echoID) {
+	delete(cc.ids, id)
+	cc.released[id] = time.Now()
+	cc.releaseOrder = append(cc.releaseOrder, id)
+}
+
+// unwait removes the waiter for the echo request identified by key.
+func (cc *connContext) unwait(key replyKey) {
+	cc.resMu.Lock()
+	defer cc.resMu.Unlock()
+
+	delete(cc.waiters, key)
+}
+
+// subscribe allocates an echo ID which is not in use, and returns a channel
+// which receives every echo response with that ID until unsubscribe is called.
+func (cc *connContext) subscribe() (echoID, <-chan pingResponse, error) {
+	cc.resMu.Lock()
+	defer cc.resMu.Unlock()
+
+	id, err := cc.allocID()
+	if err != nil {
+		return 0, nil, err
+	}
 
-		cc.resMu.RLock()
-		if pingC, ok := cc.responses[echo.ID]; ok {
-			// A caller is waiting for this echo response.
-			pingC <- pingResponse{
-				Echo: echo,
-				IP:   ip,
+	// The subscriber drains the channel continuously, but allow for bursts
+	// of replies.
+	resC := make(chan pingResponse, 64)
+	cc.subs[id] = resC
+	return id, resC, nil
+}
+
+// unsubscribe stops delivering echo responses for an ID allocated by subscribe.
+func (cc *connContext) unsubscribe(id echoID) {
+	cc.resMu.Lock()
+	defer cc.resMu.Unlock()
+	delete(cc.subs, id)
+	cc.releaseID(id)
+}
+
+// timeout computes the timeout for the specified attempt, counting from 1, of a
+// Ping to d.
+func (cc *connContext) timeout(d *destination, attempt int) time.Duration {
+	timeout := cc.cfg.Timeout
+	if cc.cfg.Adaptive {
+		cc.pingsMu.Lock()
+		if d.rtt.ok {
+			timeout = d.rtt.RTO()
+			if timeout < cc.cfg.MinTimeout {
+				timeout = cc.cfg.MinTimeout
 			}
 		}
-		cc.resMu.RUnlock()
+		cc.pingsMu.Unlock()
+	}
+
+	// Back off exponentially up to the maximum, then apply jitter.
+	t := float64(timeout) * math.Pow(cc.cfg.Backoff, float64(attempt-1))
+	if t > float64(cc.cfg.MaxTimeout) {
+		t = float64(cc.cfg.MaxTimeout)
 	}
+	if cc.cfg.Jitter > 0 {
+		t *= 1 + cc.cfg.Jitter*(2*mrand.Float64()-1)
+	}
+
+	return time.Duration(t)
+}
+
+// sample records a round-trip time measurement for d.
+func (cc *connContext) sample(d *destination, rtt time.Duration) {
+	cc.pingsMu.Lock()
+	defer cc.pingsMu.Unlock()
+
+	d.rtt.Sample(rtt)
 }
 
 // echo generates an ICMP echo message while also doing bookkeeping around the
-// ID, sequence number, and opaque data.
-func (cc *connContext) echo(ip netip.Addr) (*icmp.Echo, error) {
+// ID, sequence number, and opaque data. It registers a waiter for the reply to
+// the message, which is delivered on the returned channel until unwait is
+// called.
+func (cc *connContext) echo(d *destination) (*icmp.Echo, <-chan pingResponse, error) {
 	cc.pingsMu.Lock()
 	defer cc.pingsMu.Unlock()
 
-	if echo, ok := cc.pings[ip]; ok {
-		// Already have a message for this host, increment the sequence number
-		// and return.
-		echo.Seq++
-		cc.pings[ip] = echo
-		return &echo, nil
+	cc.resMu.Lock()
+	defer cc.resMu.Unlock()
+
+	// Increment the sequence number, which is 16 bits on the wire. Once it
+	// wraps, skip any which are still awaiting a reply.
+	echo := d.echo
+	for i := 0; ; i++ {
+		if i > 0xffff {
+			return nil, nil, fmt.Errorf("echo: too many echo requests awaiting a reply from %s", d.ip)
+		}
+
+		echo.Seq = nextSeq(echo.Seq)
+		if _, ok := cc.waiters[replyKey{ID: echo.ID, Seq: echo.Seq}]; !ok {
+			break
+		}
 	}
+	d.echo = echo
 
-	// New host, generate opaque random data and set up an initial message with
-	// a unique ID.
-	data := make([]byte, 8)
-	if _, err := rand.Read(data); err != nil {
-		return nil, err
+	resC := make(chan pingResponse, 1)
+	cc.waiters[replyKey{ID: echo.ID, Seq: echo.Seq}] = resC
+
+	return &echo, resC, nil
+}
+
+// validate verifies that the echo reply rep carries the same data as the echo
+// request req.
+func validate(req, rep *icmp.Echo) error {
+	if len(rep.Data) != len(req.Data) {
+		return fmt.Errorf("expected %d data bytes, but got %d", len(req.Data), len(rep.Data))
 	}
 
-	echo := icmp.Echo{
-		ID:   int(binary.BigEndian.Uint16(data[:2])),
-		Seq:  1,
-		Data: data,
+	for i := range req.Data {
+		if req.Data[i] != rep.Data[i] {
+			return fmt.Errorf("wrong data byte #%d: expected %#02x, but got %#02x",
+				i, req.Data[i], rep.Data[i])
+		}
 	}
-	cc.pings[ip] = echo
 
-	cc.resMu.Lock()
-	defer cc.resMu.Unlock()
+	return nil
+}
 
-	// Perform the initial setup for this ID's responses.
-	if _, ok := cc.responses[echo.ID]; !ok {
-		cc.responses[echo.ID] = make(chan pingResponse, 1)
+// payload generates the data for echo requests to ip.
+func (cc *connContext) payload(ip netip.Addr) ([]byte, error) {
+	if cc.cfg.Size > cc.maxSize {
+		return nil, fmt.Errorf("echo: size %d exceeds the maximum of %d bytes for %s",
+			cc.cfg.Size, cc.maxSize, ip)
 	}
 
-	return &echo, nil
+	data := make([]byte, cc.cfg.Size)
+	if p := cc.cfg.Pattern; len(p) > 0 {
+		for i := range data {
+			data[i] = p[i%len(p)]
+		}
+
+		return data, nil
+	}
+
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
 }
+
+// nextSeq returns the sequence number which follows seq, wrapping at 16 bits.
+func nextSeq(seq int) int { return (seq + 1) & 0xffff }
@@ -11,10 +11,13 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/mdlayher/icmpx/echo"
+	"github.com/mdlayher/icmpx/internal/testns"
 	"golang.org/x/net/nettest"
 	"golang.org/x/sync/errgroup"
 )
 
+func TestMain(m *testing.M) { testns.Main(m) }
+
 func TestIntegrationClient(t *testing.T) {
 	t.Parallel()
 
@@ -23,7 +26,7 @@ func TestIntegrationClient(t *testing.T) {
 		t.Fatalf("failed to find loopback: %v", err)
 	}
 
-	c, err := echo.NewClient(lo)
+	c, err := echo.NewClient(lo, echo.Config{})
 	if err != nil {
 		// ICMP sockets require elevated privileges.
 		if errors.Is(err, os.ErrPermission) {
@@ -71,4 +74,140 @@ func TestIntegrationClient(t *testing.T) {
 	}
 }
 
+func TestIntegrationClientPingFragmented(t *testing.T) {
+	// Echo requests and replies larger than the path MTU are fragmented, and
+	// must be reassembled in full.
+	tests := []struct {
+		name      string
+		routers   int
+		link, mtu int
+	}{
+		{
+			// The local node fragments echo requests itself.
+			name: "local link",
+			link: 0,
+			mtu:  1280,
+		},
+		{
+			// The router reports the smaller MTU of its link to the target
+			// and the local node fragments subsequent echo requests.
+			name:    "router link",
+			routers: 1,
+			link:    1,
+			mtu:     1280,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := testns.Routers(t, tt.routers)
+			path.SetMTU(t, tt.link, tt.mtu)
+
+			const size = 4000
+			c, err := echo.NewClient(path.Interface, echo.Config{Size: size, Attempts: 3})
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					t.Skipf("skipping, permission denied")
+				}
+
+				t.Fatalf("failed to create client: %v", err)
+			}
+			defer c.Close()
+
+			for _, ip := range []netip.Addr{path.Target4, path.Target6} {
+				t.Run(ip.String(), func(t *testing.T) {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+
+					got, err := c.Ping(ctx, ip)
+					if err != nil {
+						t.Fatalf("failed to ping: %v", err)
+					}
+
+					if diff := cmp.Diff(size, len(got.Pong.Data)); diff != "" {
+						t.Fatalf("unexpected reply size (-want +got):\n%s", diff)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestIntegrationClientPingTooBig(t *testing.T) {
+	// The router's link to the target has a smaller MTU than the local link,
+	// so the first echo request elicits Fragmentation Needed or Packet Too
+	// Big and the next is fragmented to fit the updated path MTU.
+	path := testns.Routers(t, 1)
+	path.SetMTU(t, 1, 1280)
+
+	c, err := echo.NewClient(path.Interface, echo.Config{Size: 1400, Attempts: 3})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	for _, ip := range []netip.Addr{path.Target4, path.Target6} {
+		t.Run(ip.String(), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			got, err := c.Ping(ctx, ip)
+			if err != nil {
+				t.Fatalf("failed to ping: %v", err)
+			}
+
+			if diff := cmp.Diff(2, got.Attempts); diff != "" {
+				t.Fatalf("unexpected attempts (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func ipEqual(x, y netip.Addr) bool { return x == y }
+
+func TestIntegrationClientSweep(t *testing.T) {
+	t.Parallel()
+
+	lo, err := nettest.LoopbackInterface()
+	if err != nil {
+		t.Fatalf("failed to find loopback: %v", err)
+	}
+
+	c, err := echo.NewClient(lo, echo.Config{})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Every address in 127.0.0.0/8 is local.
+	got := make(map[netip.Addr]int)
+	err = c.Sweep(ctx, []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.0/29"),
+		netip.MustParsePrefix("::1/128"),
+	}, echo.SweepConfig{Rate: 1000, Count: 2, Interval: 10 * time.Millisecond}, func(r *echo.SweepResult) {
+		got[r.IP] = r.Statistics.Received
+	})
+	if err != nil {
+		t.Fatalf("failed to sweep: %v", err)
+	}
+
+	want := map[netip.Addr]int{netip.IPv6Loopback(): 2}
+	for ip := netip.MustParseAddr("127.0.0.1"); ip.As4()[3] < 7; ip = ip.Next() {
+		want[ip] = 2
+	}
+
+	if diff := cmp.Diff(want, got, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected replies (-want +got):\n%s", diff)
+	}
+}
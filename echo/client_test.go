@@ -1,7 +1,10 @@
 package echo
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"math/rand"
 	"net/netip"
 	"sync"
 	"sync/atomic"
@@ -10,6 +13,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/impair"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -100,12 +104,467 @@ func TestClientPingRetry(t *testing.T) {
 			t.Fatalf("unexpected ping sequence (-want +got):\n%s", diff)
 		}
 
+		if diff := cmp.Diff(2, res.Attempts); diff != "" {
+			t.Fatalf("unexpected number of attempts (-want +got):\n%s", diff)
+		}
+
 		if diff := cmp.Diff(c.Host6.IP, res.IP, cmp.Comparer(ipEqual)); diff != "" {
 			t.Fatalf("unexpected pong IP (-want +got):\n%s", diff)
 		}
 	})
 }
 
+func TestClientPingAttempts(t *testing.T) {
+	// Emulate a host which never replies, so the Client must give up after
+	// the configured number of attempts.
+	c := testClientConfig(t, Config{
+		Timeout:  10 * time.Millisecond,
+		Attempts: 3,
+		Backoff:  2,
+	})
+	c.Host4.OnEcho = func(*icmp.Echo) *icmp.Echo { return nil }
+
+	var retries atomic.Int32
+	c.Client.v4.hooks = testHooks{
+		OnRetry: func(_ *icmp.Echo) { retries.Add(1) },
+	}
+
+	start := time.Now()
+	if _, err := c.Client.Ping(context.Background(), c.Host4.IP); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+
+	// The timeouts are 10ms, 20ms, then 40ms.
+	if d := time.Since(start); d < 70*time.Millisecond {
+		t.Fatalf("gave up after %s, before the timeouts elapsed", d)
+	}
+
+	if diff := cmp.Diff(int32(2), retries.Load()); diff != "" {
+		t.Fatalf("unexpected number of retries (-want +got):\n%s", diff)
+	}
+}
+
+func TestConnContextTimeout(t *testing.T) {
+	dst := netip.MustParseAddr("2001:db8::1")
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		samples []time.Duration
+		want    []time.Duration
+	}{
+		{
+			name: "constant",
+			cfg:  Config{Timeout: time.Second},
+			want: []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name: "backoff",
+			cfg:  Config{Timeout: time.Second, Backoff: 2, MaxTimeout: 3 * time.Second},
+			want: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		},
+		{
+			name: "adaptive no samples",
+			cfg:  Config{Timeout: time.Second, Adaptive: true},
+			want: []time.Duration{time.Second},
+		},
+		{
+			// SRTT 100ms, RTTVAR 50ms: RTO = 100ms + 4*50ms.
+			name:    "adaptive",
+			cfg:     Config{Timeout: time.Second, Backoff: 2, Adaptive: true},
+			samples: []time.Duration{100 * time.Millisecond},
+			want:    []time.Duration{300 * time.Millisecond, 600 * time.Millisecond},
+		},
+		{
+			name:    "adaptive minimum",
+			cfg:     Config{Timeout: time.Second, Adaptive: true, MinTimeout: 50 * time.Millisecond},
+			samples: []time.Duration{time.Millisecond},
+			want:    []time.Duration{50 * time.Millisecond},
+		},
+		{
+			name:    "not adaptive",
+			cfg:     Config{Timeout: time.Second},
+			samples: []time.Duration{100 * time.Millisecond},
+			want:    []time.Duration{time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.cfg.withDefaults()
+			if err != nil {
+				t.Fatalf("failed to apply defaults: %v", err)
+			}
+
+			var (
+				cc = &connContext{cfg: cfg}
+				d  = &destination{ip: dst}
+			)
+
+			for _, r := range tt.samples {
+				cc.sample(d, r)
+			}
+
+			var got []time.Duration
+			for i := range tt.want {
+				got = append(got, cc.timeout(d, i+1))
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected timeouts (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConnContextTimeoutJitter(t *testing.T) {
+	cfg, err := Config{Timeout: time.Second, Jitter: 0.5}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	cc := &connContext{cfg: cfg}
+
+	for i := 0; i < 100; i++ {
+		d := cc.timeout(&destination{ip: netip.MustParseAddr("192.0.2.1")}, 1)
+		if d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("timeout %s is outside of the jitter bounds", d)
+		}
+	}
+}
+
+func TestClientPingImpaired(t *testing.T) {
+	// Emulate a lossy link with some latency, forcing the Client to retry
+	// until each ping eventually succeeds.
+	var (
+		host4 = newTestHost(t, netip.MustParseAddr("192.0.2.0"))
+		host6 = newTestHost(t, netip.MustParseAddr("2001:db8::1"))
+	)
+
+	cfg, err := Config{Timeout: 100 * time.Millisecond}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	c := newClient(cfg, host4, impair.New(host6, impair.Config{
+		Write: impair.Impairment{Loss: impair.RandomLoss(0.5)},
+		Read: impair.Impairment{
+			Latency: 10 * time.Millisecond,
+			Jitter:  5 * time.Millisecond,
+		},
+		Source: rand.NewSource(1),
+	}))
+	var retries atomic.Int32
+	c.v6.hooks = testHooks{
+		OnRetry: func(_ *icmp.Echo) { retries.Add(1) },
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := c.Ping(context.Background(), host6.IP); err != nil {
+			t.Fatalf("failed to ping: %v", err)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	if retries.Load() == 0 {
+		t.Fatal("expected at least one retry due to packet loss")
+	}
+}
+
+func TestClientPingPayload(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want []byte
+	}{
+		{
+			name: "pattern",
+			cfg:  Config{Size: 5, Pattern: []byte{0xff, 0x00}},
+			want: []byte{0xff, 0x00, 0xff, 0x00, 0xff},
+		},
+		{
+			name: "large",
+			cfg:  Config{Size: 9000, Pattern: []byte{0xaa}},
+			want: bytes.Repeat([]byte{0xaa}, 9000),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testClientConfig(t, tt.cfg)
+
+			res, err := c.Client.Ping(context.Background(), c.Host6.IP)
+			if err != nil {
+				t.Fatalf("failed to ping: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, res.Ping.Data); diff != "" {
+				t.Fatalf("unexpected ping data (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(res.Ping, res.Pong); diff != "" {
+				t.Fatalf("unexpected ping/pong pair (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientPingInvalidReply(t *testing.T) {
+	tests := []struct {
+		name   string
+		onEcho func(req *icmp.Echo) *icmp.Echo
+	}{
+		{
+			name: "corrupted",
+			onEcho: func(req *icmp.Echo) *icmp.Echo {
+				data := append([]byte(nil), req.Data...)
+				data[10] ^= 0xff
+
+				return &icmp.Echo{ID: req.ID, Seq: req.Seq, Data: data}
+			},
+		},
+		{
+			name: "truncated",
+			onEcho: func(req *icmp.Echo) *icmp.Echo {
+				return &icmp.Echo{ID: req.ID, Seq: req.Seq, Data: req.Data[:8]}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testClient(t)
+			c.Host4.OnEcho = tt.onEcho
+
+			if _, err := c.Client.Ping(context.Background(), c.Host4.IP); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestClientPingSequenceMismatch(t *testing.T) {
+	// Emulate a host which replies with the wrong sequence number for the
+	// first request, which must not be accepted as a reply.
+	c := testClient(t)
+
+	var recv atomic.Bool
+	c.Host6.OnEcho = func(req *icmp.Echo) *icmp.Echo {
+		if recv.Swap(true) {
+			return req
+		}
+
+		return &icmp.Echo{ID: req.ID, Seq: req.Seq + 1, Data: req.Data}
+	}
+
+	res, err := c.Client.Ping(context.Background(), c.Host6.IP)
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+
+	if diff := cmp.Diff(2, res.Pong.Seq); diff != "" {
+		t.Fatalf("unexpected pong sequence (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientPingConcurrent(t *testing.T) {
+	// Emulate a host which holds replies until it has received a request
+	// from every concurrent Ping, then replies in reverse order. Each Ping
+	// must receive the reply to its own request.
+	const n = 8
+
+	var (
+		mu   sync.Mutex
+		reqs []*icmp.Echo
+	)
+
+	conn := newReplyConn(ipv6.ICMPTypeEchoReply, func(req *icmp.Echo, _ netip.Addr) []*icmp.Echo {
+		mu.Lock()
+		defer mu.Unlock()
+
+		reqs = append([]*icmp.Echo{req}, reqs...)
+		if len(reqs) < n {
+			return nil
+		}
+
+		return reqs
+	})
+
+	cfg, err := Config{Timeout: 5 * time.Second}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	c := newClient(cfg, newReplyConn(nil, nil), conn)
+	defer c.Close()
+
+	var (
+		eg   errgroup.Group
+		seqs sync.Map
+	)
+
+	for i := 0; i < n; i++ {
+		eg.Go(func() error {
+			res, err := c.Ping(context.Background(), netip.MustParseAddr("2001:db8::1"))
+			if err != nil {
+				return err
+			}
+
+			if diff := cmp.Diff(res.Ping, res.Pong); diff != "" {
+				return fmt.Errorf("unexpected ping/pong pair (-want +got):\n%s", diff)
+			}
+			if _, ok := seqs.LoadOrStore(res.Ping.Seq, true); ok {
+				return fmt.Errorf("duplicate sequence number %d", res.Ping.Seq)
+			}
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+}
+
+func TestClientPingLateReply(t *testing.T) {
+	// Emulate a host which only replies to the first request once the retry
+	// has been sent. The late reply must not satisfy the retry.
+	var first *icmp.Echo
+	conn := newReplyConn(ipv6.ICMPTypeEchoReply, func(req *icmp.Echo, _ netip.Addr) []*icmp.Echo {
+		if first == nil {
+			first = req
+			return nil
+		}
+
+		return []*icmp.Echo{first, req}
+	})
+
+	cfg, err := Config{Timeout: 50 * time.Millisecond}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	c := newClient(cfg, newReplyConn(nil, nil), conn)
+	defer c.Close()
+
+	res, err := c.Ping(context.Background(), netip.MustParseAddr("2001:db8::1"))
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+
+	if diff := cmp.Diff(2, res.Pong.Seq); diff != "" {
+		t.Fatalf("unexpected pong sequence (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(2, res.Attempts); diff != "" {
+		t.Fatalf("unexpected number of attempts (-want +got):\n%s", diff)
+	}
+}
+
+func TestConnContextAllocID(t *testing.T) {
+	cc := &connContext{
+		ids:        make(map[echoID]struct{}),
+		released:   make(map[echoID]time.Time),
+		quarantine: time.Hour,
+	}
+
+	// Every ID can be allocated exactly once.
+	for i := 0; i <= 0xffff; i++ {
+		if _, err := cc.allocID(); err != nil {
+			t.Fatalf("failed to allocate ID %d: %v", i, err)
+		}
+	}
+
+	if diff := cmp.Diff(1<<16, len(cc.ids)); diff != "" {
+		t.Fatalf("unexpected number of IDs (-want +got):\n%s", diff)
+	}
+
+	if _, err := cc.allocID(); err == nil {
+		t.Fatal("expected an error allocating an ID, but none occurred")
+	}
+
+	// Released IDs are quarantined, but once no other IDs are free the ID
+	// released first is reused early rather than failing.
+	cc.releaseID(2)
+	cc.releaseID(1)
+
+	var got []int
+	for i := 0; i < 2; i++ {
+		id, err := cc.allocID()
+		if err != nil {
+			t.Fatalf("failed to allocate quarantined ID: %v", err)
+		}
+
+		got = append(got, id)
+	}
+
+	if diff := cmp.Diff([]int{2, 1}, got); diff != "" {
+		t.Fatalf("unexpected IDs (-want +got):\n%s", diff)
+	}
+
+	// IDs whose quarantine has ended are preferred over quarantined IDs.
+	cc.releaseID(1)
+	cc.released[1] = time.Now().Add(-2 * time.Hour)
+	cc.releaseID(3)
+
+	id, err := cc.allocID()
+	if err != nil {
+		t.Fatalf("failed to allocate ID: %v", err)
+	}
+
+	if diff := cmp.Diff(1, id); diff != "" {
+		t.Fatalf("unexpected ID (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Size: -1},
+		{Size: 65528},
+		{Timeout: -1},
+		{Attempts: -1},
+		{Backoff: 0.5},
+		{Jitter: -0.1},
+		{Jitter: 1},
+		{MinTimeout: -1},
+		{MinTimeout: 2 * time.Minute},
+		{Timeout: 2 * time.Second, MaxTimeout: time.Second},
+		{MaxDestinations: -1},
+		{MaxDestinations: 1<<15 + 1},
+		{IdleTimeout: -1},
+	} {
+		if _, err := cfg.withDefaults(); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+
+	cfg, err := Config{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	want := Config{
+		Size:            56,
+		Timeout:         1 * time.Second,
+		Backoff:         1,
+		MinTimeout:      200 * time.Millisecond,
+		MaxTimeout:      60 * time.Second,
+		MaxDestinations: 4096,
+		IdleTimeout:     10 * time.Minute,
+	}
+
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatalf("unexpected config (-want +got):\n%s", diff)
+	}
+
+	// IPv4 payloads are limited by the smaller maximum packet size.
+	c := testClientConfig(t, Config{Size: 65508})
+	if _, err := c.Client.Ping(context.Background(), c.Host4.IP); err == nil {
+		t.Fatal("expected an error for an oversized IPv4 payload, but none occurred")
+	}
+}
+
 var _ icmpx.Conn = &testHost{}
 
 // A testHost implements icmpx.Conn by emulating a host that replies to ICMPv4/6
@@ -124,16 +583,29 @@ type client struct {
 
 // testClient sets up a Client that talks to emulated hosts.
 func testClient(t *testing.T) *client {
+	return testClientConfig(t, Config{})
+}
+
+// testClientConfig sets up a Client with cfg that talks to emulated hosts.
+func testClientConfig(t *testing.T, cfg Config) *client {
+	t.Helper()
+
+	// Speed up retries for tests.
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 100 * time.Millisecond
+	}
+
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
 	var (
 		host4 = newTestHost(t, netip.MustParseAddr("192.0.2.0"))
 		host6 = newTestHost(t, netip.MustParseAddr("2001:db8::1"))
 	)
 
-	c := newClient(host4, host6)
-
-	// Speed up retries for tests.
-	c.v4.retryDelay = 100 * time.Millisecond
-	c.v6.retryDelay = 100 * time.Millisecond
+	c := newClient(cfg, host4, host6)
 
 	t.Cleanup(func() {
 		if err := c.Close(); err != nil {
@@ -0,0 +1,151 @@
+package echo
+
+import (
+	"container/list"
+	"net/netip"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// A destination is the state kept by a connContext for a destination host.
+// Its fields are guarded by connContext.pingsMu.
+type destination struct {
+	ip netip.Addr
+
+	// The most recent echo request sent to ip, and its round-trip time
+	// estimate.
+	echo icmp.Echo
+	rtt  rttEstimator
+
+	// When the destination was last used and the number of Pings in
+	// progress, which prevent it from being evicted.
+	used     time.Time
+	inflight int
+
+	// The destination's element in connContext.lru, or nil once it has been
+	// forgotten.
+	elem *list.Element
+}
+
+// Forget discards the state the Client keeps for dst, such as its echo ID,
+// sequence number, and round-trip time estimate, so that the next Ping to dst
+// starts afresh. Pings to dst which are in progress are unaffected.
+func (ec *Client) Forget(dst netip.Addr) {
+	cc := ec.v6
+	if dst.Is4() {
+		cc = ec.v4
+	}
+
+	cc.pingsMu.Lock()
+	defer cc.pingsMu.Unlock()
+
+	if d, ok := cc.dests[dst]; ok {
+		cc.forget(d)
+	}
+}
+
+// acquire returns the state for dst, creating it if necessary, and prevents it
+// from being evicted until release is called.
+func (cc *connContext) acquire(dst netip.Addr) (*destination, error) {
+	cc.pingsMu.Lock()
+	defer cc.pingsMu.Unlock()
+
+	now := time.Now()
+
+	d, ok := cc.dests[dst]
+	if !ok {
+		// New host, generate the payload and set up an initial message with
+		// a unique ID. Make room for it first so that the number of IDs in
+		// use remains bounded.
+		cc.prune(now, cc.cfg.MaxDestinations-1)
+
+		data, err := cc.payload(dst)
+		if err != nil {
+			return nil, err
+		}
+
+		cc.resMu.Lock()
+		id, err := cc.allocID()
+		cc.resMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		d = &destination{
+			ip: dst,
+			echo: icmp.Echo{
+				ID:   id,
+				Data: data,
+			},
+		}
+		d.elem = cc.lru.PushFront(d)
+		cc.dests[dst] = d
+	} else {
+		cc.lru.MoveToFront(d.elem)
+		cc.prune(now, cc.cfg.MaxDestinations)
+	}
+
+	d.used = now
+	d.inflight++
+	return d, nil
+}
+
+// release marks a Ping to d as complete.
+func (cc *connContext) release(d *destination) {
+	cc.pingsMu.Lock()
+	defer cc.pingsMu.Unlock()
+
+	d.inflight--
+	if d.elem == nil {
+		// Forgotten while in use, so release the ID once the last Ping is
+		// done with it.
+		if d.inflight == 0 {
+			cc.resMu.Lock()
+			cc.releaseID(d.echo.ID)
+			cc.resMu.Unlock()
+		}
+		return
+	}
+
+	d.used = time.Now()
+	cc.lru.MoveToFront(d.elem)
+}
+
+// prune forgets the least recently used destinations without a Ping in progress
+// until at most n remain, and any which have been idle for longer than the idle
+// timeout. The caller must hold pingsMu.
+func (cc *connContext) prune(now time.Time, n int) {
+	var next *list.Element
+	for e := cc.lru.Back(); e != nil; e = next {
+		next = e.Prev()
+
+		d := e.Value.(*destination)
+		if d.inflight > 0 {
+			continue
+		}
+
+		if cc.lru.Len() <= n && now.Sub(d.used) < cc.cfg.IdleTimeout {
+			// All remaining destinations were used more recently.
+			return
+		}
+
+		cc.forget(d)
+	}
+}
+
+// forget discards the state for d. The caller must hold pingsMu.
+func (cc *connContext) forget(d *destination) {
+	delete(cc.dests, d.ip)
+	cc.lru.Remove(d.elem)
+	d.elem = nil
+
+	if d.inflight > 0 {
+		// Pings in progress still use the ID, see release.
+		return
+	}
+
+	cc.resMu.Lock()
+	defer cc.resMu.Unlock()
+	cc.releaseID(d.echo.ID)
+}
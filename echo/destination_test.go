@@ -0,0 +1,158 @@
+package echo
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClientForget(t *testing.T) {
+	c := testClient(t)
+	ctx := context.Background()
+
+	ping := func() *Response {
+		res, err := c.Client.Ping(ctx, c.Host6.IP)
+		if err != nil {
+			t.Fatalf("failed to ping: %v", err)
+		}
+
+		return res
+	}
+
+	// The sequence number continues until the destination is forgotten, at
+	// which point it starts afresh with a new ID.
+	first, second := ping(), ping()
+	if diff := cmp.Diff(first.Ping.Seq+1, second.Ping.Seq); diff != "" {
+		t.Fatalf("unexpected sequence number (-want +got):\n%s", diff)
+	}
+
+	c.Client.Forget(c.Host6.IP)
+	third := ping()
+
+	if third.Ping.ID == first.Ping.ID {
+		t.Fatalf("ID %d was reused after Forget", third.Ping.ID)
+	}
+	if diff := cmp.Diff(1, third.Ping.Seq); diff != "" {
+		t.Fatalf("unexpected sequence number (-want +got):\n%s", diff)
+	}
+
+	// Forgetting an unknown destination is a no-op.
+	c.Client.Forget(netip.MustParseAddr("2001:db8::ff"))
+}
+
+func TestConnContextAcquire(t *testing.T) {
+	var (
+		a = netip.MustParseAddr("2001:db8::a")
+		b = netip.MustParseAddr("2001:db8::b")
+		c = netip.MustParseAddr("2001:db8::c")
+	)
+
+	tests := []struct {
+		name string
+		cfg  Config
+		fn   func(t *testing.T, cc *connContext)
+		want []netip.Addr
+	}{
+		{
+			name: "least recently used",
+			cfg:  Config{MaxDestinations: 2},
+			fn: func(t *testing.T, cc *connContext) {
+				// a is used after b, so b is evicted for c.
+				for _, ip := range []netip.Addr{a, b, a, c} {
+					cc.release(mustAcquire(t, cc, ip))
+				}
+			},
+			want: []netip.Addr{a, c},
+		},
+		{
+			name: "in progress",
+			cfg:  Config{MaxDestinations: 2},
+			fn: func(t *testing.T, cc *connContext) {
+				// a has a Ping in progress so b is evicted instead.
+				da := mustAcquire(t, cc, a)
+				cc.release(mustAcquire(t, cc, b))
+				cc.release(mustAcquire(t, cc, c))
+				cc.release(da)
+			},
+			want: []netip.Addr{c, a},
+		},
+		{
+			name: "idle",
+			cfg:  Config{IdleTimeout: 50 * time.Millisecond},
+			fn: func(t *testing.T, cc *connContext) {
+				cc.release(mustAcquire(t, cc, a))
+				cc.release(mustAcquire(t, cc, b))
+				time.Sleep(100 * time.Millisecond)
+				cc.release(mustAcquire(t, cc, c))
+			},
+			want: []netip.Addr{c},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.cfg.withDefaults()
+			if err != nil {
+				t.Fatalf("failed to apply defaults: %v", err)
+			}
+
+			c := newClient(cfg, newReplyConn(nil, nil), newReplyConn(nil, nil))
+			defer c.Close()
+
+			cc := c.v6
+			tt.fn(t, cc)
+
+			// Least recently used first.
+			var got []netip.Addr
+			for e := cc.lru.Back(); e != nil; e = e.Prev() {
+				got = append(got, e.Value.(*destination).ip)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected destinations (-want +got):\n%s", diff)
+			}
+
+			// Evicted destinations' IDs are released.
+			if diff := cmp.Diff(len(tt.want), len(cc.ids)); diff != "" {
+				t.Fatalf("unexpected number of IDs (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConnContextAcquireChurn(t *testing.T) {
+	cfg, err := Config{MaxDestinations: 1024}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	c := newClient(cfg, newReplyConn(nil, nil), newReplyConn(nil, nil))
+	defer c.Close()
+
+	// Churning through more destinations than there are echo IDs reuses the
+	// IDs of evicted destinations, despite their quarantine.
+	cc := c.v6
+	ip := netip.MustParseAddr("2001:db8::")
+	for i := 0; i < 1<<16+1024; i++ {
+		ip = ip.Next()
+		cc.release(mustAcquire(t, cc, ip))
+	}
+
+	if diff := cmp.Diff(1024, len(cc.ids)); diff != "" {
+		t.Fatalf("unexpected number of IDs (-want +got):\n%s", diff)
+	}
+}
+
+func mustAcquire(t *testing.T, cc *connContext, ip netip.Addr) *destination {
+	t.Helper()
+
+	d, err := cc.acquire(ip)
+	if err != nil {
+		t.Fatalf("failed to acquire %s: %v", ip, err)
+	}
+
+	return d
+}
@@ -1,3 +1,5 @@
 // Package echo implements an ICMPv4/6 echo client for performing ping
-// operations on destination hosts.
+// operations on destination hosts. A Pinger continuously pings a destination
+// while keeping statistics like those reported by ping(8), and Client.Sweep
+// pings many destinations concurrently, as fping does.
 package echo
@@ -0,0 +1,112 @@
+package echo
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// An Error is an ICMP error message, such as Destination Unreachable or Time
+// Exceeded, sent in reply to an echo request. Ping returns an *Error rather
+// than retrying when one is received, unless the error reports that the echo
+// request exceeded the path MTU.
+type Error struct {
+	// IP is the address of the node which reported the error, typically a
+	// router on the path to Dst.
+	IP netip.Addr
+
+	// Dst is the destination of the echo request which elicited the error.
+	Dst netip.Addr
+
+	// Type and Code are the ICMPv4/6 type and code of the error message.
+	Type icmp.Type
+	Code int
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("echo: %s reported %s for %s", e.IP, e.describe(), e.Dst)
+}
+
+// tooBig reports whether e is an ICMPv6 Packet Too Big or ICMPv4 Fragmentation
+// Needed message. These update the kernel's path MTU for Dst, so the next echo
+// request is fragmented to fit and may succeed.
+func (e *Error) tooBig() bool {
+	switch e.Type {
+	case ipv6.ICMPTypePacketTooBig:
+		return true
+	case ipv4.ICMPTypeDestinationUnreachable:
+		return e.Code == 4
+	default:
+		return false
+	}
+}
+
+// describe returns a human-readable description of the error's type and code.
+func (e *Error) describe() string {
+	var s string
+	switch e.Type {
+	case ipv4.ICMPTypeDestinationUnreachable:
+		s = describeCode(e.Code, []string{
+			0:  "network unreachable",
+			1:  "host unreachable",
+			2:  "protocol unreachable",
+			3:  "port unreachable",
+			4:  "fragmentation needed",
+			5:  "source route failed",
+			6:  "destination network unknown",
+			7:  "destination host unknown",
+			8:  "source host isolated",
+			9:  "network administratively prohibited",
+			10: "host administratively prohibited",
+			11: "network unreachable for TOS",
+			12: "host unreachable for TOS",
+			13: "communication administratively prohibited",
+			14: "host precedence violation",
+			15: "precedence cutoff in effect",
+		})
+	case ipv4.ICMPTypeTimeExceeded:
+		s = describeCode(e.Code, []string{
+			0: "TTL exceeded in transit",
+			1: "fragment reassembly time exceeded",
+		})
+	case ipv6.ICMPTypeDestinationUnreachable:
+		s = describeCode(e.Code, []string{
+			0: "no route to destination",
+			1: "communication administratively prohibited",
+			2: "beyond scope of source address",
+			3: "address unreachable",
+			4: "port unreachable",
+			5: "source address failed ingress/egress policy",
+			6: "reject route to destination",
+		})
+	case ipv6.ICMPTypeTimeExceeded:
+		s = describeCode(e.Code, []string{
+			0: "hop limit exceeded in transit",
+			1: "fragment reassembly time exceeded",
+		})
+	case ipv6.ICMPTypePacketTooBig:
+		return "packet too big"
+	case ipv4.ICMPTypeParameterProblem, ipv6.ICMPTypeParameterProblem:
+		return fmt.Sprintf("parameter problem (code %d)", e.Code)
+	}
+
+	if s == "" {
+		return fmt.Sprintf("%v (code %d)", e.Type, e.Code)
+	}
+
+	return s
+}
+
+// describeCode returns the description of code, or the empty string if code is
+// unknown.
+func describeCode(code int, descs []string) string {
+	if code < 0 || code >= len(descs) {
+		return ""
+	}
+
+	return descs[code]
+}
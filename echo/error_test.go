@@ -0,0 +1,293 @@
+package echo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestClientPingError(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      netip.Addr
+		req, rep icmp.Type
+		msg      func(b []byte) *icmp.Message
+		want     string
+	}{
+		{
+			name: "IPv4 host unreachable",
+			dst:  netip.MustParseAddr("192.0.2.1"),
+			req:  ipv4.ICMPTypeEcho,
+			rep:  ipv4.ICMPTypeEchoReply,
+			msg: func(b []byte) *icmp.Message {
+				return &icmp.Message{
+					Type: ipv4.ICMPTypeDestinationUnreachable,
+					Code: 1,
+					Body: &icmp.DstUnreach{Data: b},
+				}
+			},
+			want: "echo: 192.0.2.254 reported host unreachable for 192.0.2.1",
+		},
+		{
+			name: "IPv4 TTL exceeded",
+			dst:  netip.MustParseAddr("192.0.2.1"),
+			req:  ipv4.ICMPTypeEcho,
+			rep:  ipv4.ICMPTypeEchoReply,
+			msg: func(b []byte) *icmp.Message {
+				return &icmp.Message{
+					Type: ipv4.ICMPTypeTimeExceeded,
+					Body: &icmp.TimeExceeded{Data: b},
+				}
+			},
+			want: "echo: 192.0.2.254 reported TTL exceeded in transit for 192.0.2.1",
+		},
+		{
+			name: "IPv6 administratively prohibited",
+			dst:  netip.MustParseAddr("2001:db8::1"),
+			req:  ipv6.ICMPTypeEchoRequest,
+			rep:  ipv6.ICMPTypeEchoReply,
+			msg: func(b []byte) *icmp.Message {
+				return &icmp.Message{
+					Type: ipv6.ICMPTypeDestinationUnreachable,
+					Code: 1,
+					Body: &icmp.DstUnreach{Data: b},
+				}
+			},
+			want: "echo: 2001:db8::ff reported communication administratively prohibited for 2001:db8::1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := netip.MustParseAddr("192.0.2.254")
+			if tt.dst.Is6() {
+				router = netip.MustParseAddr("2001:db8::ff")
+			}
+
+			// The router first reports an error for an echo request which
+			// was not sent by the Client, which must be ignored, followed by
+			// the error for the Client's echo request.
+			var conn *replyConn
+			conn = newReplyConn(tt.rep, func(req *icmp.Echo, dst netip.Addr) []*icmp.Echo {
+				for _, id := range []int{req.ID ^ 1, req.ID} {
+					b, err := (&icmp.Message{
+						Type: tt.req,
+						Body: &icmp.Echo{ID: id, Seq: req.Seq, Data: req.Data},
+					}).Marshal(nil)
+					if err != nil {
+						panic(err)
+					}
+
+					conn.sendMessage(tt.msg(quote(dst, b)), router)
+				}
+
+				return nil
+			})
+
+			cfg, err := Config{}.withDefaults()
+			if err != nil {
+				t.Fatalf("failed to apply defaults: %v", err)
+			}
+
+			c4, c6 := icmpxConns(tt.dst, conn)
+			c := newClient(cfg, c4, c6)
+			defer c.Close()
+
+			_, err = c.Ping(context.Background(), tt.dst)
+
+			var eerr *Error
+			if !errors.As(err, &eerr) {
+				t.Fatalf("expected *Error, but got: %v", err)
+			}
+
+			m := tt.msg(nil)
+			want := &Error{
+				IP:   router,
+				Dst:  tt.dst,
+				Type: m.Type,
+				Code: m.Code,
+			}
+
+			if diff := cmp.Diff(want, eerr, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected error (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.want, err.Error()); diff != "" {
+				t.Fatalf("unexpected error string (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(OrphanCounts{Unknown: 1}, c.Orphans()); diff != "" {
+				t.Fatalf("unexpected orphan counts (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientPingTooBig(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      netip.Addr
+		req, rep icmp.Type
+		msg      func(b []byte) *icmp.Message
+	}{
+		{
+			name: "IPv4 fragmentation needed",
+			dst:  netip.MustParseAddr("192.0.2.1"),
+			req:  ipv4.ICMPTypeEcho,
+			rep:  ipv4.ICMPTypeEchoReply,
+			msg: func(b []byte) *icmp.Message {
+				return &icmp.Message{
+					Type: ipv4.ICMPTypeDestinationUnreachable,
+					Code: 4,
+					Body: &icmp.DstUnreach{Data: b},
+				}
+			},
+		},
+		{
+			name: "IPv6 packet too big",
+			dst:  netip.MustParseAddr("2001:db8::1"),
+			req:  ipv6.ICMPTypeEchoRequest,
+			rep:  ipv6.ICMPTypeEchoReply,
+			msg: func(b []byte) *icmp.Message {
+				return &icmp.Message{
+					Type: ipv6.ICMPTypePacketTooBig,
+					Body: &icmp.PacketTooBig{MTU: 1280, Data: b},
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := netip.MustParseAddr("192.0.2.254")
+			if tt.dst.Is6() {
+				router = netip.MustParseAddr("2001:db8::ff")
+			}
+
+			// ping pings tt.dst with the specified number of attempts. The
+			// router reports that the first echo request is too big, and the
+			// destination replies to all others.
+			ping := func(attempts int) (*Response, error) {
+				var (
+					conn  *replyConn
+					first = true
+				)
+				conn = newReplyConn(tt.rep, func(req *icmp.Echo, dst netip.Addr) []*icmp.Echo {
+					if !first {
+						return []*icmp.Echo{req}
+					}
+					first = false
+
+					b, err := (&icmp.Message{Type: tt.req, Body: req}).Marshal(nil)
+					if err != nil {
+						panic(err)
+					}
+
+					conn.sendMessage(tt.msg(quote(dst, b)), router)
+					return nil
+				})
+
+				cfg, err := Config{Attempts: attempts}.withDefaults()
+				if err != nil {
+					t.Fatalf("failed to apply defaults: %v", err)
+				}
+
+				c4, c6 := icmpxConns(tt.dst, conn)
+				c := newClient(cfg, c4, c6)
+				defer c.Close()
+
+				return c.Ping(context.Background(), tt.dst)
+			}
+
+			res, err := ping(2)
+			if err != nil {
+				t.Fatalf("failed to ping: %v", err)
+			}
+
+			if diff := cmp.Diff(2, res.Attempts); diff != "" {
+				t.Fatalf("unexpected attempts (-want +got):\n%s", diff)
+			}
+
+			// With no attempts left, the error is returned.
+			_, err = ping(1)
+
+			var eerr *Error
+			if !errors.As(err, &eerr) {
+				t.Fatalf("expected *Error, but got: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.msg(nil).Type, eerr.Type); diff != "" {
+				t.Fatalf("unexpected error type (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestErrorDescribe(t *testing.T) {
+	tests := []struct {
+		typ  icmp.Type
+		code int
+		want string
+	}{
+		{typ: ipv4.ICMPTypeDestinationUnreachable, code: 13, want: "communication administratively prohibited"},
+		{typ: ipv4.ICMPTypeDestinationUnreachable, code: 16, want: "destination unreachable (code 16)"},
+		{typ: ipv4.ICMPTypeTimeExceeded, code: 1, want: "fragment reassembly time exceeded"},
+		{typ: ipv4.ICMPTypeParameterProblem, code: 0, want: "parameter problem (code 0)"},
+		{typ: ipv6.ICMPTypeDestinationUnreachable, code: 3, want: "address unreachable"},
+		{typ: ipv6.ICMPTypeTimeExceeded, code: 0, want: "hop limit exceeded in transit"},
+		{typ: ipv6.ICMPTypePacketTooBig, code: 0, want: "packet too big"},
+		{typ: ipv6.ICMPTypeParameterProblem, code: 1, want: "parameter problem (code 1)"},
+	}
+
+	for _, tt := range tests {
+		got := (&Error{Type: tt.typ, Code: tt.code}).describe()
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Fatalf("unexpected description for %v (code %d) (-want +got):\n%s", tt.typ, tt.code, diff)
+		}
+	}
+}
+
+// icmpxConns returns conn as the connection for the address family of dst, and
+// a connection which never replies for the other.
+func icmpxConns(dst netip.Addr, conn *replyConn) (*replyConn, *replyConn) {
+	if dst.Is4() {
+		return conn, newReplyConn(nil, nil)
+	}
+
+	return newReplyConn(nil, nil), conn
+}
+
+// quote produces a quoted IPv4 or IPv6 datagram carrying the ICMP message b to
+// dst, as it would be seen by a router.
+func quote(dst netip.Addr, b []byte) []byte {
+	if dst.Is4() {
+		h := make([]byte, 20, 20+len(b))
+		h[0] = 0x45
+		binary.BigEndian.PutUint16(h[2:4], uint16(20+len(b)))
+		h[8] = 1
+		h[9] = 1
+		copy(h[12:16], []byte{192, 0, 2, 100})
+		a := dst.As4()
+		copy(h[16:20], a[:])
+
+		return append(h, b...)
+	}
+
+	h := make([]byte, 40, 40+len(b))
+	h[0] = 0x60
+	binary.BigEndian.PutUint16(h[4:6], uint16(len(b)))
+	h[6] = 58
+	h[7] = 1
+	src := netip.MustParseAddr("2001:db8::100").As16()
+	copy(h[8:24], src[:])
+	a := dst.As16()
+	copy(h[24:40], a[:])
+
+	return append(h, b...)
+}
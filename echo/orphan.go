@@ -0,0 +1,103 @@
+package echo
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/net/icmp"
+)
+
+// An OrphanReason explains why an echo reply or ICMP error was not delivered to
+// a Ping, Pinger, or sweep.
+type OrphanReason int
+
+// Possible OrphanReason values.
+const (
+	// OrphanLate indicates a reply with the echo ID of a destination for
+	// which no echo request with the same sequence number is awaiting a
+	// reply, because it timed out or already received a reply.
+	OrphanLate OrphanReason = iota
+
+	// OrphanDuplicate indicates another reply to an echo request which has
+	// received a reply that has not yet been processed.
+	OrphanDuplicate
+
+	// OrphanUnknown indicates a reply with an echo ID which is not in use by
+	// the Client.
+	OrphanUnknown
+
+	// OrphanDropped indicates a reply for a Pinger or sweep which was not
+	// processing replies as quickly as they arrived.
+	OrphanDropped
+)
+
+// String returns the string representation of an OrphanReason.
+func (r OrphanReason) String() string {
+	switch r {
+	case OrphanLate:
+		return "late"
+	case OrphanDuplicate:
+		return "duplicate"
+	case OrphanUnknown:
+		return "unknown"
+	case OrphanDropped:
+		return "dropped"
+	default:
+		return fmt.Sprintf("OrphanReason(%d)", r)
+	}
+}
+
+// An Orphan is an echo reply received by a Client which was not delivered.
+type Orphan struct {
+	// Reason explains why the reply was not delivered.
+	Reason OrphanReason
+
+	// Echo is the echo reply and IP is its source address.
+	Echo *icmp.Echo
+	IP   netip.Addr
+
+	// Err is set if the reply was an ICMP error, in which case Echo holds
+	// only the ID and sequence number of the quoted echo request.
+	Err *Error
+}
+
+// OrphanCounts are the number of echo replies received by a Client which were
+// not delivered, by OrphanReason.
+type OrphanCounts struct {
+	Late, Duplicate, Unknown, Dropped int
+}
+
+// add increments the count for reason r.
+func (oc *OrphanCounts) add(r OrphanReason) {
+	switch r {
+	case OrphanLate:
+		oc.Late++
+	case OrphanDuplicate:
+		oc.Duplicate++
+	case OrphanUnknown:
+		oc.Unknown++
+	case OrphanDropped:
+		oc.Dropped++
+	}
+}
+
+// Orphans returns the number of echo replies received by the Client for either
+// address family which were not delivered.
+func (ec *Client) Orphans() OrphanCounts {
+	o4, o6 := ec.v4.orphans(), ec.v6.orphans()
+
+	return OrphanCounts{
+		Late:      o4.Late + o6.Late,
+		Duplicate: o4.Duplicate + o6.Duplicate,
+		Unknown:   o4.Unknown + o6.Unknown,
+		Dropped:   o4.Dropped + o6.Dropped,
+	}
+}
+
+// orphans returns a snapshot of the connContext's orphan counts.
+func (cc *connContext) orphans() OrphanCounts {
+	cc.resMu.Lock()
+	defer cc.resMu.Unlock()
+
+	return cc.orphanCounts
+}
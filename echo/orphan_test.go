@@ -0,0 +1,135 @@
+package echo
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+func TestClientOrphans(t *testing.T) {
+	conn := newReplyConn(ipv6.ICMPTypeEchoReply, func(req *icmp.Echo, _ netip.Addr) []*icmp.Echo {
+		return []*icmp.Echo{req}
+	})
+
+	cfg, err := Config{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	orphanC := make(chan Orphan, 2)
+	cfg.OnOrphan = func(o Orphan) { orphanC <- o }
+
+	c := newClient(cfg, newReplyConn(nil, nil), conn)
+	defer c.Close()
+
+	dst := netip.MustParseAddr("2001:db8::1")
+	res, err := c.Ping(context.Background(), dst)
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+
+	// Resend the reply once the Ping has completed, and send a reply with an
+	// ID the Client is not using.
+	conn.resend(1)
+	conn.send(&icmp.Echo{ID: res.Ping.ID ^ 1, Seq: 1}, dst)
+
+	var got []OrphanReason
+	for i := 0; i < 2; i++ {
+		select {
+		case o := <-orphanC:
+			if o.IP != dst {
+				t.Errorf("unexpected orphan source: %s", o.IP)
+			}
+
+			got = append(got, o.Reason)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for orphans")
+		}
+	}
+
+	if diff := cmp.Diff([]OrphanReason{OrphanLate, OrphanUnknown}, got); diff != "" {
+		t.Fatalf("unexpected orphan reasons (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(OrphanCounts{Late: 1, Unknown: 1}, c.Orphans()); diff != "" {
+		t.Fatalf("unexpected orphan counts (-want +got):\n%s", diff)
+	}
+}
+
+func TestConnContextDispatch(t *testing.T) {
+	cc := &connContext{
+		ids:      make(map[echoID]struct{}),
+		released: make(map[echoID]time.Time),
+		waiters:  make(map[replyKey]chan pingResponse),
+		subs:     make(map[echoID]chan pingResponse),
+	}
+
+	dispatch := func(id, seq int) OrphanReason {
+		reason, ok := cc.dispatch(pingResponse{Echo: &icmp.Echo{ID: id, Seq: seq}})
+		if ok {
+			return -1
+		}
+
+		return reason
+	}
+
+	// A subscriber which is not draining its channel never blocks dispatch.
+	id, _, err := cc.subscribe()
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	for i := 0; i < cap(cc.subs[id]); i++ {
+		if r := dispatch(id, i); r != -1 {
+			t.Fatalf("failed to deliver reply %d to subscriber: %s", i, r)
+		}
+	}
+
+	var (
+		waiter   = (id + 1) & 0xffff
+		released = (id + 2) & 0xffff
+		unknown  = (id + 3) & 0xffff
+	)
+
+	// A waiter receives only the first reply.
+	cc.ids[waiter] = struct{}{}
+	cc.waiters[replyKey{ID: waiter, Seq: 1}] = make(chan pingResponse, 1)
+
+	cc.ids[released] = struct{}{}
+	cc.releaseID(released)
+
+	got := []OrphanReason{
+		dispatch(waiter, 1),
+		dispatch(waiter, 1),
+		dispatch(waiter, 2),
+		dispatch(released, 1),
+		dispatch(unknown, 1),
+		dispatch(id, 0),
+	}
+
+	want := []OrphanReason{-1, OrphanDuplicate, OrphanLate, OrphanLate, OrphanUnknown, OrphanDropped}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected orphan reasons (-want +got):\n%s", diff)
+	}
+
+	wantCounts := OrphanCounts{Late: 2, Duplicate: 1, Unknown: 1, Dropped: 1}
+	if diff := cmp.Diff(wantCounts, cc.orphans()); diff != "" {
+		t.Fatalf("unexpected orphan counts (-want +got):\n%s", diff)
+	}
+}
+
+func TestOrphanReasonString(t *testing.T) {
+	var got []string
+	for _, r := range []OrphanReason{OrphanLate, OrphanDuplicate, OrphanUnknown, OrphanDropped, 10} {
+		got = append(got, r.String())
+	}
+
+	want := []string{"late", "duplicate", "unknown", "dropped", "OrphanReason(10)"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected strings (-want +got):\n%s", diff)
+	}
+}
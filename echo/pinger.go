@@ -0,0 +1,388 @@
+package echo
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// Default PingerConfig values.
+const (
+	defaultInterval      = 1 * time.Second
+	defaultPingerTimeout = 10 * time.Second
+)
+
+// A PingerConfig configures a Pinger. The zero value is valid and sends an echo
+// request every second until the Pinger is stopped.
+type PingerConfig struct {
+	// Interval is the time between echo requests, as with ping -i. If zero,
+	// it defaults to 1 second.
+	Interval time.Duration
+
+	// Count is the number of echo requests to send, as with ping -c. If
+	// zero, echo requests are sent until Deadline elapses or the Pinger is
+	// stopped.
+	Count int
+
+	// Deadline, if set, stops the Pinger after the specified duration
+	// regardless of how many echo requests have been sent, as with ping -w.
+	Deadline time.Duration
+
+	// Timeout is how long to wait for a reply to each echo request before it
+	// is considered lost, as with ping -W. Replies which arrive later are
+	// reported as late. If zero, it defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg PingerConfig) withDefaults() (PingerConfig, error) {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Interval < 0 {
+		return PingerConfig{}, fmt.Errorf("echo: interval must be positive: %s", cfg.Interval)
+	}
+
+	if cfg.Count < 0 {
+		return PingerConfig{}, fmt.Errorf("echo: count must not be negative: %d", cfg.Count)
+	}
+
+	if cfg.Deadline < 0 {
+		return PingerConfig{}, fmt.Errorf("echo: deadline must not be negative: %s", cfg.Deadline)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultPingerTimeout
+	}
+	if cfg.Timeout < 0 {
+		return PingerConfig{}, fmt.Errorf("echo: timeout must be positive: %s", cfg.Timeout)
+	}
+
+	return cfg, nil
+}
+
+// A Status is the outcome of an echo request sent by a Pinger.
+type Status int
+
+// Possible Status values.
+const (
+	// StatusReply indicates that a reply was received within the timeout.
+	StatusReply Status = iota
+
+	// StatusTimeout indicates that no reply was received within the timeout.
+	StatusTimeout
+
+	// StatusLate indicates that a reply was received after the timeout, when
+	// the echo request had already been reported with StatusTimeout.
+	StatusLate
+
+	// StatusDuplicate indicates that another reply was received for an echo
+	// request which had already received a reply.
+	StatusDuplicate
+
+	// StatusCorrupt indicates that a reply was received which did not carry
+	// the same data as the echo request.
+	StatusCorrupt
+)
+
+// String returns the string representation of a Status.
+func (s Status) String() string {
+	switch s {
+	case StatusReply:
+		return "reply"
+	case StatusTimeout:
+		return "timeout"
+	case StatusLate:
+		return "late"
+	case StatusDuplicate:
+		return "duplicate"
+	case StatusCorrupt:
+		return "corrupt"
+	default:
+		return fmt.Sprintf("Status(%d)", s)
+	}
+}
+
+// A Result reports the outcome of an echo request, or a reply to an echo
+// request, observed by a Pinger.
+type Result struct {
+	// Status is the outcome of the echo request.
+	Status Status
+
+	// Seq is the sequence number of the echo request.
+	Seq int
+
+	// IP is the source address of the reply, or the destination of the echo
+	// request for StatusTimeout.
+	IP netip.Addr
+
+	// RTT is the round-trip time of the reply, or zero for StatusTimeout.
+	RTT time.Duration
+
+	// Err describes how the reply was corrupted for StatusCorrupt.
+	Err error
+}
+
+// A Pinger continuously sends echo requests to a single destination and keeps
+// running statistics about the replies, as ping(8) does.
+type Pinger struct {
+	cc  *connContext
+	dst netip.Addr
+	cfg PingerConfig
+
+	mu      sync.Mutex
+	running bool
+	stats   stats
+}
+
+// NewPinger creates a Pinger which sends echo requests to dst using the
+// Client's network connections. Call Run to begin sending echo requests.
+func (ec *Client) NewPinger(dst netip.Addr, cfg PingerConfig) (*Pinger, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	if !dst.IsValid() || dst.IsUnspecified() || dst.IsMulticast() {
+		return nil, fmt.Errorf("echo: invalid destination: %q", dst)
+	}
+
+	cc := ec.v6
+	if dst.Is4() {
+		cc = ec.v4
+	}
+
+	return &Pinger{
+		cc:  cc,
+		dst: dst,
+		cfg: cfg,
+	}, nil
+}
+
+// Statistics returns a snapshot of the Pinger's statistics. It is safe to call
+// Statistics while Run is in progress.
+func (p *Pinger) Statistics() *Statistics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stats.Statistics()
+}
+
+// A pingerRequest tracks the state of an echo request sent by a Pinger.
+type pingerRequest struct {
+	sent, deadline   time.Time
+	replied, expired bool
+}
+
+// Run sends echo requests until PingerConfig.Count echo requests have been sent
+// and have received a reply or timed out, until PingerConfig.Deadline elapses,
+// or until ctx is canceled. Any echo requests awaiting a reply when Run stops
+// are counted as lost.
+//
+// If fn is not nil, it is called synchronously with each Result, including
+// late, duplicate, and corrupted replies. Run returns the final Statistics, and
+// may only be called once.
+func (p *Pinger) Run(ctx context.Context, fn func(Result)) (*Statistics, error) {
+	p.mu.Lock()
+	if p.running {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("echo: Pinger for %s has already run", p.dst)
+	}
+	p.running = true
+	p.mu.Unlock()
+
+	if fn == nil {
+		fn = func(Result) {}
+	}
+
+	data, err := p.cc.payload(p.dst)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each Pinger has a unique echo ID so that it receives every reply to
+	// its requests, including late and duplicate replies.
+	id, resC, err := p.cc.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	defer p.cc.unsubscribe(id)
+
+	if p.cfg.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.Deadline)
+		defer cancel()
+	}
+
+	var (
+		// Requests by sequence number, and the sequence numbers of requests
+		// in the order in which they expire. Every request has the same
+		// timeout, so that is also the order in which they were sent.
+		requests = make(map[int]*pingerRequest)
+		expiries []int
+
+		// The number of requests sent, and the number of requests which
+		// have neither received a reply nor expired.
+		sent, outstanding int
+	)
+
+	send := func() error {
+		sent++
+		seq := sent & 0xffff
+
+		msg := &icmp.Message{
+			Type: p.cc.typ,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: data},
+		}
+		if err := p.cc.conn.WriteTo(ctx, msg, p.dst); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		requests[seq] = &pingerRequest{
+			sent:     now,
+			deadline: now.Add(p.cfg.Timeout),
+		}
+		expiries = append(expiries, seq)
+		outstanding++
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.stats.transmitted++
+		p.stats.pending++
+
+		return nil
+	}
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	sendC := ticker.C
+
+	// Send the first request immediately rather than waiting for the first
+	// tick.
+	if err := send(); err != nil {
+		return p.stop(ctx, err)
+	}
+
+	for {
+		if p.cfg.Count > 0 && sent >= p.cfg.Count {
+			ticker.Stop()
+			sendC = nil
+
+			if outstanding == 0 {
+				return p.stop(ctx, nil)
+			}
+		}
+
+		var expireC <-chan time.Time
+		if len(expiries) > 0 {
+			expireC = time.After(time.Until(requests[expiries[0]].deadline))
+		}
+
+		select {
+		case <-ctx.Done():
+			return p.stop(ctx, nil)
+		case <-sendC:
+			if err := send(); err != nil {
+				return p.stop(ctx, err)
+			}
+		case res := <-resC:
+			req, ok := requests[res.Echo.Seq]
+			if !ok || res.Err != nil {
+				// Not a reply to any of our requests. ICMP errors are
+				// treated as lost replies.
+				continue
+			}
+
+			r := p.reply(req, data, res)
+			if r.Status == StatusReply {
+				outstanding--
+			}
+			fn(r)
+		case now := <-expireC:
+			for len(expiries) > 0 {
+				seq := expiries[0]
+				req := requests[seq]
+				if now.Before(req.deadline) {
+					break
+				}
+				expiries = expiries[1:]
+
+				if req.replied {
+					continue
+				}
+
+				req.expired = true
+				outstanding--
+
+				p.mu.Lock()
+				p.stats.pending--
+				p.mu.Unlock()
+
+				fn(Result{
+					Status: StatusTimeout,
+					Seq:    seq,
+					IP:     p.dst,
+				})
+			}
+		}
+	}
+}
+
+// reply updates the state of req and the Pinger's statistics for the reply res,
+// returning the Result of the reply.
+func (p *Pinger) reply(req *pingerRequest, data []byte, res pingResponse) Result {
+	r := Result{
+		Seq: res.Echo.Seq,
+		IP:  res.IP,
+		RTT: time.Since(req.sent),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := validate(&icmp.Echo{Data: data}, res.Echo); err != nil {
+		r.Status = StatusCorrupt
+		r.Err = err
+		p.stats.corrupt++
+		return r
+	}
+
+	switch {
+	case req.replied:
+		r.Status = StatusDuplicate
+		p.stats.duplicates++
+		return r
+	case req.expired:
+		r.Status = StatusLate
+		p.stats.late++
+	default:
+		r.Status = StatusReply
+		p.stats.pending--
+	}
+
+	req.replied = true
+	p.stats.received++
+	p.stats.sample(r.RTT)
+
+	return r
+}
+
+// stop finalizes the Pinger's statistics when Run returns. Cancelation of ctx
+// is not an error.
+func (p *Pinger) stop(ctx context.Context, err error) (*Statistics, error) {
+	if ctx.Err() != nil {
+		err = nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Any requests still awaiting a reply are lost.
+	p.stats.pending = 0
+	return p.stats.Statistics(), err
+}
@@ -0,0 +1,338 @@
+package echo
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+func TestPingerRun(t *testing.T) {
+	c := testClient(t)
+
+	p, err := c.Client.NewPinger(c.Host4.IP, PingerConfig{
+		Interval: 10 * time.Millisecond,
+		Count:    5,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create pinger: %v", err)
+	}
+
+	var seqs []int
+	stats, err := p.Run(context.Background(), func(r Result) {
+		if r.Status != StatusReply {
+			t.Errorf("unexpected result: %+v", r)
+		}
+
+		seqs = append(seqs, r.Seq)
+	})
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+
+	if diff := cmp.Diff([]int{1, 2, 3, 4, 5}, seqs); diff != "" {
+		t.Fatalf("unexpected sequence numbers (-want +got):\n%s", diff)
+	}
+
+	if stats.Transmitted != 5 || stats.Received != 5 || stats.Loss != 0 {
+		t.Fatalf("unexpected statistics: %+v", stats)
+	}
+
+	if _, err := p.Run(context.Background(), nil); err == nil {
+		t.Fatal("expected an error running twice, but none occurred")
+	}
+}
+
+func TestPingerRunReplies(t *testing.T) {
+	// Emulate a host which loses, delays, duplicates, and corrupts replies.
+	// Late replies are only sent once the request has timed out, and the
+	// final reply is only sent once the late reply has been received, so
+	// that the Pinger observes every kind of reply before it stops.
+	conn := newReplyConn(ipv6.ICMPTypeEchoReply, func(req *icmp.Echo, _ netip.Addr) []*icmp.Echo {
+		switch req.Seq {
+		case 1:
+			return []*icmp.Echo{req}
+		case 4:
+			return []*icmp.Echo{req, req}
+		case 5:
+			data := append([]byte(nil), req.Data...)
+			data[0]++
+			return []*icmp.Echo{{ID: req.ID, Seq: req.Seq, Data: data}}
+		default:
+			return nil
+		}
+	})
+
+	cfg, err := Config{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	c := newClient(cfg, newReplyConn(nil, nil), conn)
+	defer c.Close()
+
+	dst := netip.MustParseAddr("2001:db8::1")
+	p, err := c.NewPinger(dst, PingerConfig{
+		Interval: 100 * time.Millisecond,
+		Count:    5,
+		Timeout:  300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create pinger: %v", err)
+	}
+
+	got := make(map[int][]Status)
+	stats, err := p.Run(context.Background(), func(r Result) {
+		got[r.Seq] = append(got[r.Seq], r.Status)
+
+		switch {
+		case r.Seq == 3 && r.Status == StatusTimeout:
+			conn.resend(3)
+		case r.Seq == 3 && r.Status == StatusLate:
+			conn.resend(5)
+		case r.Seq == 5 && r.Status == StatusCorrupt:
+			if r.Err == nil {
+				t.Error("corrupt result has no error")
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+
+	want := map[int][]Status{
+		1: {StatusReply},
+		2: {StatusTimeout},
+		3: {StatusTimeout, StatusLate},
+		4: {StatusReply, StatusDuplicate},
+		5: {StatusCorrupt, StatusReply},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected results (-want +got):\n%s", diff)
+	}
+
+	// Zero the round-trip time statistics which vary between runs.
+	stats.Min, stats.Avg, stats.Max, stats.MDev = 0, 0, 0, 0
+	stats.P50, stats.P90, stats.P99, stats.Jitter = 0, 0, 0, 0
+
+	wantStats := &Statistics{
+		Transmitted: 5,
+		Received:    4,
+		Duplicates:  1,
+		Late:        1,
+		Corrupt:     1,
+		Loss:        20,
+	}
+
+	if diff := cmp.Diff(wantStats, stats); diff != "" {
+		t.Fatalf("unexpected statistics (-want +got):\n%s", diff)
+	}
+}
+
+func TestPingerRunDeadline(t *testing.T) {
+	// Emulate a host which never replies. Requests awaiting a reply when the
+	// deadline elapses are lost.
+	c := testClient(t)
+	c.Host6.OnEcho = func(*icmp.Echo) *icmp.Echo { return nil }
+
+	p, err := c.Client.NewPinger(c.Host6.IP, PingerConfig{
+		Interval: 10 * time.Millisecond,
+		Deadline: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create pinger: %v", err)
+	}
+
+	stats, err := p.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+
+	if stats.Transmitted == 0 || stats.Received != 0 || stats.Pending != 0 || stats.Loss != 100 {
+		t.Fatalf("unexpected statistics: %+v", stats)
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	const ms = time.Millisecond
+
+	s := stats{
+		transmitted: 6,
+		pending:     1,
+	}
+
+	for _, rtt := range []time.Duration{10 * ms, 10 * ms, 30 * ms, 30 * ms} {
+		s.received++
+		s.sample(rtt)
+	}
+
+	// Jitter: 0, then 0 + 20/16, then 1.25 - 1.25/16.
+	want := &Statistics{
+		Transmitted: 6,
+		Received:    4,
+		Pending:     1,
+		Loss:        20,
+		Min:         10 * ms,
+		Avg:         20 * ms,
+		Max:         30 * ms,
+		MDev:        10 * ms,
+		P50:         10 * ms,
+		P90:         30 * ms,
+		P99:         30 * ms,
+		Jitter:      1171875 * time.Nanosecond,
+	}
+
+	if diff := cmp.Diff(want, s.Statistics()); diff != "" {
+		t.Fatalf("unexpected statistics (-want +got):\n%s", diff)
+	}
+
+	// Only the most recent samples are used for percentiles.
+	s = stats{}
+	for i := 0; i < maxSamples+100; i++ {
+		rtt := time.Hour
+		if i >= 100 {
+			rtt = time.Duration(i) * ms
+		}
+
+		s.received++
+		s.sample(rtt)
+	}
+
+	if got := s.Statistics().P99; got >= time.Hour {
+		t.Fatalf("unexpected 99th percentile including old samples: %s", got)
+	}
+}
+
+func TestPingerConfig(t *testing.T) {
+	for _, cfg := range []PingerConfig{
+		{Interval: -1},
+		{Count: -1},
+		{Deadline: -1},
+		{Timeout: -1},
+	} {
+		if _, err := cfg.withDefaults(); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+
+	cfg, err := PingerConfig{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	want := PingerConfig{
+		Interval: 1 * time.Second,
+		Timeout:  10 * time.Second,
+	}
+
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatalf("unexpected config (-want +got):\n%s", diff)
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	var got []string
+	for _, s := range []Status{StatusReply, StatusTimeout, StatusLate, StatusDuplicate, StatusCorrupt, 10} {
+		got = append(got, s.String())
+	}
+
+	want := []string{"reply", "timeout", "late", "duplicate", "corrupt", "Status(10)"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected strings (-want +got):\n%s", diff)
+	}
+}
+
+var _ icmpx.Conn = &replyConn{}
+
+// A replyConn implements icmpx.Conn by emulating hosts which reply to each echo
+// request with the replies produced by onEcho, and which can resend requests as
+// replies later.
+type replyConn struct {
+	typ    icmp.Type
+	onEcho func(req *icmp.Echo, dst netip.Addr) []*icmp.Echo
+
+	mu    sync.Mutex
+	reqs  map[int]*icmp.Echo
+	readC chan message
+}
+
+// A message is an ICMP message and its source address.
+type message struct {
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+func newReplyConn(typ icmp.Type, onEcho func(req *icmp.Echo, dst netip.Addr) []*icmp.Echo) *replyConn {
+	return &replyConn{
+		typ:    typ,
+		onEcho: onEcho,
+		reqs:   make(map[int]*icmp.Echo),
+		readC:  make(chan message, 16),
+	}
+}
+
+func (*replyConn) Close() error { return nil }
+
+func (c *replyConn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-c.readC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (c *replyConn) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	req := msg.Body.(*icmp.Echo)
+
+	c.mu.Lock()
+	c.reqs[req.Seq] = req
+	c.mu.Unlock()
+
+	for _, rep := range c.onEcho(req, dst) {
+		c.send(rep, dst)
+	}
+
+	return nil
+}
+
+// resend replies to the echo request with sequence number seq.
+func (c *replyConn) resend(seq int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Replies are always sent from the same address in these tests.
+	c.send(c.reqs[seq], netip.MustParseAddr("2001:db8::1"))
+}
+
+// send sends an echo reply from ip over the "wire" to exercise marshaling.
+func (c *replyConn) send(echo *icmp.Echo, ip netip.Addr) {
+	c.sendMessage(&icmp.Message{Type: c.typ, Body: echo}, ip)
+}
+
+// sendMessage sends an arbitrary ICMP message from ip over the "wire".
+func (c *replyConn) sendMessage(msg *icmp.Message, ip netip.Addr) {
+	proto := 1
+	if ip.Is6() {
+		proto = 58
+	}
+
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		panic(err)
+	}
+	m, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		panic(err)
+	}
+
+	c.readC <- message{Message: m, IP: ip}
+}
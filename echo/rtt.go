@@ -0,0 +1,53 @@
+package echo
+
+import "time"
+
+// Constants from RFC 6298, section 2.
+const (
+	rttAlpha = 1.0 / 8
+	rttBeta  = 1.0 / 4
+	rttK     = 4
+
+	// rttGranularity is the clock granularity G.
+	rttGranularity = time.Millisecond
+)
+
+// An rttEstimator computes a retransmission timeout from round-trip time
+// samples for a single destination, as described in RFC 6298.
+type rttEstimator struct {
+	srtt, rttvar time.Duration
+	ok           bool
+}
+
+// Sample updates the smoothed round-trip time and round-trip time variance with
+// a new measurement r.
+func (e *rttEstimator) Sample(r time.Duration) {
+	if !e.ok {
+		// Section 2.2: the first measurement.
+		e.srtt = r
+		e.rttvar = r / 2
+		e.ok = true
+		return
+	}
+
+	// Section 2.3: subsequent measurements. RTTVAR must be updated using the
+	// previous SRTT.
+	delta := e.srtt - r
+	if delta < 0 {
+		delta = -delta
+	}
+
+	e.rttvar = time.Duration((1-rttBeta)*float64(e.rttvar) + rttBeta*float64(delta))
+	e.srtt = time.Duration((1-rttAlpha)*float64(e.srtt) + rttAlpha*float64(r))
+}
+
+// RTO returns the current retransmission timeout. Bounds are applied by the
+// caller.
+func (e *rttEstimator) RTO() time.Duration {
+	v := rttK * e.rttvar
+	if v < rttGranularity {
+		v = rttGranularity
+	}
+
+	return e.srtt + v
+}
@@ -0,0 +1,68 @@
+package echo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		srtt    time.Duration
+		rttvar  time.Duration
+		rto     time.Duration
+	}{
+		{
+			name:    "first",
+			samples: []time.Duration{100 * ms},
+			srtt:    100 * ms,
+			rttvar:  50 * ms,
+			rto:     300 * ms,
+		},
+		{
+			// RTTVAR = 3/4*50 + 1/4*|100-180| = 57.5ms.
+			// SRTT = 7/8*100 + 1/8*180 = 110ms.
+			name:    "second",
+			samples: []time.Duration{100 * ms, 180 * ms},
+			srtt:    110 * ms,
+			rttvar:  57500 * time.Microsecond,
+			rto:     340 * ms,
+		},
+		{
+			// Variance converges to zero, so RTO is bounded by the clock
+			// granularity.
+			name: "stable",
+			samples: func() []time.Duration {
+				ds := make([]time.Duration, 200)
+				for i := range ds {
+					ds[i] = 10 * ms
+				}
+				return ds
+			}(),
+			srtt:   10 * ms,
+			rttvar: 0,
+			rto:    11 * ms,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e rttEstimator
+			for _, r := range tt.samples {
+				e.Sample(r)
+			}
+
+			got := []time.Duration{e.srtt, e.rttvar, e.RTO()}
+			want := []time.Duration{tt.srtt, tt.rttvar, tt.rto}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("unexpected SRTT, RTTVAR, and RTO (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
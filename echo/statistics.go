@@ -0,0 +1,141 @@
+package echo
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// maxSamples is the number of the most recent round-trip times used to compute
+// percentiles.
+const maxSamples = 10000
+
+// Statistics are running statistics about the echo requests sent by a Pinger,
+// as reported by ping(8).
+type Statistics struct {
+	// Transmitted is the number of echo requests sent, and Received is the
+	// number which received a reply, including late replies.
+	Transmitted, Received int
+
+	// Duplicates, Late, and Corrupt are the number of duplicate, late, and
+	// corrupted replies received.
+	Duplicates, Late, Corrupt int
+
+	// Pending is the number of echo requests which have not received a
+	// reply, but have not yet timed out.
+	Pending int
+
+	// Loss is the percentage of echo requests which did not receive a reply,
+	// excluding those which are pending.
+	Loss float64
+
+	// Min, Avg, Max, and MDev are the minimum, average, maximum, and
+	// standard deviation of the round-trip times of replies. Duplicate and
+	// corrupted replies are not included.
+	Min, Avg, Max, MDev time.Duration
+
+	// P50, P90, and P99 are the 50th, 90th, and 99th percentiles of the
+	// round-trip times of up to 10000 of the most recent replies.
+	P50, P90, P99 time.Duration
+
+	// Jitter is the interarrival jitter of round-trip times in the order
+	// replies were received, as described in RFC 3550, section 6.4.1.
+	Jitter time.Duration
+}
+
+// stats accumulates Statistics.
+type stats struct {
+	transmitted, received              int
+	duplicates, late, corrupt, pending int
+
+	// Round-trip time sums in nanoseconds for the mean and standard
+	// deviation, and the jitter estimate.
+	min, max, last time.Duration
+	sum, sum2      float64
+	jitter         float64
+
+	// A ring of the most recent samples for percentiles.
+	samples []time.Duration
+	next    int
+}
+
+// sample records a round-trip time.
+func (s *stats) sample(rtt time.Duration) {
+	if s.received == 1 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+
+	f := float64(rtt)
+	s.sum += f
+	s.sum2 += f * f
+
+	// RFC 3550, section 6.4.1: J(i) = J(i-1) + (|D(i-1,i)| - J(i-1))/16.
+	// For round trips, the difference in transit times D is the difference
+	// in round-trip times.
+	if s.received > 1 {
+		d := math.Abs(float64(rtt - s.last))
+		s.jitter += (d - s.jitter) / 16
+	}
+	s.last = rtt
+
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, rtt)
+		return
+	}
+
+	s.samples[s.next] = rtt
+	s.next = (s.next + 1) % maxSamples
+}
+
+// Statistics produces Statistics from the accumulated values. Received must be
+// incremented before each call to sample.
+func (s *stats) Statistics() *Statistics {
+	st := &Statistics{
+		Transmitted: s.transmitted,
+		Received:    s.received,
+		Duplicates:  s.duplicates,
+		Late:        s.late,
+		Corrupt:     s.corrupt,
+		Pending:     s.pending,
+		Min:         s.min,
+		Max:         s.max,
+		Jitter:      time.Duration(s.jitter),
+	}
+
+	if done := s.transmitted - s.pending; done > 0 {
+		st.Loss = math.Max(0, float64(done-s.received)/float64(done)*100)
+	}
+
+	if s.received == 0 {
+		return st
+	}
+
+	// ping(8) reports the mean deviation as sqrt(E[rtt^2] - E[rtt]^2).
+	n := float64(s.received)
+	avg := s.sum / n
+	st.Avg = time.Duration(avg)
+	st.MDev = time.Duration(math.Sqrt(math.Max(0, s.sum2/n-avg*avg)))
+
+	sorted := append([]time.Duration(nil), s.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	st.P50 = percentile(sorted, 50)
+	st.P90 = percentile(sorted, 90)
+	st.P99 = percentile(sorted, 99)
+
+	return st
+}
+
+// percentile returns the pth percentile of the sorted samples using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return sorted[i]
+}
@@ -0,0 +1,518 @@
+package echo
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// Default SweepConfig values.
+const (
+	defaultRate          = 100
+	defaultCount         = 1
+	defaultSweepTimeout  = 500 * time.Millisecond
+	defaultSweepInterval = 1 * time.Second
+
+	// maxHostBits limits the size of each swept prefix to 2^24 addresses.
+	maxHostBits = 24
+)
+
+// A SweepConfig configures a Client.Sweep operation. The zero value is valid
+// and sends one echo request to each target at up to 100 echo requests per
+// second.
+type SweepConfig struct {
+	// Rate is the maximum number of echo requests sent per second across
+	// all targets. Echo requests are spaced evenly rather than sent in
+	// bursts. If zero, it defaults to 100.
+	Rate float64
+
+	// Count is the number of echo requests sent to each target, as with
+	// fping -c. If zero, it defaults to 1.
+	Count int
+
+	// Interval is the minimum time between echo requests to the same
+	// target, as with fping -p. If zero, it defaults to 1 second.
+	Interval time.Duration
+
+	// Timeout is how long to wait for a reply to each echo request. If zero,
+	// it defaults to 500 milliseconds.
+	Timeout time.Duration
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg SweepConfig) withDefaults() (SweepConfig, error) {
+	if cfg.Rate == 0 {
+		cfg.Rate = defaultRate
+	}
+	if cfg.Rate < 0 {
+		return SweepConfig{}, fmt.Errorf("echo: rate must be positive: %v", cfg.Rate)
+	}
+
+	if cfg.Count == 0 {
+		cfg.Count = defaultCount
+	}
+	if cfg.Count < 0 {
+		return SweepConfig{}, fmt.Errorf("echo: count must be positive: %d", cfg.Count)
+	}
+
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultSweepInterval
+	}
+	if cfg.Interval < 0 {
+		return SweepConfig{}, fmt.Errorf("echo: interval must be positive: %s", cfg.Interval)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultSweepTimeout
+	}
+	if cfg.Timeout < 0 {
+		return SweepConfig{}, fmt.Errorf("echo: timeout must be positive: %s", cfg.Timeout)
+	}
+
+	return cfg, nil
+}
+
+// A SweepResult is the result of sending echo requests to a single target
+// during a Client.Sweep operation.
+type SweepResult struct {
+	// IP is the address of the target.
+	IP netip.Addr
+
+	// Statistics are the statistics for the echo requests sent to the
+	// target. Replies which arrive after the timeout are not counted.
+	Statistics *Statistics
+
+	// Err reports an error which occurred while sending an echo request to
+	// the target, such as the network being unreachable. No further echo
+	// requests are sent to the target.
+	Err error
+}
+
+// PingMany sends echo requests to each of ips as described by Sweep.
+func (ec *Client) PingMany(ctx context.Context, ips []netip.Addr, cfg SweepConfig, fn func(*SweepResult)) error {
+	targets := make([]netip.Prefix, 0, len(ips))
+	for _, ip := range ips {
+		targets = append(targets, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+
+	return ec.Sweep(ctx, targets, cfg, fn)
+}
+
+// Sweep sends echo requests to every address in each of targets, in the style
+// of fping. Targets are pinged concurrently using the Client's network
+// connections, subject to the global rate and per-target interval in cfg. The
+// network and broadcast addresses of IPv4 prefixes shorter than /31 are
+// skipped. Each prefix may contain at most 2^24 addresses.
+//
+// fn is called synchronously with the result for each target as soon as all
+// of its echo requests have received a reply or timed out. Sweep returns once
+// every target has completed or ctx is canceled.
+func (ec *Client) Sweep(ctx context.Context, targets []netip.Prefix, cfg SweepConfig, fn func(*SweepResult)) error {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range targets {
+		if !p.IsValid() || p.Masked() != p {
+			return fmt.Errorf("echo: invalid target prefix: %q", p)
+		}
+		if p.Addr().BitLen()-p.Bits() > maxHostBits {
+			return fmt.Errorf("echo: target prefix %s contains more than 2^%d addresses", p, maxHostBits)
+		}
+	}
+
+	if fn == nil {
+		fn = func(*SweepResult) {}
+	}
+
+	s := &sweep{
+		ec:       ec,
+		cfg:      cfg,
+		fn:       fn,
+		gap:      time.Duration(float64(time.Second) / cfg.Rate),
+		next:     newAddrIterator(targets),
+		requests: make(map[int]*sweepRequest),
+		maxSeqs:  1 << 16,
+		ids:      make(map[*connContext]echoID),
+		data:     make(map[*connContext][]byte),
+	}
+
+	// Each sweep has a unique echo ID per address family, and a single
+	// sequence number space across both.
+	resC := make(map[*connContext]<-chan pingResponse)
+	for _, cc := range []*connContext{ec.v4, ec.v6} {
+		id, c, err := cc.subscribe()
+		if err != nil {
+			return err
+		}
+		defer cc.unsubscribe(id)
+
+		s.ids[cc] = id
+		resC[cc] = c
+	}
+
+	return s.run(ctx, resC[ec.v4], resC[ec.v6])
+}
+
+// A sweep is the state of a Client.Sweep operation.
+type sweep struct {
+	ec  *Client
+	cfg SweepConfig
+	fn  func(*SweepResult)
+
+	// The time between echo requests, and the next time an echo request
+	// may be sent.
+	gap  time.Duration
+	slot time.Time
+
+	// Targets which have not yet been pinged, and active targets in the
+	// order in which they are due to be pinged again.
+	next   *addrIterator
+	queue  []*sweepTarget
+	active int
+
+	// Echo requests which have not yet expired by sequence number, in the
+	// order in which they expire. Once all maxSeqs sequence numbers are in
+	// use, no more echo requests are sent until one expires.
+	seq      int
+	requests map[int]*sweepRequest
+	expiries []*sweepRequest
+	maxSeqs  int
+
+	// The echo ID and payload for each address family.
+	ids  map[*connContext]echoID
+	data map[*connContext][]byte
+}
+
+// A sweepTarget is the state of a single target in a sweep.
+type sweepTarget struct {
+	ip  netip.Addr
+	cc  *connContext
+	due time.Time
+
+	sent, outstanding int
+	done              bool
+	stats             stats
+}
+
+// A sweepRequest is an echo request sent to a sweepTarget.
+type sweepRequest struct {
+	t              *sweepTarget
+	seq            int
+	sent, deadline time.Time
+	replied        bool
+}
+
+// run runs the sweep until all targets have completed or ctx is canceled.
+func (s *sweep) run(ctx context.Context, res4, res6 <-chan pingResponse) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		now := time.Now()
+
+		// Send as many echo requests as are due and permitted by the rate
+		// and the sequence numbers available.
+		for !now.Before(s.slot) && !s.full() {
+			t := s.due(now)
+			if t == nil {
+				break
+			}
+
+			if err := s.send(ctx, t, now); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				t.stats.pending = 0
+				s.complete(t, err)
+			}
+
+			s.slot = now.Add(s.gap)
+		}
+
+		if s.active == 0 && s.next.Done() {
+			return nil
+		}
+
+		// Sleep until the next echo request expires or may be sent.
+		wake := s.wake(now)
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(wake))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case res := <-res4:
+			s.reply(s.ec.v4, res)
+		case res := <-res6:
+			s.reply(s.ec.v6, res)
+		case now := <-timer.C:
+			s.expire(now)
+		}
+	}
+}
+
+// full reports whether every sequence number is in use.
+func (s *sweep) full() bool { return len(s.requests) >= s.maxSeqs }
+
+// due returns the next target to ping at time now, or nil if none are due.
+func (s *sweep) due(now time.Time) *sweepTarget {
+	// Active targets take priority over new ones, to bound the number of
+	// targets in progress.
+	if len(s.queue) > 0 && !now.Before(s.queue[0].due) {
+		t := s.queue[0]
+		s.queue = s.queue[1:]
+		return t
+	}
+
+	ip, ok := s.next.Next()
+	if !ok {
+		return nil
+	}
+
+	cc := s.ec.v6
+	if ip.Is4() {
+		cc = s.ec.v4
+	}
+
+	s.active++
+	return &sweepTarget{ip: ip, cc: cc}
+}
+
+// wake returns the time at which the sweep must next act after time now.
+func (s *sweep) wake(now time.Time) time.Time {
+	wake := now.Add(s.cfg.Interval + s.cfg.Timeout)
+	if len(s.expiries) > 0 {
+		wake = s.expiries[0].deadline
+	}
+
+	send := now
+	switch {
+	case s.full():
+		// Wait for a sequence number to become available.
+		return wake
+	case !s.next.Done():
+	case len(s.queue) > 0:
+		send = s.queue[0].due
+	default:
+		return wake
+	}
+
+	if send.Before(s.slot) {
+		send = s.slot
+	}
+	if send.Before(wake) {
+		return send
+	}
+
+	return wake
+}
+
+// send sends an echo request to t at time now.
+func (s *sweep) send(ctx context.Context, t *sweepTarget, now time.Time) error {
+	data, ok := s.data[t.cc]
+	if !ok {
+		var err error
+		data, err = t.cc.payload(t.ip)
+		if err != nil {
+			return err
+		}
+		s.data[t.cc] = data
+	}
+
+	// Skip sequence numbers still in use after wrapping. The caller ensures
+	// that at least one is free.
+	for {
+		s.seq = nextSeq(s.seq)
+		if _, ok := s.requests[s.seq]; !ok {
+			break
+		}
+	}
+
+	msg := &icmp.Message{
+		Type: t.cc.typ,
+		Body: &icmp.Echo{ID: s.ids[t.cc], Seq: s.seq, Data: data},
+	}
+	if err := t.cc.conn.WriteTo(ctx, msg, t.ip); err != nil {
+		return err
+	}
+
+	req := &sweepRequest{
+		t:        t,
+		seq:      s.seq,
+		sent:     now,
+		deadline: now.Add(s.cfg.Timeout),
+	}
+	s.requests[s.seq] = req
+	s.expiries = append(s.expiries, req)
+
+	t.sent++
+	t.outstanding++
+	t.stats.transmitted++
+	t.stats.pending++
+
+	if t.sent < s.cfg.Count {
+		t.due = now.Add(s.cfg.Interval)
+		s.queue = append(s.queue, t)
+	}
+
+	return nil
+}
+
+// reply handles an echo response received on cc.
+func (s *sweep) reply(cc *connContext, res pingResponse) {
+	// Replies which arrive after their request expired are too late to be
+	// counted.
+	req, ok := s.requests[res.Echo.Seq]
+	if !ok || req.t.done || res.Err != nil || req.t.cc != cc || req.t.ip.WithZone("") != res.IP.WithZone("") {
+		// Not a reply to any of our requests. ICMP errors are treated as
+		// lost replies.
+		return
+	}
+
+	t := req.t
+	if err := validate(&icmp.Echo{Data: s.data[cc]}, res.Echo); err != nil {
+		t.stats.corrupt++
+		return
+	}
+
+	if req.replied {
+		t.stats.duplicates++
+		return
+	}
+
+	req.replied = true
+	t.outstanding--
+	t.stats.pending--
+	t.stats.received++
+	t.stats.sample(time.Since(req.sent))
+
+	s.check(t)
+}
+
+// expire handles echo requests which expire by time now, freeing their sequence
+// numbers. Requests which received a reply are kept until they expire so that
+// duplicate replies are detected.
+func (s *sweep) expire(now time.Time) {
+	for len(s.expiries) > 0 {
+		req := s.expiries[0]
+		if now.Before(req.deadline) {
+			return
+		}
+		s.expiries = s.expiries[1:]
+		delete(s.requests, req.seq)
+
+		if req.replied || req.t.done {
+			continue
+		}
+
+		req.t.outstanding--
+		req.t.stats.pending--
+
+		s.check(req.t)
+	}
+}
+
+// check completes t if all of its echo requests have been sent and have
+// received a reply or timed out.
+func (s *sweep) check(t *sweepTarget) {
+	if t.sent == s.cfg.Count && t.outstanding == 0 {
+		s.complete(t, nil)
+	}
+}
+
+// complete reports the result for t. Any of its echo requests which have not
+// yet expired are ignored from now on.
+func (s *sweep) complete(t *sweepTarget, err error) {
+	t.done = true
+	s.active--
+
+	s.fn(&SweepResult{
+		IP:         t.ip,
+		Statistics: t.stats.Statistics(),
+		Err:        err,
+	})
+}
+
+// An addrIterator produces each address to sweep in a list of prefixes.
+type addrIterator struct {
+	prefixes []netip.Prefix
+	ip       netip.Addr
+}
+
+// newAddrIterator creates an addrIterator for prefixes.
+func newAddrIterator(prefixes []netip.Prefix) *addrIterator {
+	it := &addrIterator{prefixes: prefixes}
+	it.advance()
+	return it
+}
+
+// Done reports whether all addresses have been produced.
+func (it *addrIterator) Done() bool { return len(it.prefixes) == 0 }
+
+// Next returns the next address, if any.
+func (it *addrIterator) Next() (netip.Addr, bool) {
+	if it.Done() {
+		return netip.Addr{}, false
+	}
+
+	ip := it.ip
+	it.ip = it.ip.Next()
+	if !it.valid(it.ip) {
+		it.prefixes = it.prefixes[1:]
+		it.advance()
+	}
+
+	return ip, true
+}
+
+// advance moves to the first address of the first prefix which contains any.
+func (it *addrIterator) advance() {
+	for len(it.prefixes) > 0 {
+		p := it.prefixes[0]
+		ip := p.Addr()
+		if it.skipEnds(p) {
+			// Skip the IPv4 network address.
+			ip = ip.Next()
+		}
+
+		if it.valid(ip) {
+			it.ip = ip
+			return
+		}
+
+		it.prefixes = it.prefixes[1:]
+	}
+}
+
+// valid reports whether ip is within the current prefix and may be swept.
+func (it *addrIterator) valid(ip netip.Addr) bool {
+	p := it.prefixes[0]
+	if !ip.IsValid() || !p.Contains(ip) {
+		return false
+	}
+
+	// Skip the IPv4 broadcast address, which is the last in the prefix.
+	if it.skipEnds(p) {
+		if next := ip.Next(); !next.IsValid() || !p.Contains(next) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// skipEnds reports whether the first and last addresses of p are reserved.
+func (*addrIterator) skipEnds(p netip.Prefix) bool {
+	return p.Addr().Is4() && p.Bits() < 31
+}
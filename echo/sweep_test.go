@@ -0,0 +1,263 @@
+package echo
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestClientSweep(t *testing.T) {
+	var (
+		lost    = netip.MustParseAddr("2001:db8::3")
+		spoofed = netip.MustParseAddr("2001:db8::2")
+		other   = netip.MustParseAddr("2001:db8::ff")
+	)
+
+	reply := func(req *icmp.Echo, dst netip.Addr) []*icmp.Echo {
+		if dst == lost || dst == spoofed {
+			return nil
+		}
+
+		return []*icmp.Echo{req}
+	}
+
+	var (
+		c4 = newReplyConn(ipv4.ICMPTypeEchoReply, reply)
+		c6 *replyConn
+	)
+
+	// Replies to the spoofed target come from another address and must not
+	// be counted.
+	c6 = newReplyConn(ipv6.ICMPTypeEchoReply, func(req *icmp.Echo, dst netip.Addr) []*icmp.Echo {
+		if dst == spoofed {
+			c6.send(req, other)
+		}
+
+		return reply(req, dst)
+	})
+
+	cfg, err := Config{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	c := newClient(cfg, c4, c6)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	got := make(map[netip.Addr][2]int)
+	err = c.Sweep(ctx, []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/30"),
+		netip.MustParsePrefix("2001:db8::/126"),
+	}, SweepConfig{
+		Rate:     1000,
+		Count:    2,
+		Interval: 10 * time.Millisecond,
+		Timeout:  100 * time.Millisecond,
+	}, func(r *SweepResult) {
+		if r.Err != nil {
+			t.Errorf("error for %s: %v", r.IP, r.Err)
+		}
+		if _, ok := got[r.IP]; ok {
+			t.Errorf("duplicate result for %s", r.IP)
+		}
+
+		got[r.IP] = [2]int{r.Statistics.Transmitted, r.Statistics.Received}
+	})
+	if err != nil {
+		t.Fatalf("failed to sweep: %v", err)
+	}
+
+	// The IPv4 network and broadcast addresses are skipped.
+	want := map[netip.Addr][2]int{
+		netip.MustParseAddr("192.0.2.1"):   {2, 2},
+		netip.MustParseAddr("192.0.2.2"):   {2, 2},
+		netip.MustParseAddr("2001:db8::"):  {2, 2},
+		netip.MustParseAddr("2001:db8::1"): {2, 2},
+		spoofed:                            {2, 0},
+		lost:                               {2, 0},
+	}
+
+	if diff := cmp.Diff(want, got, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected results (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientPingManyRate(t *testing.T) {
+	c := testClient(t)
+
+	ips := []netip.Addr{c.Host4.IP, c.Host6.IP, c.Host4.IP, c.Host6.IP, c.Host4.IP}
+
+	// 100 echo requests per second are sent 10ms apart.
+	start := time.Now()
+	var n int
+	err := c.Client.PingMany(context.Background(), ips, SweepConfig{Rate: 100}, func(r *SweepResult) {
+		if r.Statistics.Received != 1 {
+			t.Errorf("no reply from %s", r.IP)
+		}
+
+		n++
+	})
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+
+	if diff := cmp.Diff(len(ips), n); diff != "" {
+		t.Fatalf("unexpected number of results (-want +got):\n%s", diff)
+	}
+
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("sent %d echo requests in %s, faster than the rate limit", len(ips), d)
+	}
+}
+
+func TestClientPingManySequenceSpace(t *testing.T) {
+	// Emulate a host which never replies. Once every sequence number is in
+	// use, the sweep must wait for echo requests to expire rather than hang.
+	conn := newReplyConn(ipv6.ICMPTypeEchoReply, func(*icmp.Echo, netip.Addr) []*icmp.Echo { return nil })
+
+	cfg, err := Config{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	c := newClient(cfg, newReplyConn(nil, nil), conn)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const (
+		count   = 1<<16 + 100
+		timeout = 200 * time.Millisecond
+	)
+
+	var stats *Statistics
+	start := time.Now()
+	err = c.PingMany(ctx, []netip.Addr{netip.MustParseAddr("2001:db8::1")}, SweepConfig{
+		Rate:     1e9,
+		Count:    count,
+		Interval: time.Nanosecond,
+		Timeout:  timeout,
+	}, func(r *SweepResult) {
+		stats = r.Statistics
+	})
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+
+	if stats == nil || stats.Transmitted != count || stats.Received != 0 {
+		t.Fatalf("unexpected statistics: %+v", stats)
+	}
+
+	if d := time.Since(start); d < 2*timeout {
+		t.Fatalf("sent %d echo requests in %s without waiting for sequence numbers to expire", count, d)
+	}
+}
+
+func TestClientSweepErrors(t *testing.T) {
+	c := testClient(t)
+
+	for _, p := range []netip.Prefix{
+		{},
+		netip.MustParsePrefix("192.0.2.1/24"),
+		netip.MustParsePrefix("2001:db8::/64"),
+	} {
+		if err := c.Client.Sweep(context.Background(), []netip.Prefix{p}, SweepConfig{}, nil); err == nil {
+			t.Fatalf("expected an error for %s, but none occurred", p)
+		}
+	}
+}
+
+func TestAddrIterator(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		want     []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "IPv4 /30",
+			prefixes: []string{"192.0.2.0/30"},
+			want:     []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:     "IPv4 /31 and /32",
+			prefixes: []string{"192.0.2.0/31", "192.0.2.7/32"},
+			want:     []string{"192.0.2.0", "192.0.2.1", "192.0.2.7"},
+		},
+		{
+			name:     "IPv4 end of address space",
+			prefixes: []string{"255.255.255.252/30"},
+			want:     []string{"255.255.255.253", "255.255.255.254"},
+		},
+		{
+			name:     "IPv6",
+			prefixes: []string{"2001:db8::/127", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff/128"},
+			want:     []string{"2001:db8::", "2001:db8::1", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prefixes []netip.Prefix
+			for _, p := range tt.prefixes {
+				prefixes = append(prefixes, netip.MustParsePrefix(p))
+			}
+
+			var got []string
+			it := newAddrIterator(prefixes)
+			for {
+				ip, ok := it.Next()
+				if !ok {
+					break
+				}
+
+				got = append(got, ip.String())
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected addresses (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSweepConfig(t *testing.T) {
+	for _, cfg := range []SweepConfig{
+		{Rate: -1},
+		{Count: -1},
+		{Interval: -1},
+		{Timeout: -1},
+	} {
+		if _, err := cfg.withDefaults(); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+
+	cfg, err := SweepConfig{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	want := SweepConfig{
+		Rate:     100,
+		Count:    1,
+		Interval: 1 * time.Second,
+		Timeout:  500 * time.Millisecond,
+	}
+
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatalf("unexpected config (-want +got):\n%s", diff)
+	}
+}
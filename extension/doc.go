@@ -0,0 +1,5 @@
+// Package extension implements encoding and decoding of ICMP multi-part message
+// extensions as described in RFC 4884, including MPLS label stacks (RFC 4950),
+// interface and next-hop information (RFC 5837), and interface identification
+// for extended echo (RFC 8335).
+package extension
@@ -0,0 +1,257 @@
+package extension
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/icmp"
+)
+
+// version is the ICMP extension structure version defined by RFC 4884.
+const version = 2
+
+// Lengths of the extension and object headers.
+const (
+	extensionHeaderLen = 4
+	objectHeaderLen    = 4
+)
+
+// A Class is an ICMP extension object class number.
+type Class uint8
+
+// Possible Class values.
+const (
+	ClassMPLSLabelStack          Class = 1
+	ClassInterfaceInformation    Class = 2
+	ClassInterfaceIdentification Class = 3
+)
+
+// String returns the name of a Class.
+func (c Class) String() string {
+	switch c {
+	case ClassMPLSLabelStack:
+		return "MPLS Label Stack"
+	case ClassInterfaceInformation:
+		return "Interface Information"
+	case ClassInterfaceIdentification:
+		return "Interface Identification"
+	default:
+		return fmt.Sprintf("Class(%d)", c)
+	}
+}
+
+// An Object is an ICMP extension object. The concrete types of Object are
+// *MPLSLabelStack, *InterfaceInformation, *InterfaceIdentification, and
+// *RawObject.
+type Object interface {
+	// Class and CType return the object's class number and class sub-type.
+	Class() Class
+	CType() uint8
+
+	// marshal returns the object's payload, excluding the object header.
+	marshal() ([]byte, error)
+}
+
+var (
+	errShortExtension = errors.New("extension: extension structure too short")
+	errShortObject    = errors.New("extension: object too short")
+)
+
+// Parse parses an ICMP extension structure, beginning with the extension
+// header, into its Objects. If the checksum is non-zero, it must be valid.
+func Parse(b []byte) ([]Object, error) {
+	if len(b) < extensionHeaderLen {
+		return nil, errShortExtension
+	}
+
+	if v := b[0] >> 4; v != version {
+		return nil, fmt.Errorf("extension: unsupported version: %d", v)
+	}
+
+	if binary.BigEndian.Uint16(b[2:4]) != 0 && checksum(b) != 0 {
+		return nil, errors.New("extension: invalid checksum")
+	}
+
+	var objs []Object
+	for b = b[extensionHeaderLen:]; len(b) > 0; {
+		if len(b) < objectHeaderLen {
+			return nil, errShortObject
+		}
+
+		l := int(binary.BigEndian.Uint16(b[0:2]))
+		if l < objectHeaderLen || l > len(b) {
+			return nil, fmt.Errorf("extension: invalid object length: %d", l)
+		}
+
+		obj, err := parseObject(Class(b[2]), b[3], b[objectHeaderLen:l])
+		if err != nil {
+			return nil, err
+		}
+
+		objs = append(objs, obj)
+		b = b[l:]
+	}
+
+	return objs, nil
+}
+
+// Marshal produces an ICMP extension structure containing objs, including the
+// extension header and a valid checksum.
+func Marshal(objs []Object) ([]byte, error) {
+	b := make([]byte, extensionHeaderLen)
+	b[0] = version << 4
+
+	for _, o := range objs {
+		ob, err := marshalObject(o)
+		if err != nil {
+			return nil, err
+		}
+
+		b = append(b, ob...)
+	}
+
+	binary.BigEndian.PutUint16(b[2:4], checksum(b))
+	return b, nil
+}
+
+// FromMessage returns the Objects carried in the extensions of an ICMP
+// Destination Unreachable, Time Exceeded, Parameter Problem, or Extended Echo
+// Request message. Messages of other types produce no Objects.
+func FromMessage(m *icmp.Message) ([]Object, error) {
+	var exts []icmp.Extension
+	switch b := m.Body.(type) {
+	case *icmp.DstUnreach:
+		exts = b.Extensions
+	case *icmp.TimeExceeded:
+		exts = b.Extensions
+	case *icmp.ParamProb:
+		exts = b.Extensions
+	case *icmp.ExtendedEchoRequest:
+		exts = b.Extensions
+	default:
+		return nil, nil
+	}
+
+	// x/net/icmp has already split the extension structure into objects, so
+	// re-encode each one and decode it into our own types.
+	proto := m.Type.Protocol()
+	objs := make([]Object, 0, len(exts))
+	for _, e := range exts {
+		b, err := e.Marshal(proto)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < objectHeaderLen {
+			// x/net may produce empty raw extensions.
+			continue
+		}
+
+		obj, err := parseObject(Class(b[2]), b[3], b[objectHeaderLen:])
+		if err != nil {
+			return nil, err
+		}
+
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
+
+// Extensions converts Objects into x/net/icmp extensions which can be attached
+// to an ICMP message body for transmission.
+func Extensions(objs []Object) ([]icmp.Extension, error) {
+	exts := make([]icmp.Extension, 0, len(objs))
+	for _, o := range objs {
+		b, err := marshalObject(o)
+		if err != nil {
+			return nil, err
+		}
+
+		exts = append(exts, &icmp.RawExtension{Data: b})
+	}
+
+	return exts, nil
+}
+
+// parseObject parses a single object's payload according to its class and
+// sub-type.
+func parseObject(class Class, ctype uint8, b []byte) (Object, error) {
+	var obj interface {
+		Object
+		unmarshal(ctype uint8, b []byte) error
+	}
+
+	switch {
+	case class == ClassMPLSLabelStack && ctype == ctypeIncomingMPLSLabelStack:
+		obj = new(MPLSLabelStack)
+	case class == ClassInterfaceInformation:
+		obj = new(InterfaceInformation)
+	case class == ClassInterfaceIdentification && ctype >= ctypeIdentName && ctype <= ctypeIdentAddress:
+		obj = new(InterfaceIdentification)
+	default:
+		obj = &RawObject{ClassNum: class, Type: ctype}
+	}
+
+	if err := obj.unmarshal(ctype, b); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// marshalObject produces the wire format of an object including its header.
+func marshalObject(o Object) ([]byte, error) {
+	payload, err := o.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	l := objectHeaderLen + len(payload)
+	if l > 0xffff {
+		return nil, fmt.Errorf("extension: %s object too large: %d bytes", o.Class(), l)
+	}
+
+	b := make([]byte, objectHeaderLen, l)
+	binary.BigEndian.PutUint16(b[0:2], uint16(l))
+	b[2], b[3] = byte(o.Class()), o.CType()
+	return append(b, payload...), nil
+}
+
+var _ Object = &RawObject{}
+
+// A RawObject is an extension object of an unknown class or sub-type.
+type RawObject struct {
+	ClassNum Class
+	Type     uint8
+	Data     []byte
+}
+
+// Class implements Object.
+func (o *RawObject) Class() Class { return o.ClassNum }
+
+// CType implements Object.
+func (o *RawObject) CType() uint8 { return o.Type }
+
+func (o *RawObject) marshal() ([]byte, error) { return o.Data, nil }
+
+func (o *RawObject) unmarshal(_ uint8, b []byte) error {
+	o.Data = append([]byte(nil), b...)
+	return nil
+}
+
+// checksum computes the Internet checksum of b.
+func checksum(b []byte) uint16 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s > 0xffff {
+		s = (s >> 16) + (s & 0xffff)
+	}
+
+	return ^uint16(s)
+}
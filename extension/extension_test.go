@@ -0,0 +1,339 @@
+package extension_test
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/extension"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestMarshalParse(t *testing.T) {
+	tests := []struct {
+		name string
+		objs []extension.Object
+		b    []byte
+	}{
+		{
+			name: "MPLS",
+			objs: []extension.Object{&extension.MPLSLabelStack{
+				Labels: []extension.MPLSLabel{
+					{Label: 16, TrafficClass: 5, TTL: 1},
+					{Label: 1<<20 - 1, BottomOfStack: true, TTL: 255},
+				},
+			}},
+			b: []byte{
+				// Extension header.
+				0x20, 0x00, 0xe2, 0xf0,
+				// Object header: length 12, class 1, C-Type 1.
+				0x00, 0x0c, 0x01, 0x01,
+				0x00, 0x01, 0x0a, 0x01,
+				0xff, 0xff, 0xf1, 0xff,
+			},
+		},
+		{
+			name: "interface information",
+			objs: []extension.Object{&extension.InterfaceInformation{
+				Role:  extension.RoleIncoming,
+				Index: 2,
+				Addr:  netip.MustParseAddr("192.0.2.1"),
+				Name:  "eth0",
+				MTU:   1500,
+			}},
+			b: []byte{
+				0x20, 0x00, 0x69, 0x26,
+				// Object header: length 28, class 2, all attributes.
+				0x00, 0x1c, 0x02, 0x0f,
+				// ifIndex.
+				0x00, 0x00, 0x00, 0x02,
+				// IPv4 address sub-object.
+				0x00, 0x01, 0x00, 0x00,
+				192, 0, 2, 1,
+				// Name sub-object.
+				0x08, 'e', 't', 'h',
+				'0', 0x00, 0x00, 0x00,
+				// MTU.
+				0x00, 0x00, 0x05, 0xdc,
+			},
+		},
+		{
+			name: "interface information next-hop",
+			objs: []extension.Object{&extension.InterfaceInformation{
+				Role: extension.RoleNextHop,
+				Addr: netip.MustParseAddr("2001:db8::1"),
+			}},
+		},
+		{
+			name: "interface identification name",
+			objs: []extension.Object{&extension.InterfaceIdentification{
+				Name: "lo",
+			}},
+			b: []byte{
+				0x20, 0x00, 0x70, 0x87,
+				0x00, 0x08, 0x03, 0x01,
+				'l', 'o', 0x00, 0x00,
+			},
+		},
+		{
+			name: "interface identification index",
+			objs: []extension.Object{&extension.InterfaceIdentification{
+				Index: 1,
+			}},
+		},
+		{
+			name: "interface identification address",
+			objs: []extension.Object{&extension.InterfaceIdentification{
+				Addr: netip.MustParseAddr("2001:db8::1"),
+			}},
+		},
+		{
+			name: "raw",
+			objs: []extension.Object{&extension.RawObject{
+				ClassNum: 255,
+				Type:     1,
+				Data:     []byte{0xde, 0xad, 0xbe, 0xef},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := extension.Marshal(tt.objs)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			if tt.b != nil {
+				if diff := cmp.Diff(tt.b, b); diff != "" {
+					t.Fatalf("unexpected bytes (-want +got):\n%s", diff)
+				}
+			}
+
+			objs, err := extension.Parse(b)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.objs, objs, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected objects (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{
+			name: "short",
+			b:    []byte{0x20, 0x00},
+		},
+		{
+			name: "version",
+			b:    []byte{0x10, 0x00, 0x00, 0x00},
+		},
+		{
+			name: "checksum",
+			b: []byte{
+				0x20, 0x00, 0xff, 0xff,
+				0x00, 0x04, 0xff, 0x01,
+			},
+		},
+		{
+			name: "object length",
+			b: []byte{
+				0x20, 0x00, 0x00, 0x00,
+				0x00, 0x10, 0x01, 0x01,
+			},
+		},
+		{
+			name: "MPLS length",
+			b: []byte{
+				0x20, 0x00, 0x00, 0x00,
+				0x00, 0x06, 0x01, 0x01,
+				0x00, 0x00,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := extension.Parse(tt.b); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  extension.Object
+	}{
+		{
+			name: "MPLS label",
+			obj: &extension.MPLSLabelStack{
+				Labels: []extension.MPLSLabel{{Label: 1 << 20}},
+			},
+		},
+		{
+			name: "identification empty",
+			obj:  &extension.InterfaceIdentification{},
+		},
+		{
+			name: "identification multiple",
+			obj: &extension.InterfaceIdentification{
+				Name:  "eth0",
+				Index: 1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := extension.Marshal([]extension.Object{tt.obj}); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestFromMessage(t *testing.T) {
+	// Build a Time Exceeded message with x/net/icmp's extension types, then
+	// verify they are decoded into the equivalent objects.
+	tests := []struct {
+		name  string
+		typ   icmp.Type
+		proto int
+		addr  net.IP
+		ip    netip.Addr
+	}{
+		{
+			name:  "IPv4",
+			typ:   ipv4.ICMPTypeTimeExceeded,
+			proto: 1,
+			addr:  net.IPv4(192, 0, 2, 1),
+			ip:    netip.MustParseAddr("192.0.2.1"),
+		},
+		{
+			name:  "IPv6",
+			typ:   ipv6.ICMPTypeTimeExceeded,
+			proto: 58,
+			addr:  net.ParseIP("2001:db8::1"),
+			ip:    netip.MustParseAddr("2001:db8::1"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := (&icmp.Message{
+				Type: tt.typ,
+				Body: &icmp.TimeExceeded{
+					Data: make([]byte, 128),
+					Extensions: []icmp.Extension{
+						&icmp.MPLSLabelStack{
+							Class: 1,
+							Type:  1,
+							Labels: []icmp.MPLSLabel{{
+								Label: 16,
+								S:     true,
+								TTL:   1,
+							}},
+						},
+						&icmp.InterfaceInfo{
+							Class: 2,
+							Type:  0x0f,
+							Interface: &net.Interface{
+								Index: 2,
+								Name:  "eth0",
+								MTU:   1500,
+							},
+							Addr: &net.IPAddr{IP: tt.addr},
+						},
+					},
+				},
+			}).Marshal(nil)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			m, err := icmp.ParseMessage(tt.proto, b)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			objs, err := extension.FromMessage(m)
+			if err != nil {
+				t.Fatalf("failed to get objects: %v", err)
+			}
+
+			want := []extension.Object{
+				&extension.MPLSLabelStack{
+					Labels: []extension.MPLSLabel{{
+						Label:         16,
+						BottomOfStack: true,
+						TTL:           1,
+					}},
+				},
+				&extension.InterfaceInformation{
+					Role:  extension.RoleIncoming,
+					Index: 2,
+					Addr:  tt.ip,
+					Name:  "eth0",
+					MTU:   1500,
+				},
+			}
+
+			if diff := cmp.Diff(want, objs, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected objects (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	// Objects converted to x/net/icmp extensions can be sent in a message
+	// and decoded on the other side.
+	want := []extension.Object{&extension.InterfaceInformation{
+		Role:  extension.RoleOutgoing,
+		Index: 10,
+	}}
+
+	exts, err := extension.Extensions(want)
+	if err != nil {
+		t.Fatalf("failed to convert: %v", err)
+	}
+
+	b, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeDestinationUnreachable,
+		Body: &icmp.DstUnreach{
+			Data:       make([]byte, 128),
+			Extensions: exts,
+		},
+	}).Marshal(nil)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	m, err := icmp.ParseMessage(1, b)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	got, err := extension.FromMessage(m)
+	if err != nil {
+		t.Fatalf("failed to get objects: %v", err)
+	}
+
+	if diff := cmp.Diff(want, got, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected objects (-want +got):\n%s", diff)
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
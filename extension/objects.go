@@ -0,0 +1,364 @@
+package extension
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Class sub-types.
+const (
+	ctypeIncomingMPLSLabelStack = 1
+
+	ctypeIdentName    = 1
+	ctypeIdentIndex   = 2
+	ctypeIdentAddress = 3
+)
+
+// Address Family Identifiers used by RFC 5837 and RFC 8335.
+const (
+	afiIPv4 = 1
+	afiIPv6 = 2
+)
+
+var _ Object = &MPLSLabelStack{}
+
+// An MPLSLabelStack is an incoming MPLS label stack object, as described in
+// RFC 4950.
+type MPLSLabelStack struct {
+	Labels []MPLSLabel
+}
+
+// An MPLSLabel is a single MPLS label stack entry.
+type MPLSLabel struct {
+	// Label is the 20-bit label value.
+	Label uint32
+
+	// TrafficClass is the 3-bit traffic class, formerly the experimental
+	// use field.
+	TrafficClass uint8
+
+	// BottomOfStack reports whether this is the last entry in the stack.
+	BottomOfStack bool
+
+	TTL uint8
+}
+
+// Class implements Object.
+func (*MPLSLabelStack) Class() Class { return ClassMPLSLabelStack }
+
+// CType implements Object.
+func (*MPLSLabelStack) CType() uint8 { return ctypeIncomingMPLSLabelStack }
+
+func (ls *MPLSLabelStack) marshal() ([]byte, error) {
+	b := make([]byte, 0, 4*len(ls.Labels))
+	for _, l := range ls.Labels {
+		if l.Label > 1<<20-1 || l.TrafficClass > 7 {
+			return nil, fmt.Errorf("extension: invalid MPLS label: %+v", l)
+		}
+
+		v := l.Label<<12 | uint32(l.TrafficClass)<<9 | uint32(l.TTL)
+		if l.BottomOfStack {
+			v |= 1 << 8
+		}
+
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+
+	return b, nil
+}
+
+func (ls *MPLSLabelStack) unmarshal(_ uint8, b []byte) error {
+	if len(b)%4 != 0 {
+		return fmt.Errorf("extension: invalid MPLS label stack length: %d", len(b))
+	}
+
+	ls.Labels = make([]MPLSLabel, 0, len(b)/4)
+	for ; len(b) > 0; b = b[4:] {
+		v := binary.BigEndian.Uint32(b[:4])
+		ls.Labels = append(ls.Labels, MPLSLabel{
+			Label:         v >> 12,
+			TrafficClass:  uint8(v>>9) & 0x7,
+			BottomOfStack: v&(1<<8) != 0,
+			TTL:           uint8(v),
+		})
+	}
+
+	return nil
+}
+
+// A Role indicates the role of the interface described by an
+// InterfaceInformation object.
+type Role uint8
+
+// Possible Role values.
+const (
+	RoleIncoming Role = iota
+	RoleSubIP
+	RoleOutgoing
+	RoleNextHop
+)
+
+// String returns the name of a Role.
+func (r Role) String() string {
+	switch r {
+	case RoleIncoming:
+		return "incoming"
+	case RoleSubIP:
+		return "sub-IP"
+	case RoleOutgoing:
+		return "outgoing"
+	case RoleNextHop:
+		return "next-hop"
+	default:
+		return fmt.Sprintf("Role(%d)", r)
+	}
+}
+
+// Interface Information C-Type bits.
+const (
+	infoMTU     = 1 << 0
+	infoName    = 1 << 1
+	infoIPAddr  = 1 << 2
+	infoIfIndex = 1 << 3
+)
+
+// maxNameLen is the maximum length of an RFC 5837 interface name, excluding
+// its length octet.
+const maxNameLen = 63
+
+var _ Object = &InterfaceInformation{}
+
+// An InterfaceInformation object identifies an interface involved in
+// generating an ICMP error, as described in RFC 5837. Each field other than
+// Role is optional and is omitted when it is the zero value.
+type InterfaceInformation struct {
+	Role  Role
+	Index int
+	Addr  netip.Addr
+	Name  string
+	MTU   int
+}
+
+// Class implements Object.
+func (*InterfaceInformation) Class() Class { return ClassInterfaceInformation }
+
+// CType implements Object.
+func (ii *InterfaceInformation) CType() uint8 {
+	ctype := uint8(ii.Role&0x3) << 6
+	if ii.Index != 0 {
+		ctype |= infoIfIndex
+	}
+	if ii.Addr.IsValid() {
+		ctype |= infoIPAddr
+	}
+	if ii.Name != "" {
+		ctype |= infoName
+	}
+	if ii.MTU != 0 {
+		ctype |= infoMTU
+	}
+
+	return ctype
+}
+
+func (ii *InterfaceInformation) marshal() ([]byte, error) {
+	if ii.Role > RoleNextHop {
+		return nil, fmt.Errorf("extension: invalid interface role: %d", ii.Role)
+	}
+
+	var b []byte
+	if ii.Index != 0 {
+		b = binary.BigEndian.AppendUint32(b, uint32(ii.Index))
+	}
+	if ii.Addr.IsValid() {
+		b = appendIPAddr(b, ii.Addr)
+	}
+	if ii.Name != "" {
+		if len(ii.Name) > maxNameLen {
+			return nil, fmt.Errorf("extension: interface name too long: %q", ii.Name)
+		}
+
+		// The length octet counts itself, and the sub-object is padded to
+		// a multiple of 4 octets.
+		l := (1 + len(ii.Name) + 3) &^ 3
+		b = append(b, byte(l))
+		b = append(b, ii.Name...)
+		b = append(b, make([]byte, l-1-len(ii.Name))...)
+	}
+	if ii.MTU != 0 {
+		b = binary.BigEndian.AppendUint32(b, uint32(ii.MTU))
+	}
+
+	return b, nil
+}
+
+func (ii *InterfaceInformation) unmarshal(ctype uint8, b []byte) error {
+	ii.Role = Role(ctype >> 6)
+
+	if ctype&infoIfIndex != 0 {
+		if len(b) < 4 {
+			return errShortObject
+		}
+
+		ii.Index = int(binary.BigEndian.Uint32(b[:4]))
+		b = b[4:]
+	}
+
+	if ctype&infoIPAddr != 0 {
+		if len(b) < 4 {
+			return errShortObject
+		}
+
+		var l int
+		switch afi := binary.BigEndian.Uint16(b[0:2]); afi {
+		case afiIPv4:
+			l = 4
+		case afiIPv6:
+			l = 16
+		default:
+			return fmt.Errorf("extension: unknown address family: %d", afi)
+		}
+		if len(b) < 4+l {
+			return errShortObject
+		}
+
+		ii.Addr, _ = netip.AddrFromSlice(b[4 : 4+l])
+		b = b[4+l:]
+	}
+
+	if ctype&infoName != 0 {
+		if len(b) < 1 {
+			return errShortObject
+		}
+
+		l := int(b[0])
+		if l < 1 || l > len(b) {
+			return fmt.Errorf("extension: invalid interface name length: %d", l)
+		}
+
+		ii.Name = strings.TrimRight(string(b[1:l]), "\x00")
+		b = b[l:]
+	}
+
+	if ctype&infoMTU != 0 {
+		if len(b) < 4 {
+			return errShortObject
+		}
+
+		ii.MTU = int(binary.BigEndian.Uint32(b[:4]))
+	}
+
+	return nil
+}
+
+var _ Object = &InterfaceIdentification{}
+
+// An InterfaceIdentification object identifies the interface to be probed by
+// an Extended Echo Request, as described in RFC 8335. Exactly one of Name,
+// Index, or Addr must be set.
+type InterfaceIdentification struct {
+	Name  string
+	Index int
+	Addr  netip.Addr
+}
+
+// Class implements Object.
+func (*InterfaceIdentification) Class() Class { return ClassInterfaceIdentification }
+
+// CType implements Object.
+func (ii *InterfaceIdentification) CType() uint8 {
+	switch {
+	case ii.Name != "":
+		return ctypeIdentName
+	case ii.Index != 0:
+		return ctypeIdentIndex
+	default:
+		return ctypeIdentAddress
+	}
+}
+
+func (ii *InterfaceIdentification) marshal() ([]byte, error) {
+	var n int
+	for _, ok := range []bool{ii.Name != "", ii.Index != 0, ii.Addr.IsValid()} {
+		if ok {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, errors.New("extension: exactly one of name, index, or address must be set for interface identification")
+	}
+
+	switch ii.CType() {
+	case ctypeIdentName:
+		// The name is padded with NUL octets to a 4 octet boundary.
+		b := []byte(ii.Name)
+		return append(b, make([]byte, (4-len(b)%4)%4)...), nil
+	case ctypeIdentIndex:
+		return binary.BigEndian.AppendUint32(nil, uint32(ii.Index)), nil
+	default:
+		addr := ii.Addr.AsSlice()
+
+		var b []byte
+		if ii.Addr.Is4() {
+			b = binary.BigEndian.AppendUint16(b, afiIPv4)
+		} else {
+			b = binary.BigEndian.AppendUint16(b, afiIPv6)
+		}
+		b = append(b, byte(len(addr)), 0)
+		return append(b, addr...), nil
+	}
+}
+
+func (ii *InterfaceIdentification) unmarshal(ctype uint8, b []byte) error {
+	switch ctype {
+	case ctypeIdentName:
+		ii.Name = strings.TrimRight(string(b), "\x00")
+		if ii.Name == "" {
+			return errShortObject
+		}
+	case ctypeIdentIndex:
+		if len(b) < 4 {
+			return errShortObject
+		}
+
+		ii.Index = int(binary.BigEndian.Uint32(b[:4]))
+	case ctypeIdentAddress:
+		if len(b) < 4 {
+			return errShortObject
+		}
+
+		var (
+			afi = binary.BigEndian.Uint16(b[0:2])
+			l   = int(b[2])
+		)
+		if len(b) < 4+l {
+			return errShortObject
+		}
+
+		switch {
+		case afi == afiIPv4 && l == 4, afi == afiIPv6 && l == 16:
+			ii.Addr, _ = netip.AddrFromSlice(b[4 : 4+l])
+		default:
+			return fmt.Errorf("extension: invalid address family %d with length %d", afi, l)
+		}
+	default:
+		return fmt.Errorf("extension: unknown interface identification sub-type: %d", ctype)
+	}
+
+	return nil
+}
+
+// appendIPAddr appends an RFC 5837 IP address sub-object to b.
+func appendIPAddr(b []byte, ip netip.Addr) []byte {
+	if ip.Is4() {
+		b = binary.BigEndian.AppendUint16(b, afiIPv4)
+	} else {
+		b = binary.BigEndian.AppendUint16(b, afiIPv6)
+	}
+
+	b = append(b, 0, 0) // reserved
+	return append(b, ip.AsSlice()...)
+}
@@ -12,9 +12,17 @@ import (
 )
 
 // An IPv4Filter creates an ICMPv4 filter which may be attached to an IPv4Conn.
+//
+// The kernel only filters ICMPv4 types 0 through 31, so types 32 and above,
+// such as Extended Echo Reply, are filtered by the IPv4Conn after each message
+// is received.
 type IPv4Filter struct {
 	// A raw bitmask that mirrors the kernel's ICMPv4 data structure.
 	data uint32
+
+	// A bitmask for ICMPv4 types 32 through 255 which is applied in
+	// userspace.
+	ext [7]uint32
 }
 
 // IPv4AllowOnly constructs an IPv4Filter which only permits the specified
@@ -32,27 +40,41 @@ func IPv4AllowOnly(types ...ipv4.ICMPType) *IPv4Filter {
 
 // Accept accepts an ICMPv4 type using the filter.
 func (f *IPv4Filter) Accept(typ ipv4.ICMPType) {
-	f.data &^= 1 << (uint32(typ) & 31)
+	*f.word(typ) &^= 1 << (uint32(typ) & 31)
 }
 
 // Block blocks an ICMPv4 type using the filter.
 func (f *IPv4Filter) Block(typ ipv4.ICMPType) {
-	f.data |= 1 << (uint32(typ) & 31)
+	*f.word(typ) |= 1 << (uint32(typ) & 31)
 }
 
 // SetAll either blocks or allows all ICMPv4 types on the filter depending on
 // the input value.
 func (f *IPv4Filter) SetAll(block bool) {
+	var v uint32
 	if block {
-		f.data = 1<<32 - 1
-	} else {
-		f.data = 0
+		v = 1<<32 - 1
+	}
+
+	f.data = v
+	for i := range f.ext {
+		f.ext[i] = v
 	}
 }
 
 // WillBlock reports whether a given ICMPv4 type will be blocked by the filter.
 func (f *IPv4Filter) WillBlock(typ ipv4.ICMPType) bool {
-	return f.data&(1<<(uint32(typ)&31)) != 0
+	return *f.word(typ)&(1<<(uint32(typ)&31)) != 0
+}
+
+// word returns the bitmask word which contains the bit for typ.
+func (f *IPv4Filter) word(typ ipv4.ICMPType) *uint32 {
+	t := uint8(typ)
+	if t < 32 {
+		return &f.data
+	}
+
+	return &f.ext[(t>>5)-1]
 }
 
 // An IPv6Filter creates an ICMPv6 filter which may be attached to an IPv6Conn.
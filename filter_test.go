@@ -24,6 +24,31 @@ func TestIPv4Filter(t *testing.T) {
 	}
 }
 
+func TestIPv4FilterExtendedTypes(t *testing.T) {
+	f := icmpx.IPv4AllowOnly(ipv4.ICMPTypeExtendedEchoReply)
+
+	// Types above 31 must not alias the bits of lower types.
+	if !f.WillBlock(ipv4.ICMPTypeTimeExceeded) {
+		t.Fatalf("time exceeded should be blocked, but is not")
+	}
+	if !f.WillBlock(ipv4.ICMPTypeExtendedEchoRequest) {
+		t.Fatalf("extended echo request should be blocked, but is not")
+	}
+	if f.WillBlock(ipv4.ICMPTypeExtendedEchoReply) {
+		t.Fatalf("initial extended echo reply should not be blocked, but is")
+	}
+
+	f.Block(ipv4.ICMPTypeExtendedEchoReply)
+	if !f.WillBlock(ipv4.ICMPTypeExtendedEchoReply) {
+		t.Fatalf("final extended echo reply should be blocked, but is not")
+	}
+
+	f.SetAll(false)
+	if f.WillBlock(255) {
+		t.Fatalf("type 255 should not be blocked, but is")
+	}
+}
+
 func TestIPv6Filter(t *testing.T) {
 	f := icmpx.IPv6AllowOnly(ipv6.ICMPTypeEchoReply)
 
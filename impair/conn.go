@@ -0,0 +1,474 @@
+package impair
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/sync/errgroup"
+)
+
+var _ icmpx.Conn = &Conn{}
+
+// A Conn is an icmpx.Conn which applies Impairments to the messages written to
+// and read from an underlying icmpx.Conn.
+type Conn struct {
+	c icmpx.Conn
+
+	// Per-direction impairment state.
+	w, r *direction
+
+	// Delayed message queues and the channel used to deliver read results.
+	wq, rq *queue
+	readC  chan readResult
+
+	// Manages the concurrency of the Conn.
+	ctx    context.Context
+	eg     *errgroup.Group
+	cancel context.CancelFunc
+}
+
+// A Config configures a Conn.
+type Config struct {
+	// Write and Read are the Impairments applied to messages passed to
+	// WriteTo and returned by ReadFrom, respectively.
+	Write, Read Impairment
+
+	// Source is the source of randomness for all impairment decisions. Use a
+	// fixed seed such as rand.NewSource(1) to produce the same impairments for
+	// the same sequence of messages in each direction.
+	//
+	// If nil, a source seeded with the current time is used.
+	Source rand.Source
+}
+
+// Stats contains counters for the Impairments applied by a Conn.
+type Stats struct {
+	Write, Read DirectionStats
+}
+
+// DirectionStats contains counters for the Impairments applied to messages
+// traveling in one direction through a Conn.
+type DirectionStats struct {
+	// Messages is the number of messages which entered the Conn.
+	Messages int
+
+	// Dropped, Duplicated, Corrupted, and Reordered are the number of
+	// messages affected by each impairment. Corrupted messages which can no
+	// longer be parsed are counted as Dropped rather than Corrupted.
+	Dropped, Duplicated, Corrupted, Reordered int
+}
+
+// add adds the counters in ds to s.
+func (s *DirectionStats) add(ds DirectionStats) {
+	s.Messages += ds.Messages
+	s.Dropped += ds.Dropped
+	s.Duplicated += ds.Duplicated
+	s.Corrupted += ds.Corrupted
+	s.Reordered += ds.Reordered
+}
+
+// New creates a Conn which applies the Impairments in cfg to c. The Conn takes
+// ownership of c and reads from it continuously until the Conn is closed.
+func New(c icmpx.Conn, cfg Config) *Conn {
+	src := cfg.Source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+
+	// Each direction draws from its own generator so that the impairments in
+	// one direction do not depend on the timing of messages in the other.
+	seed := rand.New(src)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+
+	ic := &Conn{
+		c: c,
+
+		w: newDirection(cfg.Write, seed.Int63()),
+		r: newDirection(cfg.Read, seed.Int63()),
+
+		readC: make(chan readResult),
+
+		ctx:    ctx,
+		eg:     eg,
+		cancel: cancel,
+	}
+
+	ic.wq = newQueue(func(ctx context.Context, it item) {
+		// Delayed writes have no caller to report errors to, so they are
+		// treated as any other lost message.
+		_ = ic.c.WriteTo(ctx, it.Message, it.IP)
+	})
+	ic.rq = newQueue(func(ctx context.Context, it item) {
+		select {
+		case <-ctx.Done():
+		case ic.readC <- readResult{Message: it.Message, IP: it.IP}:
+		}
+	})
+
+	eg.Go(func() error { return ic.wq.run(ctx) })
+	eg.Go(func() error { return ic.rq.run(ctx) })
+	eg.Go(func() error { return ic.readLoop(ctx) })
+
+	return ic
+}
+
+// Close stops the Conn's background goroutines and closes the underlying
+// icmpx.Conn. Delayed messages which have not yet been delivered are
+// discarded.
+func (c *Conn) Close() error {
+	c.cancel()
+	if err := c.eg.Wait(); err != nil {
+		_ = c.c.Close()
+		return err
+	}
+
+	return c.c.Close()
+}
+
+// Stats returns a snapshot of the Conn's impairment counters.
+func (c *Conn) Stats() Stats {
+	return Stats{
+		Write: c.w.Stats(),
+		Read:  c.r.Stats(),
+	}
+}
+
+// WriteTo applies the write Impairment to msg and writes the result to the
+// underlying icmpx.Conn. Messages which are not delayed are written before
+// WriteTo returns and any errors are reported to the caller. Messages which
+// are delayed are written in the background and their errors are discarded.
+func (c *Conn) WriteTo(ctx context.Context, msg *icmp.Message, dst netip.Addr) error {
+	ds, err := c.w.Apply(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range ds {
+		if d.Delay == 0 {
+			if err := c.c.WriteTo(ctx, d.Message, dst); err != nil {
+				return err
+			}
+			continue
+		}
+
+		c.wq.Push(d.Delay, item{Message: d.Message, IP: dst})
+	}
+
+	return nil
+}
+
+// ReadFrom reads an ICMP message which has passed through the read Impairment.
+func (c *Conn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, netip.Addr{}, net.ErrClosed
+	case res := <-c.readC:
+		return res.Message, res.IP, res.Err
+	}
+}
+
+// A readResult is the result of a read from the underlying icmpx.Conn.
+type readResult struct {
+	Message *icmp.Message
+	IP      netip.Addr
+	Err     error
+}
+
+// readLoop reads from the underlying icmpx.Conn until ctx is canceled.
+func (c *Conn) readLoop(ctx context.Context) error {
+	for {
+		msg, ip, err := c.c.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			// Pass errors through to the caller unimpaired.
+			select {
+			case <-ctx.Done():
+				return nil
+			case c.readC <- readResult{Err: err}:
+			}
+			continue
+		}
+
+		ds, err := c.r.Apply(msg)
+		if err != nil {
+			// The message could not be cloned for impairment, so deliver
+			// it as-is.
+			ds = []delivery{{Message: msg}}
+		}
+
+		for _, d := range ds {
+			if d.Delay == 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case c.readC <- readResult{Message: d.Message, IP: ip}:
+				}
+				continue
+			}
+
+			c.rq.Push(d.Delay, item{Message: d.Message, IP: ip})
+		}
+	}
+}
+
+// A direction manages the Impairment state for one direction of a Conn.
+type direction struct {
+	mu    sync.Mutex
+	im    Impairment
+	r     *rand.Rand
+	stats DirectionStats
+}
+
+// newDirection creates a direction with its own random number generator.
+func newDirection(im Impairment, seed int64) *direction {
+	return &direction{
+		im: im,
+		r:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Stats returns a snapshot of the direction's counters.
+func (d *direction) Stats() DirectionStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// A delivery is a message and the delay which must elapse before it is
+// delivered.
+type delivery struct {
+	Message *icmp.Message
+	Delay   time.Duration
+}
+
+// Apply applies the direction's Impairment to msg, returning zero or more
+// deliveries. If msg cannot be copied, Apply returns an error and only the
+// Messages counter is updated.
+func (d *direction) Apply(msg *icmp.Message) ([]delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stats.Messages++
+
+	if d.im.Loss != nil && d.im.Loss.Lose(d.r) {
+		d.stats.Dropped++
+		return nil, nil
+	}
+
+	// Count impairments separately until all of them have been applied.
+	var stats DirectionStats
+
+	n := 1
+	if d.r.Float64() < d.im.Duplicate {
+		stats.Duplicated++
+		n = 2
+	}
+
+	ds := make([]delivery, 0, n)
+	for i := 0; i < n; i++ {
+		delay := d.im.delay(d.r)
+		reorder := delay > 0 && d.r.Float64() < d.im.Reorder
+		if reorder {
+			delay = 0
+		}
+
+		corrupt := d.r.Float64() < d.im.Corrupt
+
+		// Messages which will be modified or held for later must be copied
+		// so the caller is free to reuse the original.
+		m := msg
+		if corrupt || delay > 0 || n > 1 {
+			var err error
+			m, err = d.clone(msg, corrupt)
+			if err != nil {
+				return nil, err
+			}
+			if m == nil {
+				// The corrupted message no longer parses, so it is lost.
+				stats.Dropped++
+				continue
+			}
+		}
+
+		if reorder {
+			stats.Reordered++
+		}
+		if corrupt {
+			stats.Corrupted++
+		}
+
+		ds = append(ds, delivery{Message: m, Delay: delay})
+	}
+
+	d.stats.add(stats)
+	return ds, nil
+}
+
+// clone produces a deep copy of msg, optionally flipping a random bit in the
+// message body. If the corrupted message cannot be parsed, clone returns a nil
+// message and no error.
+func (d *direction) clone(msg *icmp.Message, corrupt bool) (*icmp.Message, error) {
+	proto := msg.Type.Protocol()
+
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave the ICMP header intact so the message can still be parsed.
+	if corrupt && len(b) > 4 {
+		bit := d.r.Intn((len(b) - 4) * 8)
+		b[4+bit/8] ^= 1 << (bit % 8)
+	}
+
+	m, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		if corrupt {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	// Preserve the original Checksum field, which no longer matches the body
+	// if the message was corrupted.
+	m.Checksum = msg.Checksum
+	return m, nil
+}
+
+// An item is a delayed message held in a queue.
+type item struct {
+	Message *icmp.Message
+	IP      netip.Addr
+
+	due time.Time
+	seq uint64
+}
+
+// A queue delivers items after their delays elapse.
+type queue struct {
+	deliver func(ctx context.Context, it item)
+	wakeC   chan struct{}
+
+	mu    sync.Mutex
+	items itemHeap
+	seq   uint64
+}
+
+// newQueue creates a queue which calls deliver for each item when it is due.
+func newQueue(deliver func(ctx context.Context, it item)) *queue {
+	return &queue{
+		deliver: deliver,
+		wakeC:   make(chan struct{}, 1),
+	}
+}
+
+// Push adds an item to the queue for delivery after delay.
+func (q *queue) Push(delay time.Duration, it item) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Items with equal due times are delivered in the order they were pushed.
+	it.due = time.Now().Add(delay)
+	it.seq = q.seq
+	q.seq++
+	heap.Push(&q.items, it)
+
+	select {
+	case q.wakeC <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers items until ctx is canceled.
+func (q *queue) run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	defer timer.Stop()
+
+	for {
+		q.mu.Lock()
+		var (
+			due   []item
+			sleep = time.Duration(-1)
+		)
+		for q.items.Len() > 0 {
+			if d := time.Until(q.items[0].due); d > 0 {
+				sleep = d
+				break
+			}
+
+			due = append(due, heap.Pop(&q.items).(item))
+		}
+		q.mu.Unlock()
+
+		for _, it := range due {
+			q.deliver(ctx, it)
+		}
+		if len(due) > 0 {
+			// Delivery may have taken some time, so check again.
+			continue
+		}
+
+		var timerC <-chan time.Time
+		if sleep >= 0 {
+			timer.Reset(sleep)
+			timerC = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.wakeC:
+		case <-timerC:
+		}
+
+		if timerC != nil && !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// An itemHeap implements heap.Interface, ordering items by due time.
+type itemHeap []item
+
+func (h itemHeap) Len() int { return len(h) }
+
+func (h itemHeap) Less(i, j int) bool {
+	if h[i].due.Equal(h[j].due) {
+		return h[i].seq < h[j].seq
+	}
+
+	return h[i].due.Before(h[j].due)
+}
+
+func (h itemHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *itemHeap) Push(x any) { *h = append(*h, x.(item)) }
+
+func (h *itemHeap) Pop() any {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	*h = old[:n-1]
+	return it
+}
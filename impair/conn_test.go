@@ -0,0 +1,320 @@
+package impair_test
+
+import (
+	"context"
+	"math/bits"
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/impair"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+var dst = netip.MustParseAddr("2001:db8::1")
+
+func TestConnWriteDeterministic(t *testing.T) {
+	t.Parallel()
+
+	cfg := func() impair.Config {
+		return impair.Config{
+			Write: impair.Impairment{
+				Loss: &impair.GilbertElliott{
+					P:        0.1,
+					R:        0.3,
+					LossGood: 0.01,
+				},
+				Duplicate: 0.1,
+			},
+			Source: rand.NewSource(1),
+		}
+	}
+
+	// Two Conns with the same seed produce exactly the same output for the
+	// same input.
+	const n = 500
+	var (
+		x = writeN(t, cfg(), n)
+		y = writeN(t, cfg(), n)
+	)
+
+	if diff := cmp.Diff(x, y); diff != "" {
+		t.Fatalf("unexpected difference between seeded Conns (-x +y):\n%s", diff)
+	}
+
+	// Verify that some loss and duplication actually occurred, and that the
+	// loss was bursty.
+	var (
+		dups  int
+		burst bool
+	)
+	for i := 1; i < len(x); i++ {
+		switch d := x[i] - x[i-1]; {
+		case d == 0:
+			dups++
+		case d > 2:
+			burst = true
+		}
+	}
+
+	if len(x) >= n+dups {
+		t.Fatalf("expected loss, but got %d messages (%d duplicates)", len(x), dups)
+	}
+	if dups == 0 {
+		t.Fatal("expected duplicate messages")
+	}
+	if !burst {
+		t.Fatal("expected a burst of consecutive losses")
+	}
+}
+
+func TestConnWriteLatencyReorder(t *testing.T) {
+	t.Parallel()
+
+	mc := newMemConn()
+	c := impair.New(mc, impair.Config{
+		Write: impair.Impairment{
+			Latency:      50 * time.Millisecond,
+			Jitter:       10 * time.Millisecond,
+			Distribution: impair.Normal,
+			Reorder:      0.25,
+		},
+		Source: rand.NewSource(1),
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 20
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := c.WriteTo(ctx, echoMessage(i), dst); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	var (
+		seqs      []int
+		reordered bool
+	)
+	for i := 0; i < n; i++ {
+		w := mc.NextWrite(t, ctx)
+		seq := w.Message.Body.(*icmp.Echo).Seq
+		if len(seqs) > 0 && seq < seqs[len(seqs)-1] {
+			reordered = true
+		}
+
+		seqs = append(seqs, seq)
+	}
+
+	if since := time.Since(start); since < 20*time.Millisecond {
+		t.Fatalf("messages were not delayed: %v", since)
+	}
+	if !reordered {
+		t.Fatalf("expected reordered messages: %v", seqs)
+	}
+
+	stats := c.Stats()
+	if stats.Write.Messages != n || stats.Write.Reordered == 0 {
+		t.Fatalf("unexpected write stats: %+v", stats.Write)
+	}
+}
+
+func TestConnReadCorrupt(t *testing.T) {
+	t.Parallel()
+
+	mc := newMemConn()
+	c := impair.New(mc, impair.Config{
+		Read: impair.Impairment{
+			Corrupt: 1,
+		},
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	in := echoMessage(1)
+	mc.readC <- write{Message: in, IP: dst}
+
+	out, ip, err := c.ReadFrom(ctx)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	if diff := cmp.Diff(dst, ip, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected source IP (-want +got):\n%s", diff)
+	}
+
+	// Exactly one bit must differ between the two message bodies.
+	var (
+		x = mustMarshal(t, in)
+		y = mustMarshal(t, out)
+	)
+
+	var flipped int
+	for i := range x {
+		flipped += bits.OnesCount8(x[i] ^ y[i])
+	}
+	if flipped != 1 {
+		t.Fatalf("expected 1 flipped bit, but got %d:\n%x\n%x", flipped, x, y)
+	}
+
+	if diff := cmp.Diff(1, c.Stats().Read.Corrupted); diff != "" {
+		t.Fatalf("unexpected corrupted count (-want +got):\n%s", diff)
+	}
+}
+
+func TestConnWriteCorruptMarshalError(t *testing.T) {
+	t.Parallel()
+
+	mc := newMemConn()
+	c := impair.New(mc, impair.Config{
+		Write: impair.Impairment{
+			Corrupt: 1,
+		},
+	})
+	defer c.Close()
+
+	// A message which cannot be marshaled cannot be corrupted, so only the
+	// message itself is counted.
+	msg := &icmp.Message{
+		Type: ipv6.ICMPTypeExtendedEchoRequest,
+		Body: &icmp.ExtendedEchoRequest{
+			Extensions: []icmp.Extension{&icmp.MPLSLabelStack{}},
+		},
+	}
+
+	if err := c.WriteTo(context.Background(), msg, dst); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+
+	want := impair.Stats{Write: impair.DirectionStats{Messages: 1}}
+	if diff := cmp.Diff(want, c.Stats()); diff != "" {
+		t.Fatalf("unexpected stats (-want +got):\n%s", diff)
+	}
+}
+
+func TestGilbertElliott(t *testing.T) {
+	t.Parallel()
+
+	var (
+		r  = rand.New(rand.NewSource(1))
+		ge = &impair.GilbertElliott{P: 0.05, R: 0.25}
+	)
+
+	// The steady state loss rate for the simple Gilbert model is P/(P+R).
+	const n = 100_000
+	var lost int
+	for i := 0; i < n; i++ {
+		if ge.Lose(r) {
+			lost++
+		}
+	}
+
+	if got := float64(lost) / n; got < 0.14 || got > 0.19 {
+		t.Fatalf("unexpected loss rate: %.3f", got)
+	}
+}
+
+// writeN writes n echo messages through a Conn configured by cfg and returns
+// the sequence numbers which arrived at the underlying icmpx.Conn.
+func writeN(t *testing.T, cfg impair.Config, n int) []int {
+	t.Helper()
+
+	mc := newMemConn()
+	c := impair.New(mc, cfg)
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		if err := c.WriteTo(ctx, echoMessage(i), dst); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	close(mc.writeC)
+
+	var seqs []int
+	for w := range mc.writeC {
+		seqs = append(seqs, w.Message.Body.(*icmp.Echo).Seq)
+	}
+
+	return seqs
+}
+
+func echoMessage(seq int) *icmp.Message {
+	return &icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: &icmp.Echo{
+			ID:   1,
+			Seq:  seq,
+			Data: []byte{0xde, 0xad, 0xbe, 0xef},
+		},
+	}
+}
+
+func mustMarshal(t *testing.T, m *icmp.Message) []byte {
+	t.Helper()
+
+	b, err := m.Marshal(nil)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	return b
+}
+
+var _ icmpx.Conn = &memConn{}
+
+// A memConn is an in-memory icmpx.Conn which records writes and returns
+// messages sent on its read channel.
+type memConn struct {
+	writeC, readC chan write
+}
+
+type write struct {
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+func newMemConn() *memConn {
+	return &memConn{
+		writeC: make(chan write, 1024),
+		readC:  make(chan write, 1024),
+	}
+}
+
+func (*memConn) Close() error { return nil }
+
+func (c *memConn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case w := <-c.readC:
+		return w.Message, w.IP, nil
+	}
+}
+
+func (c *memConn) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	c.writeC <- write{Message: msg, IP: dst}
+	return nil
+}
+
+func (c *memConn) NextWrite(t *testing.T, ctx context.Context) write {
+	t.Helper()
+
+	select {
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for write: %v", ctx.Err())
+		return write{}
+	case w := <-c.writeC:
+		return w
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
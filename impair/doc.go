@@ -0,0 +1,4 @@
+// Package impair implements an icmpx.Conn wrapper which emulates a misbehaving
+// network by applying latency, jitter, loss, reordering, duplication, and
+// corruption to ICMPv4/6 messages.
+package impair
@@ -0,0 +1,174 @@
+package impair
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// A Distribution produces random samples which determine how Jitter is applied
+// to Latency. Samples are scaled by Jitter and added to Latency, so a
+// Distribution should produce values centered on zero with a spread of roughly
+// one.
+type Distribution func(r *rand.Rand) float64
+
+// Predefined Distributions which mirror the options provided by Linux netem.
+var (
+	// Uniform produces samples evenly distributed in the range [-1, 1).
+	Uniform Distribution = func(r *rand.Rand) float64 { return 2*r.Float64() - 1 }
+
+	// Normal produces samples from the standard normal distribution.
+	Normal Distribution = func(r *rand.Rand) float64 { return r.NormFloat64() }
+
+	// Pareto produces samples from a heavy-tailed Pareto distribution which is
+	// shifted and scaled to have a mean of zero and standard deviation of one.
+	Pareto Distribution = pareto
+
+	// ParetoNormal produces samples from a mix of the Normal and Pareto
+	// distributions.
+	ParetoNormal Distribution = func(r *rand.Rand) float64 {
+		return 0.25*r.NormFloat64() + 0.75*pareto(r)
+	}
+)
+
+// pareto implements the Pareto Distribution with shape 3.
+func pareto(r *rand.Rand) float64 {
+	const (
+		alpha = 3.0
+		mean  = alpha / (alpha - 1)
+	)
+
+	// Inverse transform sampling with minimum value 1, then standardize using
+	// the known mean and standard deviation for this shape.
+	stddev := math.Sqrt(alpha / ((alpha - 1) * (alpha - 1) * (alpha - 2)))
+	x := math.Pow(1-r.Float64(), -1/alpha)
+	return (x - mean) / stddev
+}
+
+// A LossModel determines whether individual messages are lost. A LossModel may
+// be stateful and must not be shared between Impairments or Conns.
+type LossModel interface {
+	// Lose reports whether the next message should be dropped.
+	Lose(r *rand.Rand) bool
+}
+
+var (
+	_ LossModel = RandomLoss(0)
+	_ LossModel = &GilbertElliott{}
+)
+
+// RandomLoss is a LossModel which drops each message independently with a fixed
+// probability in the range [0, 1].
+type RandomLoss float64
+
+// Lose implements LossModel.
+func (p RandomLoss) Lose(r *rand.Rand) bool { return r.Float64() < float64(p) }
+
+// GilbertElliott is a LossModel which produces bursty loss using a two state
+// Markov chain, as described in RFC 3611 and implemented by netem's "gemodel".
+//
+// Before each message, the model transitions from the good state to the bad
+// state with probability P, or from the bad state to the good state with
+// probability R. The message is then lost with the loss probability of the
+// current state.
+type GilbertElliott struct {
+	// P is the probability of transitioning from the good to the bad state.
+	P float64
+
+	// R is the probability of transitioning from the bad to the good state.
+	R float64
+
+	// LossGood is the probability of loss while in the good state.
+	LossGood float64
+
+	// LossBad is the probability of loss while in the bad state. If zero, all
+	// messages are lost in the bad state, producing the simple Gilbert model.
+	LossBad float64
+
+	bad bool
+}
+
+// Lose implements LossModel.
+func (ge *GilbertElliott) Lose(r *rand.Rand) bool {
+	if ge.bad {
+		if r.Float64() < ge.R {
+			ge.bad = false
+		}
+	} else {
+		if r.Float64() < ge.P {
+			ge.bad = true
+		}
+	}
+
+	if !ge.bad {
+		return r.Float64() < ge.LossGood
+	}
+
+	loss := ge.LossBad
+	if loss == 0 {
+		loss = 1
+	}
+
+	return r.Float64() < loss
+}
+
+// An Impairment describes the network conditions applied to messages traveling
+// in one direction through a Conn. The zero value applies no impairments.
+type Impairment struct {
+	// Latency is the base delay applied to each message.
+	Latency time.Duration
+
+	// Jitter is the amount of random variation applied to Latency. Samples
+	// from Distribution are multiplied by Jitter and added to Latency. Delays
+	// are never negative.
+	Jitter time.Duration
+
+	// Distribution determines how Jitter is applied to Latency. If nil,
+	// Uniform is used.
+	Distribution Distribution
+
+	// Loss determines which messages are dropped. If nil, no messages are
+	// dropped.
+	Loss LossModel
+
+	// Reorder is the probability that a message bypasses Latency and Jitter
+	// and is delivered immediately, ahead of any delayed messages. As with
+	// netem, reordering has no effect unless messages are also delayed.
+	Reorder float64
+
+	// Duplicate is the probability that a message is delivered twice.
+	// Each copy is delayed independently.
+	Duplicate float64
+
+	// Corrupt is the probability that a single random bit is flipped in the
+	// body of a message. The ICMP type and code are unchanged. The message's
+	// Checksum field keeps its original value, but it is not used when the
+	// message is marshaled: the ICMPv4 checksum is recomputed over the
+	// corrupted body and the ICMPv6 checksum is computed by the kernel, so
+	// corrupted messages written to the network carry a valid checksum.
+	//
+	// A corrupted message which can no longer be parsed is dropped.
+	Corrupt float64
+}
+
+// delay computes the delay for a single message.
+func (im *Impairment) delay(r *rand.Rand) time.Duration {
+	if im.Latency == 0 && im.Jitter == 0 {
+		return 0
+	}
+
+	d := im.Latency
+	if im.Jitter != 0 {
+		dist := im.Distribution
+		if dist == nil {
+			dist = Uniform
+		}
+
+		d += time.Duration(dist(r) * float64(im.Jitter))
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	return d
+}
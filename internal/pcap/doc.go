@@ -0,0 +1,3 @@
+// Package pcap implements reading and writing of packet captures in the pcap
+// and pcapng file formats.
+package pcap
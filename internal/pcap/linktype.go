@@ -0,0 +1,84 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EtherTypes for IPv4 and IPv6 and VLAN tags.
+const (
+	etherTypeIPv4  = 0x0800
+	etherTypeIPv6  = 0x86dd
+	etherTypeVLAN  = 0x8100
+	etherTypeQinQ  = 0x88a8
+	linkTypeSLL2   = 276
+	sllHeaderLen   = 16
+	sll2HeaderLen  = 20
+	ethernetHdrLen = 14
+)
+
+// Network returns the network layer portion of the packet by removing any
+// link-layer header. The result begins with an IPv4 or IPv6 header, or
+// Network returns an error if the packet does not carry IP.
+func (p *Packet) Network() ([]byte, error) {
+	b := p.Data
+
+	switch p.LinkType {
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		// No link-layer header.
+	case LinkTypeNull:
+		// A 4 byte address family in the capturing host's byte order. The
+		// IP version is checked below instead.
+		if len(b) < 4 {
+			return nil, errShort(p.LinkType)
+		}
+		b = b[4:]
+	case LinkTypeEthernet:
+		if len(b) < ethernetHdrLen {
+			return nil, errShort(p.LinkType)
+		}
+
+		et := binary.BigEndian.Uint16(b[12:14])
+		b = b[ethernetHdrLen:]
+
+		// Skip any number of VLAN tags.
+		for et == etherTypeVLAN || et == etherTypeQinQ {
+			if len(b) < 4 {
+				return nil, errShort(p.LinkType)
+			}
+
+			et = binary.BigEndian.Uint16(b[2:4])
+			b = b[4:]
+		}
+
+		if et != etherTypeIPv4 && et != etherTypeIPv6 {
+			return nil, fmt.Errorf("pcap: unhandled EtherType: %#04x", et)
+		}
+	case LinkTypeLinuxSLL:
+		if len(b) < sllHeaderLen {
+			return nil, errShort(p.LinkType)
+		}
+		b = b[sllHeaderLen:]
+	case linkTypeSLL2:
+		if len(b) < sll2HeaderLen {
+			return nil, errShort(p.LinkType)
+		}
+		b = b[sll2HeaderLen:]
+	default:
+		return nil, fmt.Errorf("pcap: unhandled link type: %d", p.LinkType)
+	}
+
+	if len(b) == 0 {
+		return nil, errShort(p.LinkType)
+	}
+	if v := b[0] >> 4; v != 4 && v != 6 {
+		return nil, fmt.Errorf("pcap: unhandled IP version: %d", v)
+	}
+
+	return b, nil
+}
+
+// errShort returns an error for a packet which is too short for its link type.
+func errShort(lt LinkType) error {
+	return fmt.Errorf("pcap: packet too short for link type %d", lt)
+}
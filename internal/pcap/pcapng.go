@@ -0,0 +1,161 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// A LinkType is a pcap link-layer header type.
+type LinkType uint16
+
+// Possible LinkType values.
+const (
+	LinkTypeNull     LinkType = 0
+	LinkTypeEthernet LinkType = 1
+	LinkTypeRaw      LinkType = 101
+	LinkTypeLinuxSLL LinkType = 113
+	LinkTypeIPv4     LinkType = 228
+	LinkTypeIPv6     LinkType = 229
+)
+
+// A Direction indicates whether a packet was sent or received.
+type Direction uint8
+
+// Possible Direction values, matching the pcapng epb_flags option.
+const (
+	DirectionUnknown Direction = iota
+	DirectionInbound
+	DirectionOutbound
+)
+
+// An Interface describes the network interface on which packets are captured.
+type Interface struct {
+	Name     string
+	LinkType LinkType
+}
+
+// A Packet is a single captured packet.
+type Packet struct {
+	Timestamp time.Time
+	Direction Direction
+	Data      []byte
+
+	// Interface and LinkType are set when reading captures and are ignored
+	// when writing.
+	Interface int
+	LinkType  LinkType
+}
+
+// pcapng block types and constants.
+const (
+	blockSectionHeader    = 0x0a0d0d0a
+	blockInterface        = 0x00000001
+	blockEnhancedPacket   = 0x00000006
+	byteOrderMagic        = 0x1a2b3c4d
+	optEndOfOpt           = 0
+	optSHBUserAppl        = 4
+	optIfName             = 2
+	optIfTSResol          = 9
+	optEPBFlags           = 2
+	tsResolNanoseconds    = 9
+	sectionLengthUnknown  = 0xffffffffffffffff
+	blockHeaderTrailerLen = 12
+)
+
+// A Writer writes packets to a pcapng stream with a single interface. Writer
+// methods are safe for concurrent use.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+	b  []byte
+}
+
+// NewWriter creates a Writer which writes a pcapng stream to w, starting with
+// the section header and interface description blocks for ifi.
+func NewWriter(w io.Writer, ifi Interface) (*Writer, error) {
+	pw := &Writer{w: w}
+
+	var shb []byte
+	shb = binary.LittleEndian.AppendUint32(shb, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // major
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // minor
+	shb = binary.LittleEndian.AppendUint64(shb, sectionLengthUnknown)
+	shb = appendOption(shb, optSHBUserAppl, []byte("icmpx"))
+	shb = appendOption(shb, optEndOfOpt, nil)
+
+	if err := pw.writeBlock(blockSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	var idb []byte
+	idb = binary.LittleEndian.AppendUint16(idb, uint16(ifi.LinkType))
+	idb = binary.LittleEndian.AppendUint16(idb, 0) // reserved
+	idb = binary.LittleEndian.AppendUint32(idb, 0) // no snapshot length
+	if ifi.Name != "" {
+		idb = appendOption(idb, optIfName, []byte(ifi.Name))
+	}
+	idb = appendOption(idb, optIfTSResol, []byte{tsResolNanoseconds})
+	idb = appendOption(idb, optEndOfOpt, nil)
+
+	if err := pw.writeBlock(blockInterface, idb); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+// WritePacket writes a single packet as an enhanced packet block.
+func (w *Writer) WritePacket(p Packet) error {
+	if p.Timestamp.IsZero() {
+		return errors.New("pcap: packet timestamp must be set")
+	}
+
+	ts := uint64(p.Timestamp.UnixNano())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := w.b[:0]
+	b = binary.LittleEndian.AppendUint32(b, 0) // interface ID
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(p.Data))) // captured
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(p.Data))) // original
+	b = append(b, p.Data...)
+	b = append(b, make([]byte, pad4(len(p.Data)))...)
+	if p.Direction != DirectionUnknown {
+		b = appendOption(b, optEPBFlags, binary.LittleEndian.AppendUint32(nil, uint32(p.Direction)))
+		b = appendOption(b, optEndOfOpt, nil)
+	}
+	w.b = b
+
+	return w.writeBlock(blockEnhancedPacket, b)
+}
+
+// writeBlock writes a pcapng block with the given type and body.
+func (w *Writer) writeBlock(typ uint32, body []byte) error {
+	n := uint32(blockHeaderTrailerLen + len(body))
+
+	b := make([]byte, 0, n)
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, n)
+
+	_, err := w.w.Write(b)
+	return err
+}
+
+// appendOption appends a pcapng option with padding to b.
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad4(len(value)))...)
+}
+
+// pad4 returns the number of bytes needed to pad n to a 4 byte boundary.
+func pad4(n int) int { return (4 - n%4) % 4 }
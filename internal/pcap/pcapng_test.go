@@ -0,0 +1,84 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Interface{
+		Name:     "eth0",
+		LinkType: LinkTypeRaw,
+	})
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+
+	if err := w.WritePacket(Packet{
+		Timestamp: time.Unix(1, 2),
+		Direction: DirectionOutbound,
+		Data:      []byte{0x45, 0x00, 0x01},
+	}); err != nil {
+		t.Fatalf("failed to write packet: %v", err)
+	}
+
+	var (
+		b     = buf.Bytes()
+		types []uint32
+	)
+	for len(b) > 0 {
+		if len(b) < blockHeaderTrailerLen {
+			t.Fatalf("short block: %d bytes", len(b))
+		}
+
+		var (
+			typ = binary.LittleEndian.Uint32(b[0:4])
+			n   = int(binary.LittleEndian.Uint32(b[4:8]))
+		)
+		if n%4 != 0 || n > len(b) {
+			t.Fatalf("invalid block length: %d", n)
+		}
+		if trailer := int(binary.LittleEndian.Uint32(b[n-4 : n])); trailer != n {
+			t.Fatalf("mismatched block trailer length: %d != %d", trailer, n)
+		}
+
+		if typ == blockEnhancedPacket {
+			body := b[8 : n-4]
+
+			ts := uint64(binary.LittleEndian.Uint32(body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:12]))
+			if diff := cmp.Diff(uint64(1_000_000_002), ts); diff != "" {
+				t.Fatalf("unexpected timestamp (-want +got):\n%s", diff)
+			}
+
+			want := []byte{
+				// Captured and original lengths.
+				0x03, 0x00, 0x00, 0x00,
+				0x03, 0x00, 0x00, 0x00,
+				// Padded packet data.
+				0x45, 0x00, 0x01, 0x00,
+				// epb_flags: outbound.
+				0x02, 0x00, 0x04, 0x00,
+				0x02, 0x00, 0x00, 0x00,
+				// opt_endofopt.
+				0x00, 0x00, 0x00, 0x00,
+			}
+
+			if diff := cmp.Diff(want, body[12:]); diff != "" {
+				t.Fatalf("unexpected enhanced packet block (-want +got):\n%s", diff)
+			}
+		}
+
+		types = append(types, typ)
+		b = b[n:]
+	}
+
+	want := []uint32{blockSectionHeader, blockInterface, blockEnhancedPacket}
+	if diff := cmp.Diff(want, types); diff != "" {
+		t.Fatalf("unexpected block types (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,350 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Magic numbers for the classic pcap file format, as read in big-endian byte
+// order.
+const (
+	magicMicroseconds        = 0xa1b2c3d4
+	magicNanoseconds         = 0xa1b23c4d
+	magicMicrosecondsSwapped = 0xd4c3b2a1
+	magicNanosecondsSwapped  = 0x4d3cb2a1
+)
+
+// Additional pcapng block types which may be read.
+const (
+	blockPacketObsolete = 0x00000002
+	blockSimplePacket   = 0x00000003
+)
+
+// maxPacketLen is a sanity limit on the size of packets and blocks which will
+// be read, to avoid large allocations for corrupt input.
+const maxPacketLen = 1 << 20
+
+// A Reader reads packets from a pcap or pcapng stream.
+type Reader struct {
+	r  *bufio.Reader
+	ng bool
+
+	// Classic pcap state.
+	order    binary.ByteOrder
+	nanos    bool
+	linkType LinkType
+
+	// pcapng state for the current section.
+	ifaces []ngInterface
+}
+
+// An ngInterface is a pcapng interface description.
+type ngInterface struct {
+	LinkType LinkType
+	Units    uint64
+}
+
+// NewReader creates a Reader which detects whether r contains a pcap or pcapng
+// stream.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReader(r)}
+
+	b, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(b) == blockSectionHeader {
+		// pcapng sections are parsed as blocks are read.
+		pr.ng = true
+		return pr, nil
+	}
+
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(pr.r, hdr); err != nil {
+		return nil, err
+	}
+
+	switch binary.BigEndian.Uint32(hdr[0:4]) {
+	case magicMicroseconds:
+		pr.order = binary.BigEndian
+	case magicNanoseconds:
+		pr.order, pr.nanos = binary.BigEndian, true
+	case magicMicrosecondsSwapped:
+		pr.order = binary.LittleEndian
+	case magicNanosecondsSwapped:
+		pr.order, pr.nanos = binary.LittleEndian, true
+	default:
+		return nil, errors.New("pcap: unrecognized file format")
+	}
+
+	// The low 16 bits contain the link type, and the upper bits may contain
+	// FCS information which we ignore.
+	pr.linkType = LinkType(pr.order.Uint32(hdr[20:24]))
+	return pr, nil
+}
+
+// Next returns the next packet in the stream, or io.EOF when no packets
+// remain.
+func (r *Reader) Next() (*Packet, error) {
+	if r.ng {
+		return r.nextNG()
+	}
+
+	// io.ReadFull returns io.EOF only if no bytes were read, which is the
+	// clean end of the stream.
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r.r, hdr); err != nil {
+		return nil, err
+	}
+
+	var (
+		sec  = int64(r.order.Uint32(hdr[0:4]))
+		frac = int64(r.order.Uint32(hdr[4:8]))
+		n    = r.order.Uint32(hdr[8:12])
+	)
+	if !r.nanos {
+		frac *= 1000
+	}
+	if n > maxPacketLen {
+		return nil, fmt.Errorf("pcap: packet length too large: %d", n)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, unexpected(err)
+	}
+
+	return &Packet{
+		Timestamp: time.Unix(sec, frac),
+		Data:      data,
+		LinkType:  r.linkType,
+	}, nil
+}
+
+// nextNG returns the next packet from a pcapng stream.
+func (r *Reader) nextNG() (*Packet, error) {
+	for {
+		typ, body, order, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case blockSectionHeader:
+			// A new section resets all interfaces.
+			r.order = order
+			r.ifaces = r.ifaces[:0]
+		case blockInterface:
+			ifi, err := r.parseInterface(body)
+			if err != nil {
+				return nil, err
+			}
+			r.ifaces = append(r.ifaces, ifi)
+		case blockEnhancedPacket, blockPacketObsolete:
+			return r.parsePacket(typ, body)
+		case blockSimplePacket:
+			if len(r.ifaces) == 0 || len(body) < 4 {
+				return nil, errors.New("pcap: invalid simple packet block")
+			}
+
+			// Simple packets carry no timestamp.
+			n := int(r.order.Uint32(body[0:4]))
+			if n > len(body)-4 {
+				n = len(body) - 4
+			}
+
+			return &Packet{
+				Data:     append([]byte(nil), body[4:4+n]...),
+				LinkType: r.ifaces[0].LinkType,
+			}, nil
+		default:
+			// Skip unknown or uninteresting blocks.
+		}
+	}
+}
+
+// readBlock reads a single pcapng block and returns its type and body. If the
+// block is a section header, its byte order is also returned.
+func (r *Reader) readBlock() (uint32, []byte, binary.ByteOrder, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r.r, hdr); err != nil {
+		return 0, nil, nil, err
+	}
+
+	order := r.order
+	typ := binary.BigEndian.Uint32(hdr[0:4])
+	if typ == blockSectionHeader {
+		// The byte order magic follows the block length and determines how
+		// the rest of the section is decoded.
+		bom, err := r.r.Peek(4)
+		if err != nil {
+			return 0, nil, nil, unexpected(err)
+		}
+
+		switch {
+		case binary.LittleEndian.Uint32(bom) == byteOrderMagic:
+			order = binary.LittleEndian
+		case binary.BigEndian.Uint32(bom) == byteOrderMagic:
+			order = binary.BigEndian
+		default:
+			return 0, nil, nil, errors.New("pcap: invalid pcapng byte order magic")
+		}
+	}
+	if order == nil {
+		return 0, nil, nil, errors.New("pcap: pcapng block found before section header")
+	}
+
+	typ = order.Uint32(hdr[0:4])
+	n := int(order.Uint32(hdr[4:8]))
+	if n < blockHeaderTrailerLen || n%4 != 0 || n > maxPacketLen {
+		return 0, nil, nil, fmt.Errorf("pcap: invalid pcapng block length: %d", n)
+	}
+
+	b := make([]byte, n-8)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return 0, nil, nil, unexpected(err)
+	}
+
+	// Omit the trailing block length.
+	return typ, b[:len(b)-4], order, nil
+}
+
+// parseInterface parses an interface description block body.
+func (r *Reader) parseInterface(b []byte) (ngInterface, error) {
+	if len(b) < 8 {
+		return ngInterface{}, errors.New("pcap: short interface description block")
+	}
+
+	// Timestamps default to microsecond resolution.
+	ifi := ngInterface{
+		LinkType: LinkType(r.order.Uint16(b[0:2])),
+		Units:    1_000_000,
+	}
+
+	var rerr error
+	err := r.parseOptions(b[8:], func(code uint16, v []byte) {
+		if code != optIfTSResol || len(v) < 1 {
+			return
+		}
+
+		// The high bit selects a power of two rather than a power of ten.
+		// Reject resolutions which do not fit in 64 bits.
+		var (
+			base, max = uint64(10), 19
+			exp       = int(v[0] & 0x7f)
+		)
+		if v[0]&0x80 != 0 {
+			base, max = 2, 63
+		}
+		if exp > max {
+			rerr = fmt.Errorf("pcap: invalid interface timestamp resolution: %#02x", v[0])
+			return
+		}
+
+		units := uint64(1)
+		for i := 0; i < exp; i++ {
+			units *= base
+		}
+
+		ifi.Units = units
+	})
+	if err != nil {
+		return ngInterface{}, err
+	}
+
+	return ifi, rerr
+}
+
+// parsePacket parses an enhanced or obsolete packet block body.
+func (r *Reader) parsePacket(typ uint32, b []byte) (*Packet, error) {
+	if len(b) < 20 {
+		return nil, errors.New("pcap: short packet block")
+	}
+
+	var id int
+	if typ == blockPacketObsolete {
+		id = int(r.order.Uint16(b[0:2]))
+	} else {
+		id = int(r.order.Uint32(b[0:4]))
+	}
+	if id >= len(r.ifaces) {
+		return nil, fmt.Errorf("pcap: packet references unknown interface %d", id)
+	}
+	ifi := r.ifaces[id]
+
+	var (
+		ts = uint64(r.order.Uint32(b[4:8]))<<32 | uint64(r.order.Uint32(b[8:12]))
+		n  = int(r.order.Uint32(b[12:16]))
+	)
+	if n > len(b)-20 {
+		return nil, errors.New("pcap: packet length exceeds block length")
+	}
+
+	p := &Packet{
+		Timestamp: ifi.time(ts),
+		Data:      append([]byte(nil), b[20:20+n]...),
+		Interface: id,
+		LinkType:  ifi.LinkType,
+	}
+
+	err := r.parseOptions(b[20+n+pad4(n):], func(code uint16, v []byte) {
+		if code == optEPBFlags && len(v) >= 4 && typ == blockEnhancedPacket {
+			p.Direction = Direction(r.order.Uint32(v) & 0x3)
+		}
+	})
+
+	return p, err
+}
+
+// parseOptions parses pcapng options and calls fn for each one.
+func (r *Reader) parseOptions(b []byte, fn func(code uint16, value []byte)) error {
+	for len(b) >= 4 {
+		var (
+			code = r.order.Uint16(b[0:2])
+			n    = int(r.order.Uint16(b[2:4]))
+		)
+		if code == optEndOfOpt {
+			return nil
+		}
+		if 4+n > len(b) {
+			return errors.New("pcap: option length exceeds block length")
+		}
+
+		fn(code, b[4:4+n])
+		b = b[4+n+pad4(n):]
+	}
+
+	return nil
+}
+
+// time converts a raw pcapng timestamp to a time.Time.
+func (ifi ngInterface) time(ts uint64) time.Time {
+	var (
+		sec  = ts / ifi.Units
+		frac = ts % ifi.Units
+	)
+
+	// Scale the fractional part to nanoseconds using 128-bit arithmetic to
+	// avoid overflow for high resolution timestamps. The quotient always
+	// fits because frac < ifi.Units.
+	hi, lo := bits.Mul64(frac, uint64(time.Second))
+	nsec, _ := bits.Div64(hi, lo, ifi.Units)
+
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+// unexpected converts io.EOF into io.ErrUnexpectedEOF for reads that occur in
+// the middle of a record.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
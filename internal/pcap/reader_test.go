@@ -0,0 +1,174 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReaderPcapng(t *testing.T) {
+	// Round trip packets through a Writer and Reader.
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Interface{LinkType: LinkTypeRaw})
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+
+	want := []*Packet{
+		{
+			Timestamp: time.Unix(1, 1),
+			Direction: DirectionOutbound,
+			Data:      []byte{0x45, 0x00},
+			LinkType:  LinkTypeRaw,
+		},
+		{
+			Timestamp: time.Unix(2, 999_999_999),
+			Direction: DirectionInbound,
+			Data:      []byte{0x60, 0x00, 0x00, 0x00, 0x00},
+			LinkType:  LinkTypeRaw,
+		},
+	}
+
+	for _, p := range want {
+		if err := w.WritePacket(*p); err != nil {
+			t.Fatalf("failed to write packet: %v", err)
+		}
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+
+	if diff := cmp.Diff(want, readAll(t, r)); diff != "" {
+		t.Fatalf("unexpected packets (-want +got):\n%s", diff)
+	}
+}
+
+func TestReaderPcap(t *testing.T) {
+	// Construct a little-endian classic pcap file with microsecond
+	// timestamps and a single Ethernet frame.
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, magicMicroseconds)
+	b = binary.LittleEndian.AppendUint16(b, 2)
+	b = binary.LittleEndian.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = binary.LittleEndian.AppendUint32(b, 65535)
+	b = binary.LittleEndian.AppendUint32(b, uint32(LinkTypeEthernet))
+
+	frame := []byte{
+		// Destination and source MAC.
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x01,
+		// 802.1Q VLAN tag, then IPv6.
+		0x81, 0x00, 0x00, 0x0a, 0x86, 0xdd,
+		0x60, 0x00, 0x00, 0x00,
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, 10)
+	b = binary.LittleEndian.AppendUint32(b, 500)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(frame)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(frame)))
+	b = append(b, frame...)
+
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+
+	pkts := readAll(t, r)
+	want := []*Packet{{
+		Timestamp: time.Unix(10, 500_000),
+		Data:      frame,
+		LinkType:  LinkTypeEthernet,
+	}}
+
+	if diff := cmp.Diff(want, pkts); diff != "" {
+		t.Fatalf("unexpected packets (-want +got):\n%s", diff)
+	}
+
+	ip, err := pkts[0].Network()
+	if err != nil {
+		t.Fatalf("failed to get network layer: %v", err)
+	}
+
+	if diff := cmp.Diff(frame[18:], ip); diff != "" {
+		t.Fatalf("unexpected network layer (-want +got):\n%s", diff)
+	}
+}
+
+func TestReaderPcapngInvalidResolution(t *testing.T) {
+	// Resolutions which overflow 64 bits would otherwise cause a division by
+	// zero or wrong timestamps.
+	for _, resol := range []byte{20, 0x80 | 64, 0xff} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, Interface{LinkType: LinkTypeRaw})
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+
+		var idb []byte
+		idb = binary.LittleEndian.AppendUint16(idb, uint16(LinkTypeRaw))
+		idb = binary.LittleEndian.AppendUint16(idb, 0)
+		idb = binary.LittleEndian.AppendUint32(idb, 0)
+		idb = appendOption(idb, optIfTSResol, []byte{resol})
+		idb = appendOption(idb, optEndOfOpt, nil)
+
+		if err := w.writeBlock(blockInterface, idb); err != nil {
+			t.Fatalf("failed to write interface: %v", err)
+		}
+
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+
+		if _, err := r.Next(); err == nil || err == io.EOF {
+			t.Fatalf("expected an error for resolution %#02x, but got: %v", resol, err)
+		}
+	}
+}
+
+func TestReaderReadError(t *testing.T) {
+	// Read errors must not be mistaken for the end of the stream.
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, magicMicroseconds)
+	b = binary.LittleEndian.AppendUint16(b, 2)
+	b = binary.LittleEndian.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = binary.LittleEndian.AppendUint32(b, 65535)
+	b = binary.LittleEndian.AppendUint32(b, uint32(LinkTypeRaw))
+
+	want := errors.New("read failed")
+	r, err := NewReader(io.MultiReader(bytes.NewReader(b), iotest.ErrReader(want)))
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+
+	if _, err := r.Next(); !errors.Is(err, want) {
+		t.Fatalf("expected read error, but got: %v", err)
+	}
+}
+
+func readAll(t *testing.T, r *Reader) []*Packet {
+	t.Helper()
+
+	var pkts []*Packet
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return pkts
+		}
+		if err != nil {
+			t.Fatalf("failed to read packet: %v", err)
+		}
+
+		pkts = append(pkts, p)
+	}
+}
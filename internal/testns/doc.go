@@ -0,0 +1,3 @@
+// Package testns provides network namespace helpers which allow integration
+// tests to create and configure network interfaces without affecting the host.
+package testns
@@ -0,0 +1,453 @@
+package testns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// envChild is set in the environment of a test binary which was re-executed in
+// a new network namespace.
+const envChild = "ICMPX_TESTNS_CHILD"
+
+// Main runs the tests in m within a new network namespace, if possible, and
+// exits with their result. It is intended to be called from TestMain.
+//
+// When running as root, the test binary is re-executed in a new network
+// namespace. Otherwise, a new user namespace is also created which grants the
+// test binary privileges within the network namespace. If neither is possible,
+// the tests run in the current network namespace and any tests which depend on
+// the private namespace are skipped.
+func Main(m *testing.M) {
+	if os.Getenv(envChild) != "" {
+		if err := setup(); err != nil {
+			fmt.Fprintf(os.Stderr, "testns: failed to set up network namespace: %v\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(m.Run())
+	}
+
+	for _, attr := range []*syscall.SysProcAttr{
+		{Cloneflags: unix.CLONE_NEWNET},
+		{
+			Cloneflags: unix.CLONE_NEWUSER | unix.CLONE_NEWNET,
+			UidMappings: []syscall.SysProcIDMap{{
+				ContainerID: 0,
+				HostID:      os.Getuid(),
+				Size:        1,
+			}},
+			GidMappings: []syscall.SysProcIDMap{{
+				ContainerID: 0,
+				HostID:      os.Getgid(),
+				Size:        1,
+			}},
+			AmbientCaps: []uintptr{unix.CAP_NET_ADMIN, unix.CAP_NET_RAW},
+		},
+	} {
+		cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+		cmd.Env = append(os.Environ(), envChild+"=1")
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.SysProcAttr = attr
+
+		err := cmd.Run()
+		var eerr *exec.ExitError
+		switch {
+		case err == nil:
+			os.Exit(0)
+		case errors.As(err, &eerr):
+			os.Exit(eerr.ExitCode())
+		}
+
+		// Failed to start the child, so try the next option.
+	}
+
+	os.Exit(m.Run())
+}
+
+// setup prepares a new network namespace for tests.
+func setup() error {
+	// Addresses must be usable immediately, and the kernel should not
+	// interfere with tests by soliciting or accepting router advertisements.
+	for _, key := range []string{
+		"net/ipv6/conf/all/accept_dad",
+		"net/ipv6/conf/default/accept_dad",
+		"net/ipv6/conf/default/accept_ra",
+	} {
+		if err := sysctl(key, "0"); err != nil {
+			return err
+		}
+	}
+
+	return ip("link", "set", "lo", "up")
+}
+
+// skip skips t unless it is running in a private network namespace.
+func skip(t *testing.T) {
+	t.Helper()
+
+	if os.Getenv(envChild) == "" {
+		t.Skip("skipping, not running in a private network namespace")
+	}
+}
+
+// Sysctl sets a sysctl key, such as "net/ipv6/conf/all/forwarding", to value
+// within the private network namespace.
+func Sysctl(t *testing.T, key, value string) {
+	t.Helper()
+	skip(t)
+
+	if err := sysctl(key, value); err != nil {
+		t.Fatalf("failed to set sysctl: %v", err)
+	}
+}
+
+// AddAddress assigns the IP address prefix to ifi within the private network
+// namespace, skipping Duplicate Address Detection so the address is usable
+// immediately.
+func AddAddress(t *testing.T, ifi *net.Interface, prefix netip.Prefix) {
+	t.Helper()
+	skip(t)
+
+	if err := ip("address", "add", prefix.String(), "dev", ifi.Name, "nodad"); err != nil {
+		t.Fatalf("failed to add address: %v", err)
+	}
+}
+
+// vethN generates unique veth interface names.
+var vethN atomic.Uint32
+
+// Veth creates a veth pair in the private network namespace and returns both
+// interfaces once they are up and their IPv6 link-local addresses are usable.
+// The pair is removed when the test completes.
+func Veth(t *testing.T) (a, b *net.Interface) {
+	t.Helper()
+	skip(t)
+
+	n := vethN.Add(1)
+	var (
+		an = fmt.Sprintf("icmpx%da", n)
+		bn = fmt.Sprintf("icmpx%db", n)
+	)
+
+	for _, args := range [][]string{
+		{"link", "add", an, "type", "veth", "peer", "name", bn},
+		{"link", "set", an, "up"},
+		{"link", "set", bn, "up"},
+	} {
+		if err := ip(args...); err != nil {
+			t.Fatalf("failed to configure veth: %v", err)
+		}
+	}
+
+	t.Cleanup(func() {
+		if err := ip("link", "del", an); err != nil {
+			t.Errorf("failed to remove veth: %v", err)
+		}
+	})
+
+	return linkLocal(t, an), linkLocal(t, bn)
+}
+
+// pathN generates unique Path network namespace names and address prefixes.
+var pathN atomic.Uint32
+
+// A Path is a chain of routers between the private network namespace and a
+// target node, each in its own network namespace and connected by veth pairs.
+// Every link has both IPv4 and IPv6 addresses, and the routers forward traffic
+// and send ICMP errors without rate limiting.
+type Path struct {
+	// Interface is the local interface connected to the first router.
+	Interface *net.Interface
+
+	// Routers4 and Routers6 are the addresses from which each router, in
+	// order, sends ICMP errors to the local node.
+	Routers4, Routers6 []netip.Addr
+
+	// Target4 and Target6 are the addresses of the target node.
+	Target4, Target6 netip.Addr
+
+	// n is the number of routers, and ns and link produce the names of each
+	// node's network namespace and each end of each link.
+	n    int
+	ns   func(k int) string
+	link func(i int, end string) string
+}
+
+// Routers creates a Path with n routers. The Path is removed when the test
+// completes.
+func Routers(t *testing.T, n int) *Path {
+	t.Helper()
+	skip(t)
+
+	p := pathN.Add(1)
+	if p > 255 {
+		t.Fatal("too many paths")
+	}
+
+	// Each node k, from 1 to n+1, has its own network namespace. Node 0 is
+	// the local node.
+	ns := func(k int) string { return fmt.Sprintf("icmpx%dn%d", p, k) }
+
+	// Each link i, from 0 to n, connects node i and node i+1. The left end
+	// of a link uses host address 1 and the right end uses host address 2.
+	addr4 := func(i, host int) netip.Addr { return netip.AddrFrom4([4]byte{10, byte(p), byte(i), byte(host)}) }
+	addr6 := func(i, host int) netip.Addr {
+		return netip.AddrFrom16([16]byte{0: 0x20, 1: 0x01, 2: 0x0d, 3: 0xb8, 5: byte(p), 7: byte(i), 15: byte(host)})
+	}
+
+	// run runs an ip(8) command in the network namespace of node k.
+	run := func(k int, args ...string) {
+		t.Helper()
+
+		if k > 0 {
+			args = append([]string{"-n", ns(k)}, args...)
+		}
+
+		if err := ip(args...); err != nil {
+			t.Fatalf("failed to configure path: %v", err)
+		}
+	}
+
+	for k := 1; k <= n+1; k++ {
+		if err := ip("netns", "add", ns(k)); err != nil {
+			t.Skipf("skipping, failed to create network namespace: %v", err)
+		}
+
+		name := ns(k)
+		t.Cleanup(func() {
+			if err := ip("netns", "del", name); err != nil {
+				t.Errorf("failed to remove network namespace: %v", err)
+			}
+		})
+
+		for _, kv := range []string{
+			"net.ipv4.ip_forward=1",
+			"net.ipv4.icmp_ratelimit=0",
+			"net.ipv6.conf.all.forwarding=1",
+			"net.ipv6.conf.all.accept_dad=0",
+			"net.ipv6.conf.default.accept_dad=0",
+			"net.ipv6.icmp.ratelimit=0",
+		} {
+			if err := ip("netns", "exec", name, "sysctl", "-q", "-w", kv); err != nil {
+				t.Fatalf("failed to set sysctl: %v", err)
+			}
+		}
+
+		run(k, "link", "set", "lo", "up")
+	}
+
+	for i := 0; i <= n; i++ {
+		var (
+			left  = fmt.Sprintf("icmpx%dl%da", p, i)
+			right = fmt.Sprintf("icmpx%dl%db", p, i)
+		)
+
+		run(i, "link", "add", left, "type", "veth", "peer", "name", right, "netns", ns(i+1))
+		for _, end := range []struct {
+			k, host int
+			name    string
+		}{
+			{k: i, host: 1, name: left},
+			{k: i + 1, host: 2, name: right},
+		} {
+			run(end.k, "address", "add", addr4(i, end.host).String()+"/24", "dev", end.name)
+			run(end.k, "address", "add", addr6(i, end.host).String()+"/64", "dev", end.name, "nodad")
+			run(end.k, "link", "set", end.name, "up")
+		}
+	}
+
+	// The local node routes the entire path via the first router, and the
+	// target routes everything via the last router.
+	run(0, "route", "add", fmt.Sprintf("10.%d.0.0/16", p), "via", addr4(0, 2).String())
+	run(0, "route", "add", addr6(0, 0).String()+"/48", "via", addr6(0, 2).String())
+	run(n+1, "route", "add", "default", "via", addr4(n, 1).String())
+	run(n+1, "-6", "route", "add", "default", "via", addr6(n, 1).String())
+
+	// Wait for the remote ends of each link to come up so that neighbor
+	// discovery succeeds on the first attempt.
+	for i := 0; i <= n; i++ {
+		remoteLinkLocal(t, ns(i+1), fmt.Sprintf("icmpx%dl%db", p, i))
+		if i > 0 {
+			remoteLinkLocal(t, ns(i), fmt.Sprintf("icmpx%dl%da", p, i))
+		}
+	}
+
+	path := &Path{
+		Interface: linkLocal(t, fmt.Sprintf("icmpx%dl0a", p)),
+		Target4:   addr4(n, 2),
+		Target6:   addr6(n, 2),
+
+		n:    n,
+		ns:   ns,
+		link: func(i int, end string) string { return fmt.Sprintf("icmpx%dl%d%s", p, i, end) },
+	}
+
+	// Each router forwards toward the target by default and routes the
+	// local node's link via the previous router.
+	for k := 1; k <= n; k++ {
+		run(k, "route", "add", "default", "via", addr4(k, 2).String())
+		run(k, "-6", "route", "add", "default", "via", addr6(k, 2).String())
+		if k > 1 {
+			run(k, "route", "add", addr4(0, 0).String()+"/24", "via", addr4(k-1, 1).String())
+			run(k, "route", "add", addr6(0, 0).String()+"/64", "via", addr6(k-1, 1).String())
+		}
+
+		path.Routers4 = append(path.Routers4, addr4(k-1, 2))
+		path.Routers6 = append(path.Routers6, addr6(k-1, 2))
+	}
+
+	return path
+}
+
+// SetMTU sets the MTU of both ends of link i, which connects the local node to
+// the first router when i is zero, and otherwise connects router i to the next
+// node.
+func (p *Path) SetMTU(t *testing.T, i, mtu int) {
+	t.Helper()
+
+	m := strconv.Itoa(mtu)
+
+	left := []string{"link", "set", p.link(i, "a"), "mtu", m}
+	if i > 0 {
+		left = append([]string{"-n", p.ns(i)}, left...)
+	}
+
+	for _, args := range [][]string{
+		left,
+		{"-n", p.ns(i + 1), "link", "set", p.link(i, "b"), "mtu", m},
+	} {
+		if err := ip(args...); err != nil {
+			t.Fatalf("failed to set MTU: %v", err)
+		}
+	}
+}
+
+// InTarget calls fn with the target node's interface from an OS thread in the
+// target node's network namespace, so that any sockets created by fn belong to
+// that namespace.
+func (p *Path) InTarget(t *testing.T, fn func(ifi *net.Interface)) {
+	t.Helper()
+
+	// Namespace changes only apply to the current thread, so the thread is
+	// discarded rather than reused if the original namespace is not restored.
+	runtime.LockOSThread()
+
+	orig, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		t.Fatalf("failed to open network namespace: %v", err)
+	}
+	defer orig.Close()
+
+	target, err := os.Open(filepath.Join("/run/netns", p.ns(p.n+1)))
+	if err != nil {
+		runtime.UnlockOSThread()
+		t.Fatalf("failed to open target network namespace: %v", err)
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		t.Fatalf("failed to enter target network namespace: %v", err)
+	}
+
+	defer func() {
+		if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+			t.Errorf("failed to restore network namespace: %v", err)
+			return
+		}
+
+		runtime.UnlockOSThread()
+	}()
+
+	ifi, err := net.InterfaceByName(p.link(p.n, "b"))
+	if err != nil {
+		t.Fatalf("failed to get target interface: %v", err)
+	}
+
+	fn(ifi)
+}
+
+// linkLocal waits for the named interface to be up with an IPv6 link-local
+// address.
+func linkLocal(t *testing.T, name string) *net.Interface {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			t.Fatalf("failed to get interface: %v", err)
+		}
+
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			t.Fatalf("failed to get addresses: %v", err)
+		}
+
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.IsLinkLocalUnicast() && ifi.Flags&net.FlagRunning != 0 {
+				return ifi
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %q link-local address", name)
+	panic("unreachable")
+}
+
+// remoteLinkLocal waits for the named interface in network namespace ns to be
+// up with a usable IPv6 link-local address.
+func remoteLinkLocal(t *testing.T, ns, name string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		out, err := exec.Command(
+			"ip", "-n", ns, "-6", "address", "show",
+			"dev", name, "scope", "link", "-tentative",
+		).CombinedOutput()
+		if err != nil {
+			t.Fatalf("failed to get addresses: %v: %s", err, out)
+		}
+
+		if strings.Contains(string(out), "inet6 fe80:") {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %q link-local address in %q", name, ns)
+}
+
+// sysctl writes a sysctl value.
+func sysctl(key, value string) error {
+	return os.WriteFile(filepath.Join("/proc/sys", key), []byte(value), 0o644)
+}
+
+// ip runs an ip(8) command.
+func ip(args ...string) error {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+
+	return nil
+}
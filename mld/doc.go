@@ -0,0 +1,5 @@
+// Package mld implements IPv6 Multicast Listener Discovery messages, as
+// described in RFC 2710 (MLDv1) and RFC 3810 (MLDv2), along with a querier and
+// a passive listener which tracks multicast group memberships on a link, built
+// on icmpx.IPv6Conn.
+package mld
@@ -0,0 +1,355 @@
+package mld
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/ipv6"
+)
+
+// A FilterMode indicates how the Sources of a Membership are interpreted.
+type FilterMode int
+
+// Possible FilterMode values.
+const (
+	// Exclude indicates that the listener receives traffic from all sources
+	// except the excluded Sources. An MLDv1 listener always uses Exclude
+	// mode with no Sources.
+	Exclude FilterMode = iota
+
+	// Include indicates that the listener only receives traffic from the
+	// included Sources.
+	Include
+)
+
+// String returns the name of a FilterMode.
+func (m FilterMode) String() string {
+	switch m {
+	case Exclude:
+		return "exclude"
+	case Include:
+		return "include"
+	default:
+		return fmt.Sprintf("FilterMode(%d)", m)
+	}
+}
+
+// A Membership describes a single listener's interest in a multicast address,
+// as observed by a Listener.
+type Membership struct {
+	// MulticastAddress is the multicast address being listened to.
+	MulticastAddress netip.Addr
+
+	// Listener is the link-local address of the listening node.
+	Listener netip.Addr
+
+	// Version is the MLD version, 1 or 2, of the listener's most recent
+	// Report.
+	Version int
+
+	// Mode and Sources describe the listener's source filter for
+	// MulticastAddress.
+	Mode    FilterMode
+	Sources []netip.Addr
+
+	// Expires is the time at which the Membership is removed unless the
+	// listener reports it again.
+	Expires time.Time
+}
+
+// A ListenerConfig configures a Listener. The zero value uses the defaults
+// specified by RFC 3810.
+type ListenerConfig struct {
+	// ListeningInterval is how long a Membership remains in the table after
+	// it was last reported. If zero, the Multicast Address Listening
+	// Interval of 260 seconds is used.
+	ListeningInterval time.Duration
+
+	// Groups are additional multicast groups to join. MLDv2 Reports and
+	// MLDv1 Done messages are sent to well-known multicast addresses which
+	// the Listener always joins, but MLDv1 Reports are sent to the
+	// multicast address being reported. MLDv1 Reports are only received for
+	// groups which the Listener or another socket on its interface has
+	// joined.
+	Groups []netip.Addr
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg ListenerConfig) withDefaults() (ListenerConfig, error) {
+	if cfg.ListeningInterval < 0 {
+		return ListenerConfig{}, fmt.Errorf("mld: invalid listening interval: %v", cfg.ListeningInterval)
+	}
+	if cfg.ListeningInterval == 0 {
+		cfg.ListeningInterval = multicastAddressListeningInterval
+	}
+
+	for _, g := range cfg.Groups {
+		if err := checkGroup(g); err != nil {
+			return ListenerConfig{}, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// A Listener passively observes Multicast Listener Reports on a link and
+// maintains a table of the multicast addresses to which each node on the link
+// is listening.
+//
+// Unlike a multicast router, which only tracks the combined state of all
+// listeners for each multicast address, a Listener tracks the state reported
+// by each listener individually. MLDv1 listeners suppress their Reports when
+// another listener reports the same multicast address, so not every MLDv1
+// listener may appear in the table.
+type Listener struct {
+	c        icmpx.Conn
+	interval time.Duration
+
+	mu      sync.Mutex
+	members map[memberKey]*Membership
+
+	// Swappable parameters for testing.
+	now func() time.Time
+}
+
+// A memberKey identifies a Membership in a Listener's table.
+type memberKey struct {
+	group, listener netip.Addr
+}
+
+// NewListener binds a Listener to a link-local address on the specified network
+// interface and joins the multicast groups required to receive Reports.
+func NewListener(ifi *net.Interface, cfg ListenerConfig) (*Listener, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(
+			ipv6.ICMPTypeMulticastListenerReport,
+			ipv6.ICMPTypeMulticastListenerDone,
+			ipv6.ICMPTypeVersion2MulticastListenerReport,
+		),
+		LinkLocal:       true,
+		ReceiveHopLimit: hopLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := append([]netip.Addr{allMLDv2Routers, allRouters}, cfg.Groups...)
+	for _, g := range groups {
+		if err := c.JoinGroup(g); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+
+	return newListener(c, cfg), nil
+}
+
+// newListener creates a Listener which receives Reports on conn. cfg must
+// already have its defaults applied.
+func newListener(conn icmpx.Conn, cfg ListenerConfig) *Listener {
+	return &Listener{
+		c:        conn,
+		interval: cfg.ListeningInterval,
+		members:  make(map[memberKey]*Membership),
+		now:      time.Now,
+	}
+}
+
+// Close closes the Listener's underlying network connection.
+func (l *Listener) Close() error { return l.c.Close() }
+
+// Serve receives Multicast Listener Reports and updates the Listener's table
+// until ctx is canceled.
+func (l *Listener) Serve(ctx context.Context) error {
+	for {
+		msg, ip, err := l.c.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		// Reports must originate from a link-local address. A node which
+		// has no link-local address yet sends Reports from the unspecified
+		// address, but those do not identify a listener.
+		if !ip.IsLinkLocalUnicast() {
+			continue
+		}
+
+		m, err := ParseMessage(msg)
+		if err != nil {
+			continue
+		}
+
+		l.handle(m, ip.WithZone(""))
+	}
+}
+
+// Memberships returns a snapshot of the Listener's table, sorted by multicast
+// address and then by listener.
+func (l *Listener) Memberships() []Membership {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	ms := make([]Membership, 0, len(l.members))
+	for k, m := range l.members {
+		if !now.Before(m.Expires) {
+			delete(l.members, k)
+			continue
+		}
+
+		mc := *m
+		mc.Sources = append([]netip.Addr(nil), m.Sources...)
+		ms = append(ms, mc)
+	}
+
+	sort.Slice(ms, func(i, j int) bool {
+		if c := ms[i].MulticastAddress.Compare(ms[j].MulticastAddress); c != 0 {
+			return c < 0
+		}
+
+		return ms[i].Listener.Less(ms[j].Listener)
+	})
+
+	return ms
+}
+
+// handle applies a Message sent by listener to the table.
+func (l *Listener) handle(m Message, listener netip.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	switch m := m.(type) {
+	case *Report:
+		l.update(memberKey{m.MulticastAddress, listener}, 1, now, func(_ *Membership) (FilterMode, []netip.Addr) {
+			return Exclude, nil
+		})
+	case *Done:
+		delete(l.members, memberKey{m.MulticastAddress, listener})
+	case *ReportV2:
+		for _, r := range m.Records {
+			l.record(r, listener, now)
+		}
+	}
+}
+
+// record applies an MLDv2 MulticastAddressRecord sent by listener to the table,
+// as described in RFC 3810, section 7.4, but for a single listener.
+func (l *Listener) record(r MulticastAddressRecord, listener netip.Addr, now time.Time) {
+	k := memberKey{r.MulticastAddress, listener}
+	switch r.Type {
+	case ModeIsInclude, ChangeToInclude:
+		l.update(k, 2, now, func(_ *Membership) (FilterMode, []netip.Addr) {
+			return Include, r.Sources
+		})
+	case ModeIsExclude, ChangeToExclude:
+		l.update(k, 2, now, func(_ *Membership) (FilterMode, []netip.Addr) {
+			return Exclude, r.Sources
+		})
+	case AllowNewSources:
+		l.update(k, 2, now, func(m *Membership) (FilterMode, []netip.Addr) {
+			if m == nil {
+				return Include, r.Sources
+			}
+			if m.Mode == Include {
+				return Include, union(m.Sources, r.Sources)
+			}
+
+			return Exclude, difference(m.Sources, r.Sources)
+		})
+	case BlockOldSources:
+		if _, ok := l.members[k]; !ok {
+			// Nothing to block.
+			return
+		}
+
+		l.update(k, 2, now, func(m *Membership) (FilterMode, []netip.Addr) {
+			if m.Mode == Include {
+				return Include, difference(m.Sources, r.Sources)
+			}
+
+			return Exclude, union(m.Sources, r.Sources)
+		})
+	}
+}
+
+// update computes the new filter state for k from its current Membership, or
+// nil if none exists, and refreshes the Membership. A Membership which
+// includes no sources is removed.
+func (l *Listener) update(
+	k memberKey,
+	version int,
+	now time.Time,
+	fn func(m *Membership) (FilterMode, []netip.Addr),
+) {
+	m := l.members[k]
+	if m != nil && !now.Before(m.Expires) {
+		// The Membership expired before this Report arrived.
+		m = nil
+	}
+
+	mode, srcs := fn(m)
+	if mode == Include && len(srcs) == 0 {
+		delete(l.members, k)
+		return
+	}
+
+	l.members[k] = &Membership{
+		MulticastAddress: k.group,
+		Listener:         k.listener,
+		Version:          version,
+		Mode:             mode,
+		Sources:          union(nil, srcs),
+		Expires:          now.Add(l.interval),
+	}
+}
+
+// union returns the addresses in a or b without duplicates.
+func union(a, b []netip.Addr) []netip.Addr {
+	var (
+		out  []netip.Addr
+		seen = make(map[netip.Addr]bool)
+	)
+
+	for _, ip := range append(append([]netip.Addr(nil), a...), b...) {
+		if !seen[ip] {
+			seen[ip] = true
+			out = append(out, ip)
+		}
+	}
+
+	return out
+}
+
+// difference returns the addresses in a which are not in b.
+func difference(a, b []netip.Addr) []netip.Addr {
+	remove := make(map[netip.Addr]bool, len(b))
+	for _, ip := range b {
+		remove[ip] = true
+	}
+
+	var out []netip.Addr
+	for _, ip := range a {
+		if !remove[ip] {
+			out = append(out, ip)
+		}
+	}
+
+	return out
+}
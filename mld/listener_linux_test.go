@@ -0,0 +1,123 @@
+package mld_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/internal/testns"
+	"github.com/mdlayher/icmpx/mld"
+)
+
+func TestMain(m *testing.M) { testns.Main(m) }
+
+func TestIntegrationListener(t *testing.T) {
+	host, neighbor := testns.Veth(t)
+
+	l, err := mld.NewListener(host, mld.ListenerConfig{})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer l.Close()
+
+	q, err := mld.NewQuerier(host)
+	if err != nil {
+		t.Fatalf("failed to create querier: %v", err)
+	}
+	defer q.Close()
+
+	sctx, scancel := context.WithCancel(context.Background())
+	defer scancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- l.Serve(sctx) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Join a group on the neighbor, which causes its kernel to report the
+	// membership both immediately and in response to our General Query.
+	nc, err := icmpx.ListenIPv6(neighbor, icmpx.IPv6Config{LinkLocal: true})
+	if err != nil {
+		t.Fatalf("failed to listen on neighbor: %v", err)
+	}
+	defer nc.Close()
+
+	group := netip.MustParseAddr("ff3e::1234")
+	if err := nc.JoinGroup(group); err != nil {
+		t.Fatalf("failed to join group: %v", err)
+	}
+
+	if err := q.GeneralQuery(ctx, 100*time.Millisecond); err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+
+	want := mld.Membership{
+		MulticastAddress: group,
+		Listener:         linkLocal(t, neighbor),
+		Version:          2,
+		Mode:             mld.Exclude,
+	}
+
+	for {
+		for _, m := range l.Memberships() {
+			if m.MulticastAddress != group {
+				continue
+			}
+
+			t.Logf("membership: %s: %s %s %v", m.Listener, m.MulticastAddress, m.Mode, m.Sources)
+
+			m.Expires = time.Time{}
+			if diff := cmp.Diff(want, m, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected membership (-want +got):\n%s", diff)
+			}
+
+			scancel()
+			if err := <-errC; err != nil {
+				t.Fatalf("failed to serve: %v", err)
+			}
+
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for membership")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// linkLocal returns the IPv6 link-local address of ifi.
+func linkLocal(t *testing.T, ifi *net.Interface) netip.Addr {
+	t.Helper()
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		t.Fatalf("failed to get addresses: %v", err)
+	}
+
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if ip, ok := netip.AddrFromSlice(ipn.IP); ok && ip.Is6() && ip.IsLinkLocalUnicast() {
+			return ip
+		}
+	}
+
+	t.Fatalf("no IPv6 link-local address on %q", ifi.Name)
+	return netip.Addr{}
+}
@@ -0,0 +1,326 @@
+package mld
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+)
+
+func TestListenerHandle(t *testing.T) {
+	var (
+		h1    = netip.MustParseAddr("fe80::1")
+		h2    = netip.MustParseAddr("fe80::2")
+		group = netip.MustParseAddr("ff3e::1234")
+		s1    = netip.MustParseAddr("2001:db8::1")
+		s2    = netip.MustParseAddr("2001:db8::2")
+		s3    = netip.MustParseAddr("2001:db8::3")
+	)
+
+	// record produces a Report with a single record for the group.
+	record := func(typ RecordType, srcs ...netip.Addr) *ReportV2 {
+		return &ReportV2{Records: []MulticastAddressRecord{{
+			Type:             typ,
+			MulticastAddress: group,
+			Sources:          srcs,
+		}}}
+	}
+
+	// membership produces the expected Membership for the group from h1.
+	membership := func(version int, mode FilterMode, srcs ...netip.Addr) []Membership {
+		return []Membership{{
+			MulticastAddress: group,
+			Listener:         h1,
+			Version:          version,
+			Mode:             mode,
+			Sources:          srcs,
+		}}
+	}
+
+	tests := []struct {
+		name string
+		msgs []Message
+		want []Membership
+	}{
+		{
+			name: "MLDv1 report",
+			msgs: []Message{&Report{MulticastAddress: group}},
+			want: membership(1, Exclude),
+		},
+		{
+			name: "MLDv1 done",
+			msgs: []Message{
+				&Report{MulticastAddress: group},
+				&Done{MulticastAddress: group},
+			},
+		},
+		{
+			name: "include",
+			msgs: []Message{record(ModeIsInclude, s1, s2, s1)},
+			want: membership(2, Include, s1, s2),
+		},
+		{
+			name: "include none",
+			msgs: []Message{
+				record(ModeIsExclude),
+				record(ChangeToInclude),
+			},
+		},
+		{
+			name: "exclude",
+			msgs: []Message{
+				record(ModeIsInclude, s1),
+				record(ChangeToExclude, s2),
+			},
+			want: membership(2, Exclude, s2),
+		},
+		{
+			name: "MLDv2 replaces MLDv1",
+			msgs: []Message{
+				&Report{MulticastAddress: group},
+				record(ChangeToInclude, s1),
+			},
+			want: membership(2, Include, s1),
+		},
+		{
+			name: "allow new",
+			msgs: []Message{record(AllowNewSources, s1)},
+			want: membership(2, Include, s1),
+		},
+		{
+			name: "allow include",
+			msgs: []Message{
+				record(ModeIsInclude, s1),
+				record(AllowNewSources, s1, s2),
+			},
+			want: membership(2, Include, s1, s2),
+		},
+		{
+			name: "allow exclude",
+			msgs: []Message{
+				record(ModeIsExclude, s1, s2),
+				record(AllowNewSources, s1, s3),
+			},
+			want: membership(2, Exclude, s2),
+		},
+		{
+			name: "block new",
+			msgs: []Message{record(BlockOldSources, s1)},
+		},
+		{
+			name: "block include",
+			msgs: []Message{
+				record(ModeIsInclude, s1, s2),
+				record(BlockOldSources, s1),
+			},
+			want: membership(2, Include, s2),
+		},
+		{
+			name: "block include all",
+			msgs: []Message{
+				record(ModeIsInclude, s1),
+				record(BlockOldSources, s1),
+			},
+		},
+		{
+			name: "block exclude",
+			msgs: []Message{
+				record(ModeIsExclude, s1),
+				record(BlockOldSources, s2),
+			},
+			want: membership(2, Exclude, s1, s2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newListener(nil, ListenerConfig{ListeningInterval: time.Minute})
+
+			now := time.Unix(0, 0)
+			l.now = func() time.Time { return now }
+
+			// Reports from other listeners must not affect h1.
+			l.handle(&Report{MulticastAddress: group}, h2)
+			for _, m := range tt.msgs {
+				l.handle(m, h1)
+			}
+
+			var got []Membership
+			for _, m := range l.Memberships() {
+				if m.Listener != h1 {
+					continue
+				}
+
+				if diff := cmp.Diff(now.Add(time.Minute), m.Expires); diff != "" {
+					t.Fatalf("unexpected expiration (-want +got):\n%s", diff)
+				}
+
+				m.Expires = time.Time{}
+				got = append(got, m)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected memberships (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestListenerExpiry(t *testing.T) {
+	var (
+		h1 = netip.MustParseAddr("fe80::1")
+		h2 = netip.MustParseAddr("fe80::2")
+		g1 = netip.MustParseAddr("ff02::1:ff00:1")
+		g2 = netip.MustParseAddr("ff3e::1")
+	)
+
+	l := newListener(nil, ListenerConfig{ListeningInterval: time.Minute})
+
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	l.handle(&Report{MulticastAddress: g2}, h2)
+	l.handle(&Report{MulticastAddress: g2}, h1)
+	l.handle(&Report{MulticastAddress: g1}, h1)
+
+	// Memberships are sorted by group and then by listener.
+	want := []Membership{
+		{MulticastAddress: g1, Listener: h1, Version: 1, Expires: now.Add(time.Minute)},
+		{MulticastAddress: g2, Listener: h1, Version: 1, Expires: now.Add(time.Minute)},
+		{MulticastAddress: g2, Listener: h2, Version: 1, Expires: now.Add(time.Minute)},
+	}
+
+	if diff := cmp.Diff(want, l.Memberships(), cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected memberships (-want +got):\n%s", diff)
+	}
+
+	// Only the refreshed membership remains after the others expire.
+	now = now.Add(30 * time.Second)
+	l.handle(&Report{MulticastAddress: g2}, h2)
+	now = now.Add(30 * time.Second)
+
+	want = []Membership{
+		{MulticastAddress: g2, Listener: h2, Version: 1, Expires: now.Add(30 * time.Second)},
+	}
+
+	if diff := cmp.Diff(want, l.Memberships(), cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected memberships after expiry (-want +got):\n%s", diff)
+	}
+}
+
+func TestListenerServe(t *testing.T) {
+	group := netip.MustParseAddr("ff3e::1234")
+
+	conn := &testConn{readC: make(chan message, 16)}
+	for _, ip := range []netip.Addr{
+		// Only the link-local listener is valid.
+		netip.IPv6Unspecified(),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("fe80::1%eth0"),
+	} {
+		conn.readC <- message{
+			Message: icmpMessage(&Report{MulticastAddress: group}),
+			IP:      ip,
+		}
+	}
+
+	l := newListener(conn, ListenerConfig{ListeningInterval: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- l.Serve(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(l.Memberships()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+
+	ms := l.Memberships()
+	for i := range ms {
+		ms[i].Expires = time.Time{}
+	}
+
+	want := []Membership{{
+		MulticastAddress: group,
+		Listener:         netip.MustParseAddr("fe80::1"),
+		Version:          1,
+	}}
+
+	if diff := cmp.Diff(want, ms, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected memberships (-want +got):\n%s", diff)
+	}
+}
+
+func TestListenerConfig(t *testing.T) {
+	for _, cfg := range []ListenerConfig{
+		{ListeningInterval: -1},
+		{Groups: []netip.Addr{netip.MustParseAddr("fe80::1")}},
+	} {
+		if _, err := cfg.withDefaults(); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+
+	cfg, err := ListenerConfig{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	if diff := cmp.Diff(260*time.Second, cfg.ListeningInterval); diff != "" {
+		t.Fatalf("unexpected listening interval (-want +got):\n%s", diff)
+	}
+}
+
+var _ icmpx.Conn = &testConn{}
+
+// A testConn implements icmpx.Conn by returning messages sent on readC and
+// capturing messages written to it.
+type testConn struct {
+	readC  chan message
+	writeC chan message
+}
+
+// A message is an ICMPv6 message and its source or destination address.
+type message struct {
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+func (*testConn) Close() error { return nil }
+
+func (c *testConn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-c.readC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (c *testConn) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	// Send the message over the "wire" to exercise marshaling.
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		return err
+	}
+
+	c.writeC <- message{Message: im, IP: dst}
+	return nil
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,633 @@
+package mld
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// A Message is an MLD message body. Each Message implements icmp.MessageBody
+// so it can be sent as the Body of an icmp.Message.
+type Message interface {
+	icmp.MessageBody
+
+	// Type returns the ICMPv6 type of the Message.
+	Type() ipv6.ICMPType
+
+	unmarshal(b []byte) error
+}
+
+var (
+	_ Message = &Query{}
+	_ Message = &QueryV2{}
+	_ Message = &Report{}
+	_ Message = &Done{}
+	_ Message = &ReportV2{}
+)
+
+// Lengths of MLD message bodies and records, excluding source addresses and
+// records.
+const (
+	v1Len       = 20
+	queryV2Len  = 24
+	reportV2Len = 4
+	recordLen   = 20
+)
+
+var errShortMessage = errors.New("mld: message too short")
+
+// ParseMessage parses an MLD Message from the body of an ICMPv6 message
+// produced by icmp.ParseMessage. A Multicast Listener Query is parsed as a
+// Query or QueryV2 depending on its length, as described in RFC 3810, section
+// 8.1.
+func ParseMessage(m *icmp.Message) (Message, error) {
+	switch body := m.Body.(type) {
+	case Message:
+		if body.Type() != m.Type {
+			return nil, fmt.Errorf("mld: message body %T does not match type %v", body, m.Type)
+		}
+
+		return body, nil
+	case *icmp.RawBody:
+		var msg Message
+		switch m.Type {
+		case ipv6.ICMPTypeMulticastListenerQuery:
+			switch n := len(body.Data); {
+			case n == v1Len:
+				msg = new(Query)
+			case n >= queryV2Len:
+				msg = new(QueryV2)
+			default:
+				return nil, fmt.Errorf("mld: invalid query length: %d", n)
+			}
+		case ipv6.ICMPTypeMulticastListenerReport:
+			msg = new(Report)
+		case ipv6.ICMPTypeMulticastListenerDone:
+			msg = new(Done)
+		case ipv6.ICMPTypeVersion2MulticastListenerReport:
+			msg = new(ReportV2)
+		default:
+			return nil, fmt.Errorf("mld: unexpected ICMPv6 type: %v", m.Type)
+		}
+
+		if err := msg.unmarshal(body.Data); err != nil {
+			return nil, err
+		}
+
+		return msg, nil
+	default:
+		return nil, fmt.Errorf("mld: unexpected message body: %T", m.Body)
+	}
+}
+
+// icmpMessage wraps an MLD Message in an icmp.Message.
+func icmpMessage(m Message) *icmp.Message {
+	return &icmp.Message{
+		Type: m.Type(),
+		Body: m,
+	}
+}
+
+// A Query is an MLDv1 Multicast Listener Query message, as described in RFC
+// 2710.
+type Query struct {
+	// MaxResponseDelay is the maximum time allowed before listeners send a
+	// Report in response to the Query.
+	MaxResponseDelay time.Duration
+
+	// MulticastAddress is the multicast address being queried, or the
+	// unspecified address for a General Query. The zero value is sent as
+	// the unspecified address.
+	MulticastAddress netip.Addr
+}
+
+// Type implements Message.
+func (*Query) Type() ipv6.ICMPType { return ipv6.ICMPTypeMulticastListenerQuery }
+
+// Len implements icmp.MessageBody.
+func (*Query) Len(_ int) int { return v1Len }
+
+// Marshal implements icmp.MessageBody.
+func (q *Query) Marshal(_ int) ([]byte, error) {
+	delay := q.MaxResponseDelay / time.Millisecond
+	if delay < 0 || delay > 0xffff {
+		return nil, fmt.Errorf("mld: invalid maximum response delay: %v", q.MaxResponseDelay)
+	}
+
+	group, err := queryAddress(q.MulticastAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, v1Len)
+	binary.BigEndian.PutUint16(b[0:2], uint16(delay))
+	copy(b[4:20], group[:])
+
+	return b, nil
+}
+
+func (q *Query) unmarshal(b []byte) error {
+	if len(b) < v1Len {
+		return errShortMessage
+	}
+
+	group, err := parseQueryAddress(b[4:20])
+	if err != nil {
+		return err
+	}
+
+	*q = Query{
+		MaxResponseDelay: time.Duration(binary.BigEndian.Uint16(b[0:2])) * time.Millisecond,
+		MulticastAddress: group,
+	}
+
+	return nil
+}
+
+// MLDv2 Query flags.
+const (
+	qSuppress = 1 << 3
+	qQRV      = 0x7
+)
+
+// A QueryV2 is an MLDv2 Multicast Listener Query message, as described in RFC
+// 3810, section 5.1.
+type QueryV2 struct {
+	// MaxResponseDelay is the maximum time allowed before listeners send a
+	// Report in response to the Query. Delays of 32.768 seconds or more are
+	// rounded down to the nearest value which can be represented.
+	MaxResponseDelay time.Duration
+
+	// MulticastAddress is the multicast address being queried, or the
+	// unspecified address for a General Query. The zero value is sent as
+	// the unspecified address.
+	MulticastAddress netip.Addr
+
+	// SuppressRouterProcessing indicates that routers receiving the Query
+	// must not update their timers.
+	SuppressRouterProcessing bool
+
+	// RobustnessVariable is the Querier's Robustness Variable, from 0 to 7.
+	RobustnessVariable int
+
+	// QueryInterval is the Querier's Query Interval. Intervals of 128
+	// seconds or more are rounded down to the nearest value which can be
+	// represented.
+	QueryInterval time.Duration
+
+	// Sources are the source addresses being queried for a Multicast Address
+	// and Source Specific Query.
+	Sources []netip.Addr
+}
+
+// Type implements Message.
+func (*QueryV2) Type() ipv6.ICMPType { return ipv6.ICMPTypeMulticastListenerQuery }
+
+// Len implements icmp.MessageBody.
+func (q *QueryV2) Len(_ int) int { return queryV2Len + 16*len(q.Sources) }
+
+// Marshal implements icmp.MessageBody.
+func (q *QueryV2) Marshal(_ int) ([]byte, error) {
+	code, err := encodeFloat(q.MaxResponseDelay/time.Millisecond, 0x8000, 12)
+	if err != nil {
+		return nil, fmt.Errorf("mld: invalid maximum response delay: %v", q.MaxResponseDelay)
+	}
+
+	qqic, err := encodeFloat(q.QueryInterval/time.Second, 0x80, 4)
+	if err != nil {
+		return nil, fmt.Errorf("mld: invalid query interval: %v", q.QueryInterval)
+	}
+
+	if q.RobustnessVariable < 0 || q.RobustnessVariable > qQRV {
+		return nil, fmt.Errorf("mld: invalid robustness variable: %d", q.RobustnessVariable)
+	}
+
+	if len(q.Sources) > 0xffff {
+		return nil, fmt.Errorf("mld: too many sources: %d", len(q.Sources))
+	}
+
+	group, err := queryAddress(q.MulticastAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, queryV2Len, q.Len(0))
+	binary.BigEndian.PutUint16(b[0:2], uint16(code))
+	copy(b[4:20], group[:])
+
+	if q.SuppressRouterProcessing {
+		b[20] |= qSuppress
+	}
+	b[20] |= byte(q.RobustnessVariable)
+	b[21] = byte(qqic)
+	binary.BigEndian.PutUint16(b[22:24], uint16(len(q.Sources)))
+
+	return appendSources(b, q.Sources)
+}
+
+func (q *QueryV2) unmarshal(b []byte) error {
+	if len(b) < queryV2Len {
+		return errShortMessage
+	}
+
+	group, err := parseQueryAddress(b[4:20])
+	if err != nil {
+		return err
+	}
+
+	n := int(binary.BigEndian.Uint16(b[22:24]))
+	srcs, err := parseSources(b[queryV2Len:], n)
+	if err != nil {
+		return err
+	}
+
+	*q = QueryV2{
+		MaxResponseDelay:         decodeFloat(int(binary.BigEndian.Uint16(b[0:2])), 0x8000, 12) * time.Millisecond,
+		MulticastAddress:         group,
+		SuppressRouterProcessing: b[20]&qSuppress != 0,
+		RobustnessVariable:       int(b[20] & qQRV),
+		QueryInterval:            decodeFloat(int(b[21]), 0x80, 4) * time.Second,
+		Sources:                  srcs,
+	}
+
+	return nil
+}
+
+// A Report is an MLDv1 Multicast Listener Report message, as described in RFC
+// 2710.
+type Report struct {
+	// MulticastAddress is the multicast address to which the sender is
+	// listening.
+	MulticastAddress netip.Addr
+}
+
+// Type implements Message.
+func (*Report) Type() ipv6.ICMPType { return ipv6.ICMPTypeMulticastListenerReport }
+
+// Len implements icmp.MessageBody.
+func (*Report) Len(_ int) int { return v1Len }
+
+// Marshal implements icmp.MessageBody.
+func (r *Report) Marshal(_ int) ([]byte, error) { return marshalV1(r.MulticastAddress) }
+
+func (r *Report) unmarshal(b []byte) error {
+	group, err := parseV1(b)
+	if err != nil {
+		return err
+	}
+
+	*r = Report{MulticastAddress: group}
+	return nil
+}
+
+// A Done is an MLDv1 Multicast Listener Done message, as described in RFC 2710.
+type Done struct {
+	// MulticastAddress is the multicast address to which the sender is no
+	// longer listening.
+	MulticastAddress netip.Addr
+}
+
+// Type implements Message.
+func (*Done) Type() ipv6.ICMPType { return ipv6.ICMPTypeMulticastListenerDone }
+
+// Len implements icmp.MessageBody.
+func (*Done) Len(_ int) int { return v1Len }
+
+// Marshal implements icmp.MessageBody.
+func (d *Done) Marshal(_ int) ([]byte, error) { return marshalV1(d.MulticastAddress) }
+
+func (d *Done) unmarshal(b []byte) error {
+	group, err := parseV1(b)
+	if err != nil {
+		return err
+	}
+
+	*d = Done{MulticastAddress: group}
+	return nil
+}
+
+// marshalV1 marshals the body of an MLDv1 Report or Done message.
+func marshalV1(group netip.Addr) ([]byte, error) {
+	if err := checkGroup(group); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, v1Len)
+	a := group.As16()
+	copy(b[4:20], a[:])
+
+	return b, nil
+}
+
+// parseV1 parses the body of an MLDv1 Report or Done message.
+func parseV1(b []byte) (netip.Addr, error) {
+	if len(b) < v1Len {
+		return netip.Addr{}, errShortMessage
+	}
+
+	return parseGroup(b[4:20])
+}
+
+// A ReportV2 is an MLDv2 Multicast Listener Report message, as described in
+// RFC 3810, section 5.2.
+type ReportV2 struct {
+	Records []MulticastAddressRecord
+}
+
+// Type implements Message.
+func (*ReportV2) Type() ipv6.ICMPType { return ipv6.ICMPTypeVersion2MulticastListenerReport }
+
+// Len implements icmp.MessageBody.
+func (r *ReportV2) Len(_ int) int {
+	n := reportV2Len
+	for _, rec := range r.Records {
+		n += rec.len()
+	}
+
+	return n
+}
+
+// Marshal implements icmp.MessageBody.
+func (r *ReportV2) Marshal(_ int) ([]byte, error) {
+	if len(r.Records) > 0xffff {
+		return nil, fmt.Errorf("mld: too many records: %d", len(r.Records))
+	}
+
+	b := make([]byte, reportV2Len, r.Len(0))
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(r.Records)))
+
+	for _, rec := range r.Records {
+		var err error
+		b, err = rec.append(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func (r *ReportV2) unmarshal(b []byte) error {
+	if len(b) < reportV2Len {
+		return errShortMessage
+	}
+
+	n := int(binary.BigEndian.Uint16(b[2:4]))
+	b = b[reportV2Len:]
+
+	var recs []MulticastAddressRecord
+	for i := 0; i < n; i++ {
+		var rec MulticastAddressRecord
+		rest, err := rec.parse(b)
+		if err != nil {
+			return err
+		}
+
+		recs = append(recs, rec)
+		b = rest
+	}
+
+	*r = ReportV2{Records: recs}
+	return nil
+}
+
+// A RecordType is the type of an MLDv2 MulticastAddressRecord.
+type RecordType uint8
+
+// Possible RecordType values, as described in RFC 3810, section 5.2.12.
+const (
+	// Current State Records, sent in response to a Query.
+	ModeIsInclude RecordType = 1
+	ModeIsExclude RecordType = 2
+
+	// Filter Mode Change Records.
+	ChangeToInclude RecordType = 3
+	ChangeToExclude RecordType = 4
+
+	// Source List Change Records.
+	AllowNewSources RecordType = 5
+	BlockOldSources RecordType = 6
+)
+
+// String returns the name of a RecordType.
+func (t RecordType) String() string {
+	switch t {
+	case ModeIsInclude:
+		return "MODE_IS_INCLUDE"
+	case ModeIsExclude:
+		return "MODE_IS_EXCLUDE"
+	case ChangeToInclude:
+		return "CHANGE_TO_INCLUDE_MODE"
+	case ChangeToExclude:
+		return "CHANGE_TO_EXCLUDE_MODE"
+	case AllowNewSources:
+		return "ALLOW_NEW_SOURCES"
+	case BlockOldSources:
+		return "BLOCK_OLD_SOURCES"
+	default:
+		return fmt.Sprintf("RecordType(%d)", t)
+	}
+}
+
+// A MulticastAddressRecord describes the sender's listening state for a
+// single multicast address in a ReportV2.
+type MulticastAddressRecord struct {
+	// Type is the type of the record.
+	Type RecordType
+
+	// MulticastAddress is the multicast address to which the record
+	// applies.
+	MulticastAddress netip.Addr
+
+	// Sources are the source addresses which are included or excluded for
+	// MulticastAddress, depending on Type.
+	Sources []netip.Addr
+
+	// AuxData is auxiliary data, whose length must be a multiple of 4 bytes.
+	// It is not defined by MLDv2 and is normally empty.
+	AuxData []byte
+}
+
+// len returns the wire length of a MulticastAddressRecord.
+func (r *MulticastAddressRecord) len() int {
+	return recordLen + 16*len(r.Sources) + len(r.AuxData)
+}
+
+// append appends the wire format of a MulticastAddressRecord to b.
+func (r *MulticastAddressRecord) append(b []byte) ([]byte, error) {
+	if r.Type < ModeIsInclude || r.Type > BlockOldSources {
+		return nil, fmt.Errorf("mld: invalid record type: %d", r.Type)
+	}
+	if len(r.AuxData)%4 != 0 || len(r.AuxData)/4 > 0xff {
+		return nil, fmt.Errorf("mld: invalid auxiliary data length: %d", len(r.AuxData))
+	}
+	if len(r.Sources) > 0xffff {
+		return nil, fmt.Errorf("mld: too many sources: %d", len(r.Sources))
+	}
+	if err := checkGroup(r.MulticastAddress); err != nil {
+		return nil, err
+	}
+
+	b = append(b, byte(r.Type), byte(len(r.AuxData)/4))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(r.Sources)))
+	group := r.MulticastAddress.As16()
+	b = append(b, group[:]...)
+
+	b, err := appendSources(b, r.Sources)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, r.AuxData...), nil
+}
+
+// parse parses a MulticastAddressRecord from b and returns the remaining bytes.
+func (r *MulticastAddressRecord) parse(b []byte) ([]byte, error) {
+	if len(b) < recordLen {
+		return nil, errShortMessage
+	}
+
+	var (
+		typ  = RecordType(b[0])
+		aux  = int(b[1]) * 4
+		n    = int(binary.BigEndian.Uint16(b[2:4]))
+		size = recordLen + 16*n + aux
+	)
+
+	if len(b) < size {
+		return nil, errShortMessage
+	}
+
+	group, err := parseGroup(b[4:20])
+	if err != nil {
+		return nil, err
+	}
+
+	srcs, err := parseSources(b[recordLen:], n)
+	if err != nil {
+		return nil, err
+	}
+
+	*r = MulticastAddressRecord{
+		Type:             typ,
+		MulticastAddress: group,
+		Sources:          srcs,
+	}
+
+	if aux > 0 {
+		r.AuxData = make([]byte, aux)
+		copy(r.AuxData, b[size-aux:size])
+	}
+
+	return b[size:], nil
+}
+
+// encodeFloat encodes v using the floating point format described in RFC
+// 3810, section 5.1.3, for a field whose values at or above limit use a
+// mantissa of the specified number of bits and a 3-bit exponent. v is
+// rounded down if it cannot be represented exactly.
+func encodeFloat(v time.Duration, limit, bits int) (int, error) {
+	if v < 0 {
+		return 0, errors.New("negative value")
+	}
+	if v < time.Duration(limit) {
+		return int(v), nil
+	}
+
+	for exp := 0; exp < 8; exp++ {
+		mant := int(v >> (exp + 3))
+		if mant < 2<<bits {
+			return limit | exp<<bits | (mant - 1<<bits), nil
+		}
+	}
+
+	return 0, errors.New("value too large")
+}
+
+// decodeFloat decodes a value produced by encodeFloat.
+func decodeFloat(code, limit, bits int) time.Duration {
+	if code < limit {
+		return time.Duration(code)
+	}
+
+	var (
+		exp  = (code >> bits) & 0x7
+		mant = code & (1<<bits - 1)
+	)
+
+	return time.Duration(mant|1<<bits) << (exp + 3)
+}
+
+// queryAddress returns the wire format of a Query's multicast address.
+func queryAddress(group netip.Addr) ([16]byte, error) {
+	switch {
+	case !group.IsValid():
+		return [16]byte{}, nil
+	case group.Is6() && (group.IsUnspecified() || group.IsMulticast()):
+		return group.As16(), nil
+	default:
+		return [16]byte{}, fmt.Errorf("mld: invalid query multicast address: %s", group)
+	}
+}
+
+// parseQueryAddress parses a Query's multicast address.
+func parseQueryAddress(b []byte) (netip.Addr, error) {
+	group := netip.AddrFrom16([16]byte(b))
+	if !group.IsUnspecified() && !group.IsMulticast() {
+		return netip.Addr{}, fmt.Errorf("mld: invalid query multicast address: %s", group)
+	}
+
+	return group, nil
+}
+
+// checkGroup verifies that group is a valid IPv6 multicast address.
+func checkGroup(group netip.Addr) error {
+	if !group.Is6() || !group.IsMulticast() {
+		return fmt.Errorf("mld: invalid multicast address: %s", group)
+	}
+
+	return nil
+}
+
+// parseGroup parses an IPv6 multicast address.
+func parseGroup(b []byte) (netip.Addr, error) {
+	group := netip.AddrFrom16([16]byte(b))
+	if err := checkGroup(group); err != nil {
+		return netip.Addr{}, err
+	}
+
+	return group, nil
+}
+
+// appendSources appends the wire format of IPv6 source addresses to b.
+func appendSources(b []byte, srcs []netip.Addr) ([]byte, error) {
+	for _, src := range srcs {
+		if !src.Is6() || src.Is4In6() || src.IsMulticast() {
+			return nil, fmt.Errorf("mld: invalid source address: %s", src)
+		}
+
+		a := src.As16()
+		b = append(b, a[:]...)
+	}
+
+	return b, nil
+}
+
+// parseSources parses n IPv6 source addresses from b.
+func parseSources(b []byte, n int) ([]netip.Addr, error) {
+	if len(b) < 16*n {
+		return nil, errShortMessage
+	}
+
+	var srcs []netip.Addr
+	for i := 0; i < n; i++ {
+		srcs = append(srcs, netip.AddrFrom16([16]byte(b[16*i:16*i+16])))
+	}
+
+	return srcs, nil
+}
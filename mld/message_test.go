@@ -0,0 +1,357 @@
+package mld_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/mld"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	src   = netip.MustParseAddr("fe80::1")
+	dst   = netip.MustParseAddr("ff02::16")
+	group = netip.MustParseAddr("ff3e::1234")
+
+	s1 = netip.MustParseAddr("2001:db8::1")
+	s2 = netip.MustParseAddr("2001:db8::2")
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		m    mld.Message
+	}{
+		{
+			name: "MLDv1 general query",
+			m: &mld.Query{
+				MaxResponseDelay: 10 * time.Second,
+				MulticastAddress: netip.IPv6Unspecified(),
+			},
+		},
+		{
+			name: "MLDv1 specific query",
+			m: &mld.Query{
+				MaxResponseDelay: time.Second,
+				MulticastAddress: group,
+			},
+		},
+		{
+			name: "MLDv2 general query",
+			m: &mld.QueryV2{
+				MaxResponseDelay:   10 * time.Second,
+				MulticastAddress:   netip.IPv6Unspecified(),
+				RobustnessVariable: 2,
+				QueryInterval:      125 * time.Second,
+			},
+		},
+		{
+			name: "MLDv2 source specific query",
+			m: &mld.QueryV2{
+				// Large values which are exactly representable.
+				MaxResponseDelay:         60 * time.Second,
+				MulticastAddress:         group,
+				SuppressRouterProcessing: true,
+				RobustnessVariable:       7,
+				QueryInterval:            288 * time.Second,
+				Sources:                  []netip.Addr{s1, s2},
+			},
+		},
+		{
+			name: "MLDv1 report",
+			m:    &mld.Report{MulticastAddress: group},
+		},
+		{
+			name: "MLDv1 done",
+			m:    &mld.Done{MulticastAddress: group},
+		},
+		{
+			name: "MLDv2 report",
+			m: &mld.ReportV2{
+				Records: []mld.MulticastAddressRecord{
+					{
+						Type:             mld.ModeIsExclude,
+						MulticastAddress: netip.MustParseAddr("ff02::1:ff00:1"),
+					},
+					{
+						Type:             mld.ChangeToInclude,
+						MulticastAddress: group,
+						Sources:          []netip.Addr{s1, s2},
+						AuxData:          []byte{0xde, 0xad, 0xbe, 0xef},
+					},
+					{
+						Type:             mld.BlockOldSources,
+						MulticastAddress: group,
+						Sources:          []netip.Addr{s1},
+					},
+				},
+			},
+		},
+		{
+			name: "MLDv2 empty report",
+			m:    &mld.ReportV2{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := (&icmp.Message{
+				Type: tt.m.Type(),
+				Body: tt.m,
+			}).Marshal(icmp.IPv6PseudoHeader(src.AsSlice(), dst.AsSlice()))
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			im, err := icmp.ParseMessage(58, b)
+			if err != nil {
+				t.Fatalf("failed to parse ICMPv6 message: %v", err)
+			}
+
+			m, err := mld.ParseMessage(im)
+			if err != nil {
+				t.Fatalf("failed to parse MLD message: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.m, m, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected message (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	// An MLDv2 Report sent by Linux after joining a group on an interface.
+	b := []byte{
+		0x8f, 0x00, 0x00, 0x00,
+		// Reserved, 2 records.
+		0x00, 0x00, 0x00, 0x02,
+		// CHANGE_TO_EXCLUDE_MODE ff02::1:ff28:9c5a, no sources.
+		0x04, 0x00, 0x00, 0x00,
+		0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01, 0xff, 0x28, 0x9c, 0x5a,
+		// ALLOW_NEW_SOURCES ff3e::1234, 1 source 2001:db8::1.
+		0x05, 0x00, 0x00, 0x01,
+		0xff, 0x3e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x12, 0x34,
+		0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+	}
+
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		t.Fatalf("failed to parse ICMPv6 message: %v", err)
+	}
+
+	m, err := mld.ParseMessage(im)
+	if err != nil {
+		t.Fatalf("failed to parse MLD message: %v", err)
+	}
+
+	want := &mld.ReportV2{
+		Records: []mld.MulticastAddressRecord{
+			{
+				Type:             mld.ChangeToExclude,
+				MulticastAddress: netip.MustParseAddr("ff02::1:ff28:9c5a"),
+			},
+			{
+				Type:             mld.AllowNewSources,
+				MulticastAddress: group,
+				Sources:          []netip.Addr{s1},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(want, m, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected message (-want +got):\n%s", diff)
+	}
+}
+
+func TestQueryV2Rounding(t *testing.T) {
+	b, err := (&mld.QueryV2{
+		MaxResponseDelay: 8388 * time.Second,
+		QueryInterval:    300 * time.Second,
+	}).Marshal(58)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	m, err := mld.ParseMessage(&icmp.Message{
+		Type: ipv6.ICMPTypeMulticastListenerQuery,
+		Body: &icmp.RawBody{Data: b},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	// Values which cannot be represented exactly are rounded down.
+	want := &mld.QueryV2{
+		MaxResponseDelay: 8387584 * time.Millisecond,
+		MulticastAddress: netip.IPv6Unspecified(),
+		QueryInterval:    288 * time.Second,
+	}
+
+	if diff := cmp.Diff(want, m, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected query (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *icmp.Message
+	}{
+		{
+			name: "echo",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeEchoRequest,
+				Body: &icmp.Echo{},
+			},
+		},
+		{
+			name: "bad query length",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeMulticastListenerQuery,
+				Body: &icmp.RawBody{Data: make([]byte, 22)},
+			},
+		},
+		{
+			name: "unicast query address",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeMulticastListenerQuery,
+				Body: &icmp.RawBody{Data: []byte{
+					0x00, 0x00, 0x00, 0x00,
+					0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+				}},
+			},
+		},
+		{
+			name: "truncated query sources",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeMulticastListenerQuery,
+				Body: &icmp.RawBody{Data: []byte{
+					0x00, 0x00, 0x00, 0x00,
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+					0x02, 0x7d, 0x00, 0x01,
+				}},
+			},
+		},
+		{
+			name: "short report",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeMulticastListenerReport,
+				Body: &icmp.RawBody{Data: make([]byte, 19)},
+			},
+		},
+		{
+			name: "unspecified done address",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeMulticastListenerDone,
+				Body: &icmp.RawBody{Data: make([]byte, 20)},
+			},
+		},
+		{
+			name: "truncated record",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeVersion2MulticastListenerReport,
+				Body: &icmp.RawBody{Data: []byte{
+					0x00, 0x00, 0x00, 0x01,
+					0x01, 0x00, 0x00, 0x00,
+				}},
+			},
+		},
+		{
+			name: "truncated record auxiliary data",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeVersion2MulticastListenerReport,
+				Body: &icmp.RawBody{Data: []byte{
+					0x00, 0x00, 0x00, 0x01,
+					0x01, 0x01, 0x00, 0x00,
+					0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+				}},
+			},
+		},
+		{
+			name: "mismatched body",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeMulticastListenerReport,
+				Body: &mld.Done{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := mld.ParseMessage(tt.m); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		m    mld.Message
+	}{
+		{
+			name: "MLDv1 response delay",
+			m:    &mld.Query{MaxResponseDelay: 2 * time.Minute},
+		},
+		{
+			name: "MLDv2 response delay",
+			m:    &mld.QueryV2{MaxResponseDelay: 3 * time.Hour},
+		},
+		{
+			name: "query interval",
+			m:    &mld.QueryV2{QueryInterval: 24 * time.Hour},
+		},
+		{
+			name: "robustness variable",
+			m:    &mld.QueryV2{RobustnessVariable: 8},
+		},
+		{
+			name: "unicast query address",
+			m:    &mld.Query{MulticastAddress: s1},
+		},
+		{
+			name: "multicast source",
+			m:    &mld.QueryV2{Sources: []netip.Addr{group}},
+		},
+		{
+			name: "unicast report address",
+			m:    &mld.Report{MulticastAddress: s1},
+		},
+		{
+			name: "record type",
+			m: &mld.ReportV2{Records: []mld.MulticastAddressRecord{{
+				Type:             7,
+				MulticastAddress: group,
+			}}},
+		},
+		{
+			name: "auxiliary data",
+			m: &mld.ReportV2{Records: []mld.MulticastAddressRecord{{
+				Type:             mld.ModeIsInclude,
+				MulticastAddress: group,
+				AuxData:          []byte{0xff},
+			}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.m.Marshal(58); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,106 @@
+package mld
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+)
+
+// hopLimit is the IPv6 Hop Limit used for all MLD messages, which must never be
+// forwarded off-link.
+const hopLimit = 1
+
+// Default MLDv2 protocol values from RFC 3810, section 9.
+const (
+	robustnessVariable    = 2
+	queryInterval         = 125 * time.Second
+	queryResponseInterval = 10 * time.Second
+
+	// multicastAddressListeningInterval is the time after which a listener
+	// is considered to have left a multicast address if it has not sent a
+	// Report for that address.
+	multicastAddressListeningInterval = robustnessVariable*queryInterval + queryResponseInterval
+)
+
+// Well-known multicast addresses used by MLD.
+var (
+	allNodes        = netip.MustParseAddr("ff02::1")
+	allRouters      = netip.MustParseAddr("ff02::2")
+	allMLDv2Routers = netip.MustParseAddr("ff02::16")
+)
+
+// A Querier sends Multicast Listener Queries on a link.
+type Querier struct {
+	c icmpx.Conn
+}
+
+// NewQuerier binds a Querier to a link-local address on the specified network
+// interface. Its Queries carry the Router Alert option with a Hop Limit of 1,
+// as required by RFC 3810, section 5.
+func NewQuerier(ifi *net.Interface) (*Querier, error) {
+	c, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		// The Querier only sends messages, so receive nothing.
+		Filter:      icmpx.IPv6AllowOnly(),
+		LinkLocal:   true,
+		RouterAlert: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.SetHopLimit(hopLimit); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	return newQuerier(c), nil
+}
+
+// newQuerier creates a Querier which sends Queries on conn.
+func newQuerier(conn icmpx.Conn) *Querier { return &Querier{c: conn} }
+
+// Close closes the Querier's underlying network connection.
+func (q *Querier) Close() error { return q.c.Close() }
+
+// Query sends m, which must be a *Query or *QueryV2. A General Query is sent to
+// the link-scope all-nodes multicast address, and a Multicast Address Specific
+// Query is sent to the multicast address being queried.
+func (q *Querier) Query(ctx context.Context, m Message) error {
+	var group netip.Addr
+	switch m := m.(type) {
+	case *Query:
+		group = m.MulticastAddress
+	case *QueryV2:
+		group = m.MulticastAddress
+	default:
+		return fmt.Errorf("mld: message %T is not a query", m)
+	}
+
+	dst := allNodes
+	if group.IsValid() && !group.IsUnspecified() {
+		dst = group
+	}
+
+	return q.c.WriteTo(ctx, icmpMessage(m), dst)
+}
+
+// GeneralQuery sends an MLDv2 General Query using the default Robustness
+// Variable and Query Interval from RFC 3810, requesting that every listener on
+// the link report its state within maxResponseDelay. If maxResponseDelay is
+// zero, the default Query Response Interval of 10 seconds is used.
+func (q *Querier) GeneralQuery(ctx context.Context, maxResponseDelay time.Duration) error {
+	if maxResponseDelay == 0 {
+		maxResponseDelay = queryResponseInterval
+	}
+
+	return q.Query(ctx, &QueryV2{
+		MaxResponseDelay:   maxResponseDelay,
+		MulticastAddress:   netip.IPv6Unspecified(),
+		RobustnessVariable: robustnessVariable,
+		QueryInterval:      queryInterval,
+	})
+}
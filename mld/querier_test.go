@@ -0,0 +1,91 @@
+package mld
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQuerierQuery(t *testing.T) {
+	group := netip.MustParseAddr("ff3e::1234")
+
+	tests := []struct {
+		name  string
+		query func(q *Querier) error
+		dst   netip.Addr
+		want  Message
+	}{
+		{
+			name: "general",
+			query: func(q *Querier) error {
+				return q.GeneralQuery(context.Background(), 0)
+			},
+			dst: allNodes,
+			want: &QueryV2{
+				MaxResponseDelay:   10 * time.Second,
+				MulticastAddress:   netip.IPv6Unspecified(),
+				RobustnessVariable: 2,
+				QueryInterval:      125 * time.Second,
+			},
+		},
+		{
+			name: "MLDv1 general",
+			query: func(q *Querier) error {
+				return q.Query(context.Background(), &Query{MaxResponseDelay: time.Second})
+			},
+			dst: allNodes,
+			want: &Query{
+				MaxResponseDelay: time.Second,
+				MulticastAddress: netip.IPv6Unspecified(),
+			},
+		},
+		{
+			name: "multicast address specific",
+			query: func(q *Querier) error {
+				return q.Query(context.Background(), &QueryV2{
+					MaxResponseDelay: time.Second,
+					MulticastAddress: group,
+				})
+			},
+			dst: group,
+			want: &QueryV2{
+				MaxResponseDelay: time.Second,
+				MulticastAddress: group,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &testConn{writeC: make(chan message, 1)}
+			if err := tt.query(newQuerier(conn)); err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+
+			msg := <-conn.writeC
+			if diff := cmp.Diff(tt.dst, msg.IP, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected destination (-want +got):\n%s", diff)
+			}
+
+			m, err := ParseMessage(msg.Message)
+			if err != nil {
+				t.Fatalf("failed to parse query: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, m, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected query (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQuerierQueryNotQuery(t *testing.T) {
+	q := newQuerier(&testConn{})
+	err := q.Query(context.Background(), &Report{MulticastAddress: netip.MustParseAddr("ff02::1")})
+	if err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
@@ -0,0 +1,359 @@
+package ndp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// Router advertisement constants from RFC 4861, section 10.
+const (
+	maxInitialRtrAdvertInterval = 16 * time.Second
+	maxInitialRtrAdvertisements = 3
+	minDelayBetweenRAs          = 3 * time.Second
+	maxRADelayTime              = 500 * time.Millisecond
+)
+
+// Router advertisement interval and lifetime limits from RFC 4861, section 6.2.1.
+const (
+	defaultMaxInterval = 600 * time.Second
+	minMaxInterval     = 4 * time.Second
+	maxMaxInterval     = 1800 * time.Second
+	minMinInterval     = 3 * time.Second
+	maxRouterLifetime  = 9000 * time.Second
+	minMTU             = 1280
+)
+
+// An AdvertiserConfig configures an Advertiser. The zero value is valid and
+// advertises this router as a default router with no options other than the
+// source link-layer address of the interface.
+type AdvertiserConfig struct {
+	// MinInterval and MaxInterval bound the randomized interval between
+	// unsolicited Router Advertisements. If zero, MaxInterval defaults to 10
+	// minutes and MinInterval defaults to one third of MaxInterval, as
+	// recommended by RFC 4861.
+	MinInterval, MaxInterval time.Duration
+
+	// CurrentHopLimit, ManagedConfiguration, OtherConfiguration,
+	// RouterPreference, ReachableTime, and RetransmitTimer are advertised
+	// as-is in each Router Advertisement.
+	CurrentHopLimit      uint8
+	ManagedConfiguration bool
+	OtherConfiguration   bool
+	RouterPreference     Preference
+	ReachableTime        time.Duration
+	RetransmitTimer      time.Duration
+
+	// RouterLifetime is the lifetime of this router as a default router. If
+	// zero, it defaults to three times MaxInterval. If negative, a lifetime
+	// of zero is advertised so that hosts will not use this router as a
+	// default router.
+	RouterLifetime time.Duration
+
+	// Prefixes are advertised using Prefix Information options.
+	Prefixes []PrefixInformation
+
+	// MTU, if non-zero, is advertised using an MTU option.
+	MTU int
+
+	// DNSServers, if set, are advertised using a Recursive DNS Server
+	// option with the specified lifetime. If DNSLifetime is zero, it
+	// defaults to three times MaxInterval.
+	DNSServers  []netip.Addr
+	DNSLifetime time.Duration
+
+	// Options are arbitrary additional options appended to each Router
+	// Advertisement.
+	Options []Option
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg AdvertiserConfig) withDefaults() (AdvertiserConfig, error) {
+	if cfg.MaxInterval == 0 {
+		cfg.MaxInterval = defaultMaxInterval
+	}
+	if cfg.MaxInterval < minMaxInterval || cfg.MaxInterval > maxMaxInterval {
+		return AdvertiserConfig{}, fmt.Errorf("ndp: maximum interval must be between %s and %s: %s",
+			minMaxInterval, maxMaxInterval, cfg.MaxInterval)
+	}
+
+	if cfg.MinInterval == 0 {
+		cfg.MinInterval = cfg.MaxInterval / 3
+		if cfg.MinInterval < minMinInterval {
+			cfg.MinInterval = minMinInterval
+		}
+	}
+	if cfg.MinInterval < minMinInterval || cfg.MinInterval > cfg.MaxInterval*3/4 {
+		return AdvertiserConfig{}, fmt.Errorf("ndp: minimum interval must be between %s and 3/4 of the maximum interval: %s",
+			minMinInterval, cfg.MinInterval)
+	}
+
+	switch {
+	case cfg.RouterLifetime == 0:
+		cfg.RouterLifetime = 3 * cfg.MaxInterval
+	case cfg.RouterLifetime < 0:
+		cfg.RouterLifetime = 0
+	case cfg.RouterLifetime < cfg.MaxInterval || cfg.RouterLifetime > maxRouterLifetime:
+		return AdvertiserConfig{}, fmt.Errorf("ndp: router lifetime must be between the maximum interval and %s: %s",
+			maxRouterLifetime, cfg.RouterLifetime)
+	}
+
+	if cfg.MTU != 0 && cfg.MTU < minMTU {
+		return AdvertiserConfig{}, fmt.Errorf("ndp: MTU must be at least %d: %d", minMTU, cfg.MTU)
+	}
+
+	if cfg.DNSLifetime == 0 {
+		cfg.DNSLifetime = 3 * cfg.MaxInterval
+	}
+
+	return cfg, nil
+}
+
+// An Advertiser is a router which periodically sends unsolicited Router
+// Advertisements and answers Router Solicitations, as described in RFC 4861,
+// section 6.2.
+type Advertiser struct {
+	c  icmpx.Conn
+	ra *RouterAdvertisement
+
+	minInterval, maxInterval time.Duration
+
+	// Swappable parameters for testing.
+	minDelay, maxDelay time.Duration
+	rand               func(d time.Duration) time.Duration
+}
+
+// NewAdvertiser creates an Advertiser which sends Router Advertisements from
+// a link-local address on ifi. The Advertiser joins the all-routers multicast
+// group on ifi to receive Router Solicitations.
+func NewAdvertiser(ifi *net.Interface, cfg AdvertiserConfig) (*Advertiser, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter:          icmpx.IPv6AllowOnly(ipv6.ICMPTypeRouterSolicitation),
+		LinkLocal:       true,
+		ReceiveHopLimit: hopLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.SetHopLimit(hopLimit); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	if err := c.JoinGroup(allRouters); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	a, err := newAdvertiser(c, cfg, ifi.HardwareAddr)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// newAdvertiser creates an Advertiser which sends Router Advertisements built
+// from cfg on conn. cfg must already have its defaults applied.
+func newAdvertiser(conn icmpx.Conn, cfg AdvertiserConfig, addr net.HardwareAddr) (*Advertiser, error) {
+	ra := &RouterAdvertisement{
+		CurrentHopLimit:      cfg.CurrentHopLimit,
+		ManagedConfiguration: cfg.ManagedConfiguration,
+		OtherConfiguration:   cfg.OtherConfiguration,
+		RouterPreference:     cfg.RouterPreference,
+		RouterLifetime:       cfg.RouterLifetime,
+		ReachableTime:        cfg.ReachableTime,
+		RetransmitTimer:      cfg.RetransmitTimer,
+	}
+
+	if len(addr) > 0 {
+		ra.Options = append(ra.Options, &LinkLayerAddress{
+			Direction: Source,
+			Addr:      addr,
+		})
+	}
+	if cfg.MTU != 0 {
+		mtu := MTU(cfg.MTU)
+		ra.Options = append(ra.Options, &mtu)
+	}
+	for i := range cfg.Prefixes {
+		pi := cfg.Prefixes[i]
+		ra.Options = append(ra.Options, &pi)
+	}
+	if len(cfg.DNSServers) > 0 {
+		ra.Options = append(ra.Options, &RecursiveDNSServer{
+			Lifetime: cfg.DNSLifetime,
+			Servers:  cfg.DNSServers,
+		})
+	}
+	ra.Options = append(ra.Options, cfg.Options...)
+
+	// Catch any invalid options before the first advertisement is sent.
+	if _, err := ra.Marshal(0); err != nil {
+		return nil, err
+	}
+
+	return &Advertiser{
+		c:           conn,
+		ra:          ra,
+		minInterval: cfg.MinInterval,
+		maxInterval: cfg.MaxInterval,
+		minDelay:    minDelayBetweenRAs,
+		maxDelay:    maxRADelayTime,
+		rand:        randDuration,
+	}, nil
+}
+
+// Close closes the Advertiser's underlying connection. Close should be called
+// after Serve returns.
+func (a *Advertiser) Close() error { return a.c.Close() }
+
+// Serve sends Router Advertisements to the all-nodes multicast address until
+// ctx is canceled. Before returning, Serve sends a final Router Advertisement
+// with a router lifetime of zero so that hosts stop using this router as a
+// default router.
+func (a *Advertiser) Serve(ctx context.Context) error {
+	rsC := make(chan struct{}, 1)
+
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.Go(func() error { return a.receive(ectx, rsC) })
+	eg.Go(func() error { return a.advertise(ectx, rsC) })
+
+	// Both goroutines only return nil once ctx is done.
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	final := *a.ra
+	final.RouterLifetime = 0
+
+	return a.c.WriteTo(context.WithoutCancel(ctx), icmpMessage(&final), allNodes)
+}
+
+// receive reads Router Solicitations and notifies rsC of each valid
+// solicitation until ctx is done.
+func (a *Advertiser) receive(ctx context.Context, rsC chan<- struct{}) error {
+	for {
+		msg, ip, err := a.c.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if msg.Code != 0 {
+			continue
+		}
+
+		m, err := ParseMessage(msg)
+		if err != nil {
+			continue
+		}
+		rs, ok := m.(*RouterSolicitation)
+		if !ok {
+			continue
+		}
+
+		// Solicitations from the unspecified address must not carry a source
+		// link-layer address option.
+		if ip.IsUnspecified() && linkLayerAddress(rs.Options, Source) != nil {
+			continue
+		}
+
+		// A pending solicitation already guarantees a prompt advertisement.
+		select {
+		case rsC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// advertise sends unsolicited Router Advertisements at randomized intervals,
+// and schedules solicited advertisements as signaled by rsC, until ctx is
+// done.
+func (a *Advertiser) advertise(ctx context.Context, rsC <-chan struct{}) error {
+	var (
+		n    int
+		last time.Time
+		next = time.Now()
+	)
+
+	// Advertise immediately on startup.
+	t := time.NewTimer(0)
+	defer func() { t.Stop() }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-rsC:
+			// Respond after a random delay, but never more often than the
+			// minimum delay between multicast advertisements.
+			at := time.Now().Add(a.rand(a.maxDelay))
+			if min := last.Add(a.minDelay); at.Before(min) {
+				at = min
+			}
+			if !at.Before(next) {
+				// An advertisement is already scheduled sooner.
+				continue
+			}
+
+			next = at
+			t.Stop()
+			t = time.NewTimer(time.Until(at))
+		case <-t.C:
+			if err := a.c.WriteTo(ctx, icmpMessage(a.ra), allNodes); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				return err
+			}
+
+			n++
+			last = time.Now()
+
+			d := a.interval(n)
+			next = last.Add(d)
+			t = time.NewTimer(d)
+		}
+	}
+}
+
+// interval returns the delay before the next unsolicited advertisement, after
+// n advertisements have been sent.
+func (a *Advertiser) interval(n int) time.Duration {
+	d := a.minInterval + a.rand(a.maxInterval-a.minInterval)
+	if n < maxInitialRtrAdvertisements && d > maxInitialRtrAdvertInterval {
+		// Send the first few advertisements more quickly so hosts can
+		// configure themselves promptly.
+		d = maxInitialRtrAdvertInterval
+	}
+
+	return d
+}
+
+// randDuration returns a uniformly random duration in the range [0, d].
+func randDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
@@ -0,0 +1,280 @@
+package ndp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+func TestAdvertiserConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  AdvertiserConfig
+		want AdvertiserConfig
+		ok   bool
+	}{
+		{
+			name: "defaults",
+			want: AdvertiserConfig{
+				MinInterval:    200 * time.Second,
+				MaxInterval:    600 * time.Second,
+				RouterLifetime: 1800 * time.Second,
+				DNSLifetime:    1800 * time.Second,
+			},
+			ok: true,
+		},
+		{
+			name: "short interval",
+			cfg: AdvertiserConfig{
+				MaxInterval:    4 * time.Second,
+				RouterLifetime: -1,
+				DNSLifetime:    time.Minute,
+			},
+			want: AdvertiserConfig{
+				MinInterval: 3 * time.Second,
+				MaxInterval: 4 * time.Second,
+				DNSLifetime: time.Minute,
+			},
+			ok: true,
+		},
+		{
+			name: "bad max interval",
+			cfg:  AdvertiserConfig{MaxInterval: time.Hour},
+		},
+		{
+			name: "bad min interval",
+			cfg: AdvertiserConfig{
+				MinInterval: 10 * time.Second,
+				MaxInterval: 10 * time.Second,
+			},
+		},
+		{
+			name: "bad router lifetime",
+			cfg:  AdvertiserConfig{RouterLifetime: time.Second},
+		},
+		{
+			name: "bad MTU",
+			cfg:  AdvertiserConfig{MTU: 576},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.cfg.withDefaults()
+			if tt.ok && err != nil {
+				t.Fatalf("failed to apply defaults: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+
+			if diff := cmp.Diff(tt.want, cfg); diff != "" {
+				t.Fatalf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAdvertiserInterval(t *testing.T) {
+	a, err := newAdvertiser(nil, AdvertiserConfig{
+		MinInterval: 200 * time.Second,
+		MaxInterval: 600 * time.Second,
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to create advertiser: %v", err)
+	}
+
+	// Always choose the maximum interval.
+	a.rand = func(d time.Duration) time.Duration { return d }
+
+	var got []time.Duration
+	for n := 1; n <= 4; n++ {
+		got = append(got, a.interval(n))
+	}
+
+	want := []time.Duration{16 * time.Second, 16 * time.Second, 600 * time.Second, 600 * time.Second}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected intervals (-want +got):\n%s", diff)
+	}
+}
+
+func TestAdvertiserServe(t *testing.T) {
+	mac := net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad}
+	cfg, err := AdvertiserConfig{
+		MaxInterval: 10 * time.Minute,
+		MTU:         1500,
+		Prefixes: []PrefixInformation{{
+			Prefix:            netip.MustParsePrefix("2001:db8::/64"),
+			OnLink:            true,
+			Autonomous:        true,
+			ValidLifetime:     Infinity,
+			PreferredLifetime: Infinity,
+		}},
+		DNSServers: []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+	}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	conn := newTestAdvertiserConn()
+	a, err := newAdvertiser(conn, cfg, mac)
+	if err != nil {
+		t.Fatalf("failed to create advertiser: %v", err)
+	}
+	a.minDelay = 50 * time.Millisecond
+	a.maxDelay = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- a.Serve(ctx) }()
+
+	mtu := MTU(1500)
+	want := &RouterAdvertisement{
+		RouterLifetime: 30 * time.Minute,
+		Options: []Option{
+			&LinkLayerAddress{Direction: Source, Addr: mac},
+			&mtu,
+			&PrefixInformation{
+				Prefix:            netip.MustParsePrefix("2001:db8::/64"),
+				OnLink:            true,
+				Autonomous:        true,
+				ValidLifetime:     Infinity,
+				PreferredLifetime: Infinity,
+			},
+			&RecursiveDNSServer{
+				Lifetime: 30 * time.Minute,
+				Servers:  []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+			},
+		},
+	}
+
+	// The first advertisement is sent immediately on startup.
+	if diff := cmp.Diff(want, conn.next(t), cmp.Comparer(ipEqual), cmp.Comparer(prefixEqual)); diff != "" {
+		t.Fatalf("unexpected initial advertisement (-want +got):\n%s", diff)
+	}
+
+	// Invalid solicitations are ignored, but a valid solicitation produces
+	// a prompt advertisement once the minimum delay has elapsed.
+	var (
+		unspecified = netip.IPv6Unspecified()
+		host        = netip.MustParseAddr("fe80::1")
+		lla         = []Option{&LinkLayerAddress{Direction: Source, Addr: mac}}
+	)
+
+	conn.solicit(1, host, nil)
+	conn.solicit(0, unspecified, lla)
+
+	select {
+	case ra := <-conn.raC:
+		t.Fatalf("unexpected advertisement for invalid solicitation: %#v", ra)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	start := time.Now()
+	conn.solicit(0, host, lla)
+
+	if diff := cmp.Diff(want, conn.next(t), cmp.Comparer(ipEqual), cmp.Comparer(prefixEqual)); diff != "" {
+		t.Fatalf("unexpected solicited advertisement (-want +got):\n%s", diff)
+	}
+	if since := time.Since(start); since > 1*time.Second {
+		t.Fatalf("solicited advertisement took too long: %v", since)
+	}
+
+	// On shutdown, a final advertisement with a zero lifetime is sent.
+	cancel()
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+
+	final := *want
+	final.RouterLifetime = 0
+
+	if diff := cmp.Diff(&final, conn.next(t), cmp.Comparer(ipEqual), cmp.Comparer(prefixEqual)); diff != "" {
+		t.Fatalf("unexpected final advertisement (-want +got):\n%s", diff)
+	}
+}
+
+var _ icmpx.Conn = &testAdvertiserConn{}
+
+// A testAdvertiserConn implements icmpx.Conn by passing Router Solicitations
+// to an Advertiser and capturing the Router Advertisements it sends.
+type testAdvertiserConn struct {
+	rsC chan message
+	raC chan *RouterAdvertisement
+}
+
+func newTestAdvertiserConn() *testAdvertiserConn {
+	return &testAdvertiserConn{
+		rsC: make(chan message, 16),
+		raC: make(chan *RouterAdvertisement, 16),
+	}
+}
+
+func (*testAdvertiserConn) Close() error { return nil }
+
+func (c *testAdvertiserConn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-c.rsC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (c *testAdvertiserConn) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	if dst != allNodes {
+		panic("unexpected destination: " + dst.String())
+	}
+
+	// Send the advertisement over the "wire" to exercise marshaling.
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		return err
+	}
+	m, err := ParseMessage(im)
+	if err != nil {
+		return err
+	}
+
+	c.raC <- m.(*RouterAdvertisement)
+	return nil
+}
+
+// solicit sends a Router Solicitation with the specified code to the
+// Advertiser.
+func (c *testAdvertiserConn) solicit(code int, src netip.Addr, opts []Option) {
+	c.rsC <- message{
+		Message: &icmp.Message{
+			Type: ipv6.ICMPTypeRouterSolicitation,
+			Code: code,
+			Body: &RouterSolicitation{Options: opts},
+		},
+		IP: src,
+	}
+}
+
+// next returns the next Router Advertisement sent by the Advertiser.
+func (c *testAdvertiserConn) next(t *testing.T) *RouterAdvertisement {
+	t.Helper()
+
+	select {
+	case ra := <-c.raC:
+		return ra
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for router advertisement")
+		return nil
+	}
+}
@@ -0,0 +1,362 @@
+package ndp
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// Duplicate Address Detection constants from RFC 4861, section 10, and RFC
+// 4862, section 5.1.
+const (
+	maxRtrSolicitationDelay = 1 * time.Second
+	dupAddrDetectTransmits  = 1
+)
+
+// A DADConfig configures Duplicate Address Detection. The zero value uses the
+// defaults specified by RFC 4862.
+type DADConfig struct {
+	// Transmits is the number of Neighbor Solicitations sent for the
+	// tentative address. If zero, one solicitation is sent.
+	Transmits int
+
+	// RetransTimer is the interval between Neighbor Solicitations, and the
+	// time to wait for a conflict after the final solicitation. If zero, it
+	// defaults to 1 second.
+	RetransTimer time.Duration
+
+	// Optimistic indicates that the tentative address is an Optimistic
+	// Address, as described in RFC 4429, which the caller may use while
+	// Duplicate Address Detection is in progress. The random delay before
+	// the first Neighbor Solicitation is omitted so that any conflict is
+	// detected as quickly as possible.
+	Optimistic bool
+
+	// Enhanced enables Enhanced Duplicate Address Detection, as described
+	// in RFC 7527. Each Neighbor Solicitation carries a random Nonce option
+	// so that solicitations which are looped back by the link are not
+	// mistaken for those of another node using the same address.
+	Enhanced bool
+}
+
+// A ConflictKind indicates the type of message which revealed a duplicate
+// address.
+type ConflictKind int
+
+// Possible ConflictKind values.
+const (
+	// ConflictAdvertisement indicates that another node advertised the
+	// tentative address as its own.
+	ConflictAdvertisement ConflictKind = iota
+
+	// ConflictSolicitation indicates that another node is performing
+	// Duplicate Address Detection for the tentative address.
+	ConflictSolicitation
+)
+
+// String returns the name of a ConflictKind.
+func (k ConflictKind) String() string {
+	switch k {
+	case ConflictAdvertisement:
+		return "advertisement"
+	case ConflictSolicitation:
+		return "solicitation"
+	default:
+		return fmt.Sprintf("ConflictKind(%d)", k)
+	}
+}
+
+// A Conflict describes a duplicate address found by Duplicate Address
+// Detection.
+type Conflict struct {
+	// Kind indicates the type of Message which revealed the conflict.
+	Kind ConflictKind
+
+	// IP is the source address of Message, which is the unspecified address
+	// for a ConflictSolicitation.
+	IP netip.Addr
+
+	// Addr is the link-layer address of the conflicting node, or nil if
+	// Message did not include one.
+	Addr net.HardwareAddr
+
+	// Message is the NeighborAdvertisement or NeighborSolicitation which
+	// revealed the conflict.
+	Message Message
+}
+
+// DetectDuplicateAddress performs Duplicate Address Detection for the
+// tentative address ip on ifi, as described in RFC 4862, section 5.4. Neighbor
+// Solicitations are sent from the unspecified address, so ifi does not need
+// any IPv6 addresses of its own.
+//
+// If another node is using or performing Duplicate Address Detection for ip,
+// a Conflict is returned. If no conflict is found, DetectDuplicateAddress
+// returns a nil Conflict and nil error, and ip may be assigned to ifi. If ctx
+// is canceled before the procedure completes, its error is returned.
+func DetectDuplicateAddress(ctx context.Context, ifi *net.Interface, ip netip.Addr, cfg DADConfig) (*Conflict, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	snm, err := SolicitedNodeMulticast(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(
+			ipv6.ICMPTypeNeighborSolicitation,
+			ipv6.ICMPTypeNeighborAdvertisement,
+		),
+		UnspecifiedSource: true,
+		ReceiveHopLimit:   hopLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if err := c.SetHopLimit(hopLimit); err != nil {
+		return nil, err
+	}
+
+	// Our own solicitations must not be mistaken for those of another node,
+	// and we must receive solicitations from other nodes which are
+	// performing Duplicate Address Detection for the same address.
+	if err := c.SetMulticastLoopback(false); err != nil {
+		return nil, err
+	}
+	if err := c.JoinGroup(snm); err != nil {
+		return nil, err
+	}
+
+	return newDetector(c, cfg).Detect(ctx, ip)
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg DADConfig) withDefaults() (DADConfig, error) {
+	if cfg.Transmits < 0 {
+		return DADConfig{}, fmt.Errorf("ndp: invalid number of DAD transmits: %d", cfg.Transmits)
+	}
+	if cfg.Transmits == 0 {
+		cfg.Transmits = dupAddrDetectTransmits
+	}
+
+	if cfg.RetransTimer < 0 {
+		return DADConfig{}, fmt.Errorf("ndp: invalid DAD retransmit timer: %s", cfg.RetransTimer)
+	}
+	if cfg.RetransTimer == 0 {
+		cfg.RetransTimer = retransTimer
+	}
+
+	return cfg, nil
+}
+
+// A detector performs Duplicate Address Detection on an icmpx.Conn.
+type detector struct {
+	conn         icmpx.Conn
+	transmits    int
+	retransTimer time.Duration
+	enhanced     bool
+
+	// Swappable parameters for testing.
+	delay func() time.Duration
+}
+
+// newDetector creates a detector which uses conn. cfg must already have its
+// defaults applied.
+func newDetector(conn icmpx.Conn, cfg DADConfig) *detector {
+	d := &detector{
+		conn:         conn,
+		transmits:    cfg.Transmits,
+		retransTimer: cfg.RetransTimer,
+		enhanced:     cfg.Enhanced,
+
+		delay: func() time.Duration { return randDuration(maxRtrSolicitationDelay) },
+	}
+
+	if cfg.Optimistic {
+		d.delay = func() time.Duration { return 0 }
+	}
+
+	return d
+}
+
+// Detect performs Duplicate Address Detection for target.
+func (d *detector) Detect(ctx context.Context, target netip.Addr) (*Conflict, error) {
+	if err := checkTarget(target); err != nil {
+		return nil, err
+	}
+
+	target = target.WithZone("")
+	dst, err := SolicitedNodeMulticast(target)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := &NeighborSolicitation{TargetAddress: target}
+
+	var nonce Nonce
+	if d.enhanced {
+		nonce = make(Nonce, minNonceLen)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+
+		ns.Options = []Option{&nonce}
+	}
+
+	// The procedure ends before ctx is done when no conflict is found, but a
+	// blocked read only observes cancelation if its context has no deadline.
+	// Drop any deadline from ctx and propagate its cancelation instead.
+	parent := ctx
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stop := context.AfterFunc(parent, cancel)
+	defer stop()
+
+	var conflict *Conflict
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		// Stop soliciting as soon as a conflict is found.
+		defer cancel()
+
+		c, err := d.receive(ctx, target, nonce)
+		conflict = c
+		return err
+	})
+	eg.Go(func() error {
+		// Stop receiving once the final RetransTimer expires.
+		defer cancel()
+		return d.solicit(ctx, ns, dst)
+	})
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	if conflict == nil && parent.Err() != nil {
+		// The procedure was interrupted and the address may not be unique.
+		return nil, parent.Err()
+	}
+
+	return conflict, nil
+}
+
+// solicit sends Neighbor Solicitations for Duplicate Address Detection and
+// waits for RetransTimer after each one, until ctx is done.
+func (d *detector) solicit(ctx context.Context, ns *NeighborSolicitation, dst netip.Addr) error {
+	if !sleep(ctx, d.delay()) {
+		return nil
+	}
+
+	for i := 0; i < d.transmits; i++ {
+		if err := d.conn.WriteTo(ctx, icmpMessage(ns), dst); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if !sleep(ctx, d.retransTimer) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// receive reads Neighbor Solicitations and Advertisements until a conflict
+// with target is found or ctx is done.
+func (d *detector) receive(ctx context.Context, target netip.Addr, nonce Nonce) (*Conflict, error) {
+	for {
+		msg, ip, err := d.conn.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		if msg.Code != 0 {
+			continue
+		}
+
+		m, err := ParseMessage(msg)
+		if err != nil {
+			continue
+		}
+
+		switch m := m.(type) {
+		case *NeighborAdvertisement:
+			if m.TargetAddress != target {
+				continue
+			}
+
+			return &Conflict{
+				Kind:    ConflictAdvertisement,
+				IP:      ip,
+				Addr:    linkLayerAddress(m.Options, Target),
+				Message: m,
+			}, nil
+		case *NeighborSolicitation:
+			// Solicitations from a unicast address are for address resolution
+			// and do not indicate a conflict.
+			if m.TargetAddress != target || !ip.IsUnspecified() {
+				continue
+			}
+
+			if nonce != nil && hasNonce(m.Options, nonce) {
+				// Our own solicitation was looped back by the link.
+				continue
+			}
+
+			return &Conflict{
+				Kind:    ConflictSolicitation,
+				IP:      ip,
+				Addr:    linkLayerAddress(m.Options, Source),
+				Message: m,
+			}, nil
+		}
+	}
+}
+
+// hasNonce reports whether opts contains a Nonce option matching nonce.
+func hasNonce(opts []Option, nonce Nonce) bool {
+	for _, o := range opts {
+		if n, ok := o.(*Nonce); ok && string(*n) == string(nonce) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sleep waits for d to elapse and reports whether it did so before ctx was
+// done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,77 @@
+package ndp_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/internal/testns"
+	"github.com/mdlayher/icmpx/ndp"
+)
+
+func TestIntegrationDetectDuplicateAddress(t *testing.T) {
+	host, neighbor := testns.Veth(t)
+
+	// The neighbor already owns this address and defends it.
+	owned := netip.MustParseAddr("2001:db8::1")
+	testns.AddAddress(t, neighbor, netip.PrefixFrom(owned, 64))
+
+	tests := []struct {
+		name string
+		ip   netip.Addr
+		want *ndp.Conflict
+	}{
+		{
+			name: "unique",
+			ip:   netip.MustParseAddr("2001:db8::2"),
+		},
+		{
+			name: "duplicate",
+			ip:   owned,
+			want: &ndp.Conflict{
+				Kind: ndp.ConflictAdvertisement,
+				Addr: neighbor.HardwareAddr,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conflict, err := ndp.DetectDuplicateAddress(ctx, host, tt.ip, ndp.DADConfig{
+				RetransTimer: 200 * time.Millisecond,
+				Optimistic:   true,
+				Enhanced:     true,
+			})
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					t.Skipf("skipping, permission denied")
+				}
+
+				t.Fatalf("failed to detect duplicate address: %v", err)
+			}
+
+			if conflict != nil {
+				t.Logf("conflict from %s: %s", conflict.IP, conflict.Addr)
+
+				// The advertisement is sent from the neighbor's own choice of
+				// source address.
+				if !conflict.IP.IsValid() {
+					t.Fatal("conflict has no source address")
+				}
+				conflict.IP = netip.Addr{}
+				conflict.Message = nil
+			}
+
+			if diff := cmp.Diff(tt.want, conflict, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected conflict (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
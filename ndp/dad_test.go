@@ -0,0 +1,276 @@
+package ndp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+)
+
+func TestDetectorDetect(t *testing.T) {
+	var (
+		tentative = netip.MustParseAddr("2001:db8::1")
+		other     = netip.MustParseAddr("2001:db8::2")
+		owner     = netip.MustParseAddr("fe80::1")
+		mac       = net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad}
+	)
+
+	// Respond to each solicitation using the NS that was sent.
+	type respondFunc func(ns *NeighborSolicitation) []message
+
+	tests := []struct {
+		name     string
+		enhanced bool
+		respond  respondFunc
+		want     *Conflict
+	}{
+		{
+			name:    "unique",
+			respond: func(_ *NeighborSolicitation) []message { return nil },
+		},
+		{
+			name: "advertisement",
+			respond: func(_ *NeighborSolicitation) []message {
+				return []message{
+					// Different target, ignored.
+					{Message: icmpMessage(&NeighborAdvertisement{TargetAddress: other}), IP: owner},
+					{
+						Message: icmpMessage(&NeighborAdvertisement{
+							Override:      true,
+							TargetAddress: tentative,
+							Options:       []Option{&LinkLayerAddress{Direction: Target, Addr: mac}},
+						}),
+						IP: owner,
+					},
+				}
+			},
+			want: &Conflict{
+				Kind: ConflictAdvertisement,
+				IP:   owner,
+				Addr: mac,
+				Message: &NeighborAdvertisement{
+					Override:      true,
+					TargetAddress: tentative,
+					Options:       []Option{&LinkLayerAddress{Direction: Target, Addr: mac}},
+				},
+			},
+		},
+		{
+			name: "address resolution",
+			respond: func(_ *NeighborSolicitation) []message {
+				// A solicitation from a unicast address is not a conflict.
+				return []message{{
+					Message: icmpMessage(&NeighborSolicitation{TargetAddress: tentative}),
+					IP:      owner,
+				}}
+			},
+		},
+		{
+			name: "simultaneous",
+			respond: func(_ *NeighborSolicitation) []message {
+				return []message{{
+					Message: icmpMessage(&NeighborSolicitation{TargetAddress: tentative}),
+					IP:      netip.IPv6Unspecified(),
+				}}
+			},
+			want: &Conflict{
+				Kind:    ConflictSolicitation,
+				IP:      netip.IPv6Unspecified(),
+				Message: &NeighborSolicitation{TargetAddress: tentative},
+			},
+		},
+		{
+			name:     "enhanced looped back",
+			enhanced: true,
+			respond: func(ns *NeighborSolicitation) []message {
+				// Our own solicitation is reflected by the link.
+				return []message{{
+					Message: icmpMessage(ns),
+					IP:      netip.IPv6Unspecified(),
+				}}
+			},
+		},
+		{
+			name:     "enhanced simultaneous",
+			enhanced: true,
+			respond: func(_ *NeighborSolicitation) []message {
+				nonce := Nonce{1, 2, 3, 4, 5, 6}
+				return []message{{
+					Message: icmpMessage(&NeighborSolicitation{
+						TargetAddress: tentative,
+						Options:       []Option{&nonce},
+					}),
+					IP: netip.IPv6Unspecified(),
+				}}
+			},
+			want: &Conflict{
+				Kind: ConflictSolicitation,
+				IP:   netip.IPv6Unspecified(),
+				Message: &NeighborSolicitation{
+					TargetAddress: tentative,
+					Options:       []Option{&Nonce{1, 2, 3, 4, 5, 6}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := newTestDADLink(tt.respond)
+
+			cfg, err := DADConfig{
+				Transmits:    2,
+				RetransTimer: 20 * time.Millisecond,
+				Optimistic:   true,
+				Enhanced:     tt.enhanced,
+			}.withDefaults()
+			if err != nil {
+				t.Fatalf("failed to apply defaults: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conflict, err := newDetector(link, cfg).Detect(ctx, tentative)
+			if err != nil {
+				t.Fatalf("failed to detect: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, conflict, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected conflict (-want +got):\n%s", diff)
+			}
+
+			link.mu.Lock()
+			defer link.mu.Unlock()
+
+			// Without a conflict, every solicitation is sent.
+			if tt.want == nil {
+				if diff := cmp.Diff(cfg.Transmits, len(link.solicitations)); diff != "" {
+					t.Fatalf("unexpected number of solicitations (-want +got):\n%s", diff)
+				}
+			}
+
+			for _, ns := range link.solicitations {
+				if diff := cmp.Diff(tentative, ns.TargetAddress, cmp.Comparer(ipEqual)); diff != "" {
+					t.Fatalf("unexpected target (-want +got):\n%s", diff)
+				}
+
+				var nonce bool
+				for _, o := range ns.Options {
+					switch o.(type) {
+					case *Nonce:
+						nonce = true
+					default:
+						t.Fatalf("unexpected option: %#v", o)
+					}
+				}
+
+				if diff := cmp.Diff(tt.enhanced, nonce); diff != "" {
+					t.Fatalf("unexpected nonce presence (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectorDetectCanceled(t *testing.T) {
+	link := newTestDADLink(func(_ *NeighborSolicitation) []message { return nil })
+
+	cfg, err := DADConfig{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The default delay and retransmit timer exceed the context deadline, so
+	// the procedure cannot complete.
+	d := newDetector(link, cfg)
+	d.delay = func() time.Duration { return 0 }
+
+	if _, err := d.Detect(ctx, netip.MustParseAddr("fe80::1")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, but got: %v", err)
+	}
+}
+
+func TestDADConfigErrors(t *testing.T) {
+	for _, cfg := range []DADConfig{{Transmits: -1}, {RetransTimer: -1}} {
+		if _, err := cfg.withDefaults(); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+}
+
+var _ icmpx.Conn = &testDADLink{}
+
+// A testDADLink implements icmpx.Conn by emulating a link whose nodes respond
+// to each Duplicate Address Detection solicitation.
+type testDADLink struct {
+	respond func(ns *NeighborSolicitation) []message
+
+	mu            sync.Mutex
+	solicitations []*NeighborSolicitation
+
+	msgC chan message
+}
+
+func newTestDADLink(respond func(ns *NeighborSolicitation) []message) *testDADLink {
+	return &testDADLink{
+		respond: respond,
+		msgC:    make(chan message, 16),
+	}
+}
+
+func (*testDADLink) Close() error { return nil }
+
+func (l *testDADLink) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-l.msgC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (l *testDADLink) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	// Send the solicitation over the "wire" to exercise marshaling.
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		return err
+	}
+	m, err := ParseMessage(im)
+	if err != nil {
+		return err
+	}
+	ns := m.(*NeighborSolicitation)
+
+	snm, err := SolicitedNodeMulticast(ns.TargetAddress)
+	if err != nil {
+		return err
+	}
+	if dst != snm {
+		panic("unexpected destination: " + dst.String())
+	}
+
+	l.mu.Lock()
+	l.solicitations = append(l.solicitations, ns)
+	l.mu.Unlock()
+
+	for _, m := range l.respond(ns) {
+		l.msgC <- m
+	}
+
+	return nil
+}
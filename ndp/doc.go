@@ -0,0 +1,5 @@
+// Package ndp implements IPv6 Neighbor Discovery Protocol messages and
+// options, as described in RFC 4861 and its extensions, along with router
+// discovery, a router advertisement sender, neighbor reachability probing, and
+// Duplicate Address Detection built on icmpx.IPv6Conn.
+package ndp
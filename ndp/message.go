@@ -0,0 +1,402 @@
+package ndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// Infinity indicates that a prefix or route lifetime never expires.
+const Infinity = time.Duration(0xffffffff) * time.Second
+
+// A Message is an NDP message body. Each Message implements icmp.MessageBody
+// so it can be sent as the Body of an icmp.Message.
+type Message interface {
+	icmp.MessageBody
+
+	// Type returns the ICMPv6 type of the Message.
+	Type() ipv6.ICMPType
+
+	unmarshal(b []byte) error
+}
+
+var (
+	_ Message = &RouterSolicitation{}
+	_ Message = &RouterAdvertisement{}
+	_ Message = &NeighborSolicitation{}
+	_ Message = &NeighborAdvertisement{}
+)
+
+// Minimum lengths of NDP message bodies, excluding options.
+const (
+	rsLen = 4
+	raLen = 12
+	nsLen = 20
+	naLen = 20
+)
+
+var errShortMessage = errors.New("ndp: message too short")
+
+// ParseMessage parses an NDP Message from the body of an ICMPv6 message
+// produced by icmp.ParseMessage.
+func ParseMessage(m *icmp.Message) (Message, error) {
+	var msg Message
+	switch m.Type {
+	case ipv6.ICMPTypeRouterSolicitation:
+		msg = new(RouterSolicitation)
+	case ipv6.ICMPTypeRouterAdvertisement:
+		msg = new(RouterAdvertisement)
+	case ipv6.ICMPTypeNeighborSolicitation:
+		msg = new(NeighborSolicitation)
+	case ipv6.ICMPTypeNeighborAdvertisement:
+		msg = new(NeighborAdvertisement)
+	default:
+		return nil, fmt.Errorf("ndp: unexpected ICMPv6 type: %v", m.Type)
+	}
+
+	switch body := m.Body.(type) {
+	case Message:
+		if body.Type() != m.Type {
+			return nil, fmt.Errorf("ndp: message body %T does not match type %v", body, m.Type)
+		}
+
+		return body, nil
+	case *icmp.RawBody:
+		if err := msg.unmarshal(body.Data); err != nil {
+			return nil, err
+		}
+
+		return msg, nil
+	default:
+		return nil, fmt.Errorf("ndp: unexpected message body: %T", m.Body)
+	}
+}
+
+// icmpMessage wraps an NDP Message in an icmp.Message.
+func icmpMessage(m Message) *icmp.Message {
+	return &icmp.Message{
+		Type: m.Type(),
+		Body: m,
+	}
+}
+
+// A RouterSolicitation is a Router Solicitation message.
+type RouterSolicitation struct {
+	Options []Option
+}
+
+// Type implements Message.
+func (*RouterSolicitation) Type() ipv6.ICMPType { return ipv6.ICMPTypeRouterSolicitation }
+
+// Len implements icmp.MessageBody.
+func (rs *RouterSolicitation) Len(_ int) int { return rsLen + optionsLen(rs.Options) }
+
+// Marshal implements icmp.MessageBody.
+func (rs *RouterSolicitation) Marshal(_ int) ([]byte, error) {
+	return marshalOptions(make([]byte, rsLen), rs.Options)
+}
+
+func (rs *RouterSolicitation) unmarshal(b []byte) error {
+	if len(b) < rsLen {
+		return errShortMessage
+	}
+
+	opts, err := parseOptions(b[rsLen:])
+	if err != nil {
+		return err
+	}
+
+	rs.Options = opts
+	return nil
+}
+
+// A Preference is a router or route preference, as described in RFC 4191.
+type Preference int
+
+// Possible Preference values.
+const (
+	Medium Preference = 0
+	High   Preference = 1
+	Low    Preference = 3
+
+	// prfReserved must be treated as Medium when received, and must never be
+	// sent.
+	prfReserved Preference = 2
+)
+
+// String returns the name of a Preference.
+func (p Preference) String() string {
+	switch p {
+	case Low:
+		return "low"
+	case Medium:
+		return "medium"
+	case High:
+		return "high"
+	default:
+		return fmt.Sprintf("Preference(%d)", p)
+	}
+}
+
+// parsePreference parses a 2-bit Preference value.
+func parsePreference(v uint8) Preference {
+	p := Preference(v & 0x3)
+	if p == prfReserved {
+		return Medium
+	}
+
+	return p
+}
+
+// marshal returns the 2-bit wire format of a Preference.
+func (p Preference) marshal() (uint8, error) {
+	switch p {
+	case Low, Medium, High:
+		return uint8(p), nil
+	default:
+		return 0, fmt.Errorf("ndp: invalid preference: %d", p)
+	}
+}
+
+// Router Advertisement flags.
+const (
+	raManaged = 1 << 7
+	raOther   = 1 << 6
+)
+
+// A RouterAdvertisement is a Router Advertisement message.
+type RouterAdvertisement struct {
+	// CurrentHopLimit is the hop limit hosts should use for outgoing
+	// packets, or 0 if unspecified by this router.
+	CurrentHopLimit uint8
+
+	// ManagedConfiguration and OtherConfiguration indicate the availability
+	// of addresses and other configuration via DHCPv6.
+	ManagedConfiguration bool
+	OtherConfiguration   bool
+
+	// RouterPreference is the preference of this router as a default router.
+	RouterPreference Preference
+
+	// RouterLifetime is the lifetime of this router as a default router. A
+	// zero lifetime indicates that the router is not a default router.
+	RouterLifetime time.Duration
+
+	// ReachableTime and RetransmitTimer configure neighbor unreachability
+	// detection, or are zero if unspecified by this router.
+	ReachableTime   time.Duration
+	RetransmitTimer time.Duration
+
+	Options []Option
+}
+
+// Type implements Message.
+func (*RouterAdvertisement) Type() ipv6.ICMPType { return ipv6.ICMPTypeRouterAdvertisement }
+
+// Len implements icmp.MessageBody.
+func (ra *RouterAdvertisement) Len(_ int) int { return raLen + optionsLen(ra.Options) }
+
+// Marshal implements icmp.MessageBody.
+func (ra *RouterAdvertisement) Marshal(_ int) ([]byte, error) {
+	prf, err := ra.RouterPreference.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := ra.RouterLifetime / time.Second
+	if lifetime < 0 || lifetime > 0xffff {
+		return nil, fmt.Errorf("ndp: invalid router lifetime: %v", ra.RouterLifetime)
+	}
+
+	b := make([]byte, raLen)
+	b[0] = ra.CurrentHopLimit
+	if ra.ManagedConfiguration {
+		b[1] |= raManaged
+	}
+	if ra.OtherConfiguration {
+		b[1] |= raOther
+	}
+	b[1] |= prf << 3
+
+	binary.BigEndian.PutUint16(b[2:4], uint16(lifetime))
+	binary.BigEndian.PutUint32(b[4:8], uint32(ra.ReachableTime/time.Millisecond))
+	binary.BigEndian.PutUint32(b[8:12], uint32(ra.RetransmitTimer/time.Millisecond))
+
+	return marshalOptions(b, ra.Options)
+}
+
+func (ra *RouterAdvertisement) unmarshal(b []byte) error {
+	if len(b) < raLen {
+		return errShortMessage
+	}
+
+	opts, err := parseOptions(b[raLen:])
+	if err != nil {
+		return err
+	}
+
+	*ra = RouterAdvertisement{
+		CurrentHopLimit:      b[0],
+		ManagedConfiguration: b[1]&raManaged != 0,
+		OtherConfiguration:   b[1]&raOther != 0,
+		RouterPreference:     parsePreference(b[1] >> 3),
+		RouterLifetime:       time.Duration(binary.BigEndian.Uint16(b[2:4])) * time.Second,
+		ReachableTime:        time.Duration(binary.BigEndian.Uint32(b[4:8])) * time.Millisecond,
+		RetransmitTimer:      time.Duration(binary.BigEndian.Uint32(b[8:12])) * time.Millisecond,
+		Options:              opts,
+	}
+
+	return nil
+}
+
+// A NeighborSolicitation is a Neighbor Solicitation message.
+type NeighborSolicitation struct {
+	// TargetAddress is the IPv6 address of the target of the solicitation.
+	TargetAddress netip.Addr
+
+	Options []Option
+}
+
+// Type implements Message.
+func (*NeighborSolicitation) Type() ipv6.ICMPType { return ipv6.ICMPTypeNeighborSolicitation }
+
+// Len implements icmp.MessageBody.
+func (ns *NeighborSolicitation) Len(_ int) int { return nsLen + optionsLen(ns.Options) }
+
+// Marshal implements icmp.MessageBody.
+func (ns *NeighborSolicitation) Marshal(_ int) ([]byte, error) {
+	if err := checkTarget(ns.TargetAddress); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, nsLen)
+	target := ns.TargetAddress.As16()
+	copy(b[4:], target[:])
+
+	return marshalOptions(b, ns.Options)
+}
+
+func (ns *NeighborSolicitation) unmarshal(b []byte) error {
+	if len(b) < nsLen {
+		return errShortMessage
+	}
+
+	target, err := parseTarget(b[4:20])
+	if err != nil {
+		return err
+	}
+
+	opts, err := parseOptions(b[nsLen:])
+	if err != nil {
+		return err
+	}
+
+	*ns = NeighborSolicitation{
+		TargetAddress: target,
+		Options:       opts,
+	}
+
+	return nil
+}
+
+// Neighbor Advertisement flags.
+const (
+	naRouter    = 1 << 7
+	naSolicited = 1 << 6
+	naOverride  = 1 << 5
+)
+
+// A NeighborAdvertisement is a Neighbor Advertisement message.
+type NeighborAdvertisement struct {
+	// Router indicates that the sender is a router, Solicited indicates that
+	// the advertisement was sent in response to a Neighbor Solicitation, and
+	// Override indicates that the advertisement should override an existing
+	// cached link-layer address.
+	Router    bool
+	Solicited bool
+	Override  bool
+
+	// TargetAddress is the IPv6 address of the target of the advertisement.
+	TargetAddress netip.Addr
+
+	Options []Option
+}
+
+// Type implements Message.
+func (*NeighborAdvertisement) Type() ipv6.ICMPType { return ipv6.ICMPTypeNeighborAdvertisement }
+
+// Len implements icmp.MessageBody.
+func (na *NeighborAdvertisement) Len(_ int) int { return naLen + optionsLen(na.Options) }
+
+// Marshal implements icmp.MessageBody.
+func (na *NeighborAdvertisement) Marshal(_ int) ([]byte, error) {
+	if err := checkTarget(na.TargetAddress); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, naLen)
+	if na.Router {
+		b[0] |= naRouter
+	}
+	if na.Solicited {
+		b[0] |= naSolicited
+	}
+	if na.Override {
+		b[0] |= naOverride
+	}
+
+	target := na.TargetAddress.As16()
+	copy(b[4:], target[:])
+
+	return marshalOptions(b, na.Options)
+}
+
+func (na *NeighborAdvertisement) unmarshal(b []byte) error {
+	if len(b) < naLen {
+		return errShortMessage
+	}
+
+	target, err := parseTarget(b[4:20])
+	if err != nil {
+		return err
+	}
+
+	opts, err := parseOptions(b[naLen:])
+	if err != nil {
+		return err
+	}
+
+	*na = NeighborAdvertisement{
+		Router:        b[0]&naRouter != 0,
+		Solicited:     b[0]&naSolicited != 0,
+		Override:      b[0]&naOverride != 0,
+		TargetAddress: target,
+		Options:       opts,
+	}
+
+	return nil
+}
+
+// checkTarget verifies that ip is a valid neighbor discovery target address.
+func checkTarget(ip netip.Addr) error {
+	if !ip.Is6() || ip.Is4In6() || ip.IsMulticast() {
+		return fmt.Errorf("ndp: invalid target address: %s", ip)
+	}
+
+	return nil
+}
+
+// parseTarget parses a neighbor discovery target address.
+func parseTarget(b []byte) (netip.Addr, error) {
+	ip := netip.AddrFrom16([16]byte(b))
+	if ip.IsMulticast() {
+		return netip.Addr{}, fmt.Errorf("ndp: invalid multicast target address: %s", ip)
+	}
+
+	return ip, nil
+}
@@ -0,0 +1,335 @@
+package ndp_test
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/ndp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	src = netip.MustParseAddr("fe80::1")
+	dst = netip.MustParseAddr("ff02::1")
+	mac = net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad}
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	mtu := ndp.MTU(1500)
+
+	tests := []struct {
+		name string
+		m    ndp.Message
+	}{
+		{
+			name: "router solicitation",
+			m:    &ndp.RouterSolicitation{},
+		},
+		{
+			name: "router solicitation source LLA",
+			m: &ndp.RouterSolicitation{
+				Options: []ndp.Option{&ndp.LinkLayerAddress{
+					Direction: ndp.Source,
+					Addr:      mac,
+				}},
+			},
+		},
+		{
+			name: "router advertisement",
+			m: &ndp.RouterAdvertisement{
+				CurrentHopLimit:      64,
+				ManagedConfiguration: true,
+				OtherConfiguration:   true,
+				RouterPreference:     ndp.High,
+				RouterLifetime:       30 * time.Minute,
+				ReachableTime:        30 * time.Second,
+				RetransmitTimer:      1 * time.Second,
+				Options: []ndp.Option{
+					&ndp.LinkLayerAddress{
+						Direction: ndp.Source,
+						Addr:      mac,
+					},
+					&mtu,
+					&ndp.PrefixInformation{
+						Prefix:            netip.MustParsePrefix("2001:db8::/64"),
+						OnLink:            true,
+						Autonomous:        true,
+						ValidLifetime:     ndp.Infinity,
+						PreferredLifetime: 4 * time.Hour,
+					},
+					&ndp.RouteInformation{
+						Prefix:     netip.MustParsePrefix("2001:db8:ffff::/48"),
+						Preference: ndp.Low,
+						Lifetime:   1 * time.Hour,
+					},
+					&ndp.RouteInformation{
+						Prefix:   netip.MustParsePrefix("::/0"),
+						Lifetime: 1 * time.Hour,
+					},
+					&ndp.RecursiveDNSServer{
+						Lifetime: 1 * time.Hour,
+						Servers: []netip.Addr{
+							netip.MustParseAddr("2001:db8::53"),
+							netip.MustParseAddr("2001:db8::54"),
+						},
+					},
+					&ndp.DNSSearchList{
+						Lifetime:    1 * time.Hour,
+						DomainNames: []string{"example.com", "lan"},
+					},
+					&ndp.RawOption{
+						Type:  253,
+						Value: []byte{0, 1, 2, 3, 4, 5},
+					},
+				},
+			},
+		},
+		{
+			name: "neighbor solicitation",
+			m: &ndp.NeighborSolicitation{
+				TargetAddress: netip.MustParseAddr("fe80::2"),
+				Options: []ndp.Option{&ndp.LinkLayerAddress{
+					Direction: ndp.Source,
+					Addr:      mac,
+				}},
+			},
+		},
+		{
+			name: "neighbor advertisement",
+			m: &ndp.NeighborAdvertisement{
+				Router:        true,
+				Solicited:     true,
+				Override:      true,
+				TargetAddress: netip.MustParseAddr("2001:db8::1"),
+				Options: []ndp.Option{&ndp.LinkLayerAddress{
+					Direction: ndp.Target,
+					Addr:      mac,
+				}},
+			},
+		},
+		{
+			name: "neighbor advertisement no flags",
+			m: &ndp.NeighborAdvertisement{
+				TargetAddress: netip.MustParseAddr("fe80::1"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := (&icmp.Message{
+				Type: tt.m.Type(),
+				Body: tt.m,
+			}).Marshal(icmp.IPv6PseudoHeader(src.AsSlice(), dst.AsSlice()))
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			im, err := icmp.ParseMessage(58, b)
+			if err != nil {
+				t.Fatalf("failed to parse ICMPv6 message: %v", err)
+			}
+
+			m, err := ndp.ParseMessage(im)
+			if err != nil {
+				t.Fatalf("failed to parse NDP message: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.m, m, cmp.Comparer(ipEqual), cmp.Comparer(prefixEqual)); diff != "" {
+				t.Fatalf("unexpected message (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	// A Router Advertisement captured from a home router.
+	b := []byte{
+		0x86, 0x00, 0x00, 0x00,
+		// Hop limit 64, other configuration, lifetime 1800s.
+		0x40, 0x40, 0x07, 0x08,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		// Source link-layer address.
+		0x01, 0x01, 0xde, 0xad, 0xbe, 0xef, 0xde, 0xad,
+		// MTU 1492.
+		0x05, 0x01, 0x00, 0x00, 0x00, 0x00, 0x05, 0xd4,
+		// Prefix information 2001:db8::/64.
+		0x03, 0x04, 0x40, 0xc0,
+		0x00, 0x00, 0x0e, 0x10,
+		0x00, 0x00, 0x07, 0x08,
+		0x00, 0x00, 0x00, 0x00,
+		0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		t.Fatalf("failed to parse ICMPv6 message: %v", err)
+	}
+
+	m, err := ndp.ParseMessage(im)
+	if err != nil {
+		t.Fatalf("failed to parse NDP message: %v", err)
+	}
+
+	mtu := ndp.MTU(1492)
+	want := &ndp.RouterAdvertisement{
+		CurrentHopLimit:    64,
+		OtherConfiguration: true,
+		RouterLifetime:     30 * time.Minute,
+		Options: []ndp.Option{
+			&ndp.LinkLayerAddress{
+				Direction: ndp.Source,
+				Addr:      mac,
+			},
+			&mtu,
+			&ndp.PrefixInformation{
+				Prefix:            netip.MustParsePrefix("2001:db8::/64"),
+				OnLink:            true,
+				Autonomous:        true,
+				ValidLifetime:     1 * time.Hour,
+				PreferredLifetime: 30 * time.Minute,
+			},
+		},
+	}
+
+	if diff := cmp.Diff(want, m, cmp.Comparer(ipEqual), cmp.Comparer(prefixEqual)); diff != "" {
+		t.Fatalf("unexpected message (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *icmp.Message
+	}{
+		{
+			name: "echo",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeEchoRequest,
+				Body: &icmp.Echo{},
+			},
+		},
+		{
+			name: "short router advertisement",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeRouterAdvertisement,
+				Body: &icmp.RawBody{Data: make([]byte, 11)},
+			},
+		},
+		{
+			name: "zero length option",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeRouterSolicitation,
+				Body: &icmp.RawBody{Data: []byte{
+					0x00, 0x00, 0x00, 0x00,
+					0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				}},
+			},
+		},
+		{
+			name: "truncated option",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeRouterSolicitation,
+				Body: &icmp.RawBody{Data: []byte{
+					0x00, 0x00, 0x00, 0x00,
+					0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				}},
+			},
+		},
+		{
+			name: "short neighbor solicitation",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNeighborSolicitation,
+				Body: &icmp.RawBody{Data: make([]byte, 19)},
+			},
+		},
+		{
+			name: "multicast target",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNeighborAdvertisement,
+				Body: &icmp.RawBody{Data: []byte{
+					0x00, 0x00, 0x00, 0x00,
+					0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+				}},
+			},
+		},
+		{
+			name: "mismatched body",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeRouterAdvertisement,
+				Body: &ndp.RouterSolicitation{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ndp.ParseMessage(tt.m); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		m    ndp.Message
+	}{
+		{
+			name: "preference",
+			m:    &ndp.RouterAdvertisement{RouterPreference: 2},
+		},
+		{
+			name: "router lifetime",
+			m:    &ndp.RouterAdvertisement{RouterLifetime: 24 * time.Hour},
+		},
+		{
+			name: "IPv4 prefix",
+			m: &ndp.RouterAdvertisement{
+				Options: []ndp.Option{&ndp.PrefixInformation{
+					Prefix: netip.MustParsePrefix("192.0.2.0/24"),
+				}},
+			},
+		},
+		{
+			name: "raw option length",
+			m: &ndp.RouterSolicitation{
+				Options: []ndp.Option{&ndp.RawOption{Type: 253, Value: []byte{0}}},
+			},
+		},
+		{
+			name: "domain name",
+			m: &ndp.RouterAdvertisement{
+				Options: []ndp.Option{&ndp.DNSSearchList{DomainNames: []string{"foo..com"}}},
+			},
+		},
+		{
+			name: "IPv4 target",
+			m:    &ndp.NeighborSolicitation{TargetAddress: netip.MustParseAddr("192.0.2.1")},
+		},
+		{
+			name: "multicast target",
+			m:    &ndp.NeighborAdvertisement{TargetAddress: netip.MustParseAddr("ff02::1")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.m.Marshal(58); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
+
+func prefixEqual(x, y netip.Prefix) bool { return x == y }
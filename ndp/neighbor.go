@@ -0,0 +1,336 @@
+package ndp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// retransTimer is the default RetransTimer value from RFC 4861, section 10.
+const retransTimer = 1 * time.Second
+
+// SolicitedNodeMulticast returns the solicited-node multicast address for ip,
+// as described in RFC 4291, section 2.7.1.
+func SolicitedNodeMulticast(ip netip.Addr) (netip.Addr, error) {
+	if !ip.Is6() || ip.Is4In6() {
+		return netip.Addr{}, fmt.Errorf("ndp: invalid IPv6 address: %s", ip)
+	}
+
+	a := ip.As16()
+	return netip.AddrFrom16([16]byte{
+		0: 0xff, 1: 0x02,
+		11: 0x01, 12: 0xff,
+		13: a[13], 14: a[14], 15: a[15],
+	}), nil
+}
+
+// A Mode specifies how a Pinger addresses its Neighbor Solicitations.
+type Mode int
+
+// Possible Mode values.
+const (
+	// Multicast sends Neighbor Solicitations to the solicited-node
+	// multicast address of the target, as is done for address resolution.
+	Multicast Mode = iota
+
+	// Unicast sends Neighbor Solicitations directly to the target, as is
+	// done for neighbor unreachability detection.
+	Unicast
+)
+
+// String returns the name of a Mode.
+func (m Mode) String() string {
+	switch m {
+	case Multicast:
+		return "multicast"
+	case Unicast:
+		return "unicast"
+	default:
+		return fmt.Sprintf("Mode(%d)", m)
+	}
+}
+
+// A Pinger sends Neighbor Solicitations to check the reachability of
+// neighbors at the link layer, similar to arping for IPv4.
+type Pinger struct {
+	// Manages the underlying socket and the link-layer address advertised
+	// in solicitations.
+	conn icmpx.Conn
+	addr net.HardwareAddr
+
+	// Manages the concurrency of the Pinger.
+	eg     *errgroup.Group
+	cancel context.CancelFunc
+
+	// Manages dispatching advertisements to in-flight pings by target.
+	mu    sync.Mutex
+	pings map[netip.Addr][]chan reply
+
+	// Swappable parameters for testing.
+	retryDelay time.Duration
+}
+
+// NewPinger binds a Pinger to a link-local address on the specified network
+// interface.
+func NewPinger(ifi *net.Interface) (*Pinger, error) {
+	c, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter:          icmpx.IPv6AllowOnly(ipv6.ICMPTypeNeighborAdvertisement),
+		LinkLocal:       true,
+		ReceiveHopLimit: hopLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Neighbors discard solicitations which may have been forwarded.
+	if err := c.SetHopLimit(hopLimit); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	return newPinger(c, ifi.HardwareAddr), nil
+}
+
+// A reply contains a Neighbor Advertisement to dispatch to a listener.
+type reply struct {
+	Advertisement *NeighborAdvertisement
+	IP            netip.Addr
+	Time          time.Time
+}
+
+// newPinger constructs a Pinger from a raw icmpx.Conn which advertises addr in
+// its Neighbor Solicitations, if set, starting its background goroutines.
+func newPinger(conn icmpx.Conn, addr net.HardwareAddr) *Pinger {
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+
+	p := &Pinger{
+		conn: conn,
+		addr: addr,
+
+		eg:     eg,
+		cancel: cancel,
+
+		pings: make(map[netip.Addr][]chan reply),
+
+		// By default, we retransmit a solicitation after RetransTimer has
+		// elapsed without an advertisement.
+		retryDelay: retransTimer,
+	}
+
+	eg.Go(func() error { return p.readLoop(ctx) })
+
+	return p
+}
+
+// Close stops the Pinger's background goroutines and closes its underlying
+// network connection.
+func (p *Pinger) Close() error {
+	p.cancel()
+	if err := p.eg.Wait(); err != nil {
+		_ = p.conn.Close()
+		return err
+	}
+
+	return p.conn.Close()
+}
+
+// A PingResponse is the result of a Pinger.Ping operation.
+type PingResponse struct {
+	// Duration reports how much time elapsed between sending the final
+	// Neighbor Solicitation and receiving a Neighbor Advertisement.
+	Duration time.Duration
+
+	// Addr is the target link-layer address reported by the neighbor, or
+	// nil if the advertisement did not include one.
+	Addr net.HardwareAddr
+
+	// Router, Solicited, and Override are the flags of the advertisement.
+	Router, Solicited, Override bool
+
+	// Solicitation and Advertisement are the raw NDP messages sent by the
+	// Pinger and received from the neighbor.
+	Solicitation  *NeighborSolicitation
+	Advertisement *NeighborAdvertisement
+
+	// IP is the IPv6 source address of the advertisement.
+	IP netip.Addr
+}
+
+// Ping sends a Neighbor Solicitation for target using the specified Mode and
+// reports the Neighbor Advertisement sent in response. Ping retries until an
+// advertisement is received or ctx is canceled.
+func (p *Pinger) Ping(ctx context.Context, target netip.Addr, mode Mode) (*PingResponse, error) {
+	if err := checkTarget(target); err != nil {
+		return nil, err
+	}
+
+	var dst netip.Addr
+	switch mode {
+	case Multicast:
+		snm, err := SolicitedNodeMulticast(target)
+		if err != nil {
+			return nil, err
+		}
+		dst = snm
+	case Unicast:
+		dst = target
+	default:
+		return nil, fmt.Errorf("ndp: invalid mode: %d", mode)
+	}
+
+	// The target address in messages never carries a zone.
+	target = target.WithZone("")
+
+	ns := &NeighborSolicitation{TargetAddress: target}
+	if len(p.addr) > 0 {
+		ns.Options = []Option{&LinkLayerAddress{
+			Direction: Source,
+			Addr:      p.addr,
+		}}
+	}
+
+	replyC := p.register(target)
+	defer p.unregister(target, replyC)
+
+	// It may take more than one attempt for a solicitation to succeed, so
+	// send them at regular intervals until an advertisement is received.
+	for {
+		switch res, err := p.doPing(ctx, ns, dst, replyC); {
+		case err == nil:
+			return res, nil
+		case errors.Is(err, errRetry):
+			// Timed out waiting for an advertisement. Try again.
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
+
+// errRetry is a sentinel error indicating the caller should retry an operation.
+var errRetry = errors.New("retry")
+
+// doPing performs a single Neighbor Solicitation and Advertisement cycle with a
+// short timeout. If the ping does not receive a timely advertisement, it
+// returns errRetry.
+func (p *Pinger) doPing(
+	ctx context.Context,
+	ns *NeighborSolicitation,
+	dst netip.Addr,
+	replyC <-chan reply,
+) (*PingResponse, error) {
+	start := time.Now()
+	if err := p.conn.WriteTo(ctx, icmpMessage(ns), dst); err != nil {
+		return nil, err
+	}
+
+	t := time.NewTimer(p.retryDelay)
+	defer t.Stop()
+
+	for {
+		select {
+		case r := <-replyC:
+			if r.Time.Before(start) {
+				// Received after an earlier attempt timed out but before
+				// this solicitation was sent, so its round-trip time is
+				// unknown. Wait for an answer to this solicitation.
+				continue
+			}
+
+			na := r.Advertisement
+			return &PingResponse{
+				Duration:      r.Time.Sub(start),
+				Addr:          linkLayerAddress(na.Options, Target),
+				Router:        na.Router,
+				Solicited:     na.Solicited,
+				Override:      na.Override,
+				Solicitation:  ns,
+				Advertisement: na,
+				IP:            r.IP,
+			}, nil
+		case <-t.C:
+			return nil, errRetry
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// register allocates a reply channel for a ping of target.
+func (p *Pinger) register(target netip.Addr) chan reply {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	replyC := make(chan reply, 1)
+	p.pings[target] = append(p.pings[target], replyC)
+	return replyC
+}
+
+// unregister removes the reply channel for a ping of target.
+func (p *Pinger) unregister(target netip.Addr, replyC chan reply) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cs := p.pings[target]
+	for i, c := range cs {
+		if c == replyC {
+			cs = append(cs[:i], cs[i+1:]...)
+			break
+		}
+	}
+
+	if len(cs) == 0 {
+		delete(p.pings, target)
+	} else {
+		p.pings[target] = cs
+	}
+}
+
+// readLoop manages the Neighbor Advertisement reading goroutine until ctx is
+// canceled.
+func (p *Pinger) readLoop(ctx context.Context) error {
+	for {
+		msg, ip, err := p.conn.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		now := time.Now()
+		if msg.Code != 0 {
+			continue
+		}
+
+		m, err := ParseMessage(msg)
+		if err != nil {
+			continue
+		}
+		na, ok := m.(*NeighborAdvertisement)
+		if !ok {
+			continue
+		}
+
+		p.mu.Lock()
+		for _, replyC := range p.pings[na.TargetAddress] {
+			// Never block the reader; a caller which already has a reply
+			// pending does not need another.
+			select {
+			case replyC <- reply{Advertisement: na, IP: ip, Time: now}:
+			default:
+			}
+		}
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,60 @@
+package ndp_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/internal/testns"
+	"github.com/mdlayher/icmpx/ndp"
+)
+
+func TestIntegrationPingerPing(t *testing.T) {
+	host, neighbor := testns.Veth(t)
+
+	p, err := ndp.NewPinger(host)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create pinger: %v", err)
+	}
+	defer p.Close()
+
+	target := linkLocal(t, neighbor)
+
+	for _, mode := range []ndp.Mode{ndp.Multicast, ndp.Unicast} {
+		t.Run(mode.String(), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res, err := p.Ping(ctx, target.WithZone(host.Name), mode)
+			if err != nil {
+				t.Fatalf("failed to ping: %v", err)
+			}
+
+			t.Logf("reply from %s: %s, %v", res.IP, res.Addr, res.Duration)
+
+			// The kernel replies with its link-layer address and the solicited
+			// and override flags set, but it is not a router.
+			want := &ndp.PingResponse{
+				Addr:      neighbor.HardwareAddr,
+				Solicited: true,
+				Override:  true,
+				IP:        target.WithZone(host.Name),
+			}
+
+			res.Duration = 0
+			res.Solicitation = nil
+			res.Advertisement = nil
+
+			if diff := cmp.Diff(want, res, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected response (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package ndp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSolicitedNodeMulticast(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   netip.Addr
+		snm  netip.Addr
+		ok   bool
+	}{
+		{
+			name: "link-local",
+			ip:   netip.MustParseAddr("fe80::2aa:ff:fe28:9c5a"),
+			snm:  netip.MustParseAddr("ff02::1:ff28:9c5a"),
+			ok:   true,
+		},
+		{
+			name: "global",
+			ip:   netip.MustParseAddr("2001:db8::1234:5678"),
+			snm:  netip.MustParseAddr("ff02::1:ff34:5678"),
+			ok:   true,
+		},
+		{
+			name: "IPv4",
+			ip:   netip.MustParseAddr("192.0.2.1"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snm, err := SolicitedNodeMulticast(tt.ip)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to compute solicited-node multicast: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+
+			if diff := cmp.Diff(tt.snm, snm, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected address (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPingerPing(t *testing.T) {
+	var (
+		mac    = net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad}
+		router = netip.MustParseAddr("fe80::1")
+		host   = netip.MustParseAddr("2001:db8::2")
+	)
+
+	link := newTestNeighbors(map[netip.Addr]*NeighborAdvertisement{
+		router: {
+			Router:    true,
+			Solicited: true,
+			Override:  true,
+			Options: []Option{&LinkLayerAddress{
+				Direction: Target,
+				Addr:      net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+			}},
+		},
+		host: {Solicited: true},
+	})
+
+	p := newPinger(link, mac)
+	p.retryDelay = 20 * time.Millisecond
+	defer p.Close()
+
+	tests := []struct {
+		name   string
+		target netip.Addr
+		mode   Mode
+		drop   int
+		want   *PingResponse
+	}{
+		{
+			name:   "multicast router",
+			target: router.WithZone("eth0"),
+			mode:   Multicast,
+			want: &PingResponse{
+				Addr:      net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+				Router:    true,
+				Solicited: true,
+				Override:  true,
+				IP:        router,
+			},
+		},
+		{
+			name:   "unicast host retry",
+			target: host,
+			mode:   Unicast,
+			drop:   2,
+			want: &PingResponse{
+				Solicited: true,
+				IP:        host,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link.drop(tt.drop)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res, err := p.Ping(ctx, tt.target, tt.mode)
+			if err != nil {
+				t.Fatalf("failed to ping: %v", err)
+			}
+
+			if res.Duration <= 0 {
+				t.Fatalf("unexpected duration: %v", res.Duration)
+			}
+
+			// The solicitation must carry our link-layer address and the
+			// target address without its zone.
+			wantNS := &NeighborSolicitation{
+				TargetAddress: tt.target.WithZone(""),
+				Options: []Option{&LinkLayerAddress{
+					Direction: Source,
+					Addr:      mac,
+				}},
+			}
+			if diff := cmp.Diff(wantNS, res.Solicitation, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected solicitation (-want +got):\n%s", diff)
+			}
+
+			// Don't compare non-deterministic or raw fields.
+			res.Duration = 0
+			res.Solicitation = nil
+			res.Advertisement = nil
+
+			if diff := cmp.Diff(tt.want, res, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected response (-want +got):\n%s", diff)
+			}
+
+			link.mu.Lock()
+			defer link.mu.Unlock()
+
+			want := tt.target
+			if tt.mode == Multicast {
+				want, _ = SolicitedNodeMulticast(tt.target)
+			}
+			if diff := cmp.Diff(want, link.dst, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected destination (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPingerPingLate(t *testing.T) {
+	target := netip.MustParseAddr("fe80::1")
+	link := newTestNeighbors(map[netip.Addr]*NeighborAdvertisement{
+		target: {Solicited: true},
+	})
+
+	p := newPinger(link, nil)
+	defer p.Close()
+
+	// An advertisement which arrived after an earlier attempt timed out is
+	// already pending when the next solicitation is sent, and must not be
+	// reported as the reply to it.
+	replyC := p.register(target)
+	defer p.unregister(target, replyC)
+
+	replyC <- reply{
+		Advertisement: &NeighborAdvertisement{Router: true, TargetAddress: target},
+		IP:            target,
+		Time:          time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := p.doPing(ctx, &NeighborSolicitation{TargetAddress: target}, target, replyC)
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+
+	if res.Duration <= 0 {
+		t.Fatalf("unexpected duration: %v", res.Duration)
+	}
+	if res.Router {
+		t.Fatal("unexpected reply to an earlier solicitation")
+	}
+}
+
+func TestPingerPingConcurrent(t *testing.T) {
+	target := netip.MustParseAddr("fe80::1")
+	link := newTestNeighbors(map[netip.Addr]*NeighborAdvertisement{
+		target: {Solicited: true},
+	})
+
+	p := newPinger(link, nil)
+	p.retryDelay = 20 * time.Millisecond
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Concurrent pings of the same target must each receive a response.
+	var eg errgroup.Group
+	for i := 0; i < 8; i++ {
+		eg.Go(func() error {
+			_, err := p.Ping(ctx, target, Unicast)
+			return err
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+}
+
+func TestPingerPingErrors(t *testing.T) {
+	p := newPinger(newTestNeighbors(nil), nil)
+	defer p.Close()
+
+	tests := []struct {
+		name   string
+		target netip.Addr
+		mode   Mode
+	}{
+		{
+			name:   "IPv4",
+			target: netip.MustParseAddr("192.0.2.1"),
+		},
+		{
+			name:   "multicast",
+			target: netip.MustParseAddr("ff02::1"),
+		},
+		{
+			name:   "mode",
+			target: netip.MustParseAddr("fe80::1"),
+			mode:   Mode(10),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.Ping(context.Background(), tt.target, tt.mode); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+var _ icmpx.Conn = &testNeighbors{}
+
+// A testNeighbors implements icmpx.Conn by emulating neighbors which reply to
+// Neighbor Solicitations for their own addresses.
+type testNeighbors struct {
+	neighbors map[netip.Addr]*NeighborAdvertisement
+
+	mu      sync.Mutex
+	dst     netip.Addr
+	dropped int
+
+	naC chan message
+}
+
+func newTestNeighbors(neighbors map[netip.Addr]*NeighborAdvertisement) *testNeighbors {
+	return &testNeighbors{
+		neighbors: neighbors,
+		naC:       make(chan message, 16),
+	}
+}
+
+// drop causes the next n solicitations to be dropped.
+func (n *testNeighbors) drop(dropped int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.dropped = dropped
+}
+
+func (*testNeighbors) Close() error { return nil }
+
+func (n *testNeighbors) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-n.naC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (n *testNeighbors) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	// Send the solicitation over the "wire" to exercise marshaling.
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		return err
+	}
+	m, err := ParseMessage(im)
+	if err != nil {
+		return err
+	}
+	ns := m.(*NeighborSolicitation)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.dst = dst
+	if n.dropped > 0 {
+		n.dropped--
+		return nil
+	}
+
+	na, ok := n.neighbors[ns.TargetAddress]
+	if !ok {
+		return nil
+	}
+
+	// Only accept solicitations sent to the target or its solicited-node
+	// multicast address.
+	snm, _ := SolicitedNodeMulticast(ns.TargetAddress)
+	if d := dst.WithZone(""); d != ns.TargetAddress && d != snm {
+		return nil
+	}
+
+	res := *na
+	res.TargetAddress = ns.TargetAddress
+
+	// Non-blocking send in case the channel fills with late replies.
+	select {
+	case n.naC <- message{
+		Message: icmpMessage(&res),
+		IP:      ns.TargetAddress,
+	}:
+	default:
+	}
+
+	return nil
+}
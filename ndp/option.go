@@ -0,0 +1,528 @@
+package ndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Option type codes.
+const (
+	optSourceLLA          = 1
+	optTargetLLA          = 2
+	optPrefixInformation  = 3
+	optMTU                = 5
+	optNonce              = 14
+	optRouteInformation   = 24
+	optRecursiveDNSServer = 25
+	optDNSSearchList      = 31
+)
+
+// An Option is an NDP option.
+type Option interface {
+	// Code returns the option's type code.
+	Code() uint8
+
+	// marshal returns the option's value, excluding the type and length
+	// octets. The complete option must be a multiple of 8 octets long.
+	marshal() ([]byte, error)
+	unmarshal(b []byte) error
+}
+
+var (
+	_ Option = &LinkLayerAddress{}
+	_ Option = &PrefixInformation{}
+	_ Option = new(MTU)
+	_ Option = new(Nonce)
+	_ Option = &RouteInformation{}
+	_ Option = &RecursiveDNSServer{}
+	_ Option = &DNSSearchList{}
+	_ Option = &RawOption{}
+)
+
+var errShortOption = errors.New("ndp: option too short")
+
+// parseOptions parses a sequence of NDP options.
+func parseOptions(b []byte) ([]Option, error) {
+	var opts []Option
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, errShortOption
+		}
+
+		l := int(b[1]) * 8
+		if l == 0 || l > len(b) {
+			return nil, fmt.Errorf("ndp: invalid option length: %d", l)
+		}
+
+		var o Option
+		switch code := b[0]; code {
+		case optSourceLLA:
+			o = &LinkLayerAddress{Direction: Source}
+		case optTargetLLA:
+			o = &LinkLayerAddress{Direction: Target}
+		case optPrefixInformation:
+			o = new(PrefixInformation)
+		case optMTU:
+			o = new(MTU)
+		case optNonce:
+			o = new(Nonce)
+		case optRouteInformation:
+			o = new(RouteInformation)
+		case optRecursiveDNSServer:
+			o = new(RecursiveDNSServer)
+		case optDNSSearchList:
+			o = new(DNSSearchList)
+		default:
+			o = &RawOption{Type: code}
+		}
+
+		if err := o.unmarshal(b[2:l]); err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, o)
+		b = b[l:]
+	}
+
+	return opts, nil
+}
+
+// marshalOptions appends the wire format of opts to b.
+func marshalOptions(b []byte, opts []Option) ([]byte, error) {
+	for _, o := range opts {
+		v, err := o.marshal()
+		if err != nil {
+			return nil, err
+		}
+
+		l := 2 + len(v)
+		if l%8 != 0 || l/8 > 0xff {
+			return nil, fmt.Errorf("ndp: invalid length %d for option type %d", l, o.Code())
+		}
+
+		b = append(b, o.Code(), byte(l/8))
+		b = append(b, v...)
+	}
+
+	return b, nil
+}
+
+// optionsLen returns the length of the wire format of opts, ignoring any
+// options which cannot be marshaled.
+func optionsLen(opts []Option) int {
+	var n int
+	for _, o := range opts {
+		if v, err := o.marshal(); err == nil {
+			n += 2 + len(v)
+		}
+	}
+
+	return n
+}
+
+// pad returns b padded with zeros so that, with the type and length octets,
+// it is a multiple of 8 octets long.
+func pad(b []byte) []byte {
+	return append(b, make([]byte, (8-(len(b)+2)%8)%8)...)
+}
+
+// A Direction specifies the direction of a LinkLayerAddress Option.
+type Direction int
+
+// Possible Direction values.
+const (
+	Source Direction = optSourceLLA
+	Target Direction = optTargetLLA
+)
+
+// A LinkLayerAddress is a Source or Target Link-Layer Address option.
+type LinkLayerAddress struct {
+	Direction Direction
+	Addr      net.HardwareAddr
+}
+
+// Code implements Option.
+func (lla *LinkLayerAddress) Code() uint8 { return uint8(lla.Direction) }
+
+func (lla *LinkLayerAddress) marshal() ([]byte, error) {
+	switch lla.Direction {
+	case Source, Target:
+	default:
+		return nil, fmt.Errorf("ndp: invalid link-layer address direction: %d", lla.Direction)
+	}
+	if len(lla.Addr) == 0 {
+		return nil, errors.New("ndp: link-layer address must not be empty")
+	}
+
+	return pad(append([]byte(nil), lla.Addr...)), nil
+}
+
+func (lla *LinkLayerAddress) unmarshal(b []byte) error {
+	// Ethernet addresses are by far the most common. Other link types may
+	// use a different length, but trailing padding cannot be distinguished
+	// from the address itself.
+	n := len(b)
+	if n == 6 || n > 6 && allZero(b[6:]) {
+		n = 6
+	}
+
+	lla.Addr = append(net.HardwareAddr(nil), b[:n]...)
+	return nil
+}
+
+// linkLayerAddress returns the address of the first LinkLayerAddress option in
+// opts with the specified Direction, or nil if none is present.
+func linkLayerAddress(opts []Option, d Direction) net.HardwareAddr {
+	for _, o := range opts {
+		if lla, ok := o.(*LinkLayerAddress); ok && lla.Direction == d {
+			return lla.Addr
+		}
+	}
+
+	return nil
+}
+
+// Prefix Information flags.
+const (
+	piOnLink     = 1 << 7
+	piAutonomous = 1 << 6
+)
+
+// A PrefixInformation is a Prefix Information option.
+type PrefixInformation struct {
+	Prefix netip.Prefix
+
+	// OnLink indicates that the prefix may be used for on-link
+	// determination, and Autonomous indicates that it may be used for
+	// stateless address autoconfiguration.
+	OnLink     bool
+	Autonomous bool
+
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+// Code implements Option.
+func (*PrefixInformation) Code() uint8 { return optPrefixInformation }
+
+func (pi *PrefixInformation) marshal() ([]byte, error) {
+	if !pi.Prefix.IsValid() || !pi.Prefix.Addr().Is6() {
+		return nil, fmt.Errorf("ndp: invalid IPv6 prefix: %s", pi.Prefix)
+	}
+
+	b := make([]byte, 30)
+	b[0] = byte(pi.Prefix.Bits())
+	if pi.OnLink {
+		b[1] |= piOnLink
+	}
+	if pi.Autonomous {
+		b[1] |= piAutonomous
+	}
+
+	binary.BigEndian.PutUint32(b[2:6], seconds(pi.ValidLifetime))
+	binary.BigEndian.PutUint32(b[6:10], seconds(pi.PreferredLifetime))
+
+	a := pi.Prefix.Masked().Addr().As16()
+	copy(b[14:30], a[:])
+
+	return b, nil
+}
+
+func (pi *PrefixInformation) unmarshal(b []byte) error {
+	if len(b) < 30 {
+		return errShortOption
+	}
+
+	p, err := netip.AddrFrom16([16]byte(b[14:30])).Prefix(int(b[0]))
+	if err != nil {
+		return fmt.Errorf("ndp: invalid prefix information: %v", err)
+	}
+
+	*pi = PrefixInformation{
+		Prefix:            p,
+		OnLink:            b[1]&piOnLink != 0,
+		Autonomous:        b[1]&piAutonomous != 0,
+		ValidLifetime:     lifetime(b[2:6]),
+		PreferredLifetime: lifetime(b[6:10]),
+	}
+
+	return nil
+}
+
+// An MTU is an MTU option.
+type MTU uint32
+
+// Code implements Option.
+func (*MTU) Code() uint8 { return optMTU }
+
+func (m *MTU) marshal() ([]byte, error) {
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint32(b[2:6], uint32(*m))
+	return b, nil
+}
+
+func (m *MTU) unmarshal(b []byte) error {
+	if len(b) < 6 {
+		return errShortOption
+	}
+
+	*m = MTU(binary.BigEndian.Uint32(b[2:6]))
+	return nil
+}
+
+// minNonceLen is the minimum length of a Nonce option value.
+const minNonceLen = 6
+
+// A Nonce is a Nonce option, as described in RFC 3971 and used by Enhanced
+// Duplicate Address Detection in RFC 7527. A Nonce must be at least 6 octets
+// long, and with the type and length octets it must be a multiple of 8 octets
+// long.
+type Nonce []byte
+
+// Code implements Option.
+func (*Nonce) Code() uint8 { return optNonce }
+
+func (n *Nonce) marshal() ([]byte, error) {
+	if len(*n) < minNonceLen {
+		return nil, fmt.Errorf("ndp: nonce too short: %d octets", len(*n))
+	}
+
+	return append([]byte(nil), *n...), nil
+}
+
+func (n *Nonce) unmarshal(b []byte) error {
+	if len(b) < minNonceLen {
+		return errShortOption
+	}
+
+	*n = append(Nonce(nil), b...)
+	return nil
+}
+
+// A RouteInformation is a Route Information option, as described in RFC 4191.
+type RouteInformation struct {
+	Prefix     netip.Prefix
+	Preference Preference
+	Lifetime   time.Duration
+}
+
+// Code implements Option.
+func (*RouteInformation) Code() uint8 { return optRouteInformation }
+
+func (ri *RouteInformation) marshal() ([]byte, error) {
+	if !ri.Prefix.IsValid() || !ri.Prefix.Addr().Is6() {
+		return nil, fmt.Errorf("ndp: invalid IPv6 prefix: %s", ri.Prefix)
+	}
+
+	prf, err := ri.Preference.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 6)
+	b[0] = byte(ri.Prefix.Bits())
+	b[1] = prf << 3
+	binary.BigEndian.PutUint32(b[2:6], seconds(ri.Lifetime))
+
+	// Only as many 8 octet units of the prefix as are needed to contain its
+	// significant bits are sent.
+	a := ri.Prefix.Masked().Addr().As16()
+	return append(b, a[:(ri.Prefix.Bits()+63)/64*8]...), nil
+}
+
+func (ri *RouteInformation) unmarshal(b []byte) error {
+	if len(b) < 6 {
+		return errShortOption
+	}
+
+	var a [16]byte
+	copy(a[:], b[6:])
+
+	bits := int(b[0])
+	if bits > 128 || (bits+63)/64*8 > len(b)-6 {
+		return fmt.Errorf("ndp: invalid route information prefix length: %d", bits)
+	}
+
+	p, err := netip.AddrFrom16(a).Prefix(bits)
+	if err != nil {
+		return err
+	}
+
+	*ri = RouteInformation{
+		Prefix:     p,
+		Preference: parsePreference(b[1] >> 3),
+		Lifetime:   lifetime(b[2:6]),
+	}
+
+	return nil
+}
+
+// A RecursiveDNSServer is a Recursive DNS Server option, as described in RFC
+// 8106.
+type RecursiveDNSServer struct {
+	Lifetime time.Duration
+	Servers  []netip.Addr
+}
+
+// Code implements Option.
+func (*RecursiveDNSServer) Code() uint8 { return optRecursiveDNSServer }
+
+func (r *RecursiveDNSServer) marshal() ([]byte, error) {
+	if len(r.Servers) == 0 {
+		return nil, errors.New("ndp: recursive DNS server option requires at least one server")
+	}
+
+	b := make([]byte, 6, 6+16*len(r.Servers))
+	binary.BigEndian.PutUint32(b[2:6], seconds(r.Lifetime))
+
+	for _, s := range r.Servers {
+		if !s.Is6() {
+			return nil, fmt.Errorf("ndp: invalid IPv6 DNS server: %s", s)
+		}
+
+		a := s.As16()
+		b = append(b, a[:]...)
+	}
+
+	return b, nil
+}
+
+func (r *RecursiveDNSServer) unmarshal(b []byte) error {
+	if len(b) < 6+16 || (len(b)-6)%16 != 0 {
+		return fmt.Errorf("ndp: invalid recursive DNS server option length: %d", len(b)+2)
+	}
+
+	r.Lifetime = lifetime(b[2:6])
+	r.Servers = r.Servers[:0]
+	for b = b[6:]; len(b) > 0; b = b[16:] {
+		r.Servers = append(r.Servers, netip.AddrFrom16([16]byte(b[:16])))
+	}
+
+	return nil
+}
+
+// A DNSSearchList is a DNS Search List option, as described in RFC 8106.
+type DNSSearchList struct {
+	Lifetime    time.Duration
+	DomainNames []string
+}
+
+// Code implements Option.
+func (*DNSSearchList) Code() uint8 { return optDNSSearchList }
+
+func (d *DNSSearchList) marshal() ([]byte, error) {
+	if len(d.DomainNames) == 0 {
+		return nil, errors.New("ndp: DNS search list option requires at least one domain name")
+	}
+
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint32(b[2:6], seconds(d.Lifetime))
+
+	for _, name := range d.DomainNames {
+		start := len(b)
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("ndp: invalid domain name: %q", name)
+			}
+
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+
+		if len(b)-start > 255 {
+			return nil, fmt.Errorf("ndp: domain name too long: %q", name)
+		}
+	}
+
+	return pad(b), nil
+}
+
+func (d *DNSSearchList) unmarshal(b []byte) error {
+	if len(b) < 6 {
+		return errShortOption
+	}
+
+	d.Lifetime = lifetime(b[2:6])
+	d.DomainNames = d.DomainNames[:0]
+
+	// Each name is a sequence of labels terminated by a zero length label.
+	// The option is padded with zeros, which appear as empty names.
+	for b = b[6:]; len(b) > 0 && b[0] != 0; {
+		var labels []string
+		for {
+			if len(b) == 0 {
+				return errors.New("ndp: truncated domain name")
+			}
+
+			l := int(b[0])
+			if l == 0 {
+				b = b[1:]
+				break
+			}
+			if l > 63 || 1+l > len(b) {
+				return fmt.Errorf("ndp: invalid domain name label length: %d", l)
+			}
+
+			labels = append(labels, string(b[1:1+l]))
+			b = b[1+l:]
+		}
+
+		d.DomainNames = append(d.DomainNames, strings.Join(labels, "."))
+	}
+
+	if len(d.DomainNames) == 0 {
+		return errors.New("ndp: DNS search list option contains no domain names")
+	}
+
+	return nil
+}
+
+// A RawOption is an NDP option of an unknown type.
+type RawOption struct {
+	Type  uint8
+	Value []byte
+}
+
+// Code implements Option.
+func (r *RawOption) Code() uint8 { return r.Type }
+
+func (r *RawOption) marshal() ([]byte, error) { return r.Value, nil }
+
+func (r *RawOption) unmarshal(b []byte) error {
+	r.Value = append([]byte(nil), b...)
+	return nil
+}
+
+// seconds converts a lifetime into seconds, saturating at Infinity.
+func seconds(d time.Duration) uint32 {
+	if d >= Infinity {
+		return 0xffffffff
+	}
+	if d < 0 {
+		return 0
+	}
+
+	return uint32(d / time.Second)
+}
+
+// lifetime parses a lifetime in seconds.
+func lifetime(b []byte) time.Duration {
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Second
+}
+
+// allZero reports whether b consists entirely of zeros.
+func allZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,166 @@
+package ndp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// Router discovery constants from RFC 4861, section 10.
+const (
+	maxRtrSolicitations     = 3
+	rtrSolicitationInterval = 4 * time.Second
+)
+
+// hopLimit is the IPv6 Hop Limit used to send and verify NDP messages, which
+// ensures they cannot be forwarded onto the link by a router.
+const hopLimit = 255
+
+// Link-local multicast addresses used by NDP.
+var (
+	allNodes   = netip.MustParseAddr("ff02::1")
+	allRouters = netip.MustParseAddr("ff02::2")
+)
+
+// A Router is a router discovered on a link.
+type Router struct {
+	// IP is the link-local address of the router.
+	IP netip.Addr
+
+	// Advertisement is the most recent Router Advertisement received from
+	// the router.
+	Advertisement *RouterAdvertisement
+}
+
+// DiscoverRouters sends Router Solicitations to the all-routers multicast
+// address on ifi and collects Router Advertisements until ctx is canceled or
+// its deadline expires, at which point the routers discovered so far are
+// returned in the order they were first seen.
+func DiscoverRouters(ctx context.Context, ifi *net.Interface) ([]*Router, error) {
+	c, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter:          icmpx.IPv6AllowOnly(ipv6.ICMPTypeRouterAdvertisement),
+		LinkLocal:       true,
+		ReceiveHopLimit: hopLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	// Routers discard solicitations which may have been forwarded.
+	if err := c.SetHopLimit(hopLimit); err != nil {
+		return nil, err
+	}
+
+	return newDiscoverer(c, ifi.HardwareAddr).Discover(ctx)
+}
+
+// A discoverer performs router discovery on an icmpx.Conn.
+type discoverer struct {
+	conn icmpx.Conn
+	addr net.HardwareAddr
+
+	// Swappable parameters for testing.
+	interval time.Duration
+}
+
+// newDiscoverer creates a discoverer which advertises addr in its Router
+// Solicitations, if set.
+func newDiscoverer(conn icmpx.Conn, addr net.HardwareAddr) *discoverer {
+	return &discoverer{
+		conn:     conn,
+		addr:     addr,
+		interval: rtrSolicitationInterval,
+	}
+}
+
+// Discover performs router discovery until ctx is done.
+func (d *discoverer) Discover(ctx context.Context) ([]*Router, error) {
+	// Only the reader goroutine accesses these until the errgroup completes.
+	var (
+		routers []*Router
+		seen    = make(map[netip.Addr]*Router)
+	)
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error { return d.solicit(ctx) })
+	eg.Go(func() error {
+		for {
+			msg, ip, err := d.conn.ReadFrom(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				return err
+			}
+
+			// Advertisements must originate from a link-local address and
+			// use code 0.
+			if !ip.IsLinkLocalUnicast() || msg.Code != 0 {
+				continue
+			}
+
+			m, err := ParseMessage(msg)
+			if err != nil {
+				continue
+			}
+			ra, ok := m.(*RouterAdvertisement)
+			if !ok {
+				continue
+			}
+
+			if r, ok := seen[ip]; ok {
+				r.Advertisement = ra
+			} else {
+				r := &Router{IP: ip, Advertisement: ra}
+				seen[ip] = r
+				routers = append(routers, r)
+			}
+		}
+	})
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	return routers, nil
+}
+
+// solicit sends up to maxRtrSolicitations Router Solicitations until ctx is
+// done.
+func (d *discoverer) solicit(ctx context.Context) error {
+	rs := &RouterSolicitation{}
+	if len(d.addr) > 0 {
+		rs.Options = []Option{&LinkLayerAddress{
+			Direction: Source,
+			Addr:      d.addr,
+		}}
+	}
+
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+
+	for i := 0; i < maxRtrSolicitations; i++ {
+		if err := d.conn.WriteTo(ctx, icmpMessage(rs), allRouters); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+
+	return nil
+}
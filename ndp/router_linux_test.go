@@ -0,0 +1,212 @@
+package ndp_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/internal/testns"
+	"github.com/mdlayher/icmpx/ndp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMain(m *testing.M) { testns.Main(m) }
+
+func TestIntegrationDiscoverRouters(t *testing.T) {
+	host, router := testns.Veth(t)
+
+	// Forwarding causes the router interface to join the all-routers group so
+	// it can receive solicitations.
+	testns.Sysctl(t, "net/ipv6/conf/"+router.Name+"/forwarding", "1")
+
+	rc, err := icmpx.ListenIPv6(router, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(ipv6.ICMPTypeRouterSolicitation),
+	})
+	if err != nil {
+		t.Fatalf("failed to listen on router: %v", err)
+	}
+	defer rc.Close()
+
+	if err := rc.SetHopLimit(255); err != nil {
+		t.Fatalf("failed to set hop limit: %v", err)
+	}
+
+	mtu := ndp.MTU(1500)
+	ra := &ndp.RouterAdvertisement{
+		CurrentHopLimit: 64,
+		RouterLifetime:  30 * time.Minute,
+		Options: []ndp.Option{
+			&ndp.LinkLayerAddress{
+				Direction: ndp.Source,
+				Addr:      router.HardwareAddr,
+			},
+			&mtu,
+			&ndp.PrefixInformation{
+				Prefix:            netip.MustParsePrefix("2001:db8::/64"),
+				OnLink:            true,
+				Autonomous:        true,
+				ValidLifetime:     1 * time.Hour,
+				PreferredLifetime: 30 * time.Minute,
+			},
+			&ndp.RecursiveDNSServer{
+				Lifetime: 1 * time.Hour,
+				Servers:  []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	// Act as a fake router which replies to each solicitation with a multicast
+	// advertisement.
+	var eg errgroup.Group
+	eg.Go(func() error {
+		for {
+			_, ip, err := rc.ReadFrom(ctx)
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					return nil
+				}
+
+				return err
+			}
+			if !ip.IsLinkLocalUnicast() {
+				continue
+			}
+
+			err = rc.WriteTo(ctx, &icmp.Message{
+				Type: ipv6.ICMPTypeRouterAdvertisement,
+				Body: ra,
+			}, netip.MustParseAddr("ff02::1"))
+			if err != nil {
+				return err
+			}
+		}
+	})
+
+	routers, err := ndp.DiscoverRouters(ctx, host)
+	if err != nil {
+		t.Fatalf("failed to discover routers: %v", err)
+	}
+
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("failed to run router: %v", err)
+	}
+
+	want := []*ndp.Router{{
+		IP:            rc.IP.WithZone(host.Name),
+		Advertisement: ra,
+	}}
+
+	if diff := cmp.Diff(want, routers, cmp.Comparer(ipEqual), cmp.Comparer(prefixEqual)); diff != "" {
+		t.Fatalf("unexpected routers (-want +got):\n%s", diff)
+	}
+}
+
+func TestIntegrationAdvertiser(t *testing.T) {
+	host, router := testns.Veth(t)
+
+	a, err := ndp.NewAdvertiser(router, ndp.AdvertiserConfig{
+		MTU: 1500,
+		Prefixes: []ndp.PrefixInformation{{
+			Prefix:            netip.MustParsePrefix("2001:db8::/64"),
+			OnLink:            true,
+			Autonomous:        true,
+			ValidLifetime:     1 * time.Hour,
+			PreferredLifetime: 30 * time.Minute,
+		}},
+		DNSServers: []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create advertiser: %v", err)
+	}
+	defer a.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var eg errgroup.Group
+	eg.Go(func() error { return a.Serve(ctx) })
+
+	// The advertiser may have sent its initial advertisement before the host
+	// began listening, so allow time for a rate-limited solicited reply.
+	dctx, dcancel := context.WithTimeout(ctx, 4*time.Second)
+	defer dcancel()
+
+	routers, err := ndp.DiscoverRouters(dctx, host)
+	if err != nil {
+		t.Fatalf("failed to discover routers: %v", err)
+	}
+
+	cancel()
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+
+	mtu := ndp.MTU(1500)
+	want := []*ndp.Router{{
+		IP: linkLocal(t, router).WithZone(host.Name),
+		Advertisement: &ndp.RouterAdvertisement{
+			RouterLifetime: 30 * time.Minute,
+			Options: []ndp.Option{
+				&ndp.LinkLayerAddress{
+					Direction: ndp.Source,
+					Addr:      router.HardwareAddr,
+				},
+				&mtu,
+				&ndp.PrefixInformation{
+					Prefix:            netip.MustParsePrefix("2001:db8::/64"),
+					OnLink:            true,
+					Autonomous:        true,
+					ValidLifetime:     1 * time.Hour,
+					PreferredLifetime: 30 * time.Minute,
+				},
+				&ndp.RecursiveDNSServer{
+					Lifetime: 30 * time.Minute,
+					Servers:  []netip.Addr{netip.MustParseAddr("2001:db8::53")},
+				},
+			},
+		},
+	}}
+
+	if diff := cmp.Diff(want, routers, cmp.Comparer(ipEqual), cmp.Comparer(prefixEqual)); diff != "" {
+		t.Fatalf("unexpected routers (-want +got):\n%s", diff)
+	}
+}
+
+// linkLocal returns the IPv6 link-local address of ifi.
+func linkLocal(t *testing.T, ifi *net.Interface) netip.Addr {
+	t.Helper()
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		t.Fatalf("failed to get addresses: %v", err)
+	}
+
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if ip, ok := netip.AddrFromSlice(ipn.IP); ok && ip.Is6() && ip.IsLinkLocalUnicast() {
+			return ip
+		}
+	}
+
+	t.Fatalf("no IPv6 link-local address on %q", ifi.Name)
+	return netip.Addr{}
+}
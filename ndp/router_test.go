@@ -0,0 +1,161 @@
+package ndp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+func TestDiscoverRouters(t *testing.T) {
+	var (
+		r1 = netip.MustParseAddr("fe80::1")
+		r2 = netip.MustParseAddr("fe80::2")
+		// Not link-local, and therefore invalid.
+		r3 = netip.MustParseAddr("2001:db8::3")
+	)
+
+	link := newTestLink(map[netip.Addr]func(n int) *RouterAdvertisement{
+		r1: func(n int) *RouterAdvertisement {
+			// Each solicitation produces a new lifetime, and only the last
+			// should be retained.
+			return &RouterAdvertisement{RouterLifetime: time.Duration(n) * time.Second}
+		},
+		r2: func(_ int) *RouterAdvertisement {
+			return &RouterAdvertisement{RouterPreference: High}
+		},
+		r3: func(_ int) *RouterAdvertisement {
+			return &RouterAdvertisement{}
+		},
+	}, []netip.Addr{r1, r2, r3})
+
+	mac := net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad}
+	d := newDiscoverer(link, mac)
+	d.interval = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	routers, err := d.Discover(ctx)
+	if err != nil {
+		t.Fatalf("failed to discover routers: %v", err)
+	}
+
+	want := []*Router{
+		{
+			IP:            r1,
+			Advertisement: &RouterAdvertisement{RouterLifetime: maxRtrSolicitations * time.Second},
+		},
+		{
+			IP:            r2,
+			Advertisement: &RouterAdvertisement{RouterPreference: High},
+		},
+	}
+
+	if diff := cmp.Diff(want, routers, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected routers (-want +got):\n%s", diff)
+	}
+
+	link.mu.Lock()
+	defer link.mu.Unlock()
+
+	// Each solicitation was sent to all-routers with our link-layer address.
+	if diff := cmp.Diff(maxRtrSolicitations, len(link.solicitations)); diff != "" {
+		t.Fatalf("unexpected number of solicitations (-want +got):\n%s", diff)
+	}
+
+	wantRS := &RouterSolicitation{
+		Options: []Option{&LinkLayerAddress{Direction: Source, Addr: mac}},
+	}
+
+	for _, rs := range link.solicitations {
+		if diff := cmp.Diff(wantRS, rs); diff != "" {
+			t.Fatalf("unexpected solicitation (-want +got):\n%s", diff)
+		}
+	}
+}
+
+var _ icmpx.Conn = &testLink{}
+
+// A testLink implements icmpx.Conn by emulating a link with routers which
+// reply to each Router Solicitation.
+type testLink struct {
+	routers map[netip.Addr]func(n int) *RouterAdvertisement
+	order   []netip.Addr
+
+	mu            sync.Mutex
+	solicitations []*RouterSolicitation
+
+	raC chan message
+}
+
+type message struct {
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+func newTestLink(routers map[netip.Addr]func(n int) *RouterAdvertisement, order []netip.Addr) *testLink {
+	return &testLink{
+		routers: routers,
+		order:   order,
+		raC:     make(chan message, 16),
+	}
+}
+
+func (*testLink) Close() error { return nil }
+
+func (l *testLink) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-l.raC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (l *testLink) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	if dst != allRouters {
+		panic("unexpected destination: " + dst.String())
+	}
+
+	// Send the solicitation over the "wire" to exercise marshaling.
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		return err
+	}
+	m, err := ParseMessage(im)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.solicitations = append(l.solicitations, m.(*RouterSolicitation))
+	n := len(l.solicitations)
+	l.mu.Unlock()
+
+	for _, ip := range l.order {
+		l.raC <- message{
+			Message: &icmp.Message{
+				Type: ipv6.ICMPTypeRouterAdvertisement,
+				Body: l.routers[ip](n),
+			},
+			IP: ip,
+		}
+	}
+
+	return nil
+}
+
+func ipEqual(x, y netip.Addr) bool       { return x == y }
+func prefixEqual(x, y netip.Prefix) bool { return x == y }
@@ -0,0 +1,251 @@
+package nodeinfo
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// A Client sends Node Information Queries, similar to ping6 -N.
+type Client struct {
+	// Manages the underlying socket.
+	conn icmpx.Conn
+
+	// Manages the concurrency of the Client.
+	eg     *errgroup.Group
+	cancel context.CancelFunc
+
+	// Manages dispatching replies to in-flight queries by nonce.
+	mu      sync.Mutex
+	queries map[Nonce]chan reply
+
+	// Swappable parameters for testing.
+	retryDelay time.Duration
+}
+
+// NewClient binds a Client on the specified network interface.
+func NewClient(ifi *net.Interface) (*Client, error) {
+	c, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(ipv6.ICMPTypeNodeInformationResponse),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(c), nil
+}
+
+// A reply contains a Node Information Reply to dispatch to a listener.
+type reply struct {
+	Reply *Reply
+	IP    netip.Addr
+	Time  time.Time
+}
+
+// newClient constructs a Client from a raw icmpx.Conn, starting its background
+// goroutines.
+func newClient(conn icmpx.Conn) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+
+	c := &Client{
+		conn: conn,
+
+		eg:     eg,
+		cancel: cancel,
+
+		queries: make(map[Nonce]chan reply),
+
+		// By default, retransmit a query after 1 second without a reply.
+		retryDelay: 1 * time.Second,
+	}
+
+	eg.Go(func() error { return c.readLoop(ctx) })
+
+	return c
+}
+
+// Close stops the Client's background goroutines and closes its underlying
+// network connection.
+func (c *Client) Close() error {
+	c.cancel()
+	if err := c.eg.Wait(); err != nil {
+		_ = c.conn.Close()
+		return err
+	}
+
+	return c.conn.Close()
+}
+
+// A Response is the result of a Client.Query operation.
+type Response struct {
+	// Duration reports how much time elapsed between sending the final
+	// Query and receiving a Reply.
+	Duration time.Duration
+
+	// Query and Reply are the raw messages sent by the Client and received
+	// from the responder. The Result of the Reply indicates whether the
+	// responder answered the Query.
+	Query *Query
+	Reply *Reply
+
+	// IP is the IPv6 address of the responder.
+	IP netip.Addr
+}
+
+// Query sends q to dst and reports the first matching Reply. If q has no
+// subject, dst is used as the subject, so dst must be a unicast address. If
+// the Nonce of q is zero, a random Nonce is used. Otherwise, it must not be in
+// use by another Query in progress. Query retries until a Reply is received or
+// ctx is canceled.
+func (c *Client) Query(ctx context.Context, dst netip.Addr, q Query) (*Response, error) {
+	if !dst.Is6() || dst.Is4In6() {
+		return nil, fmt.Errorf("nodeinfo: invalid IPv6 destination: %q", dst)
+	}
+
+	if !q.Subject.IsValid() && q.SubjectName == "" && q.QType != NOOP {
+		if dst.IsMulticast() {
+			return nil, fmt.Errorf("nodeinfo: query to multicast destination %s requires a subject", dst)
+		}
+
+		q.Subject = dst.WithZone("")
+	}
+
+	if q.Nonce == (Nonce{}) {
+		if _, err := rand.Read(q.Nonce[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate the query before sending it.
+	if _, err := q.Marshal(0); err != nil {
+		return nil, err
+	}
+
+	replyC, err := c.register(q.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unregister(q.Nonce)
+
+	// It may take more than one attempt for a query to succeed, so send it
+	// at regular intervals until a reply is received.
+	for {
+		switch res, err := c.doQuery(ctx, &q, dst, replyC); {
+		case err == nil:
+			return res, nil
+		case errors.Is(err, errRetry):
+			// Timed out waiting for a reply. Try again.
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
+
+// errRetry is a sentinel error indicating the caller should retry an operation.
+var errRetry = errors.New("retry")
+
+// doQuery performs a single Query and Reply cycle with a short timeout. If the
+// query does not receive a timely reply, it returns errRetry.
+func (c *Client) doQuery(ctx context.Context, q *Query, dst netip.Addr, replyC <-chan reply) (*Response, error) {
+	start := time.Now()
+	if err := c.conn.WriteTo(ctx, icmpMessage(q), dst); err != nil {
+		return nil, err
+	}
+
+	t := time.NewTimer(c.retryDelay)
+	defer t.Stop()
+
+	for {
+		select {
+		case r := <-replyC:
+			if r.Time.Before(start) {
+				// Received after an earlier attempt timed out but before
+				// this query was sent, so its round-trip time is unknown.
+				// Wait for a reply to this query.
+				continue
+			}
+
+			return &Response{
+				Duration: r.Time.Sub(start),
+				Query:    q,
+				Reply:    r.Reply,
+				IP:       r.IP,
+			}, nil
+		case <-t.C:
+			return nil, errRetry
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// register allocates a reply channel for a query with nonce, which must not be
+// in use by another query.
+func (c *Client) register(nonce Nonce) (chan reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.queries[nonce]; ok {
+		return nil, fmt.Errorf("nodeinfo: nonce %x is in use by another query", nonce)
+	}
+
+	replyC := make(chan reply, 1)
+	c.queries[nonce] = replyC
+	return replyC, nil
+}
+
+// unregister removes the reply channel for a query with nonce.
+func (c *Client) unregister(nonce Nonce) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.queries, nonce)
+}
+
+// readLoop manages the Node Information Reply reading goroutine until ctx is
+// canceled.
+func (c *Client) readLoop(ctx context.Context) error {
+	for {
+		msg, ip, err := c.conn.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		now := time.Now()
+
+		m, err := ParseMessage(msg)
+		if err != nil {
+			continue
+		}
+		r, ok := m.(*Reply)
+		if !ok {
+			continue
+		}
+
+		c.mu.Lock()
+		if replyC, ok := c.queries[r.Nonce]; ok {
+			// Never block the reader; a query which already has a reply
+			// pending does not need another.
+			select {
+			case replyC <- reply{Reply: r, IP: ip, Time: now}:
+			default:
+			}
+		}
+		c.mu.Unlock()
+	}
+}
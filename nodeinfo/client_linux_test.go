@@ -0,0 +1,145 @@
+package nodeinfo_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/internal/testns"
+	"github.com/mdlayher/icmpx/nodeinfo"
+)
+
+func TestMain(m *testing.M) { testns.Main(m) }
+
+func TestIntegrationClientQuery(t *testing.T) {
+	host, neighbor := testns.Veth(t)
+
+	r, err := nodeinfo.NewResponder(neighbor, nodeinfo.ResponderConfig{
+		Name: "neighbor.example.",
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create responder: %v", err)
+	}
+	defer r.Close()
+
+	c, err := nodeinfo.NewClient(host)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- r.Serve(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-errC; err != nil {
+			t.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	target := linkLocal(t, neighbor)
+	group, err := nodeinfo.GroupAddress("neighbor")
+	if err != nil {
+		t.Fatalf("failed to compute group address: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		dst   netip.Addr
+		query nodeinfo.Query
+		want  *nodeinfo.Reply
+	}{
+		{
+			name:  "NOOP",
+			dst:   target.WithZone(host.Name),
+			query: nodeinfo.Query{QType: nodeinfo.NOOP},
+			want:  &nodeinfo.Reply{QType: nodeinfo.NOOP},
+		},
+		{
+			name:  "node name",
+			dst:   target.WithZone(host.Name),
+			query: nodeinfo.Query{QType: nodeinfo.NodeName},
+			want: &nodeinfo.Reply{
+				QType: nodeinfo.NodeName,
+				Names: []string{"neighbor.example."},
+			},
+		},
+		{
+			name: "link-local addresses by name",
+			dst:  group.WithZone(host.Name),
+			query: nodeinfo.Query{
+				QType:       nodeinfo.NodeAddresses,
+				Flags:       nodeinfo.LinkLocal,
+				SubjectName: "neighbor",
+			},
+			want: &nodeinfo.Reply{
+				QType: nodeinfo.NodeAddresses,
+				Addresses: []nodeinfo.Address{{
+					IP:  target,
+					TTL: 0x7fffffff * time.Second,
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res, err := c.Query(ctx, tt.dst, tt.query)
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+
+			t.Logf("reply from %s: %v, %v", res.IP, res.Reply.Result, res.Duration)
+
+			if diff := cmp.Diff(target.WithZone(host.Name), res.IP, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected IP (-want +got):\n%s", diff)
+			}
+
+			// Nonces are random.
+			res.Reply.Nonce = nodeinfo.Nonce{}
+
+			if diff := cmp.Diff(tt.want, res.Reply, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected reply (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// linkLocal returns the IPv6 link-local address of ifi.
+func linkLocal(t *testing.T, ifi *net.Interface) netip.Addr {
+	t.Helper()
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		t.Fatalf("failed to get addresses: %v", err)
+	}
+
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if ip, ok := netip.AddrFromSlice(ipn.IP); ok && ip.Is6() && ip.IsLinkLocalUnicast() {
+			return ip
+		}
+	}
+
+	t.Fatalf("no IPv6 link-local address on %q", ifi.Name)
+	return netip.Addr{}
+}
@@ -0,0 +1,366 @@
+package nodeinfo
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestClientQuery(t *testing.T) {
+	var (
+		node  = netip.MustParseAddr("fe80::1")
+		group = mustGroupAddress(t, "host")
+	)
+
+	link := newTestLink(node, testResponder(t))
+
+	c := newClient(link)
+	c.retryDelay = 20 * time.Millisecond
+	defer c.Close()
+
+	tests := []struct {
+		name  string
+		dst   netip.Addr
+		query Query
+		drop  int
+		want  *Reply
+	}{
+		{
+			name:  "NOOP",
+			dst:   node.WithZone("eth0"),
+			query: Query{QType: NOOP},
+			want:  &Reply{QType: NOOP},
+		},
+		{
+			name:  "node name retry",
+			dst:   node,
+			query: Query{QType: NodeName},
+			drop:  2,
+			want: &Reply{
+				QType: NodeName,
+				Names: []string{"host.example.com."},
+			},
+		},
+		{
+			name: "node addresses by name",
+			dst:  group,
+			query: Query{
+				QType:       NodeAddresses,
+				Flags:       Global,
+				SubjectName: "host",
+			},
+			want: &Reply{
+				QType: NodeAddresses,
+				Addresses: []Address{{
+					IP:  netip.MustParseAddr("2001:db8::1"),
+					TTL: maxTTL,
+				}},
+			},
+		},
+		{
+			name: "IPv4 addresses",
+			dst:  node,
+			query: Query{
+				QType: IPv4Addresses,
+				Nonce: Nonce{0xff},
+			},
+			want: &Reply{
+				QType: IPv4Addresses,
+				Nonce: Nonce{0xff},
+				Addresses: []Address{{
+					IP:  netip.MustParseAddr("192.0.2.1"),
+					TTL: maxTTL,
+				}},
+			},
+		},
+		{
+			name:  "unknown qtype",
+			dst:   node,
+			query: Query{QType: 100},
+			want: &Reply{
+				Result: UnknownQType,
+				QType:  100,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link.drop(tt.drop)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res, err := c.Query(ctx, tt.dst, tt.query)
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+
+			if res.Duration <= 0 {
+				t.Fatalf("unexpected duration: %v", res.Duration)
+			}
+			if res.Query.Nonce == (Nonce{}) || res.Query.Nonce != res.Reply.Nonce {
+				t.Fatalf("unexpected nonces: query %x, reply %x", res.Query.Nonce, res.Reply.Nonce)
+			}
+			if diff := cmp.Diff(node, res.IP, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected IP (-want +got):\n%s", diff)
+			}
+
+			// Random nonces are not deterministic.
+			if tt.want.Nonce == (Nonce{}) {
+				res.Reply.Nonce = Nonce{}
+			}
+
+			if diff := cmp.Diff(tt.want, res.Reply, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected reply (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientQueryConcurrent(t *testing.T) {
+	node := netip.MustParseAddr("fe80::1")
+
+	c := newClient(newTestLink(node, testResponder(t)))
+	c.retryDelay = 20 * time.Millisecond
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Concurrent queries must each receive the reply matching their nonce.
+	var eg errgroup.Group
+	for i := 0; i < 8; i++ {
+		qtype := NodeName
+		if i%2 == 0 {
+			qtype = NodeAddresses
+		}
+
+		eg.Go(func() error {
+			res, err := c.Query(ctx, node, Query{QType: qtype, Flags: LinkLocal})
+			if err != nil {
+				return err
+			}
+
+			if res.Reply.QType != qtype {
+				t.Errorf("unexpected reply QType: %v", res.Reply.QType)
+			}
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+}
+
+func TestClientQueryLate(t *testing.T) {
+	ip := netip.MustParseAddr("fe80::1")
+	c := newClient(newTestLink(ip, testResponder(t)))
+	defer c.Close()
+
+	// A reply which arrived after an earlier attempt timed out is already
+	// pending when the next query is sent, and must not be reported as the
+	// reply to it.
+	q := &Query{QType: NOOP, Nonce: Nonce{1}}
+	replyC, err := c.register(q.Nonce)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	defer c.unregister(q.Nonce)
+
+	replyC <- reply{
+		Reply: &Reply{Result: Refused, QType: NOOP, Nonce: q.Nonce},
+		IP:    ip,
+		Time:  time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := c.doQuery(ctx, q, ip, replyC)
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+
+	if res.Duration <= 0 {
+		t.Fatalf("unexpected duration: %v", res.Duration)
+	}
+	if diff := cmp.Diff(Success, res.Reply.Result); diff != "" {
+		t.Fatalf("unexpected result (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientQueryErrors(t *testing.T) {
+	c := newClient(newTestLink(netip.Addr{}, nil))
+	defer c.Close()
+
+	// Simulate a Query in progress.
+	if _, err := c.register(Nonce{1}); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		dst   netip.Addr
+		query Query
+	}{
+		{
+			name:  "IPv4",
+			dst:   netip.MustParseAddr("192.0.2.1"),
+			query: Query{QType: NOOP},
+		},
+		{
+			name:  "multicast without subject",
+			dst:   netip.MustParseAddr("ff02::1"),
+			query: Query{QType: NodeName},
+		},
+		{
+			name: "invalid subject name",
+			dst:  netip.MustParseAddr("fe80::1"),
+			query: Query{
+				QType:       NodeName,
+				SubjectName: "..",
+			},
+		},
+		{
+			name:  "nonce in use",
+			dst:   netip.MustParseAddr("fe80::1"),
+			query: Query{QType: NOOP, Nonce: Nonce{1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Query(context.Background(), tt.dst, tt.query); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+// testResponder creates a Responder for a node named host.example.com. with a
+// fixed set of addresses.
+func testResponder(t *testing.T) *Responder {
+	t.Helper()
+
+	cfg, err := ResponderConfig{
+		Name: "host.example.com.",
+		Addresses: []netip.Addr{
+			netip.MustParseAddr("fe80::1"),
+			netip.MustParseAddr("2001:db8::1"),
+			netip.MustParseAddr("192.0.2.1"),
+		},
+	}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	return newResponder(nil, cfg, func(bool) ([]netip.Addr, error) {
+		return cfg.Addresses, nil
+	})
+}
+
+func mustGroupAddress(t *testing.T, name string) netip.Addr {
+	t.Helper()
+
+	ip, err := GroupAddress(name)
+	if err != nil {
+		t.Fatalf("failed to compute group address: %v", err)
+	}
+
+	return ip
+}
+
+var _ icmpx.Conn = &testLink{}
+
+// A testLink implements icmpx.Conn by emulating a node at ip which answers
+// queries using a Responder.
+type testLink struct {
+	ip netip.Addr
+	r  *Responder
+
+	mu      sync.Mutex
+	dropped int
+
+	replyC chan message
+}
+
+func newTestLink(ip netip.Addr, r *Responder) *testLink {
+	return &testLink{
+		ip:     ip,
+		r:      r,
+		replyC: make(chan message, 16),
+	}
+}
+
+// drop causes the next n queries to be dropped.
+func (l *testLink) drop(dropped int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.dropped = dropped
+}
+
+func (*testLink) Close() error { return nil }
+
+func (l *testLink) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-l.replyC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (l *testLink) WriteTo(_ context.Context, msg *icmp.Message, _ netip.Addr) error {
+	m, err := overTheWire(msg)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.dropped > 0 {
+		l.dropped--
+		return nil
+	}
+
+	reply, err := l.r.reply(m.(*Query))
+	if err != nil || reply == nil {
+		return err
+	}
+
+	// Non-blocking send in case the channel fills with late replies.
+	select {
+	case l.replyC <- message{Message: icmpMessage(reply), IP: l.ip}:
+	default:
+	}
+
+	return nil
+}
+
+// overTheWire marshals and parses msg to exercise the wire format.
+func overTheWire(msg *icmp.Message) (Message, error) {
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseMessage(im)
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,4 @@
+// Package nodeinfo implements ICMPv6 Node Information Queries, as described in
+// RFC 4620, along with a client which sends queries and a responder which
+// answers queries for the local node, built on icmpx.IPv6Conn.
+package nodeinfo
@@ -0,0 +1,505 @@
+package nodeinfo
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// A QType is the type of information requested by a Node Information Query.
+type QType uint16
+
+// Possible QType values, as described in RFC 4620, section 6.
+const (
+	NOOP          QType = 0
+	NodeName      QType = 2
+	NodeAddresses QType = 3
+	IPv4Addresses QType = 4
+)
+
+// String returns the name of a QType.
+func (t QType) String() string {
+	switch t {
+	case NOOP:
+		return "NOOP"
+	case NodeName:
+		return "Node Name"
+	case NodeAddresses:
+		return "Node Addresses"
+	case IPv4Addresses:
+		return "IPv4 Addresses"
+	default:
+		return fmt.Sprintf("QType(%d)", t)
+	}
+}
+
+// Flags are QType-specific flags which modify a Node Information Query or
+// Reply.
+type Flags uint16
+
+// Possible Flags values, as described in RFC 4620, section 6.3 and 6.4.
+const (
+	// Truncated indicates that a Reply's address list is incomplete.
+	Truncated Flags = 1 << iota
+
+	// All requests all of the responder's unicast addresses, rather than
+	// only those of the interface on which the Query was received.
+	All
+
+	// Compatible, LinkLocal, SiteLocal, and Global request IPv6 addresses of
+	// the corresponding kinds for a NodeAddresses Query. Compatible
+	// addresses are IPv4-compatible and IPv4-mapped IPv6 addresses.
+	Compatible
+	LinkLocal
+	SiteLocal
+	Global
+)
+
+// A Result is the outcome of a Node Information Query, indicated by the Code
+// of a Reply.
+type Result int
+
+// Possible Result values.
+const (
+	Success      Result = 0
+	Refused      Result = 1
+	UnknownQType Result = 2
+)
+
+// String returns the name of a Result.
+func (r Result) String() string {
+	switch r {
+	case Success:
+		return "success"
+	case Refused:
+		return "refused"
+	case UnknownQType:
+		return "unknown qtype"
+	default:
+		return fmt.Sprintf("Result(%d)", r)
+	}
+}
+
+// A Nonce is an opaque value which matches a Reply to its Query.
+type Nonce [8]byte
+
+// A Message is a Node Information message body. Each Message implements
+// icmp.MessageBody so it can be sent as the Body of an icmp.Message, but the
+// Code of the icmp.Message must be set to the Code of the Message.
+type Message interface {
+	icmp.MessageBody
+
+	// Type and Code return the ICMPv6 type and code of the Message.
+	Type() ipv6.ICMPType
+	Code() int
+
+	unmarshal(code int, b []byte) error
+}
+
+var (
+	_ Message = &Query{}
+	_ Message = &Reply{}
+)
+
+// hdrLen is the length of the fixed portion of a Node Information message
+// body, after the ICMPv6 checksum.
+const hdrLen = 12
+
+// Query subject codes.
+const (
+	subjectIPv6 = 0
+	subjectName = 1
+	subjectIPv4 = 2
+)
+
+var errShortMessage = errors.New("nodeinfo: message too short")
+
+// ParseMessage parses a Node Information Message from the body of an ICMPv6
+// message produced by icmp.ParseMessage.
+func ParseMessage(m *icmp.Message) (Message, error) {
+	var msg Message
+	switch m.Type {
+	case ipv6.ICMPTypeNodeInformationQuery:
+		msg = new(Query)
+	case ipv6.ICMPTypeNodeInformationResponse:
+		msg = new(Reply)
+	default:
+		return nil, fmt.Errorf("nodeinfo: unexpected ICMPv6 type: %v", m.Type)
+	}
+
+	switch body := m.Body.(type) {
+	case Message:
+		if body.Type() != m.Type {
+			return nil, fmt.Errorf("nodeinfo: message body %T does not match type %v", body, m.Type)
+		}
+
+		return body, nil
+	case *icmp.RawBody:
+		if err := msg.unmarshal(m.Code, body.Data); err != nil {
+			return nil, err
+		}
+
+		return msg, nil
+	default:
+		return nil, fmt.Errorf("nodeinfo: unexpected message body: %T", m.Body)
+	}
+}
+
+// icmpMessage wraps a Node Information Message in an icmp.Message.
+func icmpMessage(m Message) *icmp.Message {
+	return &icmp.Message{
+		Type: m.Type(),
+		Code: m.Code(),
+		Body: m,
+	}
+}
+
+// A Query is a Node Information Query message.
+type Query struct {
+	QType QType
+	Flags Flags
+	Nonce Nonce
+
+	// Subject is the IPv6 or IPv4 address about which information is
+	// requested, unless SubjectName is set.
+	Subject netip.Addr
+
+	// SubjectName is the DNS name about which information is requested. A
+	// name with a trailing dot is fully qualified.
+	SubjectName string
+}
+
+// Type implements Message.
+func (*Query) Type() ipv6.ICMPType { return ipv6.ICMPTypeNodeInformationQuery }
+
+// Code implements Message.
+func (q *Query) Code() int {
+	switch {
+	case q.SubjectName != "" || q.QType == NOOP:
+		// RFC 4620, section 6.1: a NOOP Query has an empty name subject.
+		return subjectName
+	case q.Subject.Is4():
+		return subjectIPv4
+	default:
+		return subjectIPv6
+	}
+}
+
+// Len implements icmp.MessageBody.
+func (q *Query) Len(_ int) int {
+	b, _ := q.Marshal(0)
+	return len(b)
+}
+
+// Marshal implements icmp.MessageBody.
+func (q *Query) Marshal(_ int) ([]byte, error) {
+	b := marshalHeader(uint16(q.QType), q.Flags, q.Nonce)
+
+	switch q.Code() {
+	case subjectName:
+		if q.QType == NOOP && q.SubjectName == "" {
+			return b, nil
+		}
+
+		return appendName(b, q.SubjectName)
+	case subjectIPv4:
+		a := q.Subject.As4()
+		return append(b, a[:]...), nil
+	default:
+		if !q.Subject.Is6() || q.Subject.Is4In6() {
+			return nil, fmt.Errorf("nodeinfo: invalid query subject: %q", q.Subject)
+		}
+
+		a := q.Subject.As16()
+		return append(b, a[:]...), nil
+	}
+}
+
+func (q *Query) unmarshal(code int, b []byte) error {
+	if len(b) < hdrLen {
+		return errShortMessage
+	}
+
+	*q = Query{
+		QType: QType(binary.BigEndian.Uint16(b[0:2])),
+		Flags: Flags(binary.BigEndian.Uint16(b[2:4])),
+		Nonce: Nonce(b[4:12]),
+	}
+
+	data := b[hdrLen:]
+	switch code {
+	case subjectIPv6:
+		if len(data) != 16 {
+			return fmt.Errorf("nodeinfo: invalid IPv6 subject length: %d", len(data))
+		}
+
+		q.Subject = netip.AddrFrom16([16]byte(data))
+	case subjectName:
+		if len(data) == 0 {
+			// Only valid for NOOP, but the caller decides.
+			return nil
+		}
+
+		names, err := parseNames(data)
+		if err != nil {
+			return err
+		}
+		if len(names) != 1 {
+			return fmt.Errorf("nodeinfo: invalid number of subject names: %d", len(names))
+		}
+
+		q.SubjectName = names[0]
+	case subjectIPv4:
+		if len(data) != 4 {
+			return fmt.Errorf("nodeinfo: invalid IPv4 subject length: %d", len(data))
+		}
+
+		q.Subject = netip.AddrFrom4([4]byte(data))
+	default:
+		return fmt.Errorf("nodeinfo: unknown query code: %d", code)
+	}
+
+	return nil
+}
+
+// An Address is an address reported in a Reply, along with its remaining
+// valid lifetime.
+type Address struct {
+	IP  netip.Addr
+	TTL time.Duration
+}
+
+// A Reply is a Node Information Reply message.
+type Reply struct {
+	Result Result
+	QType  QType
+	Flags  Flags
+	Nonce  Nonce
+
+	// Names are the node's DNS names for a NodeName Reply. A name with a
+	// trailing dot is fully qualified.
+	Names []string
+
+	// Addresses are the node's addresses for a NodeAddresses or
+	// IPv4Addresses Reply.
+	Addresses []Address
+}
+
+// Type implements Message.
+func (*Reply) Type() ipv6.ICMPType { return ipv6.ICMPTypeNodeInformationResponse }
+
+// Code implements Message.
+func (r *Reply) Code() int { return int(r.Result) }
+
+// Len implements icmp.MessageBody.
+func (r *Reply) Len(_ int) int {
+	b, _ := r.Marshal(0)
+	return len(b)
+}
+
+// Marshal implements icmp.MessageBody.
+func (r *Reply) Marshal(_ int) ([]byte, error) {
+	b := marshalHeader(uint16(r.QType), r.Flags, r.Nonce)
+	if r.Result != Success {
+		// Refused and unknown QType replies carry no data.
+		return b, nil
+	}
+
+	switch r.QType {
+	case NodeName:
+		// The TTL field is unused and must be zero.
+		b = append(b, 0, 0, 0, 0)
+		for _, name := range r.Names {
+			var err error
+			b, err = appendName(b, name)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case NodeAddresses, IPv4Addresses:
+		for _, a := range r.Addresses {
+			if (r.QType == NodeAddresses) != (a.IP.Is6() && !a.IP.Is4In6()) {
+				return nil, fmt.Errorf("nodeinfo: invalid address for %s reply: %q", r.QType, a.IP)
+			}
+
+			b = binary.BigEndian.AppendUint32(b, ttl(a.TTL))
+			b = append(b, a.IP.AsSlice()...)
+		}
+	}
+
+	return b, nil
+}
+
+func (r *Reply) unmarshal(code int, b []byte) error {
+	if len(b) < hdrLen {
+		return errShortMessage
+	}
+
+	*r = Reply{
+		Result: Result(code),
+		QType:  QType(binary.BigEndian.Uint16(b[0:2])),
+		Flags:  Flags(binary.BigEndian.Uint16(b[2:4])),
+		Nonce:  Nonce(b[4:12]),
+	}
+
+	data := b[hdrLen:]
+	if r.Result != Success {
+		return nil
+	}
+
+	switch r.QType {
+	case NodeName:
+		if len(data) < 4 {
+			return errShortMessage
+		}
+
+		names, err := parseNames(data[4:])
+		if err != nil {
+			return err
+		}
+
+		r.Names = names
+	case NodeAddresses, IPv4Addresses:
+		size := 16
+		if r.QType == IPv4Addresses {
+			size = 4
+		}
+
+		if len(data)%(4+size) != 0 {
+			return fmt.Errorf("nodeinfo: invalid %s data length: %d", r.QType, len(data))
+		}
+
+		for ; len(data) > 0; data = data[4+size:] {
+			ip, _ := netip.AddrFromSlice(data[4 : 4+size])
+			r.Addresses = append(r.Addresses, Address{
+				IP:  ip,
+				TTL: time.Duration(binary.BigEndian.Uint32(data[0:4])) * time.Second,
+			})
+		}
+	}
+
+	return nil
+}
+
+// marshalHeader marshals the fixed portion of a Node Information message.
+func marshalHeader(qtype uint16, flags Flags, nonce Nonce) []byte {
+	b := make([]byte, hdrLen)
+	binary.BigEndian.PutUint16(b[0:2], qtype)
+	binary.BigEndian.PutUint16(b[2:4], uint16(flags))
+	copy(b[4:12], nonce[:])
+
+	return b
+}
+
+// maxTTL is the largest address TTL, as described in RFC 4620, section 6.3.
+const maxTTL = 0x7fffffff * time.Second
+
+// ttl converts an address lifetime into seconds, saturating at maxTTL.
+func ttl(d time.Duration) uint32 {
+	switch {
+	case d >= maxTTL:
+		return 0x7fffffff
+	case d < 0:
+		return 0
+	default:
+		return uint32(d / time.Second)
+	}
+}
+
+// appendName appends the DNS wire format of name to b, as described in RFC
+// 4620, section 3.1. A name which is not fully qualified is terminated by two
+// zero length labels rather than one.
+func appendName(b []byte, name string) ([]byte, error) {
+	fqdn := strings.HasSuffix(name, ".")
+
+	start := len(b)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("nodeinfo: invalid domain name: %q", name)
+		}
+
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0)
+
+	if len(b)-start > 255 {
+		return nil, fmt.Errorf("nodeinfo: domain name too long: %q", name)
+	}
+
+	if !fqdn {
+		b = append(b, 0)
+	}
+
+	return b, nil
+}
+
+// parseNames parses a sequence of names produced by appendName.
+func parseNames(b []byte) ([]string, error) {
+	var names []string
+	for len(b) > 0 {
+		if b[0] == 0 {
+			// The remaining data is padding.
+			break
+		}
+
+		var labels []string
+		for {
+			if len(b) == 0 {
+				return nil, errors.New("nodeinfo: truncated domain name")
+			}
+
+			l := int(b[0])
+			if l == 0 {
+				b = b[1:]
+				break
+			}
+			if l > 63 || 1+l > len(b) {
+				return nil, fmt.Errorf("nodeinfo: invalid domain name label length: %d", l)
+			}
+
+			labels = append(labels, string(b[1:1+l]))
+			b = b[1+l:]
+		}
+
+		name := strings.Join(labels, ".")
+		if len(b) > 0 && b[0] == 0 {
+			// A second zero length label indicates that the name is not
+			// fully qualified.
+			b = b[1:]
+		} else {
+			name += "."
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// GroupAddress returns the Node Information Group Address for name, to which
+// a Query for name may be sent when the node's address is unknown, as
+// described in RFC 4620, section 4.
+func GroupAddress(name string) (netip.Addr, error) {
+	label, _, _ := strings.Cut(name, ".")
+	if len(label) == 0 || len(label) > 63 {
+		return netip.Addr{}, fmt.Errorf("nodeinfo: invalid domain name: %q", name)
+	}
+
+	// The hash covers the first label in DNS canonical wire format, which
+	// includes its length and is lower case.
+	sum := md5.Sum(append([]byte{byte(len(label))}, strings.ToLower(label)...))
+
+	return netip.AddrFrom16([16]byte{
+		0: 0xff, 1: 0x02,
+		11: 0x02, 12: 0xff,
+		13: sum[0], 14: sum[1], 15: sum[2],
+	}), nil
+}
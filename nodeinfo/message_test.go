@@ -0,0 +1,394 @@
+package nodeinfo_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/nodeinfo"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	src = netip.MustParseAddr("fe80::1")
+	dst = netip.MustParseAddr("fe80::2")
+
+	nonce = nodeinfo.Nonce{0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04}
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		m    nodeinfo.Message
+	}{
+		{
+			name: "NOOP query",
+			m:    &nodeinfo.Query{QType: nodeinfo.NOOP, Nonce: nonce},
+		},
+		{
+			name: "IPv6 subject query",
+			m: &nodeinfo.Query{
+				QType:   nodeinfo.NodeAddresses,
+				Flags:   nodeinfo.LinkLocal | nodeinfo.Global,
+				Nonce:   nonce,
+				Subject: dst,
+			},
+		},
+		{
+			name: "IPv4 subject query",
+			m: &nodeinfo.Query{
+				QType:   nodeinfo.IPv4Addresses,
+				Flags:   nodeinfo.All,
+				Nonce:   nonce,
+				Subject: netip.MustParseAddr("192.0.2.1"),
+			},
+		},
+		{
+			name: "FQDN subject query",
+			m: &nodeinfo.Query{
+				QType:       nodeinfo.NodeName,
+				Nonce:       nonce,
+				SubjectName: "host.example.com.",
+			},
+		},
+		{
+			name: "single label subject query",
+			m: &nodeinfo.Query{
+				QType:       nodeinfo.NodeName,
+				Nonce:       nonce,
+				SubjectName: "host",
+			},
+		},
+		{
+			name: "NOOP reply",
+			m:    &nodeinfo.Reply{QType: nodeinfo.NOOP, Nonce: nonce},
+		},
+		{
+			name: "node name reply",
+			m: &nodeinfo.Reply{
+				QType: nodeinfo.NodeName,
+				Nonce: nonce,
+				Names: []string{"host.example.com.", "host"},
+			},
+		},
+		{
+			name: "node addresses reply",
+			m: &nodeinfo.Reply{
+				QType: nodeinfo.NodeAddresses,
+				Flags: nodeinfo.Truncated | nodeinfo.Global,
+				Nonce: nonce,
+				Addresses: []nodeinfo.Address{
+					{IP: netip.MustParseAddr("2001:db8::1"), TTL: time.Hour},
+					{IP: netip.MustParseAddr("fe80::1"), TTL: 0x7fffffff * time.Second},
+				},
+			},
+		},
+		{
+			name: "IPv4 addresses reply",
+			m: &nodeinfo.Reply{
+				QType: nodeinfo.IPv4Addresses,
+				Nonce: nonce,
+				Addresses: []nodeinfo.Address{
+					{IP: netip.MustParseAddr("192.0.2.1"), TTL: 10 * time.Second},
+				},
+			},
+		},
+		{
+			name: "empty addresses reply",
+			m:    &nodeinfo.Reply{QType: nodeinfo.NodeAddresses, Nonce: nonce},
+		},
+		{
+			name: "refused reply",
+			m: &nodeinfo.Reply{
+				Result: nodeinfo.Refused,
+				QType:  nodeinfo.NodeName,
+				Nonce:  nonce,
+			},
+		},
+		{
+			name: "unknown qtype reply",
+			m: &nodeinfo.Reply{
+				Result: nodeinfo.UnknownQType,
+				QType:  100,
+				Nonce:  nonce,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := (&icmp.Message{
+				Type: tt.m.Type(),
+				Code: tt.m.Code(),
+				Body: tt.m,
+			}).Marshal(icmp.IPv6PseudoHeader(src.AsSlice(), dst.AsSlice()))
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			im, err := icmp.ParseMessage(58, b)
+			if err != nil {
+				t.Fatalf("failed to parse ICMPv6 message: %v", err)
+			}
+
+			m, err := nodeinfo.ParseMessage(im)
+			if err != nil {
+				t.Fatalf("failed to parse node information message: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.m, m, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected message (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	// A Node Name Reply with a fully qualified name and a single label name
+	// followed by trailing padding.
+	b := []byte{
+		0x8c, 0x00, 0x00, 0x00,
+		// Node Name, no flags.
+		0x00, 0x02, 0x00, 0x00,
+		// Nonce.
+		0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04,
+		// TTL.
+		0x00, 0x00, 0x00, 0x00,
+		// host.example.
+		0x04, 'h', 'o', 's', 't',
+		0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+		0x00,
+		// host, not fully qualified.
+		0x04, 'h', 'o', 's', 't',
+		0x00, 0x00,
+		// Padding.
+		0x00, 0x00,
+	}
+
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		t.Fatalf("failed to parse ICMPv6 message: %v", err)
+	}
+
+	m, err := nodeinfo.ParseMessage(im)
+	if err != nil {
+		t.Fatalf("failed to parse node information message: %v", err)
+	}
+
+	want := &nodeinfo.Reply{
+		QType: nodeinfo.NodeName,
+		Nonce: nonce,
+		Names: []string{"host.example.", "host"},
+	}
+
+	if diff := cmp.Diff(want, m, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected message (-want +got):\n%s", diff)
+	}
+}
+
+func TestGroupAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		want netip.Addr
+	}{
+		{
+			name: "example",
+			want: netip.MustParseAddr("ff02::2:ff95:2c60"),
+		},
+		{
+			// Only the first label is hashed, without regard to case.
+			name: "Example.com.",
+			want: netip.MustParseAddr("ff02::2:ff95:2c60"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nodeinfo.GroupAddress(tt.name)
+			if err != nil {
+				t.Fatalf("failed to compute group address: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected group address (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := nodeinfo.GroupAddress(".example"); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	// header produces a Node Information message header for qtype.
+	header := func(qtype byte, data ...byte) []byte {
+		return append([]byte{
+			0x00, qtype, 0x00, 0x00,
+			0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04,
+		}, data...)
+	}
+
+	tests := []struct {
+		name string
+		m    *icmp.Message
+	}{
+		{
+			name: "echo",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeEchoRequest,
+				Body: &icmp.Echo{},
+			},
+		},
+		{
+			name: "short query",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationQuery,
+				Body: &icmp.RawBody{Data: make([]byte, 11)},
+			},
+		},
+		{
+			name: "bad IPv6 subject",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationQuery,
+				Code: 0,
+				Body: &icmp.RawBody{Data: header(3, make([]byte, 4)...)},
+			},
+		},
+		{
+			name: "bad IPv4 subject",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationQuery,
+				Code: 2,
+				Body: &icmp.RawBody{Data: header(4, make([]byte, 16)...)},
+			},
+		},
+		{
+			name: "truncated name subject",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationQuery,
+				Code: 1,
+				Body: &icmp.RawBody{Data: header(2, 0x04, 'h', 'o')},
+			},
+		},
+		{
+			name: "unterminated name subject",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationQuery,
+				Code: 1,
+				Body: &icmp.RawBody{Data: header(2, 0x01, 'h')},
+			},
+		},
+		{
+			name: "multiple name subjects",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationQuery,
+				Code: 1,
+				Body: &icmp.RawBody{Data: header(2, 0x01, 'a', 0x00, 0x01, 'b', 0x00)},
+			},
+		},
+		{
+			name: "unknown query code",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationQuery,
+				Code: 3,
+				Body: &icmp.RawBody{Data: header(2)},
+			},
+		},
+		{
+			name: "short node name reply",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationResponse,
+				Body: &icmp.RawBody{Data: header(2, 0x00, 0x00)},
+			},
+		},
+		{
+			name: "bad node addresses reply",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationResponse,
+				Body: &icmp.RawBody{Data: header(3, make([]byte, 16)...)},
+			},
+		},
+		{
+			name: "bad IPv4 addresses reply",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationResponse,
+				Body: &icmp.RawBody{Data: header(4, make([]byte, 6)...)},
+			},
+		},
+		{
+			name: "mismatched body",
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeNodeInformationQuery,
+				Body: &nodeinfo.Reply{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := nodeinfo.ParseMessage(tt.m); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		m    nodeinfo.Message
+	}{
+		{
+			name: "no subject",
+			m:    &nodeinfo.Query{QType: nodeinfo.NodeName},
+		},
+		{
+			name: "IPv4-mapped subject",
+			m: &nodeinfo.Query{
+				QType:   nodeinfo.NodeName,
+				Subject: netip.MustParseAddr("::ffff:192.0.2.1"),
+			},
+		},
+		{
+			name: "empty label",
+			m: &nodeinfo.Query{
+				QType:       nodeinfo.NodeName,
+				SubjectName: "host..example",
+			},
+		},
+		{
+			name: "long label",
+			m: &nodeinfo.Reply{
+				QType: nodeinfo.NodeName,
+				Names: []string{string(make([]byte, 64))},
+			},
+		},
+		{
+			name: "IPv4 node address",
+			m: &nodeinfo.Reply{
+				QType:     nodeinfo.NodeAddresses,
+				Addresses: []nodeinfo.Address{{IP: netip.MustParseAddr("192.0.2.1")}},
+			},
+		},
+		{
+			name: "IPv6 IPv4 address",
+			m: &nodeinfo.Reply{
+				QType:     nodeinfo.IPv4Addresses,
+				Addresses: []nodeinfo.Address{{IP: src}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.m.Marshal(58); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,335 @@
+package nodeinfo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/ipv6"
+)
+
+// A ResponderConfig configures a Responder. The zero value is valid and
+// answers queries from link-local sources using the system's host name and the
+// addresses of the Responder's network interfaces.
+type ResponderConfig struct {
+	// Name is the DNS name of the local node. A name with a trailing dot is
+	// fully qualified. If empty, Name defaults to the system's host name.
+	Name string
+
+	// Addresses, if set, are the IPv6 and IPv4 unicast addresses of the
+	// local node. If nil, the addresses are discovered from the network
+	// interfaces of the system when each query is answered.
+	Addresses []netip.Addr
+
+	// AllowRemote permits answering queries from sources which are not
+	// link-local. By default such queries are refused, as the Responder
+	// would otherwise reveal the local node's name and every address of the
+	// system to any host on the internet. See RFC 4620, section 9.
+	AllowRemote bool
+
+	// Logger, if set, logs any errors which prevent a query from being
+	// answered.
+	Logger *log.Logger
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg ResponderConfig) withDefaults() (ResponderConfig, error) {
+	if cfg.Name == "" {
+		name, err := os.Hostname()
+		if err != nil {
+			return ResponderConfig{}, err
+		}
+
+		cfg.Name = name
+	}
+
+	if _, err := appendName(nil, cfg.Name); err != nil {
+		return ResponderConfig{}, err
+	}
+
+	for _, ip := range cfg.Addresses {
+		if !ip.IsValid() || ip.IsUnspecified() || ip.IsMulticast() {
+			return ResponderConfig{}, fmt.Errorf("nodeinfo: invalid unicast address: %q", ip)
+		}
+	}
+
+	return cfg, nil
+}
+
+// A Responder answers Node Information Queries about the local node, as
+// described in RFC 4620, section 5. Queries whose subject does not name the
+// local node are ignored.
+type Responder struct {
+	c      icmpx.Conn
+	name   string
+	remote bool
+	ll     *log.Logger
+
+	// addrs reports the addresses of the local node, either for the
+	// interface on which the Responder is bound or for all interfaces.
+	addrs func(all bool) ([]netip.Addr, error)
+}
+
+// NewResponder binds a Responder on the specified network interface and joins
+// the Node Information Group Address for the configured name.
+func NewResponder(ifi *net.Interface, cfg ResponderConfig) (*Responder, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := GroupAddress(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(ipv6.ICMPTypeNodeInformationQuery),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.JoinGroup(group); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	addrs := func(all bool) ([]netip.Addr, error) { return interfaceAddrs(ifi, all) }
+	if cfg.Addresses != nil {
+		addrs = func(bool) ([]netip.Addr, error) { return cfg.Addresses, nil }
+	}
+
+	return newResponder(c, cfg, addrs), nil
+}
+
+// newResponder creates a Responder which answers queries on conn.
+func newResponder(conn icmpx.Conn, cfg ResponderConfig, addrs func(all bool) ([]netip.Addr, error)) *Responder {
+	return &Responder{
+		c:      conn,
+		name:   cfg.Name,
+		remote: cfg.AllowRemote,
+		ll:     cfg.Logger,
+		addrs:  addrs,
+	}
+}
+
+// Close closes the Responder's underlying network connection. Close should be
+// called after Serve returns.
+func (r *Responder) Close() error { return r.c.Close() }
+
+// Serve answers Node Information Queries until ctx is canceled. Errors which
+// only affect a single query are logged and the query is dropped; Serve returns
+// an error only if the Responder's connection fails.
+func (r *Responder) Serve(ctx context.Context) error {
+	for {
+		msg, ip, err := r.c.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if !ip.IsValid() || ip.IsUnspecified() || ip.IsMulticast() {
+			continue
+		}
+
+		m, err := ParseMessage(msg)
+		if err != nil {
+			continue
+		}
+		q, ok := m.(*Query)
+		if !ok {
+			continue
+		}
+
+		reply, err := r.reply(q)
+		if err != nil {
+			r.logf("failed to answer query from %s: %v", ip, err)
+			continue
+		}
+		if reply == nil {
+			continue
+		}
+
+		if !r.remote && !ip.IsLinkLocalUnicast() && q.QType != NOOP {
+			reply = &Reply{
+				Result: Refused,
+				QType:  q.QType,
+				Nonce:  q.Nonce,
+			}
+		}
+
+		err = r.c.WriteTo(ctx, icmpMessage(reply), ip)
+		switch {
+		case err == nil, ctx.Err() != nil:
+		case errors.Is(err, net.ErrClosed), errors.Is(err, os.ErrClosed), errors.Is(err, syscall.EBADF):
+			return err
+		default:
+			r.logf("failed to send reply to %s: %v", ip, err)
+		}
+	}
+}
+
+// logf logs a message if the Responder has a Logger.
+func (r *Responder) logf(format string, v ...any) {
+	if r.ll != nil {
+		r.ll.Printf(format, v...)
+	}
+}
+
+// reply produces the Reply to q, or nil if q should not be answered.
+func (r *Responder) reply(q *Query) (*Reply, error) {
+	reply := &Reply{
+		QType: q.QType,
+		Nonce: q.Nonce,
+	}
+
+	if q.QType == NOOP {
+		return reply, nil
+	}
+
+	ok, err := r.isSubject(q)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	switch q.QType {
+	case NodeName:
+		reply.Names = []string{r.name}
+	case NodeAddresses, IPv4Addresses:
+		addrs, err := r.addrs(q.Flags&All != 0)
+		if err != nil {
+			return nil, err
+		}
+
+		reply.Flags = q.Flags & All
+		for _, ip := range addrs {
+			if !wantAddress(q.QType, q.Flags, ip) {
+				continue
+			}
+
+			// The Responder has no knowledge of address lifetimes, so all
+			// addresses are reported with the maximum TTL.
+			reply.Addresses = append(reply.Addresses, Address{IP: ip, TTL: maxTTL})
+		}
+	default:
+		reply.Result = UnknownQType
+	}
+
+	return reply, nil
+}
+
+// isSubject reports whether the subject of q names the local node.
+func (r *Responder) isSubject(q *Query) (bool, error) {
+	if q.SubjectName != "" {
+		return r.isName(q.SubjectName), nil
+	}
+
+	addrs, err := r.addrs(true)
+	if err != nil {
+		return false, err
+	}
+
+	for _, ip := range addrs {
+		if ip.WithZone("") == q.Subject.WithZone("") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isName reports whether name names the local node. A name which is not fully
+// qualified matches the leading labels of the local node's name.
+func (r *Responder) isName(name string) bool {
+	local := strings.TrimSuffix(r.name, ".")
+	if strings.HasSuffix(name, ".") {
+		return strings.EqualFold(strings.TrimSuffix(name, "."), local)
+	}
+
+	if strings.EqualFold(name, local) {
+		return true
+	}
+
+	return len(local) > len(name) && local[len(name)] == '.' &&
+		strings.EqualFold(name, local[:len(name)])
+}
+
+// wantAddress reports whether ip should be reported in the Reply to a query of
+// type qtype with flags, as described in RFC 4620, sections 6.3 and 6.4.
+func wantAddress(qtype QType, flags Flags, ip netip.Addr) bool {
+	if qtype == IPv4Addresses {
+		return ip.Is4()
+	}
+	if ip.Is4() {
+		return false
+	}
+
+	var f Flags
+	switch {
+	case ip.Is4In6() || isCompatible(ip):
+		f = Compatible
+	case ip.IsLinkLocalUnicast():
+		f = LinkLocal
+	case isSiteLocal(ip):
+		f = SiteLocal
+	case ip.IsGlobalUnicast():
+		f = Global
+	}
+
+	return flags&f != 0
+}
+
+// isCompatible reports whether ip is a deprecated IPv4-compatible IPv6 address.
+func isCompatible(ip netip.Addr) bool {
+	return netip.MustParsePrefix("::/96").Contains(ip) && !ip.IsUnspecified() && !ip.IsLoopback()
+}
+
+// isSiteLocal reports whether ip is a deprecated IPv6 site-local address.
+func isSiteLocal(ip netip.Addr) bool {
+	return netip.MustParsePrefix("fec0::/10").Contains(ip)
+}
+
+// interfaceAddrs returns the unicast addresses of ifi, or of all network
+// interfaces if all is true.
+func interfaceAddrs(ifi *net.Interface, all bool) ([]netip.Addr, error) {
+	var (
+		addrs []net.Addr
+		err   error
+	)
+	if all {
+		addrs, err = net.InterfaceAddrs()
+	} else {
+		addrs, err = ifi.Addrs()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []netip.Addr
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip, ok := netip.AddrFromSlice(ipn.IP)
+		if !ok || ip.IsLoopback() || ip.IsMulticast() || ip.IsUnspecified() {
+			continue
+		}
+
+		ips = append(ips, ip.Unmap())
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,406 @@
+package nodeinfo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+)
+
+func TestResponderReply(t *testing.T) {
+	var (
+		ll     = netip.MustParseAddr("fe80::1")
+		sl     = netip.MustParseAddr("fec0::1")
+		global = netip.MustParseAddr("2001:db8::1")
+		mapped = netip.MustParseAddr("::ffff:192.0.2.2")
+		ipv4   = netip.MustParseAddr("192.0.2.1")
+		other  = netip.MustParseAddr("2001:db8::2")
+	)
+
+	r := newResponder(nil, ResponderConfig{Name: "Host.example.com"}, func(all bool) ([]netip.Addr, error) {
+		if all {
+			return []netip.Addr{ll, sl, global, mapped, ipv4, other}, nil
+		}
+
+		return []netip.Addr{ll, sl, global, mapped, ipv4}, nil
+	})
+
+	// addresses produces Addresses with the maximum TTL.
+	addresses := func(ips ...netip.Addr) []Address {
+		var addrs []Address
+		for _, ip := range ips {
+			addrs = append(addrs, Address{IP: ip, TTL: maxTTL})
+		}
+		return addrs
+	}
+
+	tests := []struct {
+		name string
+		q    *Query
+		want *Reply
+	}{
+		{
+			name: "NOOP",
+			q:    &Query{QType: NOOP, Nonce: Nonce{1}},
+			want: &Reply{QType: NOOP, Nonce: Nonce{1}},
+		},
+		{
+			name: "node name",
+			q:    &Query{QType: NodeName, Subject: global},
+			want: &Reply{QType: NodeName, Names: []string{"Host.example.com"}},
+		},
+		{
+			name: "IPv4 subject",
+			q:    &Query{QType: NodeName, Subject: ipv4},
+			want: &Reply{QType: NodeName, Names: []string{"Host.example.com"}},
+		},
+		{
+			name: "FQDN subject",
+			q:    &Query{QType: NodeName, SubjectName: "host.EXAMPLE.com."},
+			want: &Reply{QType: NodeName, Names: []string{"Host.example.com"}},
+		},
+		{
+			name: "single label subject",
+			q:    &Query{QType: NodeName, SubjectName: "host"},
+			want: &Reply{QType: NodeName, Names: []string{"Host.example.com"}},
+		},
+		{
+			name: "other subject",
+			q:    &Query{QType: NodeName, Subject: netip.MustParseAddr("2001:db8::ff")},
+		},
+		{
+			name: "FQDN prefix subject",
+			q:    &Query{QType: NodeName, SubjectName: "host."},
+		},
+		{
+			name: "label prefix subject",
+			q:    &Query{QType: NodeName, SubjectName: "hos"},
+		},
+		{
+			name: "link-local and global addresses",
+			q:    &Query{QType: NodeAddresses, Flags: LinkLocal | Global, Subject: ll},
+			want: &Reply{QType: NodeAddresses, Addresses: addresses(ll, global)},
+		},
+		{
+			name: "site-local and compatible addresses",
+			q:    &Query{QType: NodeAddresses, Flags: SiteLocal | Compatible, Subject: ll},
+			want: &Reply{QType: NodeAddresses, Addresses: addresses(sl, mapped)},
+		},
+		{
+			name: "all global addresses",
+			q:    &Query{QType: NodeAddresses, Flags: All | Global, Subject: ll},
+			want: &Reply{QType: NodeAddresses, Flags: All, Addresses: addresses(global, other)},
+		},
+		{
+			name: "IPv4 addresses",
+			q:    &Query{QType: IPv4Addresses, Subject: ll},
+			want: &Reply{QType: IPv4Addresses, Addresses: addresses(ipv4)},
+		},
+		{
+			name: "unknown qtype",
+			q:    &Query{QType: 100, Flags: 0xffff, Subject: ll},
+			want: &Reply{Result: UnknownQType, QType: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.reply(tt.q)
+			if err != nil {
+				t.Fatalf("failed to reply: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected reply (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResponderServe(t *testing.T) {
+	conn := &testConn{
+		readC:  make(chan message, 16),
+		writeC: make(chan message, 16),
+	}
+	for _, ip := range []netip.Addr{
+		// Only the unicast source is valid.
+		netip.IPv6Unspecified(),
+		netip.MustParseAddr("fe80::2%eth0"),
+	} {
+		conn.readC <- message{
+			Message: icmpMessage(&Query{QType: NOOP, Nonce: Nonce{1}}),
+			IP:      ip,
+		}
+	}
+
+	r := newResponder(conn, ResponderConfig{Name: "host"}, func(bool) ([]netip.Addr, error) { return nil, nil })
+	msg := serveOne(t, r, conn)
+
+	if diff := cmp.Diff(netip.MustParseAddr("fe80::2%eth0"), msg.IP, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected destination (-want +got):\n%s", diff)
+	}
+
+	m, err := ParseMessage(msg.Message)
+	if err != nil {
+		t.Fatalf("failed to parse reply: %v", err)
+	}
+
+	if diff := cmp.Diff(&Reply{QType: NOOP, Nonce: Nonce{1}}, m); diff != "" {
+		t.Fatalf("unexpected reply (-want +got):\n%s", diff)
+	}
+
+	if n := len(conn.writeC); n != 0 {
+		t.Fatalf("unexpected number of additional replies: %d", n)
+	}
+}
+
+func TestResponderServeRemote(t *testing.T) {
+	var (
+		local  = netip.MustParseAddr("fe80::2%eth0")
+		remote = netip.MustParseAddr("2001:db8::2")
+	)
+
+	tests := []struct {
+		name        string
+		ip          netip.Addr
+		allowRemote bool
+		q           *Query
+		want        *Reply
+	}{
+		{
+			name: "link-local",
+			ip:   local,
+			q:    &Query{QType: NodeName, Nonce: Nonce{1}, SubjectName: "host"},
+			want: &Reply{QType: NodeName, Nonce: Nonce{1}, Names: []string{"host"}},
+		},
+		{
+			name: "remote refused",
+			ip:   remote,
+			q:    &Query{QType: NodeAddresses, Flags: All | Global, Nonce: Nonce{2}, SubjectName: "host"},
+			want: &Reply{Result: Refused, QType: NodeAddresses, Nonce: Nonce{2}},
+		},
+		{
+			name: "remote NOOP",
+			ip:   remote,
+			q:    &Query{QType: NOOP, Nonce: Nonce{3}},
+			want: &Reply{QType: NOOP, Nonce: Nonce{3}},
+		},
+		{
+			name:        "remote allowed",
+			ip:          remote,
+			allowRemote: true,
+			q:           &Query{QType: NodeAddresses, Flags: All | Global, Nonce: Nonce{4}, SubjectName: "host"},
+			want: &Reply{
+				QType:     NodeAddresses,
+				Flags:     All,
+				Nonce:     Nonce{4},
+				Addresses: []Address{{IP: netip.MustParseAddr("2001:db8::1"), TTL: maxTTL}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &testConn{
+				readC:  make(chan message, 1),
+				writeC: make(chan message, 1),
+			}
+			conn.readC <- message{Message: icmpMessage(tt.q), IP: tt.ip}
+
+			cfg := ResponderConfig{Name: "host", AllowRemote: tt.allowRemote}
+			r := newResponder(conn, cfg, func(bool) ([]netip.Addr, error) {
+				return []netip.Addr{netip.MustParseAddr("2001:db8::1")}, nil
+			})
+
+			msg := serveOne(t, r, conn)
+
+			if diff := cmp.Diff(tt.ip, msg.IP, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected destination (-want +got):\n%s", diff)
+			}
+
+			m, err := ParseMessage(msg.Message)
+			if err != nil {
+				t.Fatalf("failed to parse reply: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, m, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected reply (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResponderServeErrors(t *testing.T) {
+	var (
+		subject     = netip.MustParseAddr("2001:db8::1")
+		unreachable = netip.MustParseAddr("fe80::3%eth0")
+		ok          = netip.MustParseAddr("fe80::4%eth0")
+	)
+
+	conn := &testConn{
+		readC:  make(chan message, 3),
+		writeC: make(chan message, 3),
+		writeErr: func(dst netip.Addr) error {
+			if dst == unreachable {
+				return syscall.EHOSTUNREACH
+			}
+			return nil
+		},
+	}
+
+	// Neither the failure to look up addresses for the first query nor the
+	// failure to send the second reply stops the Responder from answering
+	// the third query.
+	for _, m := range []message{
+		{Message: icmpMessage(&Query{QType: NodeName, Subject: subject}), IP: netip.MustParseAddr("fe80::2%eth0")},
+		{Message: icmpMessage(&Query{QType: NOOP, Nonce: Nonce{1}}), IP: unreachable},
+		{Message: icmpMessage(&Query{QType: NOOP, Nonce: Nonce{2}}), IP: ok},
+	} {
+		conn.readC <- m
+	}
+
+	var (
+		buf   bytes.Buffer
+		calls int
+	)
+	cfg := ResponderConfig{Name: "host", Logger: log.New(&buf, "", 0)}
+	r := newResponder(conn, cfg, func(bool) ([]netip.Addr, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("no addresses")
+		}
+		return []netip.Addr{subject}, nil
+	})
+
+	msg := serveOne(t, r, conn)
+
+	if diff := cmp.Diff(ok, msg.IP, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected destination (-want +got):\n%s", diff)
+	}
+
+	want := []string{
+		"failed to answer query from fe80::2%eth0: no addresses",
+		"failed to send reply to fe80::3%eth0: no route to host",
+	}
+	if diff := cmp.Diff(want, strings.Split(strings.TrimSpace(buf.String()), "\n")); diff != "" {
+		t.Fatalf("unexpected log output (-want +got):\n%s", diff)
+	}
+
+	// A closed connection does stop the Responder.
+	conn = &testConn{
+		readC:    make(chan message, 1),
+		writeC:   make(chan message, 1),
+		writeErr: func(netip.Addr) error { return net.ErrClosed },
+	}
+	conn.readC <- message{Message: icmpMessage(&Query{QType: NOOP}), IP: ok}
+
+	r = newResponder(conn, cfg, func(bool) ([]netip.Addr, error) { return nil, nil })
+	if err := r.Serve(context.Background()); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, but got: %v", err)
+	}
+}
+
+func TestResponderConfig(t *testing.T) {
+	for _, cfg := range []ResponderConfig{
+		{Name: "host..example"},
+		{Name: "host", Addresses: []netip.Addr{{}}},
+		{Name: "host", Addresses: []netip.Addr{netip.MustParseAddr("ff02::1")}},
+	} {
+		if _, err := cfg.withDefaults(); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+
+	cfg, err := ResponderConfig{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	if cfg.Name == "" {
+		t.Fatal("expected a default name, but none was set")
+	}
+}
+
+// serveOne runs r until it sends a reply on conn, and returns that reply.
+func serveOne(t *testing.T, r *Responder, conn *testConn) message {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- r.Serve(ctx) }()
+
+	var msg message
+	select {
+	case msg = <-conn.writeC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reply")
+	}
+
+	cancel()
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+
+	return msg
+}
+
+var _ icmpx.Conn = &testConn{}
+
+// A testConn implements icmpx.Conn by returning messages sent on readC and
+// capturing messages written to it. If set, writeErr produces an error for
+// messages written to dst instead.
+type testConn struct {
+	readC    chan message
+	writeC   chan message
+	writeErr func(dst netip.Addr) error
+}
+
+// A message is an ICMPv6 message and its source or destination address.
+type message struct {
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+func (*testConn) Close() error { return nil }
+
+func (c *testConn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-c.readC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (c *testConn) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	if c.writeErr != nil {
+		if err := c.writeErr(dst); err != nil {
+			return err
+		}
+	}
+
+	// Send the message over the "wire" to exercise marshaling.
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	im, err := icmp.ParseMessage(58, b)
+	if err != nil {
+		return err
+	}
+
+	c.writeC <- message{Message: im, IP: dst}
+	return nil
+}
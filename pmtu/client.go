@@ -0,0 +1,562 @@
+package pmtu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/quoted"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// Default Config values.
+const (
+	defaultAttempts = 2
+	defaultTimeout  = 2 * time.Second
+
+	maxAttempts = 10
+)
+
+// Packet size limits and header lengths, in bytes.
+const (
+	// The minimum MTU of any IPv4 or IPv6 link.
+	minMTU4 = 68
+	minMTU6 = 1280
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	echoHeaderLen = 8
+)
+
+// A Config configures a Client. The zero value is valid and uses sensible
+// defaults.
+type Config struct {
+	// MaxMTU is the size in bytes of the largest packet probed, including
+	// the IPv4 or IPv6 header. If zero, it defaults to the network interface
+	// MTU, which it must not exceed.
+	MaxMTU int
+
+	// Attempts is the number of echo requests sent for each packet size
+	// before that size is considered lost. If zero, it defaults to 2.
+	Attempts int
+
+	// Timeout is how long to wait for a reply to each echo request. If zero,
+	// it defaults to 2 seconds.
+	Timeout time.Duration
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied, given
+// the network interface MTU.
+func (cfg Config) withDefaults(mtu int) (Config, error) {
+	if cfg.MaxMTU == 0 {
+		cfg.MaxMTU = mtu
+	}
+	if cfg.MaxMTU < minMTU4 || cfg.MaxMTU > mtu {
+		return Config{}, fmt.Errorf("pmtu: maximum MTU must be between %d and the interface MTU %d: %d",
+			minMTU4, mtu, cfg.MaxMTU)
+	}
+
+	if cfg.Attempts == 0 {
+		cfg.Attempts = defaultAttempts
+	}
+	if cfg.Attempts < 1 || cfg.Attempts > maxAttempts {
+		return Config{}, fmt.Errorf("pmtu: attempts must be between 1 and %d: %d", maxAttempts, cfg.Attempts)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Timeout < 0 {
+		return Config{}, fmt.Errorf("pmtu: timeout must be positive: %s", cfg.Timeout)
+	}
+
+	return cfg, nil
+}
+
+// A Client sends ICMPv4/6 echo requests of varying sizes which must not be
+// fragmented to discover the path MTU to destination hosts.
+type Client struct {
+	cfg    Config
+	v4, v6 *connContext
+}
+
+// NewClient binds a Client on the specified network interface.
+func NewClient(ifi *net.Interface, cfg Config) (*Client, error) {
+	cfg, err := cfg.withDefaults(ifi.MTU)
+	if err != nil {
+		return nil, err
+	}
+
+	c4, err := icmpx.ListenIPv4(ifi, icmpx.IPv4Config{
+		Filter: icmpx.IPv4AllowOnly(
+			ipv4.ICMPTypeEchoReply,
+			ipv4.ICMPTypeDestinationUnreachable,
+		),
+		NextHopMTU: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c6, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(
+			ipv6.ICMPTypeEchoReply,
+			ipv6.ICMPTypePacketTooBig,
+			ipv6.ICMPTypeDestinationUnreachable,
+		),
+	})
+	if err != nil {
+		_ = c4.Close()
+		return nil, err
+	}
+
+	if err := c4.SetDontFragment(true); err != nil {
+		_ = c4.Close()
+		_ = c6.Close()
+		return nil, err
+	}
+	if err := c6.SetDontFragment(true); err != nil {
+		_ = c4.Close()
+		_ = c6.Close()
+		return nil, err
+	}
+
+	c, err := newClient(cfg, c4, c6)
+	if err != nil {
+		_ = c4.Close()
+		_ = c6.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newClient constructs a Client from raw icmpx.Conns which send packets without
+// fragmentation. cfg must already have its defaults applied.
+func newClient(cfg Config, c4, c6 icmpx.Conn) (*Client, error) {
+	// Each connContext uses a random echo ID for all of its probes.
+	var ids [4]byte
+	if _, err := rand.Read(ids[:]); err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		cfg: cfg,
+		v4:  newConnContext(ipv4.ICMPTypeEcho, c4, minMTU4, ipv4HeaderLen, int(binary.BigEndian.Uint16(ids[0:2]))),
+		v6:  newConnContext(ipv6.ICMPTypeEchoRequest, c6, minMTU6, ipv6HeaderLen, int(binary.BigEndian.Uint16(ids[2:4]))),
+	}, nil
+}
+
+// Close closes the Client's underlying network connections.
+func (c *Client) Close() error {
+	if err := c.v4.Close(); err != nil {
+		_ = c.v6.Close()
+		return err
+	}
+
+	return c.v6.Close()
+}
+
+// A Result is the result of a Client.Discover operation.
+type Result struct {
+	// MTU is the path MTU in bytes: the size of the largest packet, including
+	// the IPv4 or IPv6 header, which reached the destination.
+	MTU int
+
+	// Method indicates how MTU was learned.
+	Method Method
+
+	// From is the address of the router which reported MTU when Method is
+	// MethodReported.
+	From netip.Addr
+
+	// Blackhole reports whether probes larger than MTU were silently dropped
+	// along the path while smaller probes reached the destination, rather
+	// than eliciting Fragmentation Needed or Packet Too Big messages.
+	Blackhole bool
+
+	// Probes are the results of each echo request sent, in order.
+	Probes []Probe
+}
+
+// A Method indicates how a path MTU was learned.
+type Method int
+
+// Possible Method values.
+const (
+	// MethodLocal indicates that probes as large as Config.MaxMTU reached
+	// the destination, so the path MTU is limited by the local host.
+	MethodLocal Method = iota
+
+	// MethodReported indicates that a router reported the path MTU in an
+	// ICMPv4 Fragmentation Needed or ICMPv6 Packet Too Big message, and a
+	// probe of that size reached the destination.
+	MethodReported
+
+	// MethodProbed indicates that the path MTU was found by searching for the
+	// largest probe which reached the destination, because routers did not
+	// report a usable MTU.
+	MethodProbed
+)
+
+// String returns the name of a Method.
+func (m Method) String() string {
+	switch m {
+	case MethodLocal:
+		return "local"
+	case MethodReported:
+		return "reported"
+	case MethodProbed:
+		return "probed"
+	default:
+		return fmt.Sprintf("Method(%d)", m)
+	}
+}
+
+// A Probe is the result of a single echo request.
+type Probe struct {
+	// Size is the size of the echo request in bytes, including the IPv4 or
+	// IPv6 header.
+	Size int
+
+	// IP is the address of the node which replied, or the zero value if no
+	// reply was received before the timeout.
+	IP netip.Addr
+
+	// RTT is the time elapsed between sending the echo request and receiving
+	// a reply.
+	RTT time.Duration
+
+	// Message is the ICMP message received in reply: an echo reply from the
+	// destination, or an error which quotes the echo request. Message is nil
+	// if no reply was received.
+	Message *icmp.Message
+}
+
+// Timeout reports whether no reply was received for the Probe.
+func (p *Probe) Timeout() bool { return p.Message == nil }
+
+// TooBig returns the MTU reported by a router if the Probe elicited an ICMPv4
+// Fragmentation Needed or ICMPv6 Packet Too Big message.
+func (p *Probe) TooBig() (int, bool) {
+	if p.Message == nil {
+		return 0, false
+	}
+
+	ptb, ok := p.Message.Body.(*icmp.PacketTooBig)
+	if !ok {
+		return 0, false
+	}
+
+	return ptb.MTU, true
+}
+
+// Discover discovers the path MTU to a destination host.
+//
+// A probe as large as Config.MaxMTU is sent first. When a router reports a
+// smaller MTU, a probe of that size is sent next. If probes are silently
+// dropped or routers report no usable MTU, a binary search finds the largest
+// probe which reaches the destination.
+func (c *Client) Discover(ctx context.Context, dst netip.Addr) (*Result, error) {
+	if !dst.IsValid() || dst.IsUnspecified() || dst.IsMulticast() {
+		return nil, fmt.Errorf("pmtu: invalid destination: %q", dst)
+	}
+
+	cc := c.v6
+	if dst.Is4() {
+		cc = c.v4
+	}
+
+	if c.cfg.MaxMTU < cc.minMTU {
+		return nil, fmt.Errorf("pmtu: maximum MTU %d is less than the minimum MTU %d for %s",
+			c.cfg.MaxMTU, cc.minMTU, dst)
+	}
+
+	return cc.discover(ctx, c.cfg, dst)
+}
+
+// A connContext manages the state of an ICMPv4/6 socket for path MTU discovery
+// operations.
+type connContext struct {
+	// Manages the underlying socket and ICMPv4/6 echo request type, along
+	// with the minimum MTU and IP header length for the protocol.
+	conn           icmpx.Conn
+	typ            icmp.Type
+	minMTU, hdrLen int
+
+	// Manages the concurrency of the connContext.
+	eg     *errgroup.Group
+	cancel context.CancelFunc
+
+	// Manages dispatching replies to probes by the ICMPv4/6 echo sequence
+	// number. All probes share a random echo ID.
+	mu      sync.Mutex
+	id, seq int
+	probes  map[int]chan reply
+}
+
+// A reply contains an ICMPv4/6 reply to dispatch to a probe.
+type reply struct {
+	Message *icmp.Message
+	IP      netip.Addr
+	Time    time.Time
+}
+
+// newConnContext creates a connContext for a given ICMPv4/6 type and socket,
+// starting its background goroutines. All probes use the specified echo ID.
+func newConnContext(typ icmp.Type, conn icmpx.Conn, minMTU, hdrLen, id int) *connContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+
+	cc := &connContext{
+		conn:   conn,
+		typ:    typ,
+		minMTU: minMTU,
+		hdrLen: hdrLen,
+
+		eg:     eg,
+		cancel: cancel,
+
+		id:     id,
+		probes: make(map[int]chan reply),
+	}
+
+	eg.Go(func() error { return cc.readLoop(ctx) })
+
+	return cc
+}
+
+// Close stops the connContext's background goroutines and closes the ICMPv4/6
+// socket.
+func (cc *connContext) Close() error {
+	cc.cancel()
+	if err := cc.eg.Wait(); err != nil {
+		_ = cc.conn.Close()
+		return err
+	}
+
+	return cc.conn.Close()
+}
+
+// discover discovers the path MTU to dst.
+func (cc *connContext) discover(ctx context.Context, cfg Config, dst netip.Addr) (*Result, error) {
+	var (
+		res = &Result{}
+
+		// lo is the largest size known to reach dst, and hi is the largest
+		// size not yet known to be too big.
+		lo, hi = 0, cfg.MaxMTU
+
+		// reported is the last usable MTU reported by a router. Once a size
+		// is lost or a router reports no usable MTU, search is set and sizes
+		// are chosen by binary search instead.
+		reported int
+		search   bool
+	)
+
+	for lo < hi {
+		size := hi
+		if search {
+			size = (lo + hi + 1) / 2
+			if lo == 0 {
+				// Verify that dst is reachable at all before searching.
+				size = cc.minMTU
+			}
+		}
+
+		p, err := cc.attempt(ctx, cfg, dst, size, res)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.Timeout() {
+			if lo == 0 && size <= cc.minMTU {
+				return nil, fmt.Errorf("pmtu: no reply from %s to probes of %d bytes", dst, size)
+			}
+
+			hi, search = size-1, true
+			res.Blackhole = true
+			continue
+		}
+
+		if isEchoReply(p.Message.Type) {
+			lo = size
+			continue
+		}
+
+		mtu, ok := p.TooBig()
+		if !ok {
+			return nil, fmt.Errorf("pmtu: %s sent %v (code %d) in reply to a probe of %d bytes",
+				p.IP, p.Message.Type, p.Message.Code, size)
+		}
+
+		if mtu < cc.minMTU || mtu >= size {
+			// The router did not report a usable MTU, as routers which
+			// predate RFC 1191 may do.
+			hi, search = size-1, true
+			continue
+		}
+
+		hi, reported = mtu, mtu
+		res.From = p.IP
+	}
+
+	res.MTU = lo
+	switch {
+	case lo == reported:
+		res.Method = MethodReported
+	case lo == cfg.MaxMTU:
+		res.Method = MethodLocal
+		res.From = netip.Addr{}
+	default:
+		res.Method = MethodProbed
+		res.From = netip.Addr{}
+	}
+
+	return res, nil
+}
+
+// isEchoReply reports whether typ is an ICMPv4/6 echo reply.
+func isEchoReply(typ icmp.Type) bool {
+	return typ == ipv4.ICMPTypeEchoReply || typ == ipv6.ICMPTypeEchoReply
+}
+
+// attempt sends up to cfg.Attempts probes of the specified size to dst until a
+// reply is received, recording each probe in res.
+func (cc *connContext) attempt(ctx context.Context, cfg Config, dst netip.Addr, size int, res *Result) (*Probe, error) {
+	var p *Probe
+	for i := 0; i < cfg.Attempts; i++ {
+		var err error
+		p, err = cc.probe(ctx, dst, size, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Probes = append(res.Probes, *p)
+		if !p.Timeout() {
+			break
+		}
+	}
+
+	return p, nil
+}
+
+// probe sends a single echo request of the specified size to dst and waits up
+// to timeout for a reply.
+func (cc *connContext) probe(ctx context.Context, dst netip.Addr, size int, timeout time.Duration) (*Probe, error) {
+	echo, replyC := cc.register()
+	defer cc.unregister(echo.Seq)
+
+	// Pad the echo request to produce a packet of the specified size.
+	echo.Data = make([]byte, size-cc.hdrLen-echoHeaderLen)
+
+	start := time.Now()
+	if err := cc.conn.WriteTo(ctx, &icmp.Message{Type: cc.typ, Body: echo}, dst); err != nil {
+		return nil, err
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case r := <-replyC:
+		return &Probe{
+			Size:    size,
+			IP:      r.IP,
+			RTT:     r.Time.Sub(start),
+			Message: r.Message,
+		}, nil
+	case <-t.C:
+		return &Probe{Size: size}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// register allocates an echo request with a unique sequence number and a
+// channel which receives its reply.
+func (cc *connContext) register() (*icmp.Echo, chan reply) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	// Skip any sequence numbers which are still in use after wrapping.
+	for {
+		cc.seq = (cc.seq + 1) & 0xffff
+		if _, ok := cc.probes[cc.seq]; !ok {
+			break
+		}
+	}
+
+	replyC := make(chan reply, 1)
+	cc.probes[cc.seq] = replyC
+
+	return &icmp.Echo{ID: cc.id, Seq: cc.seq}, replyC
+}
+
+// unregister removes the reply channel for the probe with sequence number seq.
+func (cc *connContext) unregister(seq int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	delete(cc.probes, seq)
+}
+
+// readLoop manages the ICMPv4/6 reading goroutine until ctx is canceled.
+func (cc *connContext) readLoop(ctx context.Context) error {
+	for {
+		msg, ip, err := cc.conn.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		now := time.Now()
+
+		seq, ok := cc.match(msg)
+		if !ok {
+			continue
+		}
+
+		cc.mu.Lock()
+		if replyC, ok := cc.probes[seq]; ok {
+			// Never block the reader; only the first reply to a probe
+			// matters.
+			select {
+			case replyC <- reply{Message: msg, IP: ip, Time: now}:
+			default:
+			}
+		}
+		cc.mu.Unlock()
+	}
+}
+
+// match returns the sequence number of the probe which elicited msg, by
+// inspecting either an echo reply or the echo request quoted in an ICMP error.
+func (cc *connContext) match(msg *icmp.Message) (int, bool) {
+	if echo, ok := msg.Body.(*icmp.Echo); ok {
+		if !isEchoReply(msg.Type) {
+			return 0, false
+		}
+
+		return echo.Seq, echo.ID == cc.id
+	}
+
+	d, err := quoted.FromMessage(msg)
+	if err != nil || d.Echo == nil || d.Echo.Type != cc.typ {
+		return 0, false
+	}
+
+	return d.Echo.Seq, d.Echo.ID == cc.id
+}
@@ -0,0 +1,81 @@
+package pmtu_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/internal/testns"
+	"github.com/mdlayher/icmpx/pmtu"
+)
+
+func TestMain(m *testing.M) { testns.Main(m) }
+
+func TestIntegrationClientDiscover(t *testing.T) {
+	// The first router forwards packets onto a link with a smaller MTU.
+	path := testns.Routers(t, 2)
+	path.SetMTU(t, 1, 1400)
+
+	c, err := pmtu.NewClient(path.Interface, pmtu.Config{Timeout: time.Second})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		name string
+		dst  netip.Addr
+		want *pmtu.Result
+	}{
+		{
+			name: "IPv4",
+			dst:  path.Target4,
+			want: &pmtu.Result{
+				MTU:    1400,
+				Method: pmtu.MethodReported,
+				From:   path.Routers4[0],
+			},
+		},
+		{
+			name: "IPv6",
+			dst:  path.Target6,
+			want: &pmtu.Result{
+				MTU:    1400,
+				Method: pmtu.MethodReported,
+				From:   path.Routers6[0],
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			res, err := c.Discover(ctx, tt.dst)
+			if err != nil {
+				t.Fatalf("failed to discover: %v", err)
+			}
+
+			for _, p := range res.Probes {
+				mtu, _ := p.TooBig()
+				t.Logf("%d bytes: %s: %v, MTU %d", p.Size, p.IP, p.RTT, mtu)
+			}
+
+			res.Probes = nil
+			if diff := cmp.Diff(tt.want, res, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
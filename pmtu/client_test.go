@@ -0,0 +1,420 @@
+package pmtu
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestClientDiscover(t *testing.T) {
+	var (
+		r1 = netip.MustParseAddr("2001:db8::1")
+		r2 = netip.MustParseAddr("2001:db8::2")
+		d6 = netip.MustParseAddr("2001:db8::ff")
+
+		r3 = netip.MustParseAddr("192.0.2.1")
+		r4 = netip.MustParseAddr("192.0.2.2")
+		d4 = netip.MustParseAddr("192.0.2.255")
+	)
+
+	tests := []struct {
+		name string
+		hops []link
+		dst  netip.Addr
+		want *Result
+	}{
+		{
+			name: "IPv4 local",
+			hops: []link{{IP: r3, MTU: 1500}},
+			dst:  d4,
+			want: &Result{MTU: 1500, Method: MethodLocal},
+		},
+		{
+			name: "IPv4 reported",
+			hops: []link{{IP: r3, MTU: 1500}, {IP: r4, MTU: 576}},
+			dst:  d4,
+			want: &Result{MTU: 576, Method: MethodReported, From: r4},
+		},
+		{
+			name: "IPv4 blackhole",
+			hops: []link{{IP: r3, MTU: 1492, Silent: true}},
+			dst:  d4,
+			want: &Result{MTU: 1492, Method: MethodProbed, Blackhole: true},
+		},
+		{
+			name: "IPv4 no MTU reported",
+			hops: []link{{IP: r3, MTU: 1006, Report: -1}},
+			dst:  d4,
+			want: &Result{MTU: 1006, Method: MethodProbed},
+		},
+		{
+			name: "IPv6 local",
+			hops: []link{{IP: r1, MTU: 1500}, {IP: r2, MTU: 9000}},
+			dst:  d6,
+			want: &Result{MTU: 1500, Method: MethodLocal},
+		},
+		{
+			name: "IPv6 reported twice",
+			hops: []link{{IP: r1, MTU: 1480}, {IP: r2, MTU: 1400}},
+			dst:  d6,
+			want: &Result{MTU: 1400, Method: MethodReported, From: r2},
+		},
+		{
+			name: "IPv6 reported then blackhole",
+			hops: []link{{IP: r1, MTU: 1480}, {IP: r2, MTU: 1400, Silent: true}},
+			dst:  d6,
+			want: &Result{MTU: 1400, Method: MethodProbed, Blackhole: true},
+		},
+		{
+			name: "IPv6 bogus MTU reported",
+			hops: []link{{IP: r1, MTU: 1300, Report: 9000}},
+			dst:  d6,
+			want: &Result{MTU: 1300, Method: MethodProbed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Config{
+				Attempts: 1,
+				Timeout:  20 * time.Millisecond,
+			}.withDefaults(1500)
+			if err != nil {
+				t.Fatalf("failed to apply defaults: %v", err)
+			}
+
+			var (
+				p4 = newTestPath(ipv4.ICMPTypeEchoReply, tt.hops)
+				p6 = newTestPath(ipv6.ICMPTypeEchoReply, tt.hops)
+			)
+
+			c, err := newClient(cfg, p4, p6)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			res, err := c.Discover(ctx, tt.dst)
+			if err != nil {
+				t.Fatalf("failed to discover: %v", err)
+			}
+
+			// The final probe of the discovered size must have been
+			// replied to by the destination.
+			var reached bool
+			for _, p := range res.Probes {
+				if p.Size == res.MTU && p.IP == tt.dst {
+					reached = true
+				}
+			}
+			if !reached {
+				t.Fatalf("no probe of %d bytes reached the destination", res.MTU)
+			}
+
+			res.Probes = nil
+			if diff := cmp.Diff(tt.want, res, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientDiscoverErrors(t *testing.T) {
+	cfg, err := Config{
+		Attempts: 1,
+		Timeout:  20 * time.Millisecond,
+	}.withDefaults(1500)
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  Config
+		hops []link
+		dst  netip.Addr
+	}{
+		{
+			name: "invalid",
+			dst:  netip.Addr{},
+		},
+		{
+			name: "multicast",
+			dst:  netip.MustParseAddr("ff02::1"),
+		},
+		{
+			name: "IPv6 maximum MTU",
+			cfg:  Config{MaxMTU: 1000, Attempts: 1, Timeout: cfg.Timeout},
+			dst:  netip.MustParseAddr("2001:db8::ff"),
+		},
+		{
+			name: "unreachable",
+			hops: []link{{IP: netip.MustParseAddr("192.0.2.1"), Silent: true}},
+			dst:  netip.MustParseAddr("192.0.2.255"),
+		},
+		{
+			name: "host unreachable",
+			hops: []link{{IP: netip.MustParseAddr("192.0.2.1"), Prohibited: true}},
+			dst:  netip.MustParseAddr("192.0.2.255"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ccfg := cfg
+			if tt.cfg.MaxMTU != 0 {
+				ccfg = tt.cfg
+			}
+
+			var (
+				p4 = newTestPath(ipv4.ICMPTypeEchoReply, tt.hops)
+				p6 = newTestPath(ipv6.ICMPTypeEchoReply, tt.hops)
+			)
+
+			c, err := newClient(ccfg, p4, p6)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			defer c.Close()
+
+			if _, err := c.Discover(context.Background(), tt.dst); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{MaxMTU: 67},
+		{MaxMTU: 1501},
+		{Attempts: -1},
+		{Attempts: 11},
+		{Timeout: -1},
+	} {
+		if _, err := cfg.withDefaults(1500); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+
+	cfg, err := Config{}.withDefaults(1500)
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	want := Config{
+		MaxMTU:   1500,
+		Attempts: 2,
+		Timeout:  2 * time.Second,
+	}
+
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatalf("unexpected config (-want +got):\n%s", diff)
+	}
+}
+
+func TestMethodString(t *testing.T) {
+	var got []string
+	for _, m := range []Method{MethodLocal, MethodReported, MethodProbed, 10} {
+		got = append(got, m.String())
+	}
+
+	want := []string{"local", "reported", "probed", "Method(10)"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected strings (-want +got):\n%s", diff)
+	}
+}
+
+// A link is a router and the MTU of the link on which it forwards packets.
+type link struct {
+	IP  netip.Addr
+	MTU int
+
+	// Silent drops packets which are too big without reporting an error,
+	// and Report overrides the MTU reported in errors. A Report of -1
+	// reports zero, as routers which predate RFC 1191 do.
+	Silent bool
+	Report int
+
+	// Prohibited rejects every packet.
+	Prohibited bool
+}
+
+var _ icmpx.Conn = &testPath{}
+
+// A testPath implements icmpx.Conn by emulating a path of routers which drop
+// packets that are too big for their links, followed by a destination which
+// sends echo replies.
+type testPath struct {
+	reply icmp.Type
+	hops  []link
+
+	mu    sync.Mutex
+	readC chan message
+}
+
+// A message is an ICMP message and its source address.
+type message struct {
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+func newTestPath(reply icmp.Type, hops []link) *testPath {
+	return &testPath{
+		reply: reply,
+		hops:  hops,
+		readC: make(chan message, 64),
+	}
+}
+
+func (*testPath) Close() error { return nil }
+
+func (p *testPath) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-p.readC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (p *testPath) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Replies to another program's probes must be ignored.
+	echo := *msg.Body.(*icmp.Echo)
+	echo.ID++
+	p.send(&icmp.Message{Type: p.reply, Body: &echo}, dst)
+
+	size := len(b) + ipv4HeaderLen
+	if dst.Is6() {
+		size = len(b) + ipv6HeaderLen
+	}
+
+	for _, l := range p.hops {
+		if l.Prohibited {
+			p.send(&icmp.Message{
+				Type: dstUnreach(dst),
+				Code: 1,
+				Body: &icmp.DstUnreach{Data: quote(dst, b, size)},
+			}, l.IP)
+			return nil
+		}
+
+		if size <= l.MTU {
+			continue
+		}
+		if l.Silent {
+			return nil
+		}
+
+		mtu := l.MTU
+		switch {
+		case l.Report == -1:
+			mtu = 0
+		case l.Report != 0:
+			mtu = l.Report
+		}
+
+		typ, code := icmp.Type(ipv6.ICMPTypePacketTooBig), 0
+		if dst.Is4() {
+			typ, code = ipv4.ICMPTypeDestinationUnreachable, 4
+		}
+
+		p.send(&icmp.Message{
+			Type: typ,
+			Code: code,
+			Body: &icmp.PacketTooBig{MTU: mtu, Data: quote(dst, b, size)},
+		}, l.IP)
+		return nil
+	}
+
+	p.send(&icmp.Message{Type: p.reply, Body: msg.Body}, dst)
+	return nil
+}
+
+// send sends msg from ip over the "wire" to exercise marshaling.
+func (p *testPath) send(msg *icmp.Message, ip netip.Addr) {
+	proto := 1
+	if ip.Is6() {
+		proto = 58
+	}
+
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		panic(err)
+	}
+	m, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		panic(err)
+	}
+
+	// Preserve the next-hop MTU of Fragmentation Needed messages, as
+	// icmpx.IPv4Conn does when configured to do so.
+	if body, ok := m.Body.(*icmp.DstUnreach); ok && proto == 1 && m.Code == 4 {
+		m.Body = &icmp.PacketTooBig{
+			MTU:  int(binary.BigEndian.Uint16(b[6:8])),
+			Data: body.Data,
+		}
+	}
+
+	p.readC <- message{Message: m, IP: ip}
+}
+
+func dstUnreach(dst netip.Addr) icmp.Type {
+	if dst.Is4() {
+		return ipv4.ICMPTypeDestinationUnreachable
+	}
+
+	return ipv6.ICMPTypeDestinationUnreachable
+}
+
+// quote produces a quoted IPv4 or IPv6 header for a datagram of the specified
+// size carrying the ICMP message b to dst, followed by the first 8 bytes of b.
+func quote(dst netip.Addr, b []byte, size int) []byte {
+	if dst.Is4() {
+		h := make([]byte, 20, 28)
+		h[0] = 0x45
+		binary.BigEndian.PutUint16(h[2:4], uint16(size))
+		h[6] = 0x40
+		h[8] = 64
+		h[9] = 1
+		copy(h[12:16], []byte{192, 0, 2, 100})
+		a := dst.As4()
+		copy(h[16:20], a[:])
+
+		return append(h, b[:8]...)
+	}
+
+	h := make([]byte, 40, 48)
+	h[0] = 0x60
+	binary.BigEndian.PutUint16(h[4:6], uint16(size-40))
+	h[6] = 58
+	h[7] = 64
+	src := netip.MustParseAddr("2001:db8::100").As16()
+	copy(h[8:24], src[:])
+	a := dst.As16()
+	copy(h[24:40], a[:])
+
+	return append(h, b[:8]...)
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,5 @@
+// Package pmtu implements an ICMPv4/6 path MTU discovery client which measures
+// the largest packet that can be sent to a destination host without
+// fragmentation, and detects path MTU blackholes: routers which silently drop
+// packets that are too big rather than reporting their MTU.
+package pmtu
@@ -0,0 +1,390 @@
+package probe
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/extension"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// A Client sends ICMPv4/6 extended echo requests to probe the status of
+// interfaces on remote nodes.
+type Client struct {
+	v4, v6 *connContext
+}
+
+// NewClient binds a Client on the specified network interface.
+func NewClient(ifi *net.Interface) (*Client, error) {
+	c4, err := icmpx.ListenIPv4(ifi, icmpx.IPv4Config{
+		Filter: icmpx.IPv4AllowOnly(ipv4.ICMPTypeExtendedEchoReply),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c6, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(ipv6.ICMPTypeExtendedEchoReply),
+	})
+	if err != nil {
+		_ = c4.Close()
+		return nil, err
+	}
+
+	return newClient(c4, c6), nil
+}
+
+// newClient constructs a Client from raw icmpx.Conns.
+func newClient(c4, c6 icmpx.Conn) *Client {
+	return &Client{
+		v4: newConnContext(ipv4.ICMPTypeExtendedEchoRequest, c4),
+		v6: newConnContext(ipv6.ICMPTypeExtendedEchoRequest, c6),
+	}
+}
+
+// Close closes the Client's underlying network connections.
+func (pc *Client) Close() error {
+	if err := pc.v4.Close(); err != nil {
+		_ = pc.v6.Close()
+		return err
+	}
+
+	return pc.v6.Close()
+}
+
+// A Query identifies the interface to be probed. Exactly one of Name, Index,
+// or Addr must be set.
+type Query struct {
+	Name  string
+	Index int
+	Addr  netip.Addr
+
+	// Neighbor indicates that Addr identifies an interface which belongs to
+	// a neighbor of the probed node, rather than to the probed node itself.
+	// In this case the probed node reports the state of its neighbor cache
+	// entry for Addr. Neighbor may only be set along with Addr.
+	Neighbor bool
+}
+
+// A Response is the result of a Client.Probe operation.
+type Response struct {
+	// Duration reports how much time elapsed during the extended echo
+	// request and response cycle.
+	Duration time.Duration
+
+	// Status is the status of the probed interface.
+	Status Status
+
+	// Request and Reply are the raw ICMP messages sent by the Client and
+	// received from the probed node.
+	Request *icmp.ExtendedEchoRequest
+	Reply   *icmp.ExtendedEchoReply
+
+	// IP is the IPv4/6 address of the probed node.
+	IP netip.Addr
+}
+
+// A Status is the status of a probed interface reported by an extended echo
+// reply.
+type Status struct {
+	// Code reports whether the query succeeded. The remaining fields are
+	// only meaningful when Code is CodeNoError.
+	Code Code
+
+	// State is the neighbor cache state of the probed interface. It is only
+	// set when Query.Neighbor is true.
+	State State
+
+	// Active reports whether the probed interface is active, and IPv4 and
+	// IPv6 report whether it runs each protocol.
+	Active, IPv4, IPv6 bool
+}
+
+// A Code is an extended echo reply code.
+type Code int
+
+// Possible Code values.
+const (
+	CodeNoError Code = iota
+	CodeMalformedQuery
+	CodeNoSuchInterface
+	CodeNoSuchTableEntry
+	CodeMultipleInterfaces
+)
+
+// String returns the name of a Code.
+func (c Code) String() string {
+	switch c {
+	case CodeNoError:
+		return "no error"
+	case CodeMalformedQuery:
+		return "malformed query"
+	case CodeNoSuchInterface:
+		return "no such interface"
+	case CodeNoSuchTableEntry:
+		return "no such table entry"
+	case CodeMultipleInterfaces:
+		return "multiple interfaces satisfy query"
+	default:
+		return fmt.Sprintf("Code(%d)", c)
+	}
+}
+
+// A State is the neighbor cache state of a probed neighbor interface.
+type State int
+
+// Possible State values.
+const (
+	StateReserved State = iota
+	StateIncomplete
+	StateReachable
+	StateStale
+	StateDelay
+	StateProbe
+	StateFailed
+)
+
+// String returns the name of a State.
+func (s State) String() string {
+	switch s {
+	case StateReserved:
+		return "reserved"
+	case StateIncomplete:
+		return "incomplete"
+	case StateReachable:
+		return "reachable"
+	case StateStale:
+		return "stale"
+	case StateDelay:
+		return "delay"
+	case StateProbe:
+		return "probe"
+	case StateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("State(%d)", s)
+	}
+}
+
+// Probe sends an ICMPv4/6 extended echo request to a target host to query the
+// status of the interface identified by q. Probe retries until a reply is
+// received or ctx is canceled. A reply which reports an error Code is not
+// treated as an error by Probe, and must be checked using the Response.
+func (pc *Client) Probe(ctx context.Context, dst netip.Addr, q Query) (*Response, error) {
+	if dst.Is4() {
+		return pc.v4.Probe(ctx, dst, q)
+	}
+
+	return pc.v6.Probe(ctx, dst, q)
+}
+
+// extensions produces the interface identification extension for q.
+func (q Query) extensions() ([]icmp.Extension, error) {
+	if q.Neighbor && !q.Addr.IsValid() {
+		return nil, errors.New("probe: neighbor queries must identify an interface by address")
+	}
+
+	return extension.Extensions([]extension.Object{&extension.InterfaceIdentification{
+		Name:  q.Name,
+		Index: q.Index,
+		Addr:  q.Addr,
+	}})
+}
+
+// A connContext manages the state of an ICMPv4/6 socket for probe operations.
+type connContext struct {
+	// Manages the underlying socket and ICMPv4/6 extended echo request type.
+	conn icmpx.Conn
+	typ  icmp.Type
+
+	// Manages the concurrency of the connContext.
+	eg     *errgroup.Group
+	cancel context.CancelFunc
+
+	// Manages dispatching replies to in-flight probes by identifier.
+	mu     sync.Mutex
+	probes map[int]chan reply
+
+	// Swappable parameters for testing.
+	retryDelay time.Duration
+}
+
+// A reply contains an extended echo reply to dispatch to a listener.
+type reply struct {
+	Code  Code
+	Reply *icmp.ExtendedEchoReply
+	IP    netip.Addr
+}
+
+// newConnContext creates a connContext for a given ICMPv4/6 type and socket,
+// starting its background goroutines.
+func newConnContext(typ icmp.Type, conn icmpx.Conn) *connContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+
+	cc := &connContext{
+		conn: conn,
+		typ:  typ,
+
+		eg:     eg,
+		cancel: cancel,
+
+		probes: make(map[int]chan reply),
+
+		// By default, we try sending another request after 1 second has
+		// elapsed without a reply to a prior attempt.
+		retryDelay: 1 * time.Second,
+	}
+
+	eg.Go(func() error { return cc.readLoop(ctx) })
+
+	return cc
+}
+
+// Close stops the connContext's background goroutines and closes the ICMPv4/6
+// socket.
+func (cc *connContext) Close() error {
+	cc.cancel()
+	if err := cc.eg.Wait(); err != nil {
+		_ = cc.conn.Close()
+		return err
+	}
+
+	return cc.conn.Close()
+}
+
+// Probe performs a single probe operation.
+func (cc *connContext) Probe(ctx context.Context, dst netip.Addr, q Query) (*Response, error) {
+	exts, err := q.extensions()
+	if err != nil {
+		return nil, err
+	}
+
+	id, replyC, err := cc.register()
+	if err != nil {
+		return nil, err
+	}
+	defer cc.unregister(id)
+
+	start := time.Now()
+
+	// It may take more than one attempt for a request to succeed, so send
+	// them at regular intervals until a reply is received. The sequence
+	// number is only 8 bits and wraps.
+	for seq := 0; ; seq = (seq + 1) & 0xff {
+		req := &icmp.ExtendedEchoRequest{
+			ID:         id,
+			Seq:        seq,
+			Local:      !q.Neighbor,
+			Extensions: exts,
+		}
+
+		msg := &icmp.Message{
+			Type: cc.typ,
+			Body: req,
+		}
+
+		if err := cc.conn.WriteTo(ctx, msg, dst); err != nil {
+			return nil, err
+		}
+
+		t := time.NewTimer(cc.retryDelay)
+		select {
+		case r := <-replyC:
+			t.Stop()
+			return &Response{
+				Duration: time.Since(start),
+				Status: Status{
+					Code:   r.Code,
+					State:  State(r.Reply.State),
+					Active: r.Reply.Active,
+					IPv4:   r.Reply.IPv4,
+					IPv6:   r.Reply.IPv6,
+				},
+				Request: req,
+				Reply:   r.Reply,
+				IP:      r.IP,
+			}, nil
+		case <-t.C:
+			// Timed out waiting for a reply. Try again.
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// register allocates a unique identifier and reply channel for a probe.
+func (cc *connContext) register() (int, <-chan reply, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	b := make([]byte, 2)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 0, nil, err
+		}
+
+		id := int(binary.BigEndian.Uint16(b))
+		if _, ok := cc.probes[id]; ok {
+			continue
+		}
+
+		replyC := make(chan reply, 1)
+		cc.probes[id] = replyC
+		return id, replyC, nil
+	}
+}
+
+// unregister removes the reply channel for a probe identifier.
+func (cc *connContext) unregister(id int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	delete(cc.probes, id)
+}
+
+// readLoop manages the ICMPv4/6 extended echo reading goroutine until ctx is
+// canceled.
+func (cc *connContext) readLoop(ctx context.Context) error {
+	for {
+		msg, ip, err := cc.conn.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		rep, ok := msg.Body.(*icmp.ExtendedEchoReply)
+		if !ok {
+			continue
+		}
+
+		cc.mu.Lock()
+		replyC, ok := cc.probes[rep.ID]
+		cc.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		// Never block the reader; a caller which already has a reply
+		// pending does not need another.
+		select {
+		case replyC <- reply{Code: Code(msg.Code), Reply: rep, IP: ip}:
+		default:
+		}
+	}
+}
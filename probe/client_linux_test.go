@@ -0,0 +1,89 @@
+package probe_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/probe"
+	"golang.org/x/net/nettest"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestIntegrationClient(t *testing.T) {
+	t.Parallel()
+
+	// Linux only replies to extended echo requests when explicitly enabled.
+	b, err := os.ReadFile("/proc/sys/net/ipv4/icmp_echo_enable_probe")
+	if err != nil || strings.TrimSpace(string(b)) != "1" {
+		t.Skip("skipping, net.ipv4.icmp_echo_enable_probe is not enabled")
+	}
+
+	lo, err := nettest.LoopbackInterface()
+	if err != nil {
+		t.Fatalf("failed to find loopback: %v", err)
+	}
+
+	c, err := probe.NewClient(lo)
+	if err != nil {
+		// ICMP sockets require elevated privileges.
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	for _, ip := range []netip.Addr{
+		netip.MustParseAddr("127.0.0.1"),
+		netip.IPv6Loopback(),
+	} {
+		for _, tt := range []struct {
+			q probe.Query
+			s probe.Status
+		}{
+			{
+				q: probe.Query{Name: lo.Name},
+				s: probe.Status{Active: true, IPv4: true, IPv6: true},
+			},
+			{
+				q: probe.Query{Index: lo.Index},
+				s: probe.Status{Active: true, IPv4: true, IPv6: true},
+			},
+			{
+				q: probe.Query{Name: "notfound0"},
+				s: probe.Status{Code: probe.CodeNoSuchInterface},
+			},
+		} {
+			ip, tt := ip, tt
+			eg.Go(func() error {
+				res, err := c.Probe(ctx, ip, tt.q)
+				if err != nil {
+					return fmt.Errorf("probe %s: %v", ip, err)
+				}
+
+				if diff := cmp.Diff(tt.s, res.Status); diff != "" {
+					return fmt.Errorf("unexpected status for %s %+v (-want +got):\n%s", ip, tt.q, diff)
+				}
+
+				return nil
+			})
+		}
+	}
+
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+}
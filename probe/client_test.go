@@ -0,0 +1,333 @@
+package probe
+
+import (
+	"context"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/extension"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestClientProbe(t *testing.T) {
+	c := testClient(t)
+
+	tests := []struct {
+		name string
+		dst  netip.Addr
+		q    Query
+		s    Status
+	}{
+		{
+			name: "IPv4 name",
+			dst:  c.Host4.IP,
+			q:    Query{Name: "eth0"},
+			s:    Status{Active: true, IPv4: true, IPv6: true},
+		},
+		{
+			name: "IPv6 index",
+			dst:  c.Host6.IP,
+			q:    Query{Index: 2},
+			s:    Status{Active: true, IPv4: true, IPv6: true},
+		},
+		{
+			name: "IPv6 address",
+			dst:  c.Host6.IP,
+			q:    Query{Addr: c.Host6.IP},
+			s:    Status{Active: true, IPv4: true, IPv6: true},
+		},
+		{
+			name: "IPv4 neighbor",
+			dst:  c.Host4.IP,
+			q: Query{
+				Addr:     netip.MustParseAddr("192.0.2.2"),
+				Neighbor: true,
+			},
+			s: Status{State: StateReachable, Active: true, IPv4: true},
+		},
+		{
+			name: "IPv4 no such interface",
+			dst:  c.Host4.IP,
+			q:    Query{Name: "eth1"},
+			s:    Status{Code: CodeNoSuchInterface},
+		},
+		{
+			name: "IPv6 no such table entry",
+			dst:  c.Host6.IP,
+			q: Query{
+				Addr:     netip.MustParseAddr("2001:db8::2"),
+				Neighbor: true,
+			},
+			s: Status{Code: CodeNoSuchTableEntry},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := c.Client.Probe(context.Background(), tt.dst, tt.q)
+			if err != nil {
+				t.Fatalf("failed to probe: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.s, res.Status); diff != "" {
+				t.Fatalf("unexpected status (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.dst, res.IP, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected IP (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(!tt.q.Neighbor, res.Request.Local); diff != "" {
+				t.Fatalf("unexpected local bit (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientProbeRetry(t *testing.T) {
+	// Emulate a host that drops the first request, forcing the Client to
+	// retry with the next sequence number.
+	c := testClient(t)
+
+	var recv atomic.Bool
+	c.Host6.Drop = func(_ *icmp.ExtendedEchoRequest) bool {
+		return !recv.Swap(true)
+	}
+
+	res, err := c.Client.Probe(context.Background(), c.Host6.IP, Query{Name: "eth0"})
+	if err != nil {
+		t.Fatalf("failed to probe: %v", err)
+	}
+
+	if diff := cmp.Diff(1, res.Request.Seq); diff != "" {
+		t.Fatalf("unexpected request sequence (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(res.Request.Seq, res.Reply.Seq); diff != "" {
+		t.Fatalf("unexpected reply sequence (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(res.Request.ID, res.Reply.ID); diff != "" {
+		t.Fatalf("unexpected reply ID (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientProbeInvalidQuery(t *testing.T) {
+	c := testClient(t)
+
+	tests := []struct {
+		name string
+		q    Query
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "multiple",
+			q:    Query{Name: "eth0", Index: 2},
+		},
+		{
+			name: "neighbor name",
+			q:    Query{Name: "eth0", Neighbor: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Client.Probe(context.Background(), c.Host4.IP, tt.q); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+var _ icmpx.Conn = &testHost{}
+
+// A testHost implements icmpx.Conn by emulating an RFC 8335 node with a
+// single interface and a single neighbor.
+type testHost struct {
+	IP       netip.Addr
+	Neighbor netip.Addr
+	Drop     func(req *icmp.ExtendedEchoRequest) bool
+
+	reqC, resC chan message
+}
+
+type client struct {
+	Client       *Client
+	Host4, Host6 *testHost
+}
+
+// testClient sets up a Client that talks to emulated hosts.
+func testClient(t *testing.T) *client {
+	var (
+		host4 = newTestHost(t, netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"))
+		host6 = newTestHost(t, netip.MustParseAddr("2001:db8::1"), netip.Addr{})
+	)
+
+	c := newClient(host4, host6)
+
+	// Speed up retries for tests.
+	c.v4.retryDelay = 100 * time.Millisecond
+	c.v6.retryDelay = 100 * time.Millisecond
+
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Fatalf("failed to clean up client: %v", err)
+		}
+	})
+
+	return &client{
+		Client: c,
+
+		Host4: host4,
+		Host6: host6,
+	}
+}
+
+func newTestHost(t *testing.T, ip, neighbor netip.Addr) *testHost {
+	t.Helper()
+
+	h := &testHost{
+		IP:       ip,
+		Neighbor: neighbor,
+		reqC:     make(chan message, 1),
+		resC:     make(chan message, 1),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error { return h.run(ctx) })
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := eg.Wait(); err != nil {
+			t.Fatalf("failed to stop testHost: %v", err)
+		}
+	})
+
+	return h
+}
+
+type message struct {
+	Message *icmp.Message
+	Host    netip.Addr
+}
+
+func (h *testHost) run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case req := <-h.reqC:
+			var (
+				typ   icmp.Type
+				proto int
+			)
+			switch req.Message.Type {
+			case ipv4.ICMPTypeExtendedEchoRequest:
+				typ, proto = ipv4.ICMPTypeExtendedEchoReply, 1
+			case ipv6.ICMPTypeExtendedEchoRequest:
+				typ, proto = ipv6.ICMPTypeExtendedEchoReply, 58
+			}
+
+			// Send the request over the "wire" to exercise marshaling.
+			b, err := req.Message.Marshal(nil)
+			if err != nil {
+				return err
+			}
+			m, err := icmp.ParseMessage(proto, b)
+			if err != nil {
+				return err
+			}
+
+			body := m.Body.(*icmp.ExtendedEchoRequest)
+			if h.Drop != nil && h.Drop(body) {
+				continue
+			}
+
+			code, rep, err := h.reply(m, body)
+			if err != nil {
+				return err
+			}
+
+			h.resC <- message{
+				Message: &icmp.Message{
+					Type: typ,
+					Code: int(code),
+					Body: rep,
+				},
+				Host: req.Host,
+			}
+		}
+	}
+}
+
+// reply produces the reply to an extended echo request.
+func (h *testHost) reply(m *icmp.Message, req *icmp.ExtendedEchoRequest) (Code, *icmp.ExtendedEchoReply, error) {
+	rep := &icmp.ExtendedEchoReply{
+		ID:  req.ID,
+		Seq: req.Seq,
+	}
+
+	objs, err := extension.FromMessage(m)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(objs) != 1 {
+		return CodeMalformedQuery, rep, nil
+	}
+	ii, ok := objs[0].(*extension.InterfaceIdentification)
+	if !ok {
+		return CodeMalformedQuery, rep, nil
+	}
+
+	if !req.Local {
+		if !h.Neighbor.IsValid() || ii.Addr != h.Neighbor {
+			return CodeNoSuchTableEntry, rep, nil
+		}
+
+		rep.State = int(StateReachable)
+		rep.Active, rep.IPv4 = true, true
+		return CodeNoError, rep, nil
+	}
+
+	if ii.Name != "eth0" && ii.Index != 2 && ii.Addr != h.IP {
+		return CodeNoSuchInterface, rep, nil
+	}
+
+	rep.Active, rep.IPv4, rep.IPv6 = true, true, true
+	return CodeNoError, rep, nil
+}
+
+func (*testHost) Close() error { return nil }
+
+func (h *testHost) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-h.resC:
+		return m.Message, m.Host, nil
+	}
+}
+
+func (h *testHost) WriteTo(ctx context.Context, msg *icmp.Message, dst netip.Addr) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case h.reqC <- message{
+		Message: msg,
+		Host:    dst,
+	}:
+		return nil
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,3 @@
+// Package probe implements an ICMPv4/6 extended echo client which queries the
+// status of interfaces on remote nodes, as described in RFC 8335.
+package probe
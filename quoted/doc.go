@@ -0,0 +1,4 @@
+// Package quoted implements decoding of the original datagram quoted in ICMPv4
+// and ICMPv6 error messages, so that an error can be matched to the probe
+// which caused it.
+package quoted
@@ -0,0 +1,276 @@
+package quoted
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Upper-layer and IPv6 extension header protocol numbers.
+const (
+	protoHopByHop = 0
+	protoICMPv4   = 1
+	protoTCP      = 6
+	protoUDP      = 17
+	protoRouting  = 43
+	protoFragment = 44
+	protoAH       = 51
+	protoICMPv6   = 58
+	protoDstOpts  = 60
+	protoMobility = 135
+)
+
+// Lengths of fixed-size headers.
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	fragHeaderLen = 8
+
+	// minUpperLength is the number of upper-layer header bytes required to
+	// identify an echo, UDP, or TCP flow.
+	minUpperLength = 8
+)
+
+var errNoDatagram = errors.New("quoted: message does not quote a datagram")
+
+// A Datagram is the original IPv4 or IPv6 datagram quoted in an ICMP error
+// message. ICMP errors typically quote only a prefix of the original datagram,
+// so any upper-layer headers are decoded only as far as the quoted bytes
+// permit.
+type Datagram struct {
+	// Version is the IP version: 4 or 6.
+	Version int
+
+	// TrafficClass is the IPv4 TOS or IPv6 traffic class, and HopLimit is
+	// the IPv4 TTL or IPv6 hop limit, as observed by the node which generated
+	// the error.
+	TrafficClass int
+	HopLimit     int
+
+	// ID is the IPv4 identification field, or the identification from an
+	// IPv6 fragment header if present.
+	ID int
+
+	// FragmentOffset is the offset in bytes of a fragment within the original
+	// datagram. Upper-layer headers are only present when FragmentOffset is
+	// zero.
+	FragmentOffset int
+
+	// Length is the total length of the original datagram in bytes, as
+	// claimed by its IP header. Truncated reports whether fewer bytes than
+	// Length were quoted.
+	Length    int
+	Truncated bool
+
+	// Protocol is the upper-layer protocol following the IP header and any
+	// IPv6 extension headers.
+	Protocol int
+
+	Src, Dst netip.Addr
+
+	// Payload holds the quoted upper-layer bytes, beginning with the
+	// upper-layer header.
+	Payload []byte
+
+	// At most one of Echo, UDP, or TCP is set when the upper-layer header is
+	// an ICMP echo message, UDP, or TCP, and enough of it was quoted to
+	// identify the flow.
+	Echo *Echo
+	UDP  *UDP
+	TCP  *TCP
+}
+
+// An Echo is a quoted ICMPv4 or ICMPv6 echo request or reply header.
+type Echo struct {
+	Type    icmp.Type
+	ID, Seq int
+}
+
+// A UDP is a quoted UDP header.
+type UDP struct {
+	SrcPort, DstPort uint16
+	Length           int
+	Checksum         uint16
+}
+
+// A TCP is a quoted TCP header. Only the fields carried in the first 8 bytes,
+// which are guaranteed to be quoted by ICMPv4 errors, are decoded.
+type TCP struct {
+	SrcPort, DstPort uint16
+	Seq              uint32
+}
+
+// FromMessage parses the Datagram quoted in the body of an ICMP Destination
+// Unreachable, Time Exceeded, Packet Too Big, or Parameter Problem message.
+func FromMessage(m *icmp.Message) (*Datagram, error) {
+	var b []byte
+	switch body := m.Body.(type) {
+	case *icmp.DstUnreach:
+		b = body.Data
+	case *icmp.TimeExceeded:
+		b = body.Data
+	case *icmp.PacketTooBig:
+		b = body.Data
+	case *icmp.ParamProb:
+		b = body.Data
+	default:
+		return nil, errNoDatagram
+	}
+
+	return Parse(b)
+}
+
+// Parse parses a quoted IPv4 or IPv6 datagram. The IP header must be present
+// in full, but the upper-layer header may be truncated or absent.
+func Parse(b []byte) (*Datagram, error) {
+	if len(b) == 0 {
+		return nil, errNoDatagram
+	}
+
+	switch v := b[0] >> 4; v {
+	case 4:
+		return parseIPv4(b)
+	case 6:
+		return parseIPv6(b)
+	default:
+		return nil, fmt.Errorf("quoted: unknown IP version: %d", v)
+	}
+}
+
+// parseIPv4 parses a quoted IPv4 datagram.
+func parseIPv4(b []byte) (*Datagram, error) {
+	if len(b) < ipv4HeaderLen {
+		return nil, fmt.Errorf("quoted: IPv4 header too short: %d bytes", len(b))
+	}
+
+	hl := int(b[0]&0x0f) * 4
+	if hl < ipv4HeaderLen || hl > len(b) {
+		return nil, fmt.Errorf("quoted: invalid IPv4 header length: %d", hl)
+	}
+
+	d := &Datagram{
+		Version:        4,
+		TrafficClass:   int(b[1]),
+		Length:         int(binary.BigEndian.Uint16(b[2:4])),
+		ID:             int(binary.BigEndian.Uint16(b[4:6])),
+		FragmentOffset: int(binary.BigEndian.Uint16(b[6:8])&0x1fff) * 8,
+		HopLimit:       int(b[8]),
+		Protocol:       int(b[9]),
+		Src:            netip.AddrFrom4([4]byte(b[12:16])),
+		Dst:            netip.AddrFrom4([4]byte(b[16:20])),
+	}
+	d.Truncated = len(b) < d.Length
+
+	d.upper(b[hl:])
+	return d, nil
+}
+
+// parseIPv6 parses a quoted IPv6 datagram, walking any extension headers to
+// find the upper-layer header.
+func parseIPv6(b []byte) (*Datagram, error) {
+	if len(b) < ipv6HeaderLen {
+		return nil, fmt.Errorf("quoted: IPv6 header too short: %d bytes", len(b))
+	}
+
+	d := &Datagram{
+		Version:      6,
+		TrafficClass: int(binary.BigEndian.Uint16(b[0:2])>>4) & 0xff,
+		Length:       ipv6HeaderLen + int(binary.BigEndian.Uint16(b[4:6])),
+		HopLimit:     int(b[7]),
+		Src:          netip.AddrFrom16([16]byte(b[8:24])),
+		Dst:          netip.AddrFrom16([16]byte(b[24:40])),
+	}
+	d.Truncated = len(b) < d.Length
+
+	next, b := int(b[6]), b[ipv6HeaderLen:]
+	for {
+		var l int
+		switch next {
+		case protoHopByHop, protoRouting, protoDstOpts, protoMobility:
+			if len(b) < 2 {
+				return d.partial(next), nil
+			}
+			l = (int(b[1]) + 1) * 8
+		case protoAH:
+			if len(b) < 2 {
+				return d.partial(next), nil
+			}
+			l = (int(b[1]) + 2) * 4
+		case protoFragment:
+			if len(b) < fragHeaderLen {
+				return d.partial(next), nil
+			}
+			l = fragHeaderLen
+
+			d.FragmentOffset = int(binary.BigEndian.Uint16(b[2:4]) &^ 0x7)
+			d.ID = int(binary.BigEndian.Uint32(b[4:8]))
+		default:
+			// Upper-layer header or no next header.
+			d.Protocol = next
+			d.upper(b)
+			return d, nil
+		}
+
+		if len(b) < l {
+			return d.partial(next), nil
+		}
+
+		next, b = int(b[0]), b[l:]
+	}
+}
+
+// partial marks d as truncated within an IPv6 extension header of type next.
+func (d *Datagram) partial(next int) *Datagram {
+	d.Protocol = next
+	d.Truncated = true
+	return d
+}
+
+// upper decodes the upper-layer header in b, if possible.
+func (d *Datagram) upper(b []byte) {
+	d.Payload = b
+	if d.FragmentOffset != 0 || len(b) < minUpperLength {
+		// Only the first fragment carries the upper-layer header.
+		return
+	}
+
+	switch {
+	case d.Version == 4 && d.Protocol == protoICMPv4:
+		switch typ := ipv4.ICMPType(b[0]); typ {
+		case ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply:
+			d.Echo = parseEcho(typ, b)
+		}
+	case d.Version == 6 && d.Protocol == protoICMPv6:
+		switch typ := ipv6.ICMPType(b[0]); typ {
+		case ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply:
+			d.Echo = parseEcho(typ, b)
+		}
+	case d.Protocol == protoUDP:
+		d.UDP = &UDP{
+			SrcPort:  binary.BigEndian.Uint16(b[0:2]),
+			DstPort:  binary.BigEndian.Uint16(b[2:4]),
+			Length:   int(binary.BigEndian.Uint16(b[4:6])),
+			Checksum: binary.BigEndian.Uint16(b[6:8]),
+		}
+	case d.Protocol == protoTCP:
+		d.TCP = &TCP{
+			SrcPort: binary.BigEndian.Uint16(b[0:2]),
+			DstPort: binary.BigEndian.Uint16(b[2:4]),
+			Seq:     binary.BigEndian.Uint32(b[4:8]),
+		}
+	}
+}
+
+// parseEcho parses the identifying fields of an echo message header.
+func parseEcho(typ icmp.Type, b []byte) *Echo {
+	return &Echo{
+		Type: typ,
+		ID:   int(binary.BigEndian.Uint16(b[4:6])),
+		Seq:  int(binary.BigEndian.Uint16(b[6:8])),
+	}
+}
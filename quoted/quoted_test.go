@@ -0,0 +1,375 @@
+package quoted_test
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/quoted"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	src4 = netip.MustParseAddr("192.0.2.1")
+	dst4 = netip.MustParseAddr("198.51.100.1")
+	src6 = netip.MustParseAddr("2001:db8::1")
+	dst6 = netip.MustParseAddr("2001:db8::2")
+)
+
+func TestParse(t *testing.T) {
+	var (
+		echo4 = []byte{8, 0, 0xff, 0xff, 0x12, 0x34, 0x00, 0x02}
+		echo6 = []byte{128, 0, 0xff, 0xff, 0x12, 0x34, 0x00, 0x02}
+		udp   = []byte{0x82, 0x9a, 0x82, 0x9b, 0x00, 0x10, 0xab, 0xcd}
+		tcp   = []byte{0xc0, 0x00, 0x00, 0x50, 0xde, 0xad, 0xbe, 0xef}
+	)
+
+	tests := []struct {
+		name string
+		b    []byte
+		d    *quoted.Datagram
+	}{
+		{
+			name: "IPv4 echo",
+			b:    ipv4Datagram(1, 0, nil, echo4, 64),
+			d: &quoted.Datagram{
+				Version:   4,
+				HopLimit:  1,
+				ID:        1,
+				Length:    20 + 64,
+				Truncated: true,
+				Protocol:  1,
+				Src:       src4,
+				Dst:       dst4,
+				Payload:   echo4,
+				Echo: &quoted.Echo{
+					Type: ipv4.ICMPTypeEcho,
+					ID:   0x1234,
+					Seq:  2,
+				},
+			},
+		},
+		{
+			name: "IPv4 options UDP",
+			b:    ipv4Datagram(17, 0, []byte{0x01, 0x01, 0x01, 0x00}, udp, 8),
+			d: &quoted.Datagram{
+				Version:  4,
+				HopLimit: 1,
+				ID:       1,
+				Length:   24 + 8,
+				Protocol: 17,
+				Src:      src4,
+				Dst:      dst4,
+				Payload:  udp,
+				UDP: &quoted.UDP{
+					SrcPort:  33434,
+					DstPort:  33435,
+					Length:   16,
+					Checksum: 0xabcd,
+				},
+			},
+		},
+		{
+			name: "IPv4 TCP",
+			b:    ipv4Datagram(6, 0, nil, tcp, 8),
+			d: &quoted.Datagram{
+				Version:  4,
+				HopLimit: 1,
+				ID:       1,
+				Length:   20 + 8,
+				Protocol: 6,
+				Src:      src4,
+				Dst:      dst4,
+				Payload:  tcp,
+				TCP: &quoted.TCP{
+					SrcPort: 49152,
+					DstPort: 80,
+					Seq:     0xdeadbeef,
+				},
+			},
+		},
+		{
+			name: "IPv4 truncated upper-layer",
+			b:    ipv4Datagram(17, 0, nil, udp[:4], 8),
+			d: &quoted.Datagram{
+				Version:   4,
+				HopLimit:  1,
+				ID:        1,
+				Length:    20 + 8,
+				Truncated: true,
+				Protocol:  17,
+				Src:       src4,
+				Dst:       dst4,
+				Payload:   udp[:4],
+			},
+		},
+		{
+			name: "IPv4 non-first fragment",
+			b:    ipv4Datagram(17, 185, nil, udp, 8),
+			d: &quoted.Datagram{
+				Version:        4,
+				HopLimit:       1,
+				ID:             1,
+				FragmentOffset: 1480,
+				Length:         20 + 8,
+				Protocol:       17,
+				Src:            src4,
+				Dst:            dst4,
+				Payload:        udp,
+			},
+		},
+		{
+			name: "IPv6 echo",
+			b:    ipv6Datagram(58, nil, echo6, 8),
+			d: &quoted.Datagram{
+				Version:      6,
+				TrafficClass: 0xb8,
+				HopLimit:     1,
+				Length:       40 + 8,
+				Protocol:     58,
+				Src:          src6,
+				Dst:          dst6,
+				Payload:      echo6,
+				Echo: &quoted.Echo{
+					Type: ipv6.ICMPTypeEchoRequest,
+					ID:   0x1234,
+					Seq:  2,
+				},
+			},
+		},
+		{
+			name: "IPv6 extension headers UDP",
+			b: ipv6Datagram(0, [][]byte{
+				// Hop-by-hop options with PadN, followed by a first fragment.
+				{44, 0, 1, 4, 0, 0, 0, 0},
+				{17, 0, 0x00, 0x01, 0x00, 0x00, 0x00, 0x2a},
+			}, udp, 32),
+			d: &quoted.Datagram{
+				Version:      6,
+				TrafficClass: 0xb8,
+				HopLimit:     1,
+				ID:           42,
+				Length:       40 + 16 + 32,
+				Truncated:    true,
+				Protocol:     17,
+				Src:          src6,
+				Dst:          dst6,
+				Payload:      udp,
+				UDP: &quoted.UDP{
+					SrcPort:  33434,
+					DstPort:  33435,
+					Length:   16,
+					Checksum: 0xabcd,
+				},
+			},
+		},
+		{
+			name: "IPv6 non-first fragment",
+			b: ipv6Datagram(44, [][]byte{
+				{6, 0, 0x05, 0xa9, 0x00, 0x00, 0x00, 0x2a},
+			}, tcp, 8),
+			d: &quoted.Datagram{
+				Version:        6,
+				TrafficClass:   0xb8,
+				HopLimit:       1,
+				ID:             42,
+				FragmentOffset: 1448,
+				Length:         40 + 8 + 8,
+				Protocol:       6,
+				Src:            src6,
+				Dst:            dst6,
+				Payload:        tcp,
+			},
+		},
+		{
+			name: "IPv6 truncated extension header",
+			b: ipv6Datagram(60, [][]byte{
+				{17, 1, 0, 0, 0, 0, 0, 0},
+			}, nil, 16),
+			d: &quoted.Datagram{
+				Version:      6,
+				TrafficClass: 0xb8,
+				HopLimit:     1,
+				Length:       40 + 8 + 16,
+				Truncated:    true,
+				Protocol:     60,
+				Src:          src6,
+				Dst:          dst6,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := quoted.Parse(tt.b)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.d, d, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected datagram (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "version",
+			b:    []byte{0x50},
+		},
+		{
+			name: "IPv4 short",
+			b:    ipv4Datagram(1, 0, nil, nil, 0)[:19],
+		},
+		{
+			name: "IPv4 header length",
+			b: func() []byte {
+				b := ipv4Datagram(1, 0, nil, nil, 0)
+				b[0] = 0x46
+				return b
+			}(),
+		},
+		{
+			name: "IPv6 short",
+			b:    ipv6Datagram(58, nil, nil, 0)[:39],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := quoted.Parse(tt.b); err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func TestFromMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		proto int
+		m     *icmp.Message
+		ok    bool
+	}{
+		{
+			name:  "IPv4 time exceeded",
+			proto: 1,
+			m: &icmp.Message{
+				Type: ipv4.ICMPTypeTimeExceeded,
+				Body: &icmp.TimeExceeded{
+					Data: ipv4Datagram(1, 0, nil, []byte{8, 0, 0, 0, 0, 1, 0, 2}, 8),
+				},
+			},
+			ok: true,
+		},
+		{
+			name:  "IPv6 packet too big",
+			proto: 58,
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypePacketTooBig,
+				Body: &icmp.PacketTooBig{
+					MTU:  1280,
+					Data: ipv6Datagram(58, nil, []byte{128, 0, 0, 0, 0, 1, 0, 2}, 8),
+				},
+			},
+			ok: true,
+		},
+		{
+			name:  "IPv6 echo reply",
+			proto: 58,
+			m: &icmp.Message{
+				Type: ipv6.ICMPTypeEchoReply,
+				Body: &icmp.Echo{ID: 1, Seq: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Round-trip the message through x/net/icmp to ensure the
+			// quoted data is decoded from a parsed message.
+			b, err := tt.m.Marshal(nil)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+
+			m, err := icmp.ParseMessage(tt.proto, b)
+			if err != nil {
+				t.Fatalf("failed to parse message: %v", err)
+			}
+
+			d, err := quoted.FromMessage(m)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse datagram: %v", err)
+			}
+
+			if diff := cmp.Diff(&quoted.Echo{ID: 1, Seq: 2}, d.Echo, cmp.FilterPath(func(p cmp.Path) bool {
+				return p.Last().String() == ".Type"
+			}, cmp.Ignore())); diff != "" {
+				t.Fatalf("unexpected echo (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// ipv4Datagram produces a quoted IPv4 datagram with the specified options and
+// quoted payload bytes, whose header claims a payload of length n.
+func ipv4Datagram(proto, fragOff int, opts, payload []byte, n int) []byte {
+	hl := 20 + len(opts)
+
+	b := make([]byte, hl)
+	b[0] = 4<<4 | byte(hl/4)
+	binary.BigEndian.PutUint16(b[2:4], uint16(hl+n))
+	binary.BigEndian.PutUint16(b[4:6], 1)
+	binary.BigEndian.PutUint16(b[6:8], uint16(fragOff))
+	b[8], b[9] = 1, byte(proto)
+	copy(b[12:16], src4.AsSlice())
+	copy(b[16:20], dst4.AsSlice())
+	copy(b[20:], opts)
+
+	return append(b, payload...)
+}
+
+// ipv6Datagram produces a quoted IPv6 datagram with the specified extension
+// headers and quoted payload bytes, whose header claims an upper-layer payload
+// of length n.
+func ipv6Datagram(next int, exts [][]byte, payload []byte, n int) []byte {
+	var l int
+	for _, e := range exts {
+		l += len(e)
+	}
+
+	b := make([]byte, 40)
+	// Version 6, traffic class 0xb8.
+	b[0], b[1] = 0x6b, 0x80
+	binary.BigEndian.PutUint16(b[4:6], uint16(l+n))
+	b[6], b[7] = byte(next), 1
+	copy(b[8:24], src6.AsSlice())
+	copy(b[24:40], dst6.AsSlice())
+
+	for _, e := range exts {
+		b = append(b, e...)
+	}
+
+	return append(b, payload...)
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,335 @@
+package replay
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/internal/pcap"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+var _ icmpx.Conn = &Conn{}
+
+// A Conn is an icmpx.Conn which returns ICMPv4/6 messages from a packet capture
+// via ReadFrom and records all messages passed to WriteTo.
+type Conn struct {
+	cfg Config
+
+	// Replayed packets and the timing state for ReadFrom.
+	readMu      sync.Mutex
+	pkts        []packet
+	start, base time.Time
+
+	writeMu sync.Mutex
+	writes  []Write
+
+	closeOnce sync.Once
+	closedC   chan struct{}
+}
+
+// A Config configures a Conn.
+type Config struct {
+	// Network limits ReadFrom to ICMPv4 messages when set to "ip4", or
+	// ICMPv6 messages when set to "ip6". If empty, all ICMPv4/6 messages are
+	// returned.
+	Network string
+
+	// Local limits ReadFrom to messages which were sent to Local or to a
+	// multicast address. Packets captured in the outbound direction are
+	// always skipped. If Local is the zero value, all destinations are
+	// permitted.
+	Local netip.Addr
+
+	// Speed scales the rate of playback relative to the original capture.
+	// For example, a Speed of 10 replays messages ten times faster than they
+	// were captured, and a Speed of math.Inf(1) replays messages without any
+	// delay. If zero, messages are replayed in real time.
+	Speed float64
+
+	// Hold causes ReadFrom to block until its context is canceled or the
+	// Conn is closed once all messages have been replayed, rather than
+	// returning io.EOF. This is useful for consumers such as echo.Client
+	// which treat any read error as fatal.
+	Hold bool
+}
+
+// A Write is a message passed to Conn.WriteTo.
+type Write struct {
+	Time    time.Time
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+// A packet is a replayable ICMPv4/6 message from a capture.
+type packet struct {
+	Time    time.Time
+	Message *icmp.Message
+	IP      netip.Addr
+	Err     error
+}
+
+// Open opens a pcap or pcapng file and creates a Conn which replays it.
+func Open(name string, cfg Config) (*Conn, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return New(f, cfg)
+}
+
+// New creates a Conn which replays the pcap or pcapng stream read from r. The
+// entire stream is read before New returns.
+func New(r io.Reader, cfg Config) (*Conn, error) {
+	switch cfg.Network {
+	case "", "ip4", "ip6":
+	default:
+		return nil, fmt.Errorf("replay: invalid network: %q", cfg.Network)
+	}
+	if cfg.Speed < 0 {
+		return nil, fmt.Errorf("replay: invalid speed: %v", cfg.Speed)
+	}
+	if cfg.Speed == 0 {
+		cfg.Speed = 1
+	}
+
+	pr, err := pcap.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		cfg:     cfg,
+		closedC: make(chan struct{}),
+	}
+
+	for {
+		p, err := pr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		// Timing is relative to the first packet in the capture, whether or
+		// not it is replayed.
+		if c.base.IsZero() {
+			c.base = p.Timestamp
+		}
+
+		pkt, ok := c.decode(p)
+		if !ok {
+			continue
+		}
+
+		c.pkts = append(c.pkts, pkt)
+	}
+
+	return c, nil
+}
+
+// Close closes the Conn, unblocking any pending calls to ReadFrom.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closedC) })
+	return nil
+}
+
+// ReadFrom returns the next replayed ICMPv4/6 message and its source address
+// once the appropriate amount of time has elapsed since the first call to
+// ReadFrom. When all messages have been replayed, ReadFrom returns io.EOF
+// unless Config.Hold is set.
+func (c *Conn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.start.IsZero() {
+		c.start = time.Now()
+	}
+
+	if len(c.pkts) == 0 {
+		if !c.cfg.Hold {
+			return nil, netip.Addr{}, io.EOF
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, netip.Addr{}, ctx.Err()
+		case <-c.closedC:
+			return nil, netip.Addr{}, net.ErrClosed
+		}
+	}
+
+	p := c.pkts[0]
+	if d := c.delay(p); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, netip.Addr{}, ctx.Err()
+		case <-c.closedC:
+			return nil, netip.Addr{}, net.ErrClosed
+		case <-t.C:
+		}
+	}
+
+	c.pkts = c.pkts[1:]
+	return p.Message, p.IP, p.Err
+}
+
+// WriteTo records msg and dst for later inspection with Writes.
+func (c *Conn) WriteTo(ctx context.Context, msg *icmp.Message, dst netip.Addr) error {
+	select {
+	case <-c.closedC:
+		return net.ErrClosed
+	default:
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.writes = append(c.writes, Write{
+		Time:    time.Now(),
+		Message: msg,
+		IP:      dst,
+	})
+
+	return nil
+}
+
+// Writes returns all of the messages passed to WriteTo, in order.
+func (c *Conn) Writes() []Write {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return append([]Write(nil), c.writes...)
+}
+
+// delay returns how long ReadFrom must wait before returning p.
+func (c *Conn) delay(p packet) time.Duration {
+	if p.Time.IsZero() {
+		// No timestamp available.
+		return 0
+	}
+
+	offset := time.Duration(float64(p.Time.Sub(c.base)) / c.cfg.Speed)
+	return time.Until(c.start.Add(offset))
+}
+
+// decode decodes a captured packet into a replayable ICMPv4/6 message. It
+// reports false if the packet should not be replayed.
+func (c *Conn) decode(p *pcap.Packet) (packet, bool) {
+	if p.Direction == pcap.DirectionOutbound {
+		return packet{}, false
+	}
+
+	b, err := p.Network()
+	if err != nil {
+		return packet{}, false
+	}
+
+	var (
+		src, dst netip.Addr
+		proto    int
+		ok       bool
+	)
+	switch b[0] >> 4 {
+	case 4:
+		if c.cfg.Network == "ip6" {
+			return packet{}, false
+		}
+		src, dst, b, ok = parseIPv4(b)
+		proto = 1
+	case 6:
+		if c.cfg.Network == "ip4" {
+			return packet{}, false
+		}
+		src, dst, b, ok = parseIPv6(b)
+		proto = 58
+	}
+	if !ok {
+		return packet{}, false
+	}
+
+	if c.cfg.Local.IsValid() && !dst.IsMulticast() && dst != c.cfg.Local.WithZone("") {
+		return packet{}, false
+	}
+
+	// Like a real icmpx.Conn, messages which cannot be parsed produce an
+	// error from ReadFrom.
+	m, err := icmp.ParseMessage(proto, b)
+	return packet{
+		Time:    p.Timestamp,
+		Message: m,
+		IP:      src,
+		Err:     err,
+	}, true
+}
+
+// parseIPv4 parses an IPv4 packet and returns its ICMPv4 payload, reporting
+// false if the packet does not carry an unfragmented ICMPv4 message.
+func parseIPv4(b []byte) (src, dst netip.Addr, payload []byte, ok bool) {
+	h, err := ipv4.ParseHeader(b)
+	if err != nil || h.Protocol != 1 || h.FragOff != 0 || h.Flags&ipv4.MoreFragments != 0 {
+		return netip.Addr{}, netip.Addr{}, nil, false
+	}
+
+	// Trim any link-layer padding.
+	end := h.TotalLen
+	if end > len(b) || end < h.Len {
+		end = len(b)
+	}
+
+	src, _ = netip.AddrFromSlice(h.Src.To4())
+	dst, _ = netip.AddrFromSlice(h.Dst.To4())
+	return src, dst, b[h.Len:end], true
+}
+
+// parseIPv6 parses an IPv6 packet and returns its ICMPv6 payload, reporting
+// false if the packet does not carry an unfragmented ICMPv6 message.
+func parseIPv6(b []byte) (src, dst netip.Addr, payload []byte, ok bool) {
+	const hdrLen = 40
+	if len(b) < hdrLen {
+		return netip.Addr{}, netip.Addr{}, nil, false
+	}
+
+	src = netip.AddrFrom16([16]byte(b[8:24]))
+	dst = netip.AddrFrom16([16]byte(b[24:40]))
+
+	// Trim any link-layer padding.
+	end := hdrLen + int(binary.BigEndian.Uint16(b[4:6]))
+	if end > len(b) {
+		end = len(b)
+	}
+
+	next, b := b[6], b[hdrLen:end]
+	for {
+		switch next {
+		case 58:
+			return src, dst, b, true
+		case 0, 43, 60:
+			// Hop-by-hop options, routing, and destination options headers.
+			if len(b) < 2 || len(b) < (int(b[1])+1)*8 {
+				return netip.Addr{}, netip.Addr{}, nil, false
+			}
+
+			next, b = b[0], b[(int(b[1])+1)*8:]
+		default:
+			// Fragments and other protocols are not replayed.
+			return netip.Addr{}, netip.Addr{}, nil, false
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/replay"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/nettest"
+)
+
+func TestIntegrationConnCaptureReplay(t *testing.T) {
+	t.Parallel()
+
+	lo, err := nettest.LoopbackInterface()
+	if err != nil {
+		t.Fatalf("failed to find loopback: %v", err)
+	}
+
+	// Capture a real echo exchange and verify that the reply is replayed.
+	var buf bytes.Buffer
+	c, err := icmpx.ListenIPv4(lo, icmpx.IPv4Config{
+		Filter:  icmpx.IPv4AllowOnly(ipv4.ICMPTypeEchoReply),
+		Capture: &buf,
+	})
+	if err != nil {
+		// ICMPv4 sockets require elevated privileges.
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to listen IPv4: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		dst = netip.MustParseAddr("127.0.0.1")
+		req = echoMessage(ipv4.ICMPTypeEcho, 1)
+	)
+
+	if err := c.WriteTo(ctx, req, dst); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	want, _, err := c.ReadFrom(ctx)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	rc, err := replay.New(&buf, replay.Config{
+		Network: "ip4",
+		Speed:   math.Inf(1),
+	})
+	if err != nil {
+		t.Fatalf("failed to create replay conn: %v", err)
+	}
+	defer rc.Close()
+
+	got, ip, err := rc.ReadFrom(ctx)
+	if err != nil {
+		t.Fatalf("failed to read replay: %v", err)
+	}
+
+	if diff := cmp.Diff(dst, ip, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected source IP (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected replayed message (-want +got):\n%s", diff)
+	}
+	if _, ok := got.Body.(*icmp.Echo); !ok {
+		t.Fatalf("unexpected message body: %#v", got.Body)
+	}
+}
@@ -0,0 +1,260 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/internal/pcap"
+	"github.com/mdlayher/icmpx/replay"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	local4  = netip.MustParseAddr("192.0.2.1")
+	remote4 = netip.MustParseAddr("192.0.2.2")
+	local6  = netip.MustParseAddr("2001:db8::1")
+	remote6 = netip.MustParseAddr("2001:db8::2")
+)
+
+func TestConnReadFrom(t *testing.T) {
+	t.Parallel()
+
+	b := testCapture(t)
+
+	tests := []struct {
+		name string
+		cfg  replay.Config
+		ips  []netip.Addr
+	}{
+		{
+			name: "all",
+			ips:  []netip.Addr{remote4, remote6, remote4},
+		},
+		{
+			name: "IPv4",
+			cfg:  replay.Config{Network: "ip4"},
+			ips:  []netip.Addr{remote4, remote4},
+		},
+		{
+			name: "IPv6",
+			cfg:  replay.Config{Network: "ip6"},
+			ips:  []netip.Addr{remote6},
+		},
+		{
+			name: "local",
+			cfg:  replay.Config{Local: local4},
+			ips:  []netip.Addr{remote4},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Replay without delays to speed up the test.
+			tt.cfg.Speed = math.Inf(1)
+
+			c, err := replay.New(bytes.NewReader(b), tt.cfg)
+			if err != nil {
+				t.Fatalf("failed to create conn: %v", err)
+			}
+			defer c.Close()
+
+			var ips []netip.Addr
+			for {
+				m, ip, err := c.ReadFrom(context.Background())
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read: %v", err)
+				}
+
+				if _, ok := m.Body.(*icmp.Echo); !ok {
+					t.Fatalf("unexpected message body: %#v", m.Body)
+				}
+
+				ips = append(ips, ip)
+			}
+
+			if diff := cmp.Diff(tt.ips, ips, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected source IPs (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConnTiming(t *testing.T) {
+	t.Parallel()
+
+	// The capture spans 200ms, so at double speed it should take roughly
+	// 100ms to replay.
+	c, err := replay.New(bytes.NewReader(testCapture(t)), replay.Config{
+		Speed: 2,
+		Hold:  true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create conn: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, _, err := c.ReadFrom(ctx); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+	}
+
+	if since := time.Since(start); since < 90*time.Millisecond || since > 1*time.Second {
+		t.Fatalf("unexpected replay duration: %v", since)
+	}
+
+	// With Hold set, further reads block until the context is canceled.
+	ctx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := c.ReadFrom(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, but got: %v", err)
+	}
+}
+
+func TestConnWriteTo(t *testing.T) {
+	t.Parallel()
+
+	c, err := replay.New(bytes.NewReader(testCapture(t)), replay.Config{})
+	if err != nil {
+		t.Fatalf("failed to create conn: %v", err)
+	}
+	defer c.Close()
+
+	msg := echoMessage(ipv4.ICMPTypeEcho, 1)
+	if err := c.WriteTo(context.Background(), msg, remote4); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	ws := c.Writes()
+	if diff := cmp.Diff(1, len(ws)); diff != "" {
+		t.Fatalf("unexpected number of writes (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(msg, ws[0].Message); diff != "" {
+		t.Fatalf("unexpected message (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(remote4, ws[0].IP, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected destination (-want +got):\n%s", diff)
+	}
+}
+
+// testCapture produces a pcapng capture of an IPv4 echo exchange, an IPv6 echo
+// reply, and an IPv4 echo reply for another host, each 100ms apart.
+func testCapture(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := pcap.NewWriter(&buf, pcap.Interface{
+		Name:     "eth0",
+		LinkType: pcap.LinkTypeRaw,
+	})
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+
+	start := time.Unix(1, 0)
+	for _, p := range []pcap.Packet{
+		{
+			Timestamp: start,
+			Direction: pcap.DirectionOutbound,
+			Data:      ipv4Packet(t, local4, remote4, echoMessage(ipv4.ICMPTypeEcho, 1)),
+		},
+		{
+			Timestamp: start.Add(1 * time.Millisecond),
+			Direction: pcap.DirectionInbound,
+			Data:      ipv4Packet(t, remote4, local4, echoMessage(ipv4.ICMPTypeEchoReply, 1)),
+		},
+		{
+			Timestamp: start.Add(100 * time.Millisecond),
+			Data:      ipv6Packet(t, remote6, local6, echoMessage(ipv6.ICMPTypeEchoReply, 2)),
+		},
+		{
+			Timestamp: start.Add(200 * time.Millisecond),
+			Data: ipv4Packet(
+				t, remote4, netip.MustParseAddr("192.0.2.255"),
+				echoMessage(ipv4.ICMPTypeEchoReply, 3),
+			),
+		},
+	} {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatalf("failed to write packet: %v", err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func ipv4Packet(t *testing.T, src, dst netip.Addr, m *icmp.Message) []byte {
+	t.Helper()
+
+	b, err := m.Marshal(nil)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	h, err := (&ipv4.Header{
+		Version:  4,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + len(b),
+		TTL:      64,
+		Protocol: 1,
+		Src:      src.AsSlice(),
+		Dst:      dst.AsSlice(),
+	}).Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal IPv4 header: %v", err)
+	}
+
+	return append(h, b...)
+}
+
+func ipv6Packet(t *testing.T, src, dst netip.Addr, m *icmp.Message) []byte {
+	t.Helper()
+
+	b, err := m.Marshal(icmp.IPv6PseudoHeader(src.AsSlice(), dst.AsSlice()))
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	h := make([]byte, ipv6.HeaderLen)
+	h[0] = 6 << 4
+	binary.BigEndian.PutUint16(h[4:6], uint16(len(b)))
+	h[6], h[7] = 58, 64
+	copy(h[8:24], src.AsSlice())
+	copy(h[24:40], dst.AsSlice())
+
+	return append(h, b...)
+}
+
+func echoMessage(typ icmp.Type, seq int) *icmp.Message {
+	return &icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{
+			ID:   1,
+			Seq:  seq,
+			Data: []byte{0xde, 0xad, 0xbe, 0xef},
+		},
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,3 @@
+// Package replay implements an icmpx.Conn which replays ICMPv4/6 messages from
+// a pcap or pcapng capture file.
+package replay
@@ -0,0 +1,8 @@
+// Package responder implements a userspace ICMPv4/6 echo responder which
+// replies to echo requests in place of the kernel, applying policies such as
+// per-source rate limits and artificial delays to each request.
+//
+// The kernel's own echo replies should be disabled on hosts which run a
+// Responder, using the net.ipv4.icmp_echo_ignore_all and
+// net.ipv6.icmp.echo_ignore_all sysctls.
+package responder
@@ -0,0 +1,131 @@
+package responder
+
+import (
+	"container/list"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// A Request is an ICMPv4/6 echo request received by a Responder.
+type Request struct {
+	// IP is the source address of the request.
+	IP netip.Addr
+
+	// Echo is the body of the request.
+	Echo *icmp.Echo
+
+	// Time is the time at which the request was received.
+	Time time.Time
+}
+
+// A Verdict is a Policy's decision about how to handle a Request.
+type Verdict struct {
+	// Drop causes the Request to be ignored, and no further Policies are
+	// evaluated.
+	Drop bool
+
+	// Delay is added to the time the Responder waits before replying.
+	Delay time.Duration
+
+	// Reason, if set, explains the Verdict in the Responder's log.
+	Reason string
+}
+
+// A Policy decides how a Responder handles each Request. A Policy may be called
+// concurrently for requests received over IPv4 and IPv6.
+type Policy func(req *Request) Verdict
+
+// Delay returns a Policy which delays every reply by d.
+func Delay(d time.Duration) Policy {
+	return func(*Request) Verdict { return Verdict{Delay: d} }
+}
+
+// maxSources is the number of source addresses tracked by a RateLimit Policy
+// before the least recently seen sources are forgotten.
+const maxSources = 4096
+
+// RateLimit returns a Policy which drops requests from any source address which
+// exceeds rate requests per second, using a token bucket which holds up to
+// burst tokens for each source.
+func RateLimit(rate float64, burst int) Policy {
+	l := &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[netip.Addr]*bucket),
+		lru:     list.New(),
+	}
+
+	return func(req *Request) Verdict {
+		if l.allow(req.IP, req.Time) {
+			return Verdict{}
+		}
+
+		return Verdict{
+			Drop:   true,
+			Reason: fmt.Sprintf("rate limit of %v per second exceeded", rate),
+		}
+	}
+}
+
+// A limiter tracks a token bucket for each source address, with the most
+// recently seen sources at the front of lru.
+type limiter struct {
+	rate, burst float64
+
+	mu      sync.Mutex
+	buckets map[netip.Addr]*bucket
+	lru     *list.List
+}
+
+// A bucket is the token bucket for a single source address.
+type bucket struct {
+	ip     netip.Addr
+	tokens float64
+	last   time.Time
+	elem   *list.Element
+}
+
+// allow reports whether a request from ip at time now is within the rate limit,
+// consuming a token if so.
+func (l *limiter) allow(ip netip.Addr, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[ip]
+	if ok {
+		l.lru.MoveToFront(b.elem)
+	} else {
+		if len(l.buckets) >= maxSources {
+			// Forget the least recently seen source. Its bucket has had
+			// the longest to refill, so it is the most likely to be
+			// indistinguishable from a new source.
+			old := l.lru.Remove(l.lru.Back()).(*bucket)
+			delete(l.buckets, old.ip)
+		}
+
+		b = &bucket{ip: ip, tokens: l.burst, last: now}
+		b.elem = l.lru.PushFront(b)
+		l.buckets[ip] = b
+	}
+
+	// Refill the bucket for the time elapsed since the last request.
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
@@ -0,0 +1,150 @@
+package responder
+
+import (
+	"container/list"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/icmp"
+)
+
+func TestRateLimit(t *testing.T) {
+	var (
+		a = netip.MustParseAddr("192.0.2.1")
+		b = netip.MustParseAddr("2001:db8::1")
+
+		t0 = time.Unix(0, 0)
+	)
+
+	// 2 requests per second with a burst of 3.
+	p := RateLimit(2, 3)
+
+	tests := []struct {
+		ip    netip.Addr
+		after time.Duration
+		drop  bool
+	}{
+		// The burst is exhausted.
+		{ip: a},
+		{ip: a},
+		{ip: a},
+		{ip: a, drop: true},
+		// Sources are limited independently.
+		{ip: b},
+		// A token is refilled every 500ms.
+		{ip: a, after: 400 * time.Millisecond, drop: true},
+		{ip: a, after: 500 * time.Millisecond},
+		{ip: a, after: 500 * time.Millisecond, drop: true},
+		// The bucket never holds more than the burst.
+		{ip: a, after: time.Minute},
+		{ip: a, after: time.Minute},
+		{ip: a, after: time.Minute},
+		{ip: a, after: time.Minute, drop: true},
+	}
+
+	for i, tt := range tests {
+		v := p(&Request{IP: tt.ip, Echo: &icmp.Echo{}, Time: t0.Add(tt.after)})
+		if diff := cmp.Diff(tt.drop, v.Drop); diff != "" {
+			t.Fatalf("unexpected drop for request %d (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+func TestRateLimitEvict(t *testing.T) {
+	var (
+		l = &limiter{
+			rate:    1,
+			burst:   1,
+			buckets: make(map[netip.Addr]*bucket),
+			lru:     list.New(),
+		}
+
+		t0 = time.Unix(0, 0)
+	)
+
+	// Fill the limiter with sources which have all exhausted their buckets,
+	// as a flood of spoofed sources would, but see the first source again.
+	first := netip.MustParseAddr("2001:db8::")
+	ip := first
+	for i := 0; i < maxSources; i++ {
+		l.allow(ip, t0)
+		ip = ip.Next()
+	}
+	l.allow(first, t0)
+
+	// A new source evicts the least recently seen source rather than growing
+	// the limiter.
+	if !l.allow(ip, t0) {
+		t.Fatal("new source was rate limited")
+	}
+
+	if diff := cmp.Diff(maxSources, len(l.buckets)); diff != "" {
+		t.Fatalf("unexpected number of sources (-want +got):\n%s", diff)
+	}
+
+	if _, ok := l.buckets[first]; !ok {
+		t.Fatal("most recently seen source was evicted")
+	}
+	if _, ok := l.buckets[first.Next()]; ok {
+		t.Fatal("least recently seen source was not evicted")
+	}
+}
+
+func TestResponderEvaluate(t *testing.T) {
+	var calls int
+	count := func(v Verdict) Policy {
+		return func(*Request) Verdict {
+			calls++
+			return v
+		}
+	}
+
+	tests := []struct {
+		name     string
+		policies []Policy
+		want     Verdict
+		calls    int
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "delays",
+			policies: []Policy{
+				count(Verdict{Delay: time.Second, Reason: "slow"}),
+				count(Verdict{}),
+				count(Verdict{Delay: time.Second, Reason: "slower"}),
+			},
+			want:  Verdict{Delay: 2 * time.Second, Reason: "slow, slower"},
+			calls: 3,
+		},
+		{
+			name: "drop",
+			policies: []Policy{
+				count(Verdict{Delay: time.Second}),
+				count(Verdict{Drop: true, Reason: "denied"}),
+				count(Verdict{}),
+			},
+			want:  Verdict{Drop: true, Delay: time.Second, Reason: "denied"},
+			calls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+
+			r := &Responder{policies: tt.policies}
+			v := r.evaluate(&Request{Echo: &icmp.Echo{}})
+
+			if diff := cmp.Diff(tt.want, v); diff != "" {
+				t.Fatalf("unexpected verdict (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.calls, calls); diff != "" {
+				t.Fatalf("unexpected number of policy calls (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
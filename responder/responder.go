@@ -0,0 +1,312 @@
+package responder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxDelayed is the maximum number of delayed replies which may be pending at
+// once. Requests which would exceed it are dropped.
+const maxDelayed = 1024
+
+// A Config configures a Responder. The zero value is valid and replies to
+// every echo request immediately.
+type Config struct {
+	// RateLimit is the maximum sustained number of echo replies per second
+	// sent to each source address, and Burst is the number of replies which
+	// may be sent to a source at once. Requests which exceed the limit are
+	// dropped. If RateLimit is zero, no rate limit is applied. If Burst is
+	// zero, it defaults to 1.
+	RateLimit float64
+	Burst     int
+
+	// Delay is an artificial delay applied to every echo reply, which is
+	// useful for emulating a distant host in tests. At most 1024 delayed
+	// replies may be pending at once, and any further requests are dropped.
+	Delay time.Duration
+
+	// Policies are evaluated for every echo request before the rate limit
+	// and delay.
+	Policies []Policy
+
+	// Logger, if set, logs every echo request and how it was handled, and
+	// any errors sending echo replies.
+	Logger *log.Logger
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg Config) withDefaults() (Config, error) {
+	if cfg.RateLimit < 0 {
+		return Config{}, fmt.Errorf("responder: rate limit must not be negative: %v", cfg.RateLimit)
+	}
+
+	if cfg.Burst == 0 {
+		cfg.Burst = 1
+	}
+	if cfg.Burst < 1 {
+		return Config{}, fmt.Errorf("responder: burst must be at least 1: %d", cfg.Burst)
+	}
+
+	if cfg.Delay < 0 {
+		return Config{}, fmt.Errorf("responder: delay must not be negative: %s", cfg.Delay)
+	}
+
+	return cfg, nil
+}
+
+// policies returns the Policies which apply cfg.
+func (cfg Config) policies() []Policy {
+	ps := append([]Policy(nil), cfg.Policies...)
+	if cfg.RateLimit > 0 {
+		ps = append(ps, RateLimit(cfg.RateLimit, cfg.Burst))
+	}
+	if cfg.Delay > 0 {
+		ps = append(ps, Delay(cfg.Delay))
+	}
+
+	return ps
+}
+
+// A Responder replies to ICMPv4/6 echo requests received on a network
+// interface.
+type Responder struct {
+	v4, v6   icmpx.Conn
+	policies []Policy
+	ll       *log.Logger
+
+	// delayed holds a token for each pending delayed reply.
+	delayed chan struct{}
+}
+
+// NewResponder binds a Responder on the specified network interface.
+func NewResponder(ifi *net.Interface, cfg Config) (*Responder, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	c4, err := icmpx.ListenIPv4(ifi, icmpx.IPv4Config{
+		Filter: icmpx.IPv4AllowOnly(ipv4.ICMPTypeEcho),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c6, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(ipv6.ICMPTypeEchoRequest),
+	})
+	if err != nil {
+		_ = c4.Close()
+		return nil, err
+	}
+
+	return newResponder(cfg, c4, c6), nil
+}
+
+// newResponder creates a Responder which replies to echo requests on raw
+// icmpx.Conns. cfg must already have its defaults applied.
+func newResponder(cfg Config, c4, c6 icmpx.Conn) *Responder {
+	return &Responder{
+		v4:       c4,
+		v6:       c6,
+		policies: cfg.policies(),
+		ll:       cfg.Logger,
+		delayed:  make(chan struct{}, maxDelayed),
+	}
+}
+
+// Close closes the Responder's underlying network connections. Close should be
+// called after Serve returns.
+func (r *Responder) Close() error {
+	if err := r.v4.Close(); err != nil {
+		_ = r.v6.Close()
+		return err
+	}
+
+	return r.v6.Close()
+}
+
+// Serve replies to echo requests until ctx is canceled. Any delayed replies
+// which have not been sent when ctx is canceled are discarded.
+func (r *Responder) Serve(ctx context.Context) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return r.serve(ctx, eg, r.v4, ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply)
+	})
+	eg.Go(func() error {
+		return r.serve(ctx, eg, r.v6, ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply)
+	})
+
+	return eg.Wait()
+}
+
+// serve replies to echo requests of type req on conn with echo replies of type
+// rep until ctx is canceled or an error occurs on conn. Delayed replies are
+// sent by goroutines in eg.
+func (r *Responder) serve(ctx context.Context, eg *errgroup.Group, conn icmpx.Conn, req, rep icmp.Type) error {
+	for {
+		msg, ip, err := conn.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || msg.Type != req {
+			continue
+		}
+		if !ip.IsValid() || ip.IsUnspecified() || ip.IsMulticast() {
+			continue
+		}
+
+		request := &Request{
+			IP:   ip,
+			Echo: echo,
+			Time: time.Now(),
+		}
+
+		v := r.evaluate(request)
+		if v.Delay > 0 && !v.Drop {
+			// Bound the memory used by a flood of delayed requests.
+			select {
+			case r.delayed <- struct{}{}:
+			default:
+				v.Drop = true
+				v.Reason = joinReasons(v.Reason, "too many delayed replies")
+			}
+		}
+
+		r.log(request, v)
+		if v.Drop {
+			continue
+		}
+
+		// The reply carries the same identifier, sequence number, and data
+		// as the request, as described in RFC 792 and RFC 4443.
+		reply := &icmp.Message{
+			Type: rep,
+			Body: &icmp.Echo{
+				ID:   echo.ID,
+				Seq:  echo.Seq,
+				Data: echo.Data,
+			},
+		}
+
+		if v.Delay == 0 {
+			if err := r.write(ctx, conn, reply, ip); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		eg.Go(func() error {
+			defer func() { <-r.delayed }()
+
+			t := time.NewTimer(v.Delay)
+			defer t.Stop()
+
+			select {
+			case <-t.C:
+				return r.write(ctx, conn, reply, ip)
+			case <-ctx.Done():
+				return nil
+			}
+		})
+	}
+}
+
+// write sends msg to ip on conn. It returns an error only if conn itself has
+// failed. Errors which only affect this reply, such as ip being unreachable,
+// are logged and the reply is dropped, so that no request can stop the
+// Responder.
+func (r *Responder) write(ctx context.Context, conn icmpx.Conn, msg *icmp.Message, ip netip.Addr) error {
+	err := conn.WriteTo(ctx, msg, ip)
+	switch {
+	case err == nil, ctx.Err() != nil:
+		return nil
+	case errors.Is(err, net.ErrClosed), errors.Is(err, os.ErrClosed), errors.Is(err, syscall.EBADF):
+		return err
+	}
+
+	if r.ll != nil {
+		r.ll.Printf("failed to send echo reply to %s: %v", ip, err)
+	}
+
+	return nil
+}
+
+// evaluate applies each of the Responder's Policies to req in order, stopping
+// at the first which drops req. Delays and reasons are accumulated.
+func (r *Responder) evaluate(req *Request) Verdict {
+	var (
+		v       Verdict
+		reasons []string
+	)
+
+	for _, p := range r.policies {
+		pv := p(req)
+		v.Delay += pv.Delay
+		if pv.Reason != "" {
+			reasons = append(reasons, pv.Reason)
+		}
+
+		if pv.Drop {
+			v.Drop = true
+			break
+		}
+	}
+
+	v.Reason = joinReasons(reasons...)
+	return v
+}
+
+// joinReasons joins the non-empty reasons for a Verdict.
+func joinReasons(reasons ...string) string {
+	var rs []string
+	for _, r := range reasons {
+		if r != "" {
+			rs = append(rs, r)
+		}
+	}
+
+	return strings.Join(rs, ", ")
+}
+
+// log logs req and its Verdict, if logging is enabled.
+func (r *Responder) log(req *Request, v Verdict) {
+	if r.ll == nil {
+		return
+	}
+
+	action := "replied"
+	switch {
+	case v.Drop:
+		action = "dropped"
+	case v.Delay > 0:
+		action = fmt.Sprintf("replied after %s", v.Delay)
+	}
+	if v.Reason != "" {
+		action += ": " + v.Reason
+	}
+
+	r.ll.Printf("echo request from %s: id %d, seq %d, %d bytes: %s",
+		req.IP, req.Echo.ID, req.Echo.Seq, len(req.Echo.Data), action)
+}
@@ -0,0 +1,82 @@
+package responder_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/echo"
+	"github.com/mdlayher/icmpx/internal/testns"
+	"github.com/mdlayher/icmpx/responder"
+)
+
+func TestMain(m *testing.M) { testns.Main(m) }
+
+func TestIntegrationResponderServe(t *testing.T) {
+	path := testns.Routers(t, 0)
+
+	const delay = 100 * time.Millisecond
+
+	// The target's kernel must not reply to echo requests, so that every
+	// reply is sent by the Responder.
+	var (
+		r   *responder.Responder
+		err error
+	)
+	path.InTarget(t, func(ifi *net.Interface) {
+		testns.Sysctl(t, "net/ipv4/icmp_echo_ignore_all", "1")
+		testns.Sysctl(t, "net/ipv6/icmp/echo_ignore_all", "1")
+
+		r, err = responder.NewResponder(ifi, responder.Config{Delay: delay})
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create responder: %v", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- r.Serve(ctx) }()
+
+	c, err := echo.NewClient(path.Interface, echo.Config{})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	for _, ip := range []netip.Addr{path.Target4, path.Target6} {
+		t.Run(ip.String(), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+
+			res, err := c.Ping(ctx, ip)
+			if err != nil {
+				t.Fatalf("failed to ping: %v", err)
+			}
+
+			if res.Duration < delay {
+				t.Fatalf("reply was received after %s, before the %s delay", res.Duration, delay)
+			}
+
+			if diff := cmp.Diff(res.Ping, res.Pong); diff != "" {
+				t.Fatalf("unexpected ping/pong (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	cancel()
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,412 @@
+package responder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestResponderServe(t *testing.T) {
+	var (
+		src4 = netip.MustParseAddr("192.0.2.1")
+		src6 = netip.MustParseAddr("2001:db8::1")
+		drop = netip.MustParseAddr("2001:db8::2")
+	)
+
+	var buf lockedBuffer
+	cfg, err := Config{
+		Policies: []Policy{func(req *Request) Verdict {
+			if req.IP != drop {
+				return Verdict{}
+			}
+
+			return Verdict{Drop: true, Reason: "denied"}
+		}},
+		Logger: log.New(&buf, "", 0),
+	}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	var (
+		c4 = newTestConn()
+		c6 = newTestConn()
+		r  = newResponder(cfg, c4, c6)
+	)
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- r.Serve(ctx) }()
+
+	// Messages which must be ignored: an echo reply, a request from a
+	// multicast source, and a request from a denied source.
+	c4.readC <- message{
+		Message: &icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1}},
+		IP:      src4,
+	}
+	c6.readC <- message{
+		Message: &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: 2}},
+		IP:      netip.MustParseAddr("ff02::1"),
+	}
+	c6.readC <- message{
+		Message: &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: 3}},
+		IP:      drop,
+	}
+
+	tests := []struct {
+		name     string
+		c        *testConn
+		req, rep icmp.Type
+		ip       netip.Addr
+	}{
+		{
+			name: "IPv4",
+			c:    c4,
+			req:  ipv4.ICMPTypeEcho,
+			rep:  ipv4.ICMPTypeEchoReply,
+			ip:   src4,
+		},
+		{
+			name: "IPv6",
+			c:    c6,
+			req:  ipv6.ICMPTypeEchoRequest,
+			rep:  ipv6.ICMPTypeEchoReply,
+			ip:   src6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := &icmp.Echo{ID: 10, Seq: 20, Data: []byte("hello world")}
+			tt.c.readC <- message{
+				Message: &icmp.Message{Type: tt.req, Body: echo},
+				IP:      tt.ip,
+			}
+
+			want := message{
+				Message: &icmp.Message{Type: tt.rep, Body: echo},
+				IP:      tt.ip,
+			}
+
+			if diff := cmp.Diff(want, <-tt.c.writeC, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected reply (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	cancel()
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+
+	select {
+	case m := <-c4.writeC:
+		t.Fatalf("unexpected IPv4 reply: %+v", m)
+	case m := <-c6.writeC:
+		t.Fatalf("unexpected IPv6 reply: %+v", m)
+	default:
+	}
+
+	// IPv4 and IPv6 requests are logged concurrently.
+	want := []string{
+		"echo request from 192.0.2.1: id 10, seq 20, 11 bytes: replied",
+		"echo request from 2001:db8::1: id 10, seq 20, 11 bytes: replied",
+		"echo request from 2001:db8::2: id 3, seq 0, 0 bytes: dropped: denied",
+	}
+
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	sort.Strings(got)
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected log (-want +got):\n%s", diff)
+	}
+}
+
+func TestResponderServeDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	cfg, err := Config{Delay: delay}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	var (
+		c4 = newTestConn()
+		c6 = newTestConn()
+		r  = newResponder(cfg, c4, c6)
+	)
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- r.Serve(ctx) }()
+
+	start := time.Now()
+	c6.readC <- message{
+		Message: &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: 1}},
+		IP:      netip.MustParseAddr("2001:db8::1"),
+	}
+
+	<-c6.writeC
+	if d := time.Since(start); d < delay {
+		t.Fatalf("reply was sent after %s, before the %s delay", d, delay)
+	}
+
+	// Canceling Serve discards any pending replies.
+	c6.readC <- message{
+		Message: &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: 2}},
+		IP:      netip.MustParseAddr("2001:db8::1"),
+	}
+
+	cancel()
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+
+	select {
+	case m := <-c6.writeC:
+		t.Fatalf("unexpected reply: %+v", m)
+	default:
+	}
+}
+
+func TestResponderServeWriteError(t *testing.T) {
+	var (
+		unreachable = netip.MustParseAddr("2001:db8::1")
+		reachable   = netip.MustParseAddr("2001:db8::2")
+	)
+
+	var buf lockedBuffer
+	cfg, err := Config{Logger: log.New(&buf, "", 0)}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	var (
+		c4 = newTestConn()
+		c6 = newTestConn()
+		r  = newResponder(cfg, c4, c6)
+	)
+	defer r.Close()
+
+	c6.writeErr = func(dst netip.Addr) error {
+		switch dst {
+		case unreachable:
+			return syscall.EHOSTUNREACH
+		case reachable:
+			return nil
+		default:
+			return net.ErrClosed
+		}
+	}
+
+	errC := make(chan error, 1)
+	go func() { errC <- r.Serve(context.Background()) }()
+
+	// Failing to reply to one source does not stop the Responder.
+	for _, ip := range []netip.Addr{unreachable, reachable} {
+		c6.readC <- message{
+			Message: &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: 1}},
+			IP:      ip,
+		}
+	}
+
+	if diff := cmp.Diff(reachable, (<-c6.writeC).IP, cmp.Comparer(ipEqual)); diff != "" {
+		t.Fatalf("unexpected reply destination (-want +got):\n%s", diff)
+	}
+
+	if !strings.Contains(buf.String(), "failed to send echo reply to 2001:db8::1") {
+		t.Fatalf("send error was not logged:\n%s", buf.String())
+	}
+
+	// An error from the socket itself stops the Responder.
+	c6.readC <- message{
+		Message: &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: 1}},
+		IP:      netip.MustParseAddr("2001:db8::3"),
+	}
+
+	if err := <-errC; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected closed error, but got: %v", err)
+	}
+}
+
+func TestResponderServeMaxDelayed(t *testing.T) {
+	var buf lockedBuffer
+	cfg, err := Config{
+		Delay:  time.Hour,
+		Logger: log.New(&buf, "", 0),
+	}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	var (
+		c4 = newTestConn()
+		c6 = newTestConn()
+		r  = newResponder(cfg, c4, c6)
+	)
+	defer r.Close()
+
+	// Only allow a single pending delayed reply.
+	r.delayed = make(chan struct{}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- r.Serve(ctx) }()
+
+	// The Responder has processed each request once it reads the next, so the
+	// final request ensures the others were handled before cancelation.
+	for i := 0; i < 3; i++ {
+		c6.readC <- message{
+			Message: &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: i}},
+			IP:      netip.MustParseAddr("2001:db8::1"),
+		}
+	}
+
+	cancel()
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+
+	want := []string{
+		"echo request from 2001:db8::1: id 0, seq 0, 0 bytes: replied after 1h0m0s",
+		"echo request from 2001:db8::1: id 1, seq 0, 0 bytes: dropped: too many delayed replies",
+	}
+
+	// The final request may or may not be handled before cancelation.
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	got = got[:len(want)]
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected log (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{RateLimit: -1},
+		{Burst: -1},
+		{Delay: -1},
+	} {
+		if _, err := cfg.withDefaults(); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+
+	cfg, err := Config{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	if diff := cmp.Diff(Config{Burst: 1}, cfg); diff != "" {
+		t.Fatalf("unexpected config (-want +got):\n%s", diff)
+	}
+}
+
+var _ icmpx.Conn = &testConn{}
+
+// A testConn is an icmpx.Conn which reads messages from readC and writes them
+// to writeC. If writeErr is set, writes fail with the error it returns for
+// each destination.
+type testConn struct {
+	readC    chan message
+	writeC   chan message
+	writeErr func(dst netip.Addr) error
+}
+
+// A message is an ICMP message and its source or destination address.
+type message struct {
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+func newTestConn() *testConn {
+	return &testConn{
+		readC:  make(chan message),
+		writeC: make(chan message, 8),
+	}
+}
+
+func (*testConn) Close() error { return nil }
+
+func (c *testConn) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-c.readC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (c *testConn) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	if c.writeErr != nil {
+		if err := c.writeErr(dst); err != nil {
+			return err
+		}
+	}
+
+	proto := 1
+	if dst.Is6() {
+		proto = 58
+	}
+
+	// Send the message over the "wire" to exercise marshaling.
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	im, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return err
+	}
+
+	// Checksums are not relevant to comparisons.
+	im.Checksum = 0
+
+	c.writeC <- message{Message: im, IP: dst}
+	return nil
+}
+
+// A lockedBuffer is a bytes.Buffer which is safe for concurrent use.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.b.String()
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
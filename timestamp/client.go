@@ -0,0 +1,281 @@
+package timestamp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sync/errgroup"
+)
+
+// A Client sends ICMPv4 timestamp requests to estimate the clock offset and
+// one-way delays of remote hosts.
+type Client struct {
+	// Manages the underlying socket.
+	conn icmpx.Conn
+
+	// Manages the concurrency of the Client.
+	eg     *errgroup.Group
+	cancel context.CancelFunc
+
+	// Manages dispatching replies to in-flight queries by identifier.
+	mu      sync.Mutex
+	queries map[int]chan reply
+
+	// Swappable parameters for testing.
+	retryDelay time.Duration
+}
+
+// NewClient binds a Client on the specified network interface.
+func NewClient(ifi *net.Interface) (*Client, error) {
+	conn, err := icmpx.ListenIPv4(ifi, icmpx.IPv4Config{
+		Filter: icmpx.IPv4AllowOnly(ipv4.ICMPTypeTimestampReply),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(conn), nil
+}
+
+// A reply contains a timestamp reply to dispatch to a listener.
+type reply struct {
+	Message *Message
+	IP      netip.Addr
+	Time    time.Time
+}
+
+// newClient constructs a Client from a raw icmpx.Conn, starting its background
+// goroutines.
+func newClient(conn icmpx.Conn) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+
+	c := &Client{
+		conn: conn,
+
+		eg:     eg,
+		cancel: cancel,
+
+		queries: make(map[int]chan reply),
+
+		// By default, we try sending another request after 1 second has
+		// elapsed without a reply to a prior attempt.
+		retryDelay: 1 * time.Second,
+	}
+
+	eg.Go(func() error { return c.readLoop(ctx) })
+
+	return c
+}
+
+// Close stops the Client's background goroutines and closes its underlying
+// network connection.
+func (c *Client) Close() error {
+	c.cancel()
+	if err := c.eg.Wait(); err != nil {
+		_ = c.conn.Close()
+		return err
+	}
+
+	return c.conn.Close()
+}
+
+// A Response is the result of a Client.Query operation.
+type Response struct {
+	// Duration reports how much time elapsed during the final timestamp
+	// request and reply cycle.
+	Duration time.Duration
+
+	// Request and Reply are the raw timestamp messages sent by the Client
+	// and received from the target host.
+	Request, Reply *Message
+
+	// Sample is the clock offset and delay computed from the exchange.
+	Sample Sample
+
+	// IP is the IPv4 address of the target host.
+	IP netip.Addr
+}
+
+// Query sends an ICMPv4 timestamp request to a target host and computes a
+// Sample from its reply. Query retries until a reply is received or ctx is
+// canceled.
+func (c *Client) Query(ctx context.Context, dst netip.Addr) (*Response, error) {
+	if !dst.Is4() {
+		return nil, errors.New("timestamp: target must be an IPv4 address")
+	}
+
+	id, replyC, err := c.register()
+	if err != nil {
+		return nil, err
+	}
+	defer c.unregister(id)
+
+	// It may take more than one attempt for a request to succeed, so send
+	// them at regular intervals until a reply is received.
+	for seq := 1; ; seq = (seq + 1) & 0xffff {
+		switch res, err := c.doQuery(ctx, dst, id, seq, replyC); {
+		case err == nil:
+			return res, nil
+		case errors.Is(err, errRetry):
+			// Timed out waiting for a reply. Try again.
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
+
+// errRetry is a sentinel error indicating the caller should retry an operation.
+var errRetry = errors.New("retry")
+
+// doQuery performs a single timestamp request/reply cycle with a short timeout.
+// If the query does not receive a timely reply, it returns errRetry.
+func (c *Client) doQuery(
+	ctx context.Context,
+	dst netip.Addr,
+	id, seq int,
+	replyC <-chan reply,
+) (*Response, error) {
+	start := time.Now()
+	req := &Message{
+		ID:        id,
+		Seq:       seq,
+		Originate: TimeOf(start),
+	}
+
+	msg := &icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Body: req,
+	}
+
+	if err := c.conn.WriteTo(ctx, msg, dst); err != nil {
+		return nil, err
+	}
+
+	t := time.NewTimer(c.retryDelay)
+	defer t.Stop()
+
+	for {
+		select {
+		case r := <-replyC:
+			if r.Message.Seq != seq {
+				// Late reply to an earlier attempt.
+				continue
+			}
+
+			return &Response{
+				Duration: r.Time.Sub(start),
+				Request:  req,
+				Reply:    r.Message,
+				Sample:   newSample(start, r.Message, r.Time),
+				IP:       r.IP,
+			}, nil
+		case <-t.C:
+			return nil, errRetry
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Estimate sends n sequential timestamp requests to a target host and
+// aggregates the resulting Samples.
+func (c *Client) Estimate(ctx context.Context, dst netip.Addr, n int) (*Estimate, error) {
+	if n < 1 {
+		return nil, errors.New("timestamp: at least one sample is required")
+	}
+
+	samples := make([]Sample, 0, n)
+	for i := 0; i < n; i++ {
+		res, err := c.Query(ctx, dst)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, res.Sample)
+	}
+
+	return Aggregate(samples)
+}
+
+// register allocates a unique identifier and reply channel for a query.
+func (c *Client) register() (int, <-chan reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := make([]byte, 2)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 0, nil, err
+		}
+
+		id := int(binary.BigEndian.Uint16(b))
+		if _, ok := c.queries[id]; ok {
+			continue
+		}
+
+		replyC := make(chan reply, 1)
+		c.queries[id] = replyC
+		return id, replyC, nil
+	}
+}
+
+// unregister removes the reply channel for a query identifier.
+func (c *Client) unregister(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.queries, id)
+}
+
+// readLoop manages the ICMPv4 timestamp reading goroutine until ctx is
+// canceled.
+func (c *Client) readLoop(ctx context.Context) error {
+	for {
+		msg, ip, err := c.conn.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		// Record the receive time as early as possible.
+		now := time.Now()
+
+		if msg.Type != ipv4.ICMPTypeTimestampReply {
+			continue
+		}
+
+		m, err := ParseMessage(msg)
+		if err != nil {
+			// Malformed reply.
+			continue
+		}
+
+		c.mu.Lock()
+		replyC, ok := c.queries[m.ID]
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		// Never block the reader; a caller which already has a reply
+		// pending does not need another.
+		select {
+		case replyC <- reply{Message: m, IP: ip, Time: now}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package timestamp_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/timestamp"
+	"golang.org/x/net/nettest"
+)
+
+func TestIntegrationClient(t *testing.T) {
+	t.Parallel()
+
+	lo, err := nettest.LoopbackInterface()
+	if err != nil {
+		t.Fatalf("failed to find loopback: %v", err)
+	}
+
+	c, err := timestamp.NewClient(lo)
+	if err != nil {
+		// ICMP sockets require elevated privileges.
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ip := netip.MustParseAddr("127.0.0.1")
+	e, err := c.Estimate(ctx, ip, 3)
+	if err != nil {
+		t.Fatalf("failed to estimate: %v", err)
+	}
+
+	if diff := cmp.Diff(3, e.Samples); diff != "" {
+		t.Fatalf("unexpected number of samples (-want +got):\n%s", diff)
+	}
+
+	// The kernel shares our clock, so only the millisecond resolution of the
+	// timestamps should contribute to the offset.
+	if e.Offset < -10*time.Millisecond || e.Offset > 10*time.Millisecond {
+		t.Fatalf("unexpected offset: %v", e.Offset)
+	}
+}
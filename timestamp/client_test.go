@@ -0,0 +1,277 @@
+package timestamp
+
+import (
+	"context"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	want := &Message{
+		ID:        1,
+		Seq:       2,
+		Originate: 1000,
+		Receive:   nonStandard | 2000,
+		Transmit:  3000,
+	}
+
+	b, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeTimestampReply,
+		Body: want,
+	}).Marshal(nil)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	m, err := icmp.ParseMessage(1, b)
+	if err != nil {
+		t.Fatalf("failed to parse ICMP message: %v", err)
+	}
+
+	got, err := ParseMessage(m)
+	if err != nil {
+		t.Fatalf("failed to parse timestamp message: %v", err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected message (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		skew        time.Duration
+		nonStandard bool
+	}{
+		{
+			name: "synchronized",
+		},
+		{
+			name: "ahead",
+			skew: 3 * time.Second,
+		},
+		{
+			name: "behind",
+			skew: -90 * time.Minute,
+		},
+		{
+			name:        "non-standard",
+			nonStandard: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHost(t, netip.MustParseAddr("192.0.2.1"))
+			h.Skew = tt.skew
+			h.NonStandard = tt.nonStandard
+
+			c := testClient(t, h)
+
+			res, err := c.Query(context.Background(), h.IP)
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.nonStandard, res.Sample.NonStandard); diff != "" {
+				t.Fatalf("unexpected non-standard flag (-want +got):\n%s", diff)
+			}
+
+			// Allow for the millisecond resolution of Times and scheduling
+			// delays in the emulated host. Non-standard replies report no
+			// offset at all.
+			if d := res.Sample.Offset - tt.skew; d < -50*time.Millisecond || d > 50*time.Millisecond {
+				t.Fatalf("unexpected offset: %v", res.Sample.Offset)
+			}
+
+			if diff := cmp.Diff(res.Request.ID, res.Reply.ID); diff != "" {
+				t.Fatalf("unexpected reply ID (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(res.Request.Originate, res.Reply.Originate); diff != "" {
+				t.Fatalf("unexpected reply originate time (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientQueryRetry(t *testing.T) {
+	// Emulate a host that drops the first request, forcing the Client to
+	// retry with the next sequence number.
+	h := newTestHost(t, netip.MustParseAddr("192.0.2.1"))
+
+	var recv atomic.Bool
+	h.Drop = func(_ *Message) bool { return !recv.Swap(true) }
+
+	c := testClient(t, h)
+
+	res, err := c.Query(context.Background(), h.IP)
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+
+	if diff := cmp.Diff(2, res.Reply.Seq); diff != "" {
+		t.Fatalf("unexpected reply sequence (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientEstimate(t *testing.T) {
+	h := newTestHost(t, netip.MustParseAddr("192.0.2.1"))
+	h.Skew = -1 * time.Second
+
+	c := testClient(t, h)
+
+	e, err := c.Estimate(context.Background(), h.IP, 5)
+	if err != nil {
+		t.Fatalf("failed to estimate: %v", err)
+	}
+
+	if diff := cmp.Diff(5, e.Samples); diff != "" {
+		t.Fatalf("unexpected number of samples (-want +got):\n%s", diff)
+	}
+
+	if d := e.Offset - h.Skew; d < -50*time.Millisecond || d > 50*time.Millisecond {
+		t.Fatalf("unexpected offset: %v", e.Offset)
+	}
+}
+
+// testClient sets up a Client that talks to an emulated host.
+func testClient(t *testing.T, h *testHost) *Client {
+	t.Helper()
+
+	c := newClient(h)
+
+	// Speed up retries for tests.
+	c.retryDelay = 100 * time.Millisecond
+
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Fatalf("failed to clean up client: %v", err)
+		}
+	})
+
+	return c
+}
+
+var _ icmpx.Conn = &testHost{}
+
+// A testHost implements icmpx.Conn by emulating a host that replies to ICMPv4
+// timestamp requests using a clock which differs from the local clock by Skew.
+type testHost struct {
+	IP          netip.Addr
+	Skew        time.Duration
+	NonStandard bool
+	Drop        func(req *Message) bool
+
+	reqC, resC chan message
+}
+
+type message struct {
+	Message *icmp.Message
+	Host    netip.Addr
+}
+
+func newTestHost(t *testing.T, ip netip.Addr) *testHost {
+	t.Helper()
+
+	h := &testHost{
+		IP:   ip,
+		reqC: make(chan message, 1),
+		resC: make(chan message, 1),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error { return h.run(ctx) })
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := eg.Wait(); err != nil {
+			t.Fatalf("failed to stop testHost: %v", err)
+		}
+	})
+
+	return h
+}
+
+func (h *testHost) run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case req := <-h.reqC:
+			recv := h.now()
+
+			// Send the request over the "wire" to exercise marshaling.
+			b, err := req.Message.Marshal(nil)
+			if err != nil {
+				return err
+			}
+			m, err := icmp.ParseMessage(1, b)
+			if err != nil {
+				return err
+			}
+			ts, err := ParseMessage(m)
+			if err != nil {
+				return err
+			}
+
+			if h.Drop != nil && h.Drop(ts) {
+				continue
+			}
+
+			ts.Receive, ts.Transmit = recv, h.now()
+
+			h.resC <- message{
+				Message: &icmp.Message{
+					Type: ipv4.ICMPTypeTimestampReply,
+					Body: ts,
+				},
+				Host: req.Host,
+			}
+		}
+	}
+}
+
+// now returns the current time according to the testHost's clock.
+func (h *testHost) now() Time {
+	if h.NonStandard {
+		// Milliseconds since some arbitrary epoch.
+		return nonStandard | Time(time.Now().UnixMilli()&0x7fffffff)
+	}
+
+	return TimeOf(time.Now().Add(h.Skew))
+}
+
+func (*testHost) Close() error { return nil }
+
+func (h *testHost) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-h.resC:
+		return m.Message, m.Host, nil
+	}
+}
+
+func (h *testHost) WriteTo(ctx context.Context, msg *icmp.Message, dst netip.Addr) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case h.reqC <- message{
+		Message: msg,
+		Host:    dst,
+	}:
+		return nil
+	}
+}
@@ -0,0 +1,3 @@
+// Package timestamp implements an ICMPv4 timestamp client which estimates
+// one-way delays and the clock offset of a remote host, as described in RFC 792.
+package timestamp
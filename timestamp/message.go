@@ -0,0 +1,111 @@
+package timestamp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// messageLen is the length of a timestamp message body.
+const messageLen = 16
+
+// nonStandard is the high bit which indicates a non-standard Time.
+const nonStandard = 1 << 31
+
+// A Time is an ICMPv4 timestamp: the number of milliseconds since midnight UT.
+// A host which cannot provide such a value may instead set the high bit and
+// use any time value it likes.
+type Time uint32
+
+// TimeOf returns the Time for t.
+func TimeOf(t time.Time) Time {
+	return Time(sinceMidnight(t) / time.Millisecond)
+}
+
+// NonStandard reports whether the high bit is set, indicating that t is not
+// measured in milliseconds since midnight UT.
+func (t Time) NonStandard() bool { return t&nonStandard != 0 }
+
+// Duration returns t as a time.Duration with the high bit cleared. For a
+// standard Time, this is the time elapsed since midnight UT.
+func (t Time) Duration() time.Duration {
+	return time.Duration(t&^nonStandard) * time.Millisecond
+}
+
+// String returns the string representation of t.
+func (t Time) String() string {
+	if t.NonStandard() {
+		return fmt.Sprintf("%d (non-standard)", uint32(t&^nonStandard))
+	}
+
+	return t.Duration().String()
+}
+
+var _ icmp.MessageBody = &Message{}
+
+// A Message is an ICMPv4 Timestamp or Timestamp Reply message body.
+type Message struct {
+	ID, Seq int
+
+	// Originate is the time the sender last touched the request, Receive is
+	// the time the echoer first touched it, and Transmit is the time the
+	// echoer last touched the reply.
+	Originate, Receive, Transmit Time
+}
+
+// Len implements icmp.MessageBody.
+func (*Message) Len(_ int) int { return messageLen }
+
+// Marshal implements icmp.MessageBody.
+func (m *Message) Marshal(_ int) ([]byte, error) {
+	b := make([]byte, messageLen)
+	binary.BigEndian.PutUint16(b[0:2], uint16(m.ID))
+	binary.BigEndian.PutUint16(b[2:4], uint16(m.Seq))
+	binary.BigEndian.PutUint32(b[4:8], uint32(m.Originate))
+	binary.BigEndian.PutUint32(b[8:12], uint32(m.Receive))
+	binary.BigEndian.PutUint32(b[12:16], uint32(m.Transmit))
+
+	return b, nil
+}
+
+// ParseMessage parses a Message from the body of an ICMPv4 Timestamp or
+// Timestamp Reply message produced by icmp.ParseMessage.
+func ParseMessage(m *icmp.Message) (*Message, error) {
+	switch m.Type {
+	case ipv4.ICMPTypeTimestamp, ipv4.ICMPTypeTimestampReply:
+	default:
+		return nil, fmt.Errorf("timestamp: unexpected ICMP type: %v", m.Type)
+	}
+
+	var b []byte
+	switch body := m.Body.(type) {
+	case *Message:
+		return body, nil
+	case *icmp.RawBody:
+		b = body.Data
+	default:
+		return nil, fmt.Errorf("timestamp: unexpected message body: %T", m.Body)
+	}
+
+	if len(b) < messageLen {
+		return nil, errors.New("timestamp: message too short")
+	}
+
+	return &Message{
+		ID:        int(binary.BigEndian.Uint16(b[0:2])),
+		Seq:       int(binary.BigEndian.Uint16(b[2:4])),
+		Originate: Time(binary.BigEndian.Uint32(b[4:8])),
+		Receive:   Time(binary.BigEndian.Uint32(b[8:12])),
+		Transmit:  Time(binary.BigEndian.Uint32(b[12:16])),
+	}, nil
+}
+
+// sinceMidnight returns the time elapsed between midnight UT and t.
+func sinceMidnight(t time.Time) time.Duration {
+	t = t.UTC()
+	return t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+}
@@ -0,0 +1,147 @@
+package timestamp
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// day is the period after which standard Times wrap.
+const day = 24 * time.Hour
+
+// A Sample is the clock offset and delay computed from a single timestamp
+// request and reply exchange.
+type Sample struct {
+	// Offset is the estimated offset of the remote clock relative to the
+	// local clock.
+	Offset time.Duration
+
+	// Delay is the round-trip network delay, excluding the time the remote
+	// host spent processing the request.
+	Delay time.Duration
+
+	// Forward and Reverse are the one-way delays from the local host to the
+	// remote host and back. They include the clock offset, and so are only
+	// meaningful when the clocks are synchronized.
+	Forward, Reverse time.Duration
+
+	// NonStandard reports whether the remote host replied with non-standard
+	// Times. If set, only Delay is computed.
+	NonStandard bool
+}
+
+// newSample computes a Sample from the local send time t1, the reply, and the
+// local receive time t4.
+func newSample(t1 time.Time, rep *Message, t4 time.Time) Sample {
+	var (
+		rtt = t4.Sub(t1)
+		s   = Sample{NonStandard: rep.Receive.NonStandard() || rep.Transmit.NonStandard()}
+	)
+
+	if s.NonStandard {
+		// The remote clock cannot be compared to ours, but if both Times come
+		// from the same non-standard clock, the processing time can still be
+		// subtracted from the round-trip time.
+		if rep.Receive.NonStandard() && rep.Transmit.NonStandard() {
+			proc := (uint32(rep.Transmit) - uint32(rep.Receive)) &^ nonStandard
+			rtt -= time.Duration(proc) * time.Millisecond
+		}
+
+		s.Delay = rtt
+		return s
+	}
+
+	var (
+		local1 = sinceMidnight(t1)
+		t2     = rep.Receive.Duration()
+		t3     = rep.Transmit.Duration()
+		local4 = local1 + rtt
+	)
+
+	s.Forward = wrap(t2 - local1)
+	s.Reverse = wrap(local4 - t3)
+	s.Offset = (s.Forward - s.Reverse) / 2
+	s.Delay = rtt - wrap(t3-t2)
+
+	return s
+}
+
+// wrap normalizes a difference between two standard Times into the range
+// (-12h, 12h], accounting for Times which wrap at midnight.
+func wrap(d time.Duration) time.Duration {
+	d %= day
+	switch {
+	case d > day/2:
+		d -= day
+	case d <= -day/2:
+		d += day
+	}
+
+	return d
+}
+
+// An Estimate is the result of aggregating multiple Samples.
+type Estimate struct {
+	// Offset, Delay, Forward, and Reverse are taken from the Sample with the
+	// lowest Delay, which is least affected by queuing delays.
+	Offset, Delay    time.Duration
+	Forward, Reverse time.Duration
+
+	// Jitter is the root mean square difference between the Offsets of the
+	// other Samples and the chosen Offset.
+	Jitter time.Duration
+
+	// Samples is the number of Samples which contributed to the Estimate.
+	Samples int
+}
+
+// errNoSamples indicates that no standard Samples were available.
+var errNoSamples = errors.New("timestamp: no samples with standard times")
+
+// Aggregate combines Samples in the manner of the NTP clock filter: the Sample
+// with the lowest round-trip delay is chosen as the best estimate and the
+// remaining Samples determine the jitter. NonStandard Samples are ignored.
+func Aggregate(samples []Sample) (*Estimate, error) {
+	var (
+		best Sample
+		n    int
+	)
+
+	for _, s := range samples {
+		if s.NonStandard {
+			continue
+		}
+		if n == 0 || s.Delay < best.Delay {
+			best = s
+		}
+		n++
+	}
+	if n == 0 {
+		return nil, errNoSamples
+	}
+
+	e := &Estimate{
+		Offset:  best.Offset,
+		Delay:   best.Delay,
+		Forward: best.Forward,
+		Reverse: best.Reverse,
+		Samples: n,
+	}
+	if n == 1 {
+		return e, nil
+	}
+
+	// The chosen Sample contributes nothing to the sum.
+	var sum float64
+	for _, s := range samples {
+		if s.NonStandard {
+			continue
+		}
+
+		d := float64(s.Offset - best.Offset)
+		sum += d * d
+	}
+
+	e.Jitter = time.Duration(math.Sqrt(sum / float64(n-1)))
+	return e, nil
+}
@@ -0,0 +1,188 @@
+package timestamp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewSample(t *testing.T) {
+	const ms = time.Millisecond
+
+	var (
+		noon   = time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+		before = time.Date(2020, time.January, 1, 23, 59, 59, 995_000_000, time.UTC)
+	)
+
+	tests := []struct {
+		name   string
+		t1     time.Time
+		rep    *Message
+		t4     time.Time
+		sample Sample
+	}{
+		{
+			name: "synchronized",
+			t1:   noon,
+			rep: &Message{
+				Receive:  TimeOf(noon.Add(10 * ms)),
+				Transmit: TimeOf(noon.Add(11 * ms)),
+			},
+			t4: noon.Add(21 * ms),
+			sample: Sample{
+				Delay:   20 * ms,
+				Forward: 10 * ms,
+				Reverse: 10 * ms,
+			},
+		},
+		{
+			name: "remote ahead",
+			t1:   noon,
+			rep: &Message{
+				Receive:  TimeOf(noon.Add(5*time.Second + 10*ms)),
+				Transmit: TimeOf(noon.Add(5*time.Second + 10*ms)),
+			},
+			t4: noon.Add(20 * ms),
+			sample: Sample{
+				Offset:  5 * time.Second,
+				Delay:   20 * ms,
+				Forward: 5*time.Second + 10*ms,
+				Reverse: -5*time.Second + 10*ms,
+			},
+		},
+		{
+			name: "remote behind across midnight",
+			t1:   before,
+			rep: &Message{
+				// The remote clock has already wrapped past midnight.
+				Receive:  TimeOf(before.Add(10*ms - 2*time.Second)),
+				Transmit: TimeOf(before.Add(10*ms - 2*time.Second)),
+			},
+			t4: before.Add(20 * ms),
+			sample: Sample{
+				Offset:  -2 * time.Second,
+				Delay:   20 * ms,
+				Forward: -2*time.Second + 10*ms,
+				Reverse: 2*time.Second + 10*ms,
+			},
+		},
+		{
+			name: "local across midnight",
+			t1:   before,
+			rep: &Message{
+				Receive:  TimeOf(before.Add(10 * ms)),
+				Transmit: TimeOf(before.Add(10 * ms)),
+			},
+			t4: before.Add(20 * ms),
+			sample: Sample{
+				Delay:   20 * ms,
+				Forward: 10 * ms,
+				Reverse: 10 * ms,
+			},
+		},
+		{
+			name: "non-standard",
+			t1:   noon,
+			rep: &Message{
+				Receive:  nonStandard | 1000,
+				Transmit: nonStandard | 1005,
+			},
+			t4: noon.Add(25 * ms),
+			sample: Sample{
+				Delay:       20 * ms,
+				NonStandard: true,
+			},
+		},
+		{
+			name: "partially non-standard",
+			t1:   noon,
+			rep: &Message{
+				Receive:  TimeOf(noon.Add(10 * ms)),
+				Transmit: nonStandard | 1005,
+			},
+			t4: noon.Add(25 * ms),
+			sample: Sample{
+				Delay:       25 * ms,
+				NonStandard: true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newSample(tt.t1, tt.rep, tt.t4)
+			if diff := cmp.Diff(tt.sample, got); diff != "" {
+				t.Fatalf("unexpected sample (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name    string
+		samples []Sample
+		e       *Estimate
+		ok      bool
+	}{
+		{
+			name: "no samples",
+		},
+		{
+			name:    "only non-standard",
+			samples: []Sample{{Delay: 1 * ms, NonStandard: true}},
+		},
+		{
+			name:    "one",
+			samples: []Sample{{Offset: 3 * ms, Delay: 2 * ms, Forward: 4 * ms, Reverse: -2 * ms}},
+			e: &Estimate{
+				Offset:  3 * ms,
+				Delay:   2 * ms,
+				Forward: 4 * ms,
+				Reverse: -2 * ms,
+				Samples: 1,
+			},
+			ok: true,
+		},
+		{
+			name: "minimum delay",
+			samples: []Sample{
+				{Offset: 10 * ms, Delay: 30 * ms},
+				{Offset: 1 * ms, Delay: 2 * ms},
+				{Offset: 0, Delay: 1 * ms, NonStandard: true},
+				{Offset: -2 * ms, Delay: 8 * ms},
+			},
+			e: &Estimate{
+				Offset: 1 * ms,
+				Delay:  2 * ms,
+				// sqrt(((9ms)² + (-3ms)²) / 2)
+				Jitter:  6708203,
+				Samples: 3,
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Aggregate(tt.samples)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to aggregate: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.e, e); diff != "" {
+				t.Fatalf("unexpected estimate (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,563 @@
+package traceroute
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/icmpx"
+	"github.com/mdlayher/icmpx/quoted"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// Default Config values, matching those of traceroute(8).
+const (
+	defaultFirstHop = 1
+	defaultMaxHops  = 30
+	defaultProbes   = 3
+	defaultParallel = 16
+	defaultTimeout  = 5 * time.Second
+
+	defaultConfidence = 0.95
+	defaultMaxFlows   = 256
+
+	maxHops   = 255
+	maxProbes = 10
+	maxFlows  = 0xffff
+)
+
+// A Config configures a Client. The zero value is valid and uses the same
+// defaults as traceroute(8).
+type Config struct {
+	// FirstHop and MaxHops are the first and last TTL or hop limit values
+	// probed. If zero, FirstHop defaults to 1 and MaxHops defaults to 30.
+	FirstHop, MaxHops int
+
+	// Probes is the number of probes sent for each hop. If zero, it
+	// defaults to 3.
+	Probes int
+
+	// Parallel is the maximum number of probes in flight at once. If zero,
+	// it defaults to 16. A value of 1 probes each hop in turn.
+	Parallel int
+
+	// Timeout is how long to wait for a reply to each probe. If zero, it
+	// defaults to 5 seconds.
+	Timeout time.Duration
+
+	// Paris enables flow-stable probing for Trace. If true, the payload of
+	// each echo request is chosen so that the ICMP checksum is constant for
+	// every probe, and per-flow load balancers forward every probe along the
+	// same path. Multipath always uses flow-stable probes.
+	Paris bool
+
+	// Confidence is the probability with which Multipath discovers every
+	// load balanced next hop of each interface. If zero, it defaults to 0.95.
+	Confidence float64
+
+	// MaxFlows is the maximum number of distinct flows probed by Multipath.
+	// If zero, it defaults to 256.
+	MaxFlows int
+}
+
+// withDefaults validates cfg and returns a copy with defaults applied.
+func (cfg Config) withDefaults() (Config, error) {
+	if cfg.FirstHop == 0 {
+		cfg.FirstHop = defaultFirstHop
+	}
+	if cfg.MaxHops == 0 {
+		cfg.MaxHops = defaultMaxHops
+	}
+	if cfg.FirstHop < 1 || cfg.FirstHop > cfg.MaxHops || cfg.MaxHops > maxHops {
+		return Config{}, fmt.Errorf("traceroute: hops must be between 1 and %d: first %d, max %d",
+			maxHops, cfg.FirstHop, cfg.MaxHops)
+	}
+
+	if cfg.Probes == 0 {
+		cfg.Probes = defaultProbes
+	}
+	if cfg.Probes < 1 || cfg.Probes > maxProbes {
+		return Config{}, fmt.Errorf("traceroute: probes per hop must be between 1 and %d: %d", maxProbes, cfg.Probes)
+	}
+
+	if cfg.Parallel == 0 {
+		cfg.Parallel = defaultParallel
+	}
+	if cfg.Parallel < 1 {
+		return Config{}, fmt.Errorf("traceroute: parallel probes must be at least 1: %d", cfg.Parallel)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Timeout < 0 {
+		return Config{}, fmt.Errorf("traceroute: timeout must be positive: %s", cfg.Timeout)
+	}
+
+	if cfg.Confidence == 0 {
+		cfg.Confidence = defaultConfidence
+	}
+	if cfg.Confidence <= 0 || cfg.Confidence >= 1 {
+		return Config{}, fmt.Errorf("traceroute: confidence must be between 0 and 1: %v", cfg.Confidence)
+	}
+
+	if cfg.MaxFlows == 0 {
+		cfg.MaxFlows = defaultMaxFlows
+	}
+	if cfg.MaxFlows < 1 || cfg.MaxFlows > maxFlows {
+		return Config{}, fmt.Errorf("traceroute: flows must be between 1 and %d: %d", maxFlows, cfg.MaxFlows)
+	}
+
+	return cfg, nil
+}
+
+// A Client sends ICMPv4/6 echo requests with increasing TTL or hop limit
+// values to perform traceroute operations.
+type Client struct {
+	cfg    Config
+	v4, v6 *connContext
+}
+
+// NewClient binds a Client on the specified network interface.
+func NewClient(ifi *net.Interface, cfg Config) (*Client, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	c4, err := icmpx.ListenIPv4(ifi, icmpx.IPv4Config{
+		Filter: icmpx.IPv4AllowOnly(
+			ipv4.ICMPTypeEchoReply,
+			ipv4.ICMPTypeTimeExceeded,
+			ipv4.ICMPTypeDestinationUnreachable,
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c6, err := icmpx.ListenIPv6(ifi, icmpx.IPv6Config{
+		Filter: icmpx.IPv6AllowOnly(
+			ipv6.ICMPTypeEchoReply,
+			ipv6.ICMPTypeTimeExceeded,
+			ipv6.ICMPTypeDestinationUnreachable,
+		),
+	})
+	if err != nil {
+		_ = c4.Close()
+		return nil, err
+	}
+
+	c, err := newClient(cfg, c4, c4.SetTTL, c6, c6.SetHopLimit)
+	if err != nil {
+		_ = c4.Close()
+		_ = c6.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newClient constructs a Client from raw icmpx.Conns and functions which set
+// their TTL or hop limit. cfg must already have its defaults applied.
+func newClient(cfg Config, c4 icmpx.Conn, ttl4 func(int) error, c6 icmpx.Conn, ttl6 func(int) error) (*Client, error) {
+	// Each connContext uses a random echo ID for all of its probes.
+	var ids [4]byte
+	if _, err := rand.Read(ids[:]); err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		cfg: cfg,
+		v4:  newConnContext(ipv4.ICMPTypeEcho, c4, ttl4, int(binary.BigEndian.Uint16(ids[0:2]))),
+		v6:  newConnContext(ipv6.ICMPTypeEchoRequest, c6, ttl6, int(binary.BigEndian.Uint16(ids[2:4]))),
+	}, nil
+}
+
+// Close closes the Client's underlying network connections.
+func (c *Client) Close() error {
+	if err := c.v4.Close(); err != nil {
+		_ = c.v6.Close()
+		return err
+	}
+
+	return c.v6.Close()
+}
+
+// A Trace is the result of a Client.Trace operation.
+type Trace struct {
+	// Hops are the results for each probed TTL or hop limit, in order. If
+	// the destination was reached, the final Hop is the first hop at which a
+	// probe reached it.
+	Hops []Hop
+
+	// Reached reports whether an echo reply was received from the
+	// destination.
+	Reached bool
+}
+
+// A Hop is the result of probing a single TTL or hop limit value.
+type Hop struct {
+	// TTL is the IPv4 TTL or IPv6 hop limit of each probe.
+	TTL int
+
+	// Probes are the results of each probe sent for this hop, in the order
+	// they were sent.
+	Probes []Probe
+}
+
+// A Probe is the result of a single echo request.
+type Probe struct {
+	// IP is the address of the node which replied, or the zero value if no
+	// reply was received before the timeout.
+	IP netip.Addr
+
+	// RTT is the time elapsed between sending the echo request and receiving
+	// a reply.
+	RTT time.Duration
+
+	// Message is the ICMP message received in reply: an echo reply from the
+	// destination, or a time exceeded or destination unreachable error which
+	// quotes the echo request. Message is nil if no reply was received.
+	Message *icmp.Message
+}
+
+// Timeout reports whether no reply was received for the Probe.
+func (p *Probe) Timeout() bool { return p.Message == nil }
+
+// Trace performs a traceroute to a destination host.
+func (c *Client) Trace(ctx context.Context, dst netip.Addr) (*Trace, error) {
+	if !dst.IsValid() || dst.IsUnspecified() || dst.IsMulticast() {
+		return nil, fmt.Errorf("traceroute: invalid destination: %q", dst)
+	}
+
+	if dst.Is4() {
+		return c.v4.trace(ctx, c.cfg, dst)
+	}
+
+	return c.v6.trace(ctx, c.cfg, dst)
+}
+
+// A connContext manages the state of an ICMPv4/6 socket for traceroute
+// operations.
+type connContext struct {
+	// Manages the underlying socket and ICMPv4/6 echo request type.
+	conn icmpx.Conn
+	typ  icmp.Type
+
+	// Serializes setting the TTL or hop limit and sending each probe.
+	sendMu sync.Mutex
+	setTTL func(int) error
+
+	// Manages the concurrency of the connContext.
+	eg     *errgroup.Group
+	cancel context.CancelFunc
+
+	// Manages dispatching replies to probes by the ICMPv4/6 echo sequence
+	// number. All probes share a random echo ID.
+	mu      sync.Mutex
+	id, seq int
+	probes  map[int]chan reply
+}
+
+// A reply contains an ICMPv4/6 reply to dispatch to a probe.
+type reply struct {
+	Message *icmp.Message
+	IP      netip.Addr
+	Time    time.Time
+}
+
+// newConnContext creates a connContext for a given ICMPv4/6 type and socket,
+// starting its background goroutines. All probes use the specified echo ID.
+func newConnContext(typ icmp.Type, conn icmpx.Conn, setTTL func(int) error, id int) *connContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+
+	cc := &connContext{
+		conn:   conn,
+		typ:    typ,
+		setTTL: setTTL,
+
+		eg:     eg,
+		cancel: cancel,
+
+		id:     id,
+		probes: make(map[int]chan reply),
+	}
+
+	eg.Go(func() error { return cc.readLoop(ctx) })
+
+	return cc
+}
+
+// Close stops the connContext's background goroutines and closes the ICMPv4/6
+// socket.
+func (cc *connContext) Close() error {
+	cc.cancel()
+	if err := cc.eg.Wait(); err != nil {
+		_ = cc.conn.Close()
+		return err
+	}
+
+	return cc.conn.Close()
+}
+
+// trace performs a traceroute to dst.
+func (cc *connContext) trace(ctx context.Context, cfg Config, dst netip.Addr) (*Trace, error) {
+	hops := make([]Hop, cfg.MaxHops-cfg.FirstHop+1)
+	for i := range hops {
+		hops[i] = Hop{
+			TTL:    cfg.FirstHop + i,
+			Probes: make([]Probe, cfg.Probes),
+		}
+	}
+
+	var (
+		// last is the lowest TTL at which a probe was not forwarded, after
+		// which no further hops are probed.
+		mu   sync.Mutex
+		last = cfg.MaxHops + 1
+
+		sem = make(chan struct{}, cfg.Parallel)
+
+		// In flow-stable mode, every probe belongs to the first flow.
+		flow = noFlow
+	)
+
+	if cfg.Paris {
+		flow = 0
+	}
+
+	eg, ectx := errgroup.WithContext(ctx)
+
+send:
+	for i := range hops {
+		for j := range hops[i].Probes {
+			select {
+			case sem <- struct{}{}:
+			case <-ectx.Done():
+				break send
+			}
+
+			mu.Lock()
+			stop := hops[i].TTL > last
+			mu.Unlock()
+			if stop {
+				<-sem
+				break send
+			}
+
+			i, j := i, j
+			eg.Go(func() error {
+				defer func() { <-sem }()
+
+				p, err := cc.probe(ectx, dst, hops[i].TTL, flow, cfg.Timeout)
+				if err != nil {
+					return err
+				}
+				hops[i].Probes[j] = *p
+
+				if final(p.Message) {
+					mu.Lock()
+					defer mu.Unlock()
+
+					if hops[i].TTL < last {
+						last = hops[i].TTL
+					}
+				}
+
+				return nil
+			})
+		}
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if last > cfg.MaxHops {
+		return &Trace{Hops: hops}, nil
+	}
+
+	// Discard the results of any probes which passed the last hop, and
+	// determine whether the last hop was the destination itself.
+	hops = hops[:last-cfg.FirstHop+1]
+
+	var reached bool
+	for _, p := range hops[len(hops)-1].Probes {
+		if p.Message != nil && isEchoReply(p.Message.Type) {
+			reached = true
+		}
+	}
+
+	return &Trace{
+		Hops:    hops,
+		Reached: reached,
+	}, nil
+}
+
+// final reports whether m indicates that a probe will not be forwarded beyond
+// the node which sent m.
+func final(m *icmp.Message) bool {
+	if m == nil {
+		return false
+	}
+
+	switch m.Type {
+	case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply,
+		ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+		return true
+	default:
+		return false
+	}
+}
+
+// isEchoReply reports whether typ is an ICMPv4/6 echo reply.
+func isEchoReply(typ icmp.Type) bool {
+	return typ == ipv4.ICMPTypeEchoReply || typ == ipv6.ICMPTypeEchoReply
+}
+
+// noFlow indicates that a probe does not belong to any flow, and its ICMP
+// checksum varies with its echo sequence number.
+const noFlow = -1
+
+// probe sends a single echo request to dst with the specified TTL or hop limit
+// and waits up to timeout for a reply. Unless flow is noFlow, the probe's ICMP
+// checksum is fixed by flow.
+func (cc *connContext) probe(ctx context.Context, dst netip.Addr, ttl, flow int, timeout time.Duration) (*Probe, error) {
+	echo, replyC := cc.register()
+	defer cc.unregister(echo.Seq)
+
+	if flow != noFlow {
+		echo.Data = flowData(cc.typ, echo, flow)
+	}
+
+	start, err := cc.send(ctx, &icmp.Message{Type: cc.typ, Body: echo}, dst, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case r := <-replyC:
+		return &Probe{
+			IP:      r.IP,
+			RTT:     r.Time.Sub(start),
+			Message: r.Message,
+		}, nil
+	case <-t.C:
+		return &Probe{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send sends msg to dst with the specified TTL or hop limit and returns the
+// time at which it was sent.
+func (cc *connContext) send(ctx context.Context, msg *icmp.Message, dst netip.Addr, ttl int) (time.Time, error) {
+	cc.sendMu.Lock()
+	defer cc.sendMu.Unlock()
+
+	if err := cc.setTTL(ttl); err != nil {
+		return time.Time{}, err
+	}
+
+	start := time.Now()
+	if err := cc.conn.WriteTo(ctx, msg, dst); err != nil {
+		return time.Time{}, err
+	}
+
+	return start, nil
+}
+
+// register allocates an echo request with a unique sequence number and a
+// channel which receives its reply.
+func (cc *connContext) register() (*icmp.Echo, chan reply) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	// Skip any sequence numbers which are still in use after wrapping.
+	for {
+		cc.seq = (cc.seq + 1) & 0xffff
+		if _, ok := cc.probes[cc.seq]; !ok {
+			break
+		}
+	}
+
+	replyC := make(chan reply, 1)
+	cc.probes[cc.seq] = replyC
+
+	return &icmp.Echo{ID: cc.id, Seq: cc.seq}, replyC
+}
+
+// unregister removes the reply channel for the probe with sequence number seq.
+func (cc *connContext) unregister(seq int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	delete(cc.probes, seq)
+}
+
+// readLoop manages the ICMPv4/6 reading goroutine until ctx is canceled.
+func (cc *connContext) readLoop(ctx context.Context) error {
+	for {
+		msg, ip, err := cc.conn.ReadFrom(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		now := time.Now()
+
+		seq, ok := cc.match(msg)
+		if !ok {
+			continue
+		}
+
+		cc.mu.Lock()
+		if replyC, ok := cc.probes[seq]; ok {
+			// Never block the reader; only the first reply to a probe
+			// matters.
+			select {
+			case replyC <- reply{Message: msg, IP: ip, Time: now}:
+			default:
+			}
+		}
+		cc.mu.Unlock()
+	}
+}
+
+// match returns the sequence number of the probe which elicited msg, by
+// inspecting either an echo reply or the echo request quoted in an ICMP error.
+func (cc *connContext) match(msg *icmp.Message) (int, bool) {
+	if echo, ok := msg.Body.(*icmp.Echo); ok {
+		if !isEchoReply(msg.Type) {
+			return 0, false
+		}
+
+		return echo.Seq, echo.ID == cc.id
+	}
+
+	d, err := quoted.FromMessage(msg)
+	if err != nil || d.Echo == nil || d.Echo.Type != cc.typ {
+		return 0, false
+	}
+
+	return d.Echo.Seq, d.Echo.ID == cc.id
+}
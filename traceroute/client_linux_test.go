@@ -0,0 +1,161 @@
+package traceroute_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx/internal/testns"
+	"github.com/mdlayher/icmpx/traceroute"
+)
+
+func TestMain(m *testing.M) { testns.Main(m) }
+
+func TestIntegrationClientTrace(t *testing.T) {
+	path := testns.Routers(t, 2)
+
+	c, err := traceroute.NewClient(path.Interface, traceroute.Config{
+		MaxHops: 5,
+		Probes:  2,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		name    string
+		dst     netip.Addr
+		routers []netip.Addr
+	}{
+		{
+			name:    "IPv4",
+			dst:     path.Target4,
+			routers: path.Routers4,
+		},
+		{
+			name:    "IPv6",
+			dst:     path.Target6,
+			routers: path.Routers6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			tr, err := c.Trace(ctx, tt.dst)
+			if err != nil {
+				t.Fatalf("failed to trace: %v", err)
+			}
+
+			var got [][]netip.Addr
+			for _, h := range tr.Hops {
+				var ips []netip.Addr
+				for _, p := range h.Probes {
+					t.Logf("%d: %s: %v", h.TTL, p.IP, p.RTT)
+					ips = append(ips, p.IP)
+				}
+
+				got = append(got, ips)
+			}
+
+			// Each router replies in turn, followed by the target.
+			var want [][]netip.Addr
+			for _, ip := range append(tt.routers, tt.dst) {
+				want = append(want, []netip.Addr{ip, ip})
+			}
+
+			if diff := cmp.Diff(want, got, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected hops (-want +got):\n%s", diff)
+			}
+
+			if !tr.Reached {
+				t.Fatal("destination was not reached")
+			}
+		})
+	}
+}
+
+func TestIntegrationClientMultipath(t *testing.T) {
+	path := testns.Routers(t, 2)
+
+	c, err := traceroute.NewClient(path.Interface, traceroute.Config{
+		MaxHops: 5,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("skipping, permission denied")
+		}
+
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		name    string
+		dst     netip.Addr
+		routers []netip.Addr
+	}{
+		{
+			name:    "IPv4",
+			dst:     path.Target4,
+			routers: path.Routers4,
+		},
+		{
+			name:    "IPv6",
+			dst:     path.Target6,
+			routers: path.Routers6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			mp, err := c.Multipath(ctx, tt.dst)
+			if err != nil {
+				t.Fatalf("failed to trace: %v", err)
+			}
+
+			var got [][]netip.Addr
+			for _, h := range mp.Hops {
+				var ips []netip.Addr
+				for _, ifi := range h.Interfaces {
+					t.Logf("%d: %s: %d flows, next %v", h.TTL, ifi.IP, len(ifi.Flows), ifi.Next)
+					ips = append(ips, ifi.IP)
+				}
+
+				got = append(got, ips)
+			}
+
+			// Without load balancing, each hop has a single interface.
+			var want [][]netip.Addr
+			for _, ip := range append(tt.routers, tt.dst) {
+				want = append(want, []netip.Addr{ip})
+			}
+
+			if diff := cmp.Diff(want, got, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected interfaces (-want +got):\n%s", diff)
+			}
+
+			if !mp.Reached {
+				t.Fatal("destination was not reached")
+			}
+		})
+	}
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
@@ -0,0 +1,447 @@
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/icmpx"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestClientTrace(t *testing.T) {
+	var (
+		r1 = netip.MustParseAddr("2001:db8::1")
+		r2 = netip.MustParseAddr("2001:db8::2")
+		r3 = netip.MustParseAddr("2001:db8::3")
+		d6 = netip.MustParseAddr("2001:db8::ff")
+
+		r4 = netip.MustParseAddr("192.0.2.1")
+		d4 = netip.MustParseAddr("192.0.2.255")
+	)
+
+	tests := []struct {
+		name        string
+		cfg         Config
+		routers     [][]netip.Addr
+		unreachable int
+		dst         netip.Addr
+		want        []hop
+		reached     bool
+	}{
+		{
+			name:    "IPv4 in turn",
+			cfg:     Config{Probes: 2, Parallel: 1},
+			routers: [][]netip.Addr{{r4}},
+			dst:     d4,
+			want: []hop{
+				{TTL: 1, IPs: []netip.Addr{r4, r4}},
+				{TTL: 2, IPs: []netip.Addr{d4, d4}},
+			},
+			reached: true,
+		},
+		{
+			name:    "IPv6 parallel",
+			routers: [][]netip.Addr{{r1}, {r2}, {r3}},
+			dst:     d6,
+			want: []hop{
+				{TTL: 1, IPs: []netip.Addr{r1, r1, r1}},
+				{TTL: 2, IPs: []netip.Addr{r2, r2, r2}},
+				{TTL: 3, IPs: []netip.Addr{r3, r3, r3}},
+				{TTL: 4, IPs: []netip.Addr{d6, d6, d6}},
+			},
+			reached: true,
+		},
+		{
+			name:    "silent router",
+			cfg:     Config{Probes: 1},
+			routers: [][]netip.Addr{{r1}, {{}}, {r3}},
+			dst:     d6,
+			want: []hop{
+				{TTL: 1, IPs: []netip.Addr{r1}},
+				{TTL: 2, IPs: []netip.Addr{{}}},
+				{TTL: 3, IPs: []netip.Addr{r3}},
+				{TTL: 4, IPs: []netip.Addr{d6}},
+			},
+			reached: true,
+		},
+		{
+			name:        "unreachable",
+			cfg:         Config{Probes: 1},
+			routers:     [][]netip.Addr{{r1}, {r2}, {r3}},
+			unreachable: 2,
+			dst:         d6,
+			want: []hop{
+				{TTL: 1, IPs: []netip.Addr{r1}},
+				{TTL: 2, IPs: []netip.Addr{r2}},
+			},
+		},
+		{
+			name:    "first and max hops",
+			cfg:     Config{FirstHop: 2, MaxHops: 3, Probes: 1},
+			routers: [][]netip.Addr{{r1}, {r2}, {r3}},
+			dst:     d6,
+			want: []hop{
+				{TTL: 2, IPs: []netip.Addr{r2}},
+				{TTL: 3, IPs: []netip.Addr{r3}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Timeout = 100 * time.Millisecond
+			cfg, err := tt.cfg.withDefaults()
+			if err != nil {
+				t.Fatalf("failed to apply defaults: %v", err)
+			}
+
+			var (
+				p4 = newTestPath(ipv4.ICMPTypeEchoReply, tt.routers, tt.unreachable)
+				p6 = newTestPath(ipv6.ICMPTypeEchoReply, tt.routers, tt.unreachable)
+			)
+
+			c, err := newClient(cfg, p4, p4.setTTL, p6, p6.setTTL)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			tr, err := c.Trace(ctx, tt.dst)
+			if err != nil {
+				t.Fatalf("failed to trace: %v", err)
+			}
+
+			var got []hop
+			for _, h := range tr.Hops {
+				g := hop{TTL: h.TTL}
+				for _, p := range h.Probes {
+					if !p.Timeout() && p.RTT <= 0 {
+						t.Fatalf("unexpected RTT: %v", p.RTT)
+					}
+
+					g.IPs = append(g.IPs, p.IP)
+				}
+
+				got = append(got, g)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected hops (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.reached, tr.Reached); diff != "" {
+				t.Fatalf("unexpected reached (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientTraceParis(t *testing.T) {
+	var (
+		r1 = netip.MustParseAddr("2001:db8::1")
+		r2 = netip.MustParseAddr("2001:db8::2")
+		r3 = netip.MustParseAddr("2001:db8::3")
+		r4 = netip.MustParseAddr("2001:db8::4")
+		d6 = netip.MustParseAddr("2001:db8::ff")
+
+		routers = [][]netip.Addr{{r1}, {r2, r3, r4}, {r2, r3, r4}}
+	)
+
+	cfg, err := Config{Probes: 10, Paris: true}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	var (
+		p4 = newTestPath(ipv4.ICMPTypeEchoReply, routers, 0)
+		p6 = newTestPath(ipv6.ICMPTypeEchoReply, routers, 0)
+	)
+
+	c, err := newClient(cfg, p4, p4.setTTL, p6, p6.setTTL)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	tr, err := c.Trace(context.Background(), d6)
+	if err != nil {
+		t.Fatalf("failed to trace: %v", err)
+	}
+
+	if diff := cmp.Diff(4, len(tr.Hops)); diff != "" {
+		t.Fatalf("unexpected number of hops (-want +got):\n%s", diff)
+	}
+
+	// Every probe must follow the same path through the load balanced hops.
+	for _, h := range tr.Hops {
+		for _, p := range h.Probes {
+			if diff := cmp.Diff(h.Probes[0].IP, p.IP, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected IP at hop %d (-want +got):\n%s", h.TTL, diff)
+			}
+		}
+	}
+}
+
+func TestClientTraceErrors(t *testing.T) {
+	cfg, err := Config{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	var (
+		p4 = newTestPath(ipv4.ICMPTypeEchoReply, nil, 0)
+		p6 = newTestPath(ipv6.ICMPTypeEchoReply, nil, 0)
+	)
+
+	c, err := newClient(cfg, p4, p4.setTTL, p6, p6.setTTL)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	for _, dst := range []netip.Addr{
+		{},
+		netip.IPv6Unspecified(),
+		netip.MustParseAddr("ff02::1"),
+	} {
+		if _, err := c.Trace(context.Background(), dst); err == nil {
+			t.Fatalf("expected an error for %q, but none occurred", dst)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{FirstHop: -1},
+		{FirstHop: 10, MaxHops: 5},
+		{MaxHops: 256},
+		{Probes: 11},
+		{Parallel: -1},
+		{Timeout: -1},
+		{Confidence: -1},
+		{Confidence: 1},
+		{MaxFlows: -1},
+		{MaxFlows: 0x10000},
+	} {
+		if _, err := cfg.withDefaults(); err == nil {
+			t.Fatalf("expected an error for %+v, but none occurred", cfg)
+		}
+	}
+
+	cfg, err := Config{}.withDefaults()
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	want := Config{
+		FirstHop:   1,
+		MaxHops:    30,
+		Probes:     3,
+		Parallel:   16,
+		Timeout:    5 * time.Second,
+		Confidence: 0.95,
+		MaxFlows:   256,
+	}
+
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Fatalf("unexpected config (-want +got):\n%s", diff)
+	}
+}
+
+// A hop is a simplified Hop for comparisons.
+type hop struct {
+	TTL int
+	IPs []netip.Addr
+}
+
+var _ icmpx.Conn = &testPath{}
+
+// A testPath implements icmpx.Conn by emulating a path of routers which send
+// time exceeded errors, followed by a destination which sends echo replies.
+// When a hop has multiple routers, probes are distributed among them by a
+// per-flow load balancer.
+type testPath struct {
+	reply       icmp.Type
+	routers     [][]netip.Addr
+	unreachable int
+
+	mu  sync.Mutex
+	ttl int
+
+	readC chan message
+}
+
+// A message is an ICMP message and its source address.
+type message struct {
+	Message *icmp.Message
+	IP      netip.Addr
+}
+
+func newTestPath(reply icmp.Type, routers [][]netip.Addr, unreachable int) *testPath {
+	return &testPath{
+		reply:       reply,
+		routers:     routers,
+		unreachable: unreachable,
+		readC:       make(chan message, 64),
+	}
+}
+
+func (p *testPath) setTTL(ttl int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ttl = ttl
+	return nil
+}
+
+func (*testPath) Close() error { return nil }
+
+func (p *testPath) ReadFrom(ctx context.Context) (*icmp.Message, netip.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, netip.Addr{}, ctx.Err()
+	case m := <-p.readC:
+		return m.Message, m.IP, nil
+	}
+}
+
+func (p *testPath) WriteTo(_ context.Context, msg *icmp.Message, dst netip.Addr) error {
+	b, err := msg.Marshal(pseudoHeader(dst))
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Replies to another program's probes must be ignored.
+	echo := *msg.Body.(*icmp.Echo)
+	echo.ID++
+	p.send(&icmp.Message{Type: p.reply, Body: &echo}, dst)
+
+	var (
+		ip  = dst
+		res = &icmp.Message{Type: p.reply, Body: msg.Body}
+	)
+
+	if p.ttl <= len(p.routers) {
+		ip = route(p.routers[p.ttl-1], p.ttl, b)
+		if !ip.IsValid() {
+			// Silently drop the probe.
+			return nil
+		}
+
+		res = &icmp.Message{
+			Type: timeExceeded(dst),
+			Body: &icmp.TimeExceeded{Data: quote(dst, b)},
+		}
+
+		if p.ttl == p.unreachable {
+			res = &icmp.Message{
+				Type: dstUnreach(dst),
+				Body: &icmp.DstUnreach{Data: quote(dst, b)},
+			}
+		}
+	}
+
+	p.send(res, ip)
+	return nil
+}
+
+// send sends msg from ip over the "wire" to exercise marshaling.
+func (p *testPath) send(msg *icmp.Message, ip netip.Addr) {
+	proto := 1
+	if ip.Is6() {
+		proto = 58
+	}
+
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		panic(err)
+	}
+	m, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		panic(err)
+	}
+
+	p.readC <- message{Message: m, IP: ip}
+}
+
+// pseudoHeader returns the IPv6 pseudo-header for a message sent to dst so its
+// checksum can be computed, or nil for IPv4.
+func pseudoHeader(dst netip.Addr) []byte {
+	if dst.Is4() {
+		return nil
+	}
+
+	return icmp.IPv6PseudoHeader(net.ParseIP("2001:db8::100"), dst.AsSlice())
+}
+
+// route selects one of routers for the ICMP message b at ttl by hashing its
+// type, code, and checksum, like a per-flow load balancer.
+func route(routers []netip.Addr, ttl int, b []byte) netip.Addr {
+	h := fnv.New32a()
+	_, _ = h.Write(b[:4])
+	_, _ = h.Write([]byte{byte(ttl)})
+
+	return routers[h.Sum32()%uint32(len(routers))]
+}
+
+func timeExceeded(dst netip.Addr) icmp.Type {
+	if dst.Is4() {
+		return ipv4.ICMPTypeTimeExceeded
+	}
+
+	return ipv6.ICMPTypeTimeExceeded
+}
+
+func dstUnreach(dst netip.Addr) icmp.Type {
+	if dst.Is4() {
+		return ipv4.ICMPTypeDestinationUnreachable
+	}
+
+	return ipv6.ICMPTypeDestinationUnreachable
+}
+
+// quote produces a quoted IPv4 or IPv6 datagram carrying the ICMP message b to
+// dst, as it would be seen by the last router.
+func quote(dst netip.Addr, b []byte) []byte {
+	if dst.Is4() {
+		h := make([]byte, 20, 20+len(b))
+		h[0] = 0x45
+		binary.BigEndian.PutUint16(h[2:4], uint16(20+len(b)))
+		h[8] = 1
+		h[9] = 1
+		copy(h[12:16], []byte{192, 0, 2, 100})
+		a := dst.As4()
+		copy(h[16:20], a[:])
+
+		return append(h, b...)
+	}
+
+	h := make([]byte, 40, 40+len(b))
+	h[0] = 0x60
+	binary.BigEndian.PutUint16(h[4:6], uint16(len(b)))
+	h[6] = 58
+	h[7] = 1
+	src := netip.MustParseAddr("2001:db8::100").As16()
+	copy(h[8:24], src[:])
+	a := dst.As16()
+	copy(h[24:40], a[:])
+
+	return append(h, b...)
+}
+
+func ipEqual(x, y netip.Addr) bool { return x == y }
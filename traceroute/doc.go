@@ -0,0 +1,9 @@
+// Package traceroute implements an ICMPv4/6 traceroute client which discovers
+// the routers along the path to a destination host by sending echo requests
+// with increasing TTL or hop limit values.
+//
+// To avoid misleading results across per-flow load balancers, the client can
+// keep the ICMP checksum of its probes constant (Paris traceroute), and can
+// discover the interfaces along every load balanced path using the Multipath
+// Detection Algorithm (MDA).
+package traceroute
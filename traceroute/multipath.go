@@ -0,0 +1,457 @@
+package traceroute
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// A Multipath is the result of a Client.Multipath operation: a graph of the
+// interfaces discovered at each hop along every load balanced path to a
+// destination host.
+type Multipath struct {
+	// Hops are the interfaces discovered at each probed TTL or hop limit, in
+	// order.
+	Hops []MultipathHop
+
+	// Reached reports whether an echo reply was received from the
+	// destination.
+	Reached bool
+}
+
+// A MultipathHop is the set of interfaces discovered at a single TTL or hop
+// limit value.
+type MultipathHop struct {
+	// TTL is the IPv4 TTL or IPv6 hop limit of each probe.
+	TTL int
+
+	// Interfaces are the interfaces which replied to probes at this hop,
+	// sorted by IP address.
+	Interfaces []Interface
+
+	// Timeouts is the number of flows for which no reply was received at
+	// this hop.
+	Timeouts int
+}
+
+// An Interface is a node interface discovered by Multipath.
+type Interface struct {
+	// IP is the address from which the interface replied.
+	IP netip.Addr
+
+	// Flows are the identifiers of the flows whose probes elicited a reply
+	// from this interface, in ascending order.
+	Flows []int
+
+	// Next are the addresses of the interfaces at the following hop which
+	// replied to probes for any of Flows, sorted by IP address.
+	Next []netip.Addr
+}
+
+// Multipath performs a Paris traceroute to a destination host using the
+// Multipath Detection Algorithm (MDA) to discover the interfaces along every
+// path through per-flow load balancers.
+//
+// Each flow is identified by the constant ICMP checksum of its probes. For
+// every interface at a hop, Multipath probes the next hop with enough distinct
+// flows which pass through that interface to discover all of its next hops
+// with probability Config.Confidence, generating new flows as needed until
+// Config.MaxFlows are in use.
+func (c *Client) Multipath(ctx context.Context, dst netip.Addr) (*Multipath, error) {
+	if !dst.IsValid() || dst.IsUnspecified() || dst.IsMulticast() {
+		return nil, fmt.Errorf("traceroute: invalid destination: %q", dst)
+	}
+
+	cc := c.v6
+	if dst.Is4() {
+		cc = c.v4
+	}
+
+	m := &mda{
+		cc:  cc,
+		cfg: c.cfg,
+		dst: dst,
+	}
+
+	return m.run(ctx)
+}
+
+// A flowResult is the reply to the probe for a single flow at a single hop.
+type flowResult struct {
+	IP      netip.Addr
+	Message *icmp.Message
+}
+
+// An mda performs a single run of the Multipath Detection Algorithm.
+type mda struct {
+	cc  *connContext
+	cfg Config
+	dst netip.Addr
+
+	// flows is the number of flows allocated so far.
+	flows int
+
+	// results hold the reply for each flow probed at each hop, starting at
+	// cfg.FirstHop.
+	results []map[int]flowResult
+}
+
+// run discovers the interfaces at each hop until every path has reached the
+// destination or cfg.MaxHops.
+func (m *mda) run(ctx context.Context) (*Multipath, error) {
+	for i := 0; i < m.cfg.MaxHops-m.cfg.FirstHop+1; i++ {
+		m.results = append(m.results, make(map[int]flowResult))
+
+		// Explore the next hops of each interface discovered at the
+		// previous hop, including any which are discovered along the way.
+		var (
+			explored = make(map[netip.Addr]bool)
+			known    bool
+		)
+
+		for i > 0 {
+			var (
+				ip    netip.Addr
+				found bool
+			)
+
+			for _, ifi := range m.interfaces(i - 1) {
+				known = true
+				if !explored[ifi.IP] && !ifi.Final {
+					ip, found = ifi.IP, true
+					break
+				}
+			}
+			if !found {
+				break
+			}
+
+			explored[ip] = true
+			if err := m.explore(ctx, i, ip); err != nil {
+				return nil, err
+			}
+		}
+
+		// When no interface replied at the previous hop, or this is the first
+		// hop, probe the next hop with flows of unknown origin instead.
+		if !known {
+			if err := m.explore(ctx, i, netip.Addr{}); err != nil {
+				return nil, err
+			}
+		}
+
+		// Continue until the only interfaces which replied are the
+		// destination or nodes reporting it as unreachable.
+		var transit, final bool
+		for _, ifi := range m.interfaces(i) {
+			if ifi.Final {
+				final = true
+			} else {
+				transit = true
+			}
+		}
+		if final && !transit {
+			break
+		}
+	}
+
+	return m.graph(), nil
+}
+
+// A vertex is an interface and the flows which reached it at a single hop.
+type vertex struct {
+	IP    netip.Addr
+	Flows []int
+	Final bool
+}
+
+// interfaces returns the interfaces which replied to probes at hop i, sorted by
+// IP address.
+func (m *mda) interfaces(i int) []vertex {
+	byIP := make(map[netip.Addr]*vertex)
+	for f, r := range m.results[i] {
+		if !r.IP.IsValid() {
+			continue
+		}
+
+		v, ok := byIP[r.IP]
+		if !ok {
+			v = &vertex{IP: r.IP}
+			byIP[r.IP] = v
+		}
+
+		v.Flows = append(v.Flows, f)
+		if final(r.Message) {
+			v.Final = true
+		}
+	}
+
+	vs := make([]vertex, 0, len(byIP))
+	for _, v := range byIP {
+		sort.Ints(v.Flows)
+		vs = append(vs, *v)
+	}
+
+	sort.Slice(vs, func(i, j int) bool { return vs[i].IP.Less(vs[j].IP) })
+	return vs
+}
+
+// explore probes hop i with flows which passed through the interface ip at hop
+// i-1 until the MDA stopping rule is satisfied for that interface or no more
+// flows are available. If ip is the zero value, flows of unknown origin are
+// used instead: those which received no reply at hop i-1, or new flows.
+func (m *mda) explore(ctx context.Context, i int, ip netip.Addr) error {
+	for {
+		var (
+			candidates []int
+			probed     int
+			next       = make(map[netip.Addr]bool)
+		)
+
+		// count tallies a flow which passed through ip at hop i-1.
+		count := func(f int) {
+			r, ok := m.results[i][f]
+			if !ok {
+				candidates = append(candidates, f)
+				return
+			}
+
+			probed++
+			if r.IP.IsValid() {
+				next[r.IP] = true
+			}
+		}
+
+		if ip.IsValid() {
+			for f, r := range m.results[i-1] {
+				if r.IP == ip {
+					count(f)
+				}
+			}
+		} else {
+			for f := range m.results[i] {
+				if i == 0 {
+					count(f)
+				} else if r, ok := m.results[i-1][f]; !ok || !r.IP.IsValid() {
+					count(f)
+				}
+			}
+			if i > 0 {
+				for f, r := range m.results[i-1] {
+					if _, ok := m.results[i][f]; !ok && !r.IP.IsValid() {
+						count(f)
+					}
+				}
+			}
+		}
+
+		need := stoppingPoint(len(next), m.cfg.Confidence) - probed
+		if need <= 0 {
+			return nil
+		}
+
+		sort.Ints(candidates)
+		if len(candidates) > need {
+			candidates = candidates[:need]
+		}
+
+		if n := need - len(candidates); n > 0 {
+			flows := m.allocate(n)
+			switch {
+			case len(flows) == 0 && len(candidates) == 0:
+				// No more flows are available.
+				return nil
+			case !ip.IsValid():
+				candidates = append(candidates, flows...)
+			case len(flows) > 0:
+				// Find more flows which pass through ip by probing the
+				// previous hop, then try again.
+				if err := m.probe(ctx, i-1, flows); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if err := m.probe(ctx, i, candidates); err != nil {
+			return err
+		}
+	}
+}
+
+// allocate allocates up to n new flows, limited by cfg.MaxFlows.
+func (m *mda) allocate(n int) []int {
+	if rem := m.cfg.MaxFlows - m.flows; n > rem {
+		n = rem
+	}
+
+	flows := make([]int, 0, n)
+	for j := 0; j < n; j++ {
+		flows = append(flows, m.flows)
+		m.flows++
+	}
+
+	return flows
+}
+
+// probe sends a probe for each of flows at hop i in parallel and records the
+// replies.
+func (m *mda) probe(ctx context.Context, i int, flows []int) error {
+	var (
+		mu  sync.Mutex
+		sem = make(chan struct{}, m.cfg.Parallel)
+	)
+
+	eg, ectx := errgroup.WithContext(ctx)
+
+send:
+	for _, f := range flows {
+		select {
+		case sem <- struct{}{}:
+		case <-ectx.Done():
+			break send
+		}
+
+		f := f
+		eg.Go(func() error {
+			defer func() { <-sem }()
+
+			p, err := m.cc.probe(ectx, m.dst, m.cfg.FirstHop+i, f, m.cfg.Timeout)
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			m.results[i][f] = flowResult{IP: p.IP, Message: p.Message}
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+// graph produces a Multipath from the results of each hop.
+func (m *mda) graph() *Multipath {
+	var mp Multipath
+	for i := range m.results {
+		hop := MultipathHop{TTL: m.cfg.FirstHop + i}
+		for _, r := range m.results[i] {
+			if !r.IP.IsValid() {
+				hop.Timeouts++
+			}
+		}
+
+		for _, v := range m.interfaces(i) {
+			ifi := Interface{
+				IP:    v.IP,
+				Flows: v.Flows,
+			}
+
+			if i+1 < len(m.results) {
+				next := make(map[netip.Addr]bool)
+				for _, f := range v.Flows {
+					if r := m.results[i+1][f]; r.IP.IsValid() && !next[r.IP] {
+						next[r.IP] = true
+						ifi.Next = append(ifi.Next, r.IP)
+					}
+				}
+
+				sort.Slice(ifi.Next, func(i, j int) bool { return ifi.Next[i].Less(ifi.Next[j]) })
+			}
+
+			hop.Interfaces = append(hop.Interfaces, ifi)
+		}
+
+		mp.Hops = append(mp.Hops, hop)
+	}
+
+	// The destination was reached if it replied at the final hop.
+	if len(m.results) > 0 {
+		for _, r := range m.results[len(m.results)-1] {
+			if r.Message != nil && isEchoReply(r.Message.Type) {
+				mp.Reached = true
+			}
+		}
+	}
+
+	return &mp
+}
+
+// stoppingPoint returns the number of probes which must be sent to an
+// interface with k known next hops to rule out the existence of another next
+// hop with the specified confidence, assuming that a load balancer distributes
+// flows uniformly among its next hops. At least one next hop is always
+// assumed to exist.
+func stoppingPoint(k int, confidence float64) int {
+	if k < 1 {
+		k = 1
+	}
+
+	// Find the smallest n for which the probability that n probes, uniformly
+	// distributed among k+1 next hops, miss any of them is within bounds. By
+	// inclusion-exclusion, that probability is the sum over i of
+	// (-1)^(i+1) * C(k+1, i) * ((k+1-i)/(k+1))^n.
+	hops := float64(k + 1)
+	for n := 1; ; n++ {
+		var (
+			p     float64
+			sign  = 1.0
+			binom = 1.0
+		)
+
+		for i := 1; i <= k; i++ {
+			binom = binom * (hops - float64(i) + 1) / float64(i)
+			p += sign * binom * math.Pow((hops-float64(i))/hops, float64(n))
+			sign = -sign
+		}
+
+		if p <= 1-confidence {
+			return n
+		}
+	}
+}
+
+// flowData returns echo request data for echo which fixes the ones' complement
+// sum of an ICMP echo request of type typ for flow. As a result, the ICMPv4
+// checksum of the request is flow regardless of the echo ID and sequence
+// number, and the ICMPv6 checksum, which also covers a pseudo-header, is
+// likewise constant for a given source and destination.
+func flowData(typ icmp.Type, echo *icmp.Echo, flow int) []byte {
+	var t uint32
+	switch typ := typ.(type) {
+	case ipv4.ICMPType:
+		t = uint32(typ)
+	case ipv6.ICMPType:
+		t = uint32(typ)
+	}
+
+	// The sum of the type, code, and echo fields, to which data is added to
+	// produce the complement of the desired checksum.
+	sum := fold(t<<8 + uint32(uint16(echo.ID)) + uint32(uint16(echo.Seq)))
+	data := fold(uint32(^uint16(flow)) + uint32(^sum))
+
+	return []byte{byte(data >> 8), byte(data)}
+}
+
+// fold folds a 32-bit ones' complement sum into 16 bits.
+func fold(s uint32) uint16 {
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+
+	return uint16(s)
+}
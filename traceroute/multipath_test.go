@@ -0,0 +1,241 @@
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestClientMultipath(t *testing.T) {
+	var (
+		r1 = netip.MustParseAddr("2001:db8::1")
+		r2 = netip.MustParseAddr("2001:db8::2")
+		r3 = netip.MustParseAddr("2001:db8::3")
+		r4 = netip.MustParseAddr("2001:db8::4")
+		r5 = netip.MustParseAddr("2001:db8::5")
+		r6 = netip.MustParseAddr("2001:db8::6")
+		d6 = netip.MustParseAddr("2001:db8::ff")
+
+		r7 = netip.MustParseAddr("192.0.2.1")
+		r8 = netip.MustParseAddr("192.0.2.2")
+		d4 = netip.MustParseAddr("192.0.2.255")
+	)
+
+	tests := []struct {
+		name        string
+		cfg         Config
+		routers     [][]netip.Addr
+		unreachable int
+		dst         netip.Addr
+		want        [][]netip.Addr
+		reached     bool
+	}{
+		{
+			name:    "IPv4 diamond",
+			routers: [][]netip.Addr{{r7}, {r7, r8}},
+			dst:     d4,
+			want:    [][]netip.Addr{{r7}, {r7, r8}, {d4}},
+			reached: true,
+		},
+		{
+			name:    "IPv6 load balancers",
+			routers: [][]netip.Addr{{r1}, {r2, r3}, {r4, r5, r6}, {r1}},
+			dst:     d6,
+			want:    [][]netip.Addr{{r1}, {r2, r3}, {r4, r5, r6}, {r1}, {d6}},
+			reached: true,
+		},
+		{
+			name:    "silent router",
+			routers: [][]netip.Addr{{r1}, {{}}, {r2, r3}},
+			dst:     d6,
+			want:    [][]netip.Addr{{r1}, nil, {r2, r3}, {d6}},
+			reached: true,
+		},
+		{
+			name:        "unreachable",
+			routers:     [][]netip.Addr{{r1}, {r2, r3}, {r4}},
+			unreachable: 3,
+			dst:         d6,
+			want:        [][]netip.Addr{{r1}, {r2, r3}, {r4}},
+		},
+		{
+			name:    "max flows",
+			cfg:     Config{MaxFlows: 1, MaxHops: 3},
+			routers: [][]netip.Addr{{r1}, {r2, r3}, {r4}},
+			dst:     d6,
+			want:    [][]netip.Addr{{r1}, {r3}, {r4}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Timeout = 100 * time.Millisecond
+			cfg, err := tt.cfg.withDefaults()
+			if err != nil {
+				t.Fatalf("failed to apply defaults: %v", err)
+			}
+
+			var (
+				p4 = newTestPath(ipv4.ICMPTypeEchoReply, tt.routers, tt.unreachable)
+				p6 = newTestPath(ipv6.ICMPTypeEchoReply, tt.routers, tt.unreachable)
+			)
+
+			c, err := newClient(cfg, p4, p4.setTTL, p6, p6.setTTL)
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			mp, err := c.Multipath(ctx, tt.dst)
+			if err != nil {
+				t.Fatalf("failed to trace: %v", err)
+			}
+
+			var got [][]netip.Addr
+			for i, h := range mp.Hops {
+				if diff := cmp.Diff(cfg.FirstHop+i, h.TTL); diff != "" {
+					t.Fatalf("unexpected TTL (-want +got):\n%s", diff)
+				}
+
+				var ips []netip.Addr
+				for _, ifi := range h.Interfaces {
+					ips = append(ips, ifi.IP)
+
+					// Every flow through an interface must have followed
+					// the edges of the graph.
+					for _, f := range ifi.Flows {
+						if i+1 == len(mp.Hops) {
+							break
+						}
+
+						next, ok := flowAt(mp.Hops[i+1], f)
+						if ok && !contains(ifi.Next, next) {
+							t.Fatalf("flow %d reached %s without an edge from %s", f, next, ifi.IP)
+						}
+					}
+				}
+
+				got = append(got, ips)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(ipEqual)); diff != "" {
+				t.Fatalf("unexpected interfaces (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.reached, mp.Reached); diff != "" {
+				t.Fatalf("unexpected reached (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStoppingPoint(t *testing.T) {
+	// The number of probes for each number of known next hops at 95%
+	// confidence, as published with the MDA.
+	want := []int{6, 11, 16, 21, 27, 33, 38, 44, 51, 57, 63, 70, 76, 83, 90, 96}
+
+	var got []int
+	for k := 1; k <= len(want); k++ {
+		got = append(got, stoppingPoint(k, 0.95))
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected stopping points (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(stoppingPoint(1, 0.95), stoppingPoint(0, 0.95)); diff != "" {
+		t.Fatalf("unexpected stopping point with no next hops (-want +got):\n%s", diff)
+	}
+}
+
+func TestFlowData(t *testing.T) {
+	var (
+		src = netip.MustParseAddr("2001:db8::1").AsSlice()
+		dst = netip.MustParseAddr("2001:db8::2").AsSlice()
+	)
+
+	tests := []struct {
+		name string
+		typ  icmp.Type
+		psh  []byte
+	}{
+		{
+			name: "IPv4",
+			typ:  ipv4.ICMPTypeEcho,
+		},
+		{
+			name: "IPv6",
+			typ:  ipv6.ICMPTypeEchoRequest,
+			psh:  icmp.IPv6PseudoHeader(src, dst),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, flow := range []int{0, 1, 0x1234, 0xfffe} {
+				sums := make(map[uint16]bool)
+				for _, echo := range []icmp.Echo{
+					{ID: 0, Seq: 0},
+					{ID: 1, Seq: 1},
+					{ID: 0xffff, Seq: 0xffff},
+					{ID: 0x8000, Seq: 0x1234},
+				} {
+					echo := echo
+					echo.Data = flowData(tt.typ, &echo, flow)
+
+					b, err := (&icmp.Message{Type: tt.typ, Body: &echo}).Marshal(tt.psh)
+					if err != nil {
+						t.Fatalf("failed to marshal: %v", err)
+					}
+
+					sum := binary.BigEndian.Uint16(b[2:4])
+					if tt.psh == nil {
+						if diff := cmp.Diff(uint16(flow), sum); diff != "" {
+							t.Fatalf("unexpected checksum (-want +got):\n%s", diff)
+						}
+					}
+
+					sums[sum] = true
+				}
+
+				if len(sums) != 1 {
+					t.Fatalf("checksum varied for flow %d: %v", flow, sums)
+				}
+			}
+		})
+	}
+}
+
+// flowAt returns the interface which replied to flow at hop h.
+func flowAt(h MultipathHop, flow int) (netip.Addr, bool) {
+	for _, ifi := range h.Interfaces {
+		for _, f := range ifi.Flows {
+			if f == flow {
+				return ifi.IP, true
+			}
+		}
+	}
+
+	return netip.Addr{}, false
+}
+
+// contains reports whether ips contains ip.
+func contains(ips []netip.Addr, ip netip.Addr) bool {
+	for _, v := range ips {
+		if v == ip {
+			return true
+		}
+	}
+
+	return false
+}